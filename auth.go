@@ -0,0 +1,176 @@
+package nfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+const (
+	// authUnixMaxMachineName is the longest machine name allowed in an AUTH_UNIX credential
+	authUnixMaxMachineName = 255
+	// authUnixMaxGroups is the most supplementary groups allowed in an AUTH_UNIX credential
+	authUnixMaxGroups = 16
+)
+
+// AuthUnix is the credential body of an AUTH_UNIX (AUTH_SYS) request,
+// per rfc5531 appendix A.
+type AuthUnix struct {
+	Stamp       uint32
+	MachineName string
+	UID         uint32
+	GID         uint32
+	GIDs        []uint32
+}
+
+// ParseAuthUnix decodes the body of an AUTH_UNIX credential.
+func ParseAuthUnix(body []byte) (*AuthUnix, error) {
+	r := bytes.NewReader(body)
+	cred := AuthUnix{}
+
+	var err error
+	if cred.Stamp, err = xdr.ReadUint32(r); err != nil {
+		return nil, err
+	}
+	nameLen, err := xdr.ReadUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	if nameLen > authUnixMaxMachineName || int(nameLen) > r.Len() {
+		return nil, ErrInputInvalid
+	}
+	name := make([]byte, nameLen)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return nil, err
+	}
+	cred.MachineName = string(name)
+	if pad := (4 - nameLen%4) % 4; pad > 0 {
+		if _, err := r.Seek(int64(pad), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+	if cred.UID, err = xdr.ReadUint32(r); err != nil {
+		return nil, err
+	}
+	if cred.GID, err = xdr.ReadUint32(r); err != nil {
+		return nil, err
+	}
+	numGroups, err := xdr.ReadUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	if numGroups > authUnixMaxGroups {
+		return nil, ErrInputInvalid
+	}
+	cred.GIDs = make([]uint32, numGroups)
+	for i := range cred.GIDs {
+		if cred.GIDs[i], err = xdr.ReadUint32(r); err != nil {
+			return nil, err
+		}
+	}
+	return &cred, nil
+}
+
+// UnixPrincipal is the principal name for AUTH_UNIX requests made as uid.
+func UnixPrincipal(uid uint32) string {
+	return "uid:" + strconv.FormatUint(uint64(uid), 10)
+}
+
+type credentialContextKey struct{}
+
+// callCredential is the credential and verifier a call is made with, and
+// for RPCSEC_GSS calls the principal and service of their context.
+type callCredential struct {
+	cred, verf rpc.Auth
+	principal  string
+	service    GSSService
+}
+
+func contextWithCredential(ctx context.Context, cred callCredential) context.Context {
+	return context.WithValue(ctx, credentialContextKey{}, cred)
+}
+
+// CredentialFromContext returns the flavor and raw body of the credential
+// of the request being handled, whatever its flavor, for handlers
+// validating flavors the server does not understand itself (see
+// ServerOptions.Authenticate). The body must not be modified.
+func CredentialFromContext(ctx context.Context) (flavor uint32, body []byte, ok bool) {
+	c, ok := ctx.Value(credentialContextKey{}).(callCredential)
+	return c.cred.Flavor, c.cred.Body, ok
+}
+
+// VerifierFromContext is CredentialFromContext for the verifier of the
+// request's credential.
+func VerifierFromContext(ctx context.Context) (flavor uint32, body []byte, ok bool) {
+	c, ok := ctx.Value(credentialContextKey{}).(callCredential)
+	return c.verf.Flavor, c.verf.Body, ok
+}
+
+// GSSServiceFromContext returns the service protecting the request being
+// handled, when it is made with an RPCSEC_GSS credential, for handlers
+// requiring integrity or privacy of some exports.
+func GSSServiceFromContext(ctx context.Context) (GSSService, bool) {
+	c, ok := ctx.Value(credentialContextKey{}).(callCredential)
+	return c.service, ok && c.service != 0
+}
+
+// PrincipalFromContext returns the principal making the request being
+// handled, when its credential identifies one.
+func PrincipalFromContext(ctx context.Context) (string, bool) {
+	c, ok := ctx.Value(credentialContextKey{}).(callCredential)
+	if !ok {
+		return "", false
+	}
+	if c.principal != "" {
+		return c.principal, true
+	}
+	cred := c.cred
+	switch AuthFlavor(cred.Flavor) {
+	case AuthFlavorUnix:
+		unixCred, err := ParseAuthUnix(cred.Body)
+		if err != nil {
+			return "", false
+		}
+		return UnixPrincipal(unixCred.UID), true
+	}
+	return "", false
+}
+
+// checkCredential authenticates a call by the credential and verifier in
+// its header, returning the AuthError to reject it with if they are not
+// acceptable. Flavors other than AUTH_NULL and AUTH_UNIX are not
+// understood, and are left to authenticate if it is set; neither carries a
+// verifier.
+func checkCredential(hdr rpc.Header, authenticate func(cred, verf rpc.Auth) error) error {
+	switch AuthFlavor(hdr.Cred.Flavor) {
+	case AuthFlavorNull:
+		if len(hdr.Cred.Body) != 0 {
+			return &AuthError{AuthStatBadCred}
+		}
+	case AuthFlavorUnix:
+		if _, err := ParseAuthUnix(hdr.Cred.Body); err != nil {
+			return &AuthError{AuthStatBadCred}
+		}
+	default:
+		if authenticate == nil {
+			return &AuthError{AuthStatBadCred}
+		}
+		if err := authenticate(hdr.Cred, hdr.Verf); err != nil {
+			var authErr *AuthError
+			if errors.As(err, &authErr) {
+				return authErr
+			}
+			return &AuthError{AuthStatBadCred}
+		}
+		return nil
+	}
+	if AuthFlavor(hdr.Verf.Flavor) != AuthFlavorNull {
+		return &AuthError{AuthStatBadVerifier}
+	}
+	return nil
+}
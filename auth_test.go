@@ -0,0 +1,376 @@
+package nfs_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+func TestOperationAllowList(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/test": "hello"})
+	readOnly := []nfs.NFSProcedure{
+		nfs.NFSProcedureGetAttr, nfs.NFSProcedureLookup, nfs.NFSProcedureAccess,
+		nfs.NFSProcedureRead, nfs.NFSProcedureReadDir, nfs.NFSProcedureReadDirPlus,
+		nfs.NFSProcedureFSStat, nfs.NFSProcedureFSInfo, nfs.NFSProcedurePathConf,
+	}
+	srv := &nfs.Server{
+		Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024),
+		ServerOptions: nfs.ServerOptions{
+			AllowedOperations: nfs.OperationAllowList{nfs.UnixPrincipal(1000): readOnly},
+		},
+	}
+
+	backup := serveAndMount(t, srv, rpc.NewAuthUnix("backup", 1000, 1000).Auth())
+
+	f, err := backup.Open("/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, []byte("hello")) {
+		t.Fatalf("unexpected read %q", got)
+	}
+
+	wf, err := backup.OpenFile("/test", 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wf.Write([]byte("HELLO")); nfsStatus(err) != nfsc.NFS3ErrAcces {
+		t.Fatalf("expected ACCES writing as a read-only principal, got %v", err)
+	}
+
+	// Principals without an entry are not restricted.
+	other := serveAndMount(t, srv, rpc.NewAuthUnix("writer", 2000, 2000).Auth())
+	wf, err = other.OpenFile("/test", 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wf.Write([]byte("HELLO")); err != nil {
+		t.Fatalf("unrestricted principal failed to write: %v", err)
+	}
+}
+
+// callWithCredential sends a NULL call to srv with the given credential and
+// verifier, and returns the reply that follows its xid.
+func callWithCredential(t *testing.T, srv *nfs.Server, cred, verf rpc.Auth) io.Reader {
+	t.Helper()
+	return callProcWithCredential(t, srv, nfsc.Nfs3Prog, nfsc.Nfs3Vers, 0, cred, verf)
+}
+
+// callProcWithCredential is callWithCredential for the call proc of version
+// vers of prog, with args.
+func callProcWithCredential(t *testing.T, srv *nfs.Server, prog, vers, proc uint32, cred, verf rpc.Auth, args ...interface{}) io.Reader {
+	t.Helper()
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		_ = srv.Serve(listener)
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	call := new(bytes.Buffer)
+	if err := xdr.Write(call, &struct {
+		Xid  uint32
+		Type uint32
+		rpc.Header
+	}{
+		Xid: 1,
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    prog,
+			Vers:    vers,
+			Proc:    proc,
+			Cred:    cred,
+			Verf:    verf,
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range args {
+		if err := xdr.Write(call, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var fragment [4]byte
+	binary.BigEndian.PutUint32(fragment[:], uint32(call.Len())|1<<31)
+	if _, err := conn.Write(append(fragment[:], call.Bytes()...)); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, fragment[:]); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, binary.BigEndian.Uint32(fragment[:])&^(1<<31))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(reply[4:])
+}
+
+func TestAuthRejectedReply(t *testing.T) {
+	srv := &nfs.Server{Handler: helpers.NewNullAuthHandler(newTestFS(t, nil))}
+
+	for _, tc := range []struct {
+		name       string
+		cred, verf rpc.Auth
+		stat       nfs.AuthStat
+	}{
+		{"unsupported flavor", rpc.Auth{Flavor: uint32(nfs.AuthFlavorDES), Body: []byte{}}, rpc.AuthNull, nfs.AuthStatBadCred},
+		{"malformed unix credential", rpc.Auth{Flavor: uint32(nfs.AuthFlavorUnix), Body: []byte{1, 2}}, rpc.AuthNull, nfs.AuthStatBadCred},
+		{"unexpected verifier", rpc.NewAuthUnix("host", 1000, 1000).Auth(), rpc.Auth{Flavor: uint32(nfs.AuthFlavorDES), Body: []byte{}}, nfs.AuthStatBadVerifier},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res := callWithCredential(t, srv, tc.cred, tc.verf)
+			var reply struct {
+				Type       uint32
+				ReplyStat  uint32
+				RejectStat uint32
+				AuthStat   uint32
+			}
+			if err := xdr.Read(res, &reply); err != nil {
+				t.Fatal(err)
+			}
+			if reply.Type != 1 || reply.ReplyStat != rpc.MsgDenied {
+				t.Fatalf("expected a denied reply, got type %d stat %d", reply.Type, reply.ReplyStat)
+			}
+			if reply.RejectStat != 1 {
+				t.Fatalf("expected reject_stat AUTH_ERROR, got %d", reply.RejectStat)
+			}
+			if nfs.AuthStat(reply.AuthStat) != tc.stat {
+				t.Fatalf("expected auth_stat %d, got %d", tc.stat, reply.AuthStat)
+			}
+		})
+	}
+
+	// an acceptable credential is still answered.
+	res := callWithCredential(t, srv, rpc.NewAuthUnix("host", 1000, 1000).Auth(), rpc.AuthNull)
+	var reply struct {
+		Type       uint32
+		ReplyStat  uint32
+		Verf       rpc.Auth
+		AcceptStat uint32
+	}
+	if err := xdr.Read(res, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.ReplyStat != rpc.MsgAccepted || reply.AcceptStat != 0 {
+		t.Fatalf("expected a successful reply, got stat %d accept_stat %d", reply.ReplyStat, reply.AcceptStat)
+	}
+}
+
+// credentialHandler records the credential and verifier of the mounts it
+// is asked for.
+type credentialHandler struct {
+	nfs.Handler
+	cred, verf rpc.Auth
+}
+
+func (h *credentialHandler) Mount(ctx context.Context, conn net.Conn, req nfs.MountRequest) (nfs.MountStatus, billy.Filesystem, []nfs.AuthFlavor) {
+	h.cred.Flavor, h.cred.Body, _ = nfs.CredentialFromContext(ctx)
+	h.verf.Flavor, h.verf.Body, _ = nfs.VerifierFromContext(ctx)
+	return h.Handler.Mount(ctx, conn, req)
+}
+
+func TestCredentialFromContext(t *testing.T) {
+	const flavorToken = 0x4e465354
+	cred := rpc.Auth{Flavor: flavorToken, Body: []byte("token-1234")}
+	verf := rpc.Auth{Flavor: flavorToken, Body: []byte("signature")}
+	handler := &credentialHandler{Handler: helpers.NewNullAuthHandler(newTestFS(t, nil))}
+	srv := &nfs.Server{Handler: handler}
+
+	mountStatus := func(res io.Reader) uint32 {
+		t.Helper()
+		var reply struct {
+			Type       uint32
+			ReplyStat  uint32
+			Verf       rpc.Auth
+			AcceptStat uint32
+			Status     uint32
+		}
+		if err := xdr.Read(res, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.ReplyStat != rpc.MsgAccepted || reply.AcceptStat != 0 {
+			t.Fatalf("expected a successful reply, got stat %d accept_stat %d", reply.ReplyStat, reply.AcceptStat)
+		}
+		return reply.Status
+	}
+
+	// a flavor the server does not understand is rejected without a hook.
+	res := callProcWithCredential(t, srv, nfsc.MountProg, nfsc.MountVers, nfsc.MountProc3MNT, cred, verf, "/")
+	var denied struct {
+		Type      uint32
+		ReplyStat uint32
+	}
+	if err := xdr.Read(res, &denied); err != nil {
+		t.Fatal(err)
+	}
+	if denied.ReplyStat != rpc.MsgDenied {
+		t.Fatalf("expected an unknown flavor to be denied, got stat %d", denied.ReplyStat)
+	}
+
+	srv = &nfs.Server{Handler: handler, ServerOptions: nfs.ServerOptions{
+		Authenticate: func(c, v rpc.Auth) error {
+			if c.Flavor != flavorToken || string(c.Body) != "token-1234" {
+				return &nfs.AuthError{AuthStat: nfs.AuthStatRejectedCred}
+			}
+			return nil
+		},
+	}}
+	res = callProcWithCredential(t, srv, nfsc.MountProg, nfsc.MountVers, nfsc.MountProc3MNT, cred, verf, "/")
+	if status := mountStatus(res); status != nfsc.MNT3Ok {
+		t.Fatalf("mount failed with status %d", status)
+	}
+	if handler.cred.Flavor != cred.Flavor || !bytes.Equal(handler.cred.Body, cred.Body) {
+		t.Fatalf("handler saw credential %+v, sent %+v", handler.cred, cred)
+	}
+	if handler.verf.Flavor != verf.Flavor || !bytes.Equal(handler.verf.Body, verf.Body) {
+		t.Fatalf("handler saw verifier %+v, sent %+v", handler.verf, verf)
+	}
+
+	// the hook's rejection is sent to the client.
+	res = callProcWithCredential(t, srv, nfsc.MountProg, nfsc.MountVers, nfsc.MountProc3MNT, rpc.Auth{Flavor: flavorToken, Body: []byte("stolen")}, verf, "/")
+	var rejected struct {
+		Type       uint32
+		ReplyStat  uint32
+		RejectStat uint32
+		AuthStat   uint32
+	}
+	if err := xdr.Read(res, &rejected); err != nil {
+		t.Fatal(err)
+	}
+	if rejected.ReplyStat != rpc.MsgDenied || nfs.AuthStat(rejected.AuthStat) != nfs.AuthStatRejectedCred {
+		t.Fatalf("expected AUTH_REJECTEDCRED, got stat %d auth_stat %d", rejected.ReplyStat, rejected.AuthStat)
+	}
+
+	// the standard flavors are still read as before.
+	res = callProcWithCredential(t, srv, nfsc.MountProg, nfsc.MountVers, nfsc.MountProc3MNT, rpc.NewAuthUnix("host", 1000, 1000).Auth(), rpc.AuthNull, "/")
+	if status := mountStatus(res); status != nfsc.MNT3Ok {
+		t.Fatalf("mount failed with status %d", status)
+	}
+	if handler.cred.Flavor != uint32(nfs.AuthFlavorUnix) || handler.verf.Flavor != uint32(nfs.AuthFlavorNull) {
+		t.Fatalf("handler saw flavors %d and %d", handler.cred.Flavor, handler.verf.Flavor)
+	}
+}
+
+// tenantHandler serves each principal from its own file system, choosing
+// between them by the identity the request carries.
+type tenantHandler struct {
+	nfs.Handler
+	tenants map[string]billy.Filesystem
+}
+
+func (h *tenantHandler) FromHandleContext(ctx context.Context, fh []byte) (billy.Filesystem, []string, error) {
+	fs, p, err := h.Handler.FromHandle(fh)
+	if err != nil {
+		return nil, nil, err
+	}
+	if principal, ok := nfs.PrincipalFromContext(ctx); ok && h.tenants[principal] != nil {
+		return h.tenants[principal], p, nil
+	}
+	return fs, p, nil
+}
+
+func TestFromHandleContext(t *testing.T) {
+	alice := newTestFS(t, map[string]string{"/test": "alice"})
+	bob := newTestFS(t, map[string]string{"/test": "bob"})
+	srv := &nfs.Server{Handler: &tenantHandler{
+		Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(alice), 1024),
+		tenants: map[string]billy.Filesystem{
+			nfs.UnixPrincipal(1000): alice,
+			nfs.UnixPrincipal(2000): bob,
+		},
+	}}
+
+	for uid, want := range map[uint32]string{1000: "alice", 2000: "bob"} {
+		target := serveAndMount(t, srv, rpc.NewAuthUnix("host", uid, uid).Auth())
+		f, err := target.Open("/test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Fatalf("uid %d read %q, expected %q", uid, got, want)
+		}
+	}
+}
+
+func TestOperationAllowListLinkReply(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/test": "hello"})
+	srv := &nfs.Server{
+		Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024),
+		ServerOptions: nfs.ServerOptions{
+			AllowedOperations: nfs.OperationAllowList{nfs.UnixPrincipal(1000): {nfs.NFSProcedureLookup, nfs.NFSProcedureGetAttr, nfs.NFSProcedureFSInfo}},
+		},
+	}
+	cred := rpc.NewAuthUnix("backup", 1000, 1000).Auth()
+	target := serveAndMount(t, srv, cred)
+	_, fh, err := target.Lookup("/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, root := mount(t, target, "/")
+
+	res, err := target.Call(&struct {
+		rpc.Header
+		File []byte
+		Dir  []byte
+		Name string
+	}{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfsc.Nfs3Prog,
+			Vers:    nfsc.Nfs3Vers,
+			Proc:    uint32(nfs.NFSProcedureLink),
+			Cred:    cred,
+			Verf:    rpc.AuthNull,
+		},
+		File: fh,
+		Dir:  root,
+		Name: "hardlink",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// LINK3resfail: the file's post_op_attr, then the directory's wcc_data.
+	var reply struct {
+		Status   uint32
+		FileAttr nfsc.PostOpAttr
+		DirWcc   nfsc.WccData
+	}
+	if err := xdr.Read(res, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Status != nfsc.NFS3ErrAcces {
+		t.Fatalf("expected ACCES for a disallowed LINK, got %d", reply.Status)
+	}
+	if rest, _ := io.ReadAll(res); len(rest) != 0 {
+		t.Fatalf("LINK failure carried %d bytes past its body", len(rest))
+	}
+}
@@ -0,0 +1,37 @@
+package nfs
+
+import "sync"
+
+// getBuffer returns a buffer of n bytes for READ data, recycled
+// from earlier calls where possible. Its contents are undefined.
+func (s *Server) getBuffer(n uint32) []byte {
+	if b, ok := s.buffers.Get().(*[]byte); ok && uint32(cap(*b)) >= n {
+		return (*b)[:n]
+	}
+	size := s.maxTransferSize()
+	if n > size {
+		size = n
+	}
+	return make([]byte, n, size)
+}
+
+// putBuffer recycles a buffer from getBuffer, which must no longer be
+// referenced. Buffers larger than the transfer size are left to the
+// garbage collector, so the pool never holds on to them.
+func (s *Server) putBuffer(b []byte) {
+	if b == nil || uint32(cap(b)) > s.maxTransferSize() {
+		return
+	}
+	b = b[:0]
+	s.buffers.Put(&b)
+}
+
+// writeChunkSize is how much of the data of a WRITE is held in memory at
+// once, as it is copied from the call to the file.
+const writeChunkSize = 64 << 10
+
+// writeChunks recycles the buffers WRITE copies its data through.
+var writeChunks = sync.Pool{New: func() interface{} {
+	b := make([]byte, writeChunkSize)
+	return &b
+}}
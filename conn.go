@@ -9,6 +9,9 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"runtime/debug"
+	"time"
 
 	xdr2 "github.com/rasky/go-xdr/xdr2"
 	"github.com/willscott/go-nfs-client/nfs/rpc"
@@ -22,13 +25,15 @@ var (
 	ErrAlreadySent = errors.New("response already started")
 )
 
-// ResponseCode is a combination of accept_stat and reject_stat.
+// ResponseCode is a combination of accept_stat and reject_stat. The codes
+// of accepted replies are sent as their accept_stat.
 type ResponseCode uint32
 
 // ResponseCode Codes
 const (
 	ResponseCodeSuccess ResponseCode = iota
 	ResponseCodeProgUnavailable
+	ResponseCodeProgMismatch
 	ResponseCodeProcUnavailable
 	ResponseCodeGarbageArgs
 	ResponseCodeSystemErr
@@ -36,20 +41,33 @@ const (
 	ResponseCodeAuthError
 )
 
+// reject_stat Codes, the reasons a call is denied.
+const (
+	rejectStatRPCMismatch uint32 = 0
+	rejectStatAuthError   uint32 = 1
+)
+
 type conn struct {
 	*Server
-	writeSerializer chan []byte
+	writeSerializer chan reply
 	net.Conn
+	limiter *bandwidthLimiter
 }
 
 func (c *conn) serve(ctx context.Context) {
 	connCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	c.writeSerializer = make(chan []byte, 1)
+	c.writeSerializer = make(chan reply, 1)
 	go c.serializeWrites(connCtx)
+	// the writer accounts for every reply queued, until it is told none
+	// remain.
+	defer close(c.writeSerializer)
 
 	bio := bufio.NewReader(c.Conn)
 	for {
+		if c.IdleTimeout > 0 {
+			_ = c.SetReadDeadline(time.Now().Add(c.IdleTimeout))
+		}
 		w, err := c.readRequestHeader(connCtx, bio)
 		if err != nil {
 			if err == io.EOF {
@@ -57,72 +75,123 @@ func (c *conn) serve(ctx context.Context) {
 				c.Close()
 				return
 			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				c.logger().Debugf("closing connection from %v: idle for %v", c.RemoteAddr(), c.IdleTimeout)
+				c.Close()
+			}
+			return
+		}
+		if !c.Server.acquireSlot(connCtx) {
+			c.Close()
+			return
+		}
+		if !c.Server.beginRequest() {
+			c.Server.releaseSlot()
+			c.Close()
 			return
 		}
-		Log.Tracef("request: %v", w.req)
-		err = c.handle(connCtx, w)
+		if c.IdleTimeout > 0 {
+			// the call's arguments may take longer to arrive.
+			_ = c.SetReadDeadline(time.Time{})
+		}
+		w.logger().Tracef("request: %v", w.req)
+		err = c.handleOnce(connCtx, w)
 		respErr := w.finish(connCtx)
+		c.Server.releaseSlot()
 		if err != nil {
-			Log.Errorf("error handling req: %v", err)
+			c.logger().Errorf("error handling req: %v", err)
 			// failure to handle at a level needing to close the connection.
 			c.Close()
 			return
 		}
 		if respErr != nil {
-			Log.Errorf("error sending response: %v", respErr)
+			c.logger().Errorf("error sending response: %v", respErr)
 			c.Close()
 			return
 		}
 	}
 }
 
+// serializeWrites sends the replies queued on the connection in turn, until
+// serve closes the queue. Replies queued once ctx is done, or once a write
+// has failed, are dropped, releasing their files.
 func (c *conn) serializeWrites(ctx context.Context) {
 	// todo: maybe don't need the extra buffer
 	writer := bufio.NewWriter(c.Conn)
 	var fragmentBuf [4]byte
-	var fragmentInt uint32
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case msg, ok := <-c.writeSerializer:
-			if !ok {
-				return
-			}
-			// prepend the fragmentation header
-			fragmentInt = uint32(len(msg))
-			fragmentInt |= (1 << 31)
-			binary.BigEndian.PutUint32(fragmentBuf[:], fragmentInt)
-			n, err := writer.Write(fragmentBuf[:])
-			if n < 4 || err != nil {
-				return
-			}
-			n, err = writer.Write(msg)
-			if err != nil {
-				return
-			}
-			if n < len(msg) {
-				panic("todo: ensure writes complete fully.")
-			}
-			if err = writer.Flush(); err != nil {
-				return
+	var err error
+	for r := range c.writeSerializer {
+		if err == nil && ctx.Err() == nil {
+			if err = c.writeReply(writer, fragmentBuf[:], r); err != nil {
+				// discard the replies that follow until the
+				// connection is done with.
+				c.Close()
 			}
+		} else {
+			r.file.close()
 		}
+		c.Server.inFlight.Done()
 	}
 }
 
+// writeReply sends r on the connection with its fragmentation header.
+func (c *conn) writeReply(writer *bufio.Writer, fragmentBuf []byte, r reply) error {
+	defer r.file.close()
+	fragmentInt := uint32(len(r.msg) + r.file.length())
+	fragmentInt |= (1 << 31)
+	binary.BigEndian.PutUint32(fragmentBuf, fragmentInt)
+	n, err := writer.Write(fragmentBuf)
+	if err != nil {
+		return err
+	}
+	if n < 4 {
+		return io.ErrShortWrite
+	}
+	n, err = writer.Write(r.msg)
+	if err != nil {
+		return err
+	}
+	if n < len(r.msg) {
+		panic("todo: ensure writes complete fully.")
+	}
+	if r.file != nil {
+		if err := c.writeSection(writer, r.file); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
 // Handle a request. errors from this method indicate a failure to read or
 // write on the network stream, and trigger a disconnection of the connection.
 func (c *conn) handle(ctx context.Context, w *response) error {
+	defer w.recordMetrics(time.Now())
+	authErr := c.authenticate(w)
+	if authErr != nil {
+		w.logger().Debugf("rejecting call: %v", authErr)
+		if err := w.drain(ctx); err != nil {
+			return err
+		}
+		return c.err(ctx, w, authErr)
+	}
+	if w.responded || w.dropped {
+		// an RPCSEC_GSS control call, answered in authenticating it, or a
+		// call outside its context's sequence window.
+		return w.drain(ctx)
+	}
 	handler := c.Server.handlerFor(w.req.Header.Prog, w.req.Header.Proc)
 	if handler == nil {
-		Log.Errorf("No handler for %d.%d", w.req.Header.Prog, w.req.Header.Proc)
+		w.logger().Errorf("No handler for %d.%d", w.req.Header.Prog, w.req.Header.Proc)
 		if err := w.drain(ctx); err != nil {
 			return err
 		}
 		return c.err(ctx, w, &ResponseCodeProcUnavailableError{})
 	}
-	appError := handler(ctx, w, c.Server.Handler)
+	ctx = contextWithCredential(ctx, w.credential())
+	appError := c.Server.checkOperation(ctx, w)
+	if appError == nil {
+		appError = c.invoke(ctx, handler, w)
+	}
 	if drainErr := w.drain(ctx); drainErr != nil {
 		return drainErr
 	}
@@ -132,14 +201,104 @@ func (c *conn) handle(ctx context.Context, w *response) error {
 		}
 	}
 	if !w.responded {
-		Log.Errorf("Handler did not indicate response status via writing or erroring")
+		w.logger().Errorf("Handler did not indicate response status via writing or erroring")
 		if err := c.err(ctx, w, &ResponseCodeSystemError{}); err != nil {
 			return err
 		}
 	}
+	if err := w.protectResults(); err != nil {
+		w.logger().Errorf("failed to protect results: %v", err)
+		w.writer.Reset()
+		w.responded = false
+		w.file.close()
+		w.file = nil
+		return c.err(ctx, w, &ResponseCodeSystemError{})
+	}
 	return nil
 }
 
+// authenticate checks the credential of the call w answers, returning the
+// AuthError to reject it with if it is not acceptable.
+func (c *conn) authenticate(w *response) error {
+	if c.Server.GSS != nil && AuthFlavor(w.req.Header.Cred.Flavor) == AuthFlavorRPCSECGSS {
+		if !c.Server.allowsClient(c.RemoteAddr()) {
+			return &AuthError{AuthStatTooWeak}
+		}
+		return c.authenticateGSS(w)
+	}
+	authErr := checkCredential(w.req.Header, c.Server.Authenticate)
+	if authErr == nil && !c.Server.allowsClient(c.RemoteAddr()) {
+		authErr = &AuthError{AuthStatTooWeak}
+	}
+	if authErr == nil && c.Server.RequireGSSService != 0 && w.req.Header.Proc != 0 {
+		authErr = &AuthError{AuthStatTooWeak}
+	}
+	return authErr
+}
+
+// credential returns what the call's credential establishes, for handlers
+// to find in its context.
+func (w *response) credential() callCredential {
+	cred := callCredential{cred: w.req.Header.Cred, verf: w.req.Header.Verf}
+	if w.gss != nil {
+		cred.principal, _ = w.gss.session.established()
+		cred.service = w.gss.service
+	}
+	return cred
+}
+
+// invoke runs handler for w, converting a panic into a ServerFault (or
+// system error, outside of the nfs program) reply so one bad request
+// does not take down the server, unless DisablePanicRecovery is set.
+func (c *conn) invoke(ctx context.Context, handler HandleFunc, w *response) (err error) {
+	if c.Server.DisablePanicRecovery {
+		return handler(ctx, w, c.Server.Handler)
+	}
+	var args *bytes.Buffer
+	if body, ok := w.req.Body.(*io.LimitedReader); ok && c.Server.LogPanicArguments {
+		args = bytes.NewBuffer(make([]byte, 0, body.N))
+		body.R = io.TeeReader(body.R, args)
+	}
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		w.logger().Errorf("panic: %v\n%s\n%s", r, describeCall(w.req, args), debug.Stack())
+
+		// Discard anything the handler had started to write.
+		w.writer.Reset()
+		w.responded = false
+		if w.req.Header.Prog != nfsServiceID {
+			err = &ResponseCodeSystemError{}
+			return
+		}
+		w.errorFmt = errorFormatterFor(NFSProcedure(w.req.Header.Proc))
+		err = &NFSStatusError{NFSStatusServerFault, fmt.Errorf("panic: %v", r)}
+	}()
+	return handler(ctx, w, c.Server.Handler)
+}
+
+// describeCall summarizes the arguments of a call for logging. args holds
+// the part of the body read so far, when the server is retaining it.
+func describeCall(req *request, args *bytes.Buffer) string {
+	desc := fmt.Sprintf("prog=%d vers=%d proc=%d cred=%d", req.Header.Prog, req.Header.Vers, req.Header.Proc, req.Header.Cred.Flavor)
+	if args == nil {
+		return desc
+	}
+	raw := args.Bytes()
+	// Every nfs procedure but NULL leads with the file handle it acts on.
+	if req.Header.Prog == nfsServiceID && req.Header.Proc != uint32(NFSProcedureNull) {
+		if len(raw) >= 4 {
+			if n := binary.BigEndian.Uint32(raw); n <= uint32(len(raw)-4) {
+				desc += " handle=" + HandleString(raw[4:4+n])
+			}
+		}
+	}
+	return desc + fmt.Sprintf(" args=%x", raw)
+}
+
 func (c *conn) err(ctx context.Context, w *response, err error) error {
 	select {
 	case <-ctx.Done():
@@ -171,12 +330,19 @@ type request struct {
 }
 
 func (r *request) String() string {
+	return fmt.Sprintf("RPC #%d (%s)", r.xid, r.op())
+}
+
+// op names the procedure called, with its program.
+func (r *request) op() string {
 	if r.Header.Prog == nfsServiceID {
-		return fmt.Sprintf("RPC #%d (nfs.%s)", r.xid, NFSProcedure(r.Header.Proc))
+		return "nfs." + NFSProcedure(r.Header.Proc).String()
 	} else if r.Header.Prog == mountServiceID {
-		return fmt.Sprintf("RPC #%d (mount.%s)", r.xid, MountProcedure(r.Header.Proc))
+		return "mount." + MountProcedure(r.Header.Proc).String()
+	} else if r.Header.Prog == ExtensionProgram {
+		return "ext." + ExtensionProcedure(r.Header.Proc).String()
 	}
-	return fmt.Sprintf("RPC #%d (%d.%d)", r.xid, r.Header.Prog, r.Header.Proc)
+	return fmt.Sprintf("%d.%d", r.Header.Prog, r.Header.Proc)
 }
 
 type response struct {
@@ -186,6 +352,23 @@ type response struct {
 	err       error
 	errorFmt  func(error) RPCError
 	req       *request
+	// handle is the file handle the request acts on, once known.
+	handle []byte
+	// file, if set, is sent after the reply as the end of its data.
+	file *fileSection
+	// verf is the verifier of an accepted reply, AUTH_NULL if unset.
+	verf rpc.Auth
+	// resultsAt is where the results of a successful reply begin.
+	resultsAt int
+	// gss is the RPCSEC_GSS context the call is made in, if any.
+	gss *gssCall
+	// dropped is set for a call that is not to be answered.
+	dropped bool
+}
+
+// logger returns a logger that tags messages with the request's details.
+func (w *response) logger() *requestLogger {
+	return &requestLogger{w.conn.logger(), w}
 }
 
 func (w *response) writeXdrHeader() error {
@@ -210,25 +393,34 @@ func (w *response) writeHeader(code ResponseCode) error {
 		return err
 	}
 
-	status := rpc.MsgAccepted
-	if code == ResponseCodeAuthError || code == ResponseCodeRPCMismatch {
-		status = rpc.MsgDenied
+	// A denied reply carries a reject_stat in place of the verifier and
+	// accept_stat of an accepted one.
+	switch code {
+	case ResponseCodeRPCMismatch:
+		return xdr.Write(w.writer, [2]uint32{rpc.MsgDenied, rejectStatRPCMismatch})
+	case ResponseCodeAuthError:
+		return xdr.Write(w.writer, [2]uint32{rpc.MsgDenied, rejectStatAuthError})
 	}
 
-	err := xdr.Write(w.writer, &status)
-	if err != nil {
+	status := uint32(rpc.MsgAccepted)
+	if err := xdr.Write(w.writer, &status); err != nil {
 		return err
 	}
-
-	if status == rpc.MsgAccepted {
-		// Write opaque_auth header.
-		err = xdr.Write(w.writer, &rpc.AuthNull)
-		if err != nil {
-			return err
-		}
+	// Write opaque_auth header.
+	verf := &rpc.AuthNull
+	if w.verf.Flavor != uint32(AuthFlavorNull) {
+		verf = &w.verf
 	}
-
-	return xdr.Write(w.writer, &code)
+	if err := xdr.Write(w.writer, verf); err != nil {
+		return err
+	}
+	if err := xdr.Write(w.writer, &code); err != nil {
+		return err
+	}
+	if code == ResponseCodeSuccess {
+		w.resultsAt = w.writer.Len()
+	}
+	return nil
 }
 
 // Write a response to an xdr message
@@ -267,10 +459,16 @@ func (w *response) drain(ctx context.Context) error {
 }
 
 func (w *response) finish(ctx context.Context) error {
+	if w.dropped {
+		w.Server.inFlight.Done()
+		return nil
+	}
 	select {
-	case w.conn.writeSerializer <- w.writer.Bytes():
+	case w.conn.writeSerializer <- reply{w.writer.Bytes(), w.file}:
 		return nil
 	case <-ctx.Done():
+		w.file.close()
+		w.Server.inFlight.Done()
 		return ctx.Err()
 	}
 }
@@ -278,29 +476,33 @@ func (w *response) finish(ctx context.Context) error {
 func (c *conn) readRequestHeader(ctx context.Context, reader *bufio.Reader) (w *response, err error) {
 	fragment, err := xdr.ReadUint32(reader)
 	if err != nil {
-		if xdrErr, ok := err.(*xdr2.UnmarshalError); ok {
-			if xdrErr.Err == io.EOF {
-				return nil, io.EOF
-			}
+		// surface the I/O error waiting for the call, such as the end of
+		// the stream or an idle timeout.
+		if xdrErr, ok := err.(*xdr2.UnmarshalError); ok && xdrErr.Err != nil {
+			return nil, xdrErr.Err
 		}
 		return nil, err
 	}
 	if fragment&(1<<31) == 0 {
-		Log.Warnf("Warning: haven't implemented fragment reconstruction.\n")
+		c.logger().Warnf("Warning: haven't implemented fragment reconstruction.\n")
 		return nil, ErrInputInvalid
 	}
 	reqLen := fragment - uint32(1<<31)
-	if reqLen < 40 {
+	return c.readRequest(&io.LimitedReader{R: reader, N: int64(reqLen)})
+}
+
+// readRequest reads the header of the call whose message r is limited to,
+// leaving its arguments to be read by the procedure's handler.
+func (c *conn) readRequest(r *io.LimitedReader) (w *response, err error) {
+	if r.N < 40 {
 		return nil, ErrInputInvalid
 	}
 
-	r := io.LimitedReader{R: reader, N: int64(reqLen)}
-
-	xid, err := xdr.ReadUint32(&r)
+	xid, err := xdr.ReadUint32(r)
 	if err != nil {
 		return nil, err
 	}
-	reqType, err := xdr.ReadUint32(&r)
+	reqType, err := xdr.ReadUint32(r)
 	if err != nil {
 		return nil, err
 	}
@@ -311,9 +513,9 @@ func (c *conn) readRequestHeader(ctx context.Context, reader *bufio.Reader) (w *
 	req := request{
 		xid,
 		rpc.Header{},
-		&r,
+		r,
 	}
-	if err = xdr.Read(&r, &req.Header); err != nil {
+	if err = xdr.Read(r, &req.Header); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,102 @@
+package nfs_test
+
+import (
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+)
+
+// panicFS panics when the named file is looked up, standing in for a
+// buggy backend.
+type panicFS struct {
+	billy.Filesystem
+	name string
+}
+
+func (p *panicFS) Lstat(filename string) (os.FileInfo, error) {
+	if strings.TrimPrefix(filename, "/") == p.name {
+		panic("backend bug")
+	}
+	return p.Filesystem.Lstat(filename)
+}
+
+func TestHandlerPanicRecovery(t *testing.T) {
+	logs := &captureLogger{}
+	mem := &panicFS{newTestFS(t, map[string]string{"/test": "hello"}), "boom"}
+	srv := &nfs.Server{
+		Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024),
+		ServerOptions: nfs.ServerOptions{LogPanicArguments: true, Logger: logs},
+	}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+
+	if _, _, err := target.Lookup("/boom"); nfsStatus(err) != nfsc.NFS3ErrServerFault {
+		t.Fatalf("expected SERVERFAULT from a panicking handler, got %v", err)
+	}
+
+	line := logs.find("panic:")
+	if line == "" {
+		t.Fatal("expected the panic to be logged")
+	}
+	for _, want := range []string{"nfs.Lookup", "backend bug", "handle=", "args=", "goroutine"} {
+		if !strings.Contains(line, want) {
+			t.Fatalf("panic log is missing %q: %s", want, line)
+		}
+	}
+
+	// The connection and server survive the panic.
+	if _, _, err := target.Lookup("/test"); err != nil {
+		t.Fatalf("lookup after panic failed: %v", err)
+	}
+}
+
+// pipeListener hands out one end of a pipe as its only connection.
+type pipeListener struct {
+	conns chan net.Conn
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	c, ok := <-l.conns
+	if !ok {
+		return nil, net.ErrClosed
+	}
+	return c, nil
+}
+
+func (l *pipeListener) Close() error   { return nil }
+func (l *pipeListener) Addr() net.Addr { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+
+func TestIdleTimeout(t *testing.T) {
+	const idle = 100 * time.Millisecond
+
+	client, server := net.Pipe()
+	t.Cleanup(func() { _ = client.Close() })
+	l := &pipeListener{conns: make(chan net.Conn, 1)}
+	l.conns <- server
+	srv := &nfs.Server{
+		Handler:       helpers.NewNullAuthHandler(newTestFS(t, nil)),
+		ServerOptions: nfs.ServerOptions{IdleTimeout: idle},
+	}
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	t.Cleanup(func() { close(l.conns) })
+
+	start := time.Now()
+	_ = client.SetReadDeadline(start.Add(10 * idle))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the idle connection to be closed, got %v", err)
+	}
+	if waited := time.Since(start); waited < idle {
+		t.Fatalf("connection closed after %v, before the idle timeout", waited)
+	}
+}
@@ -0,0 +1,159 @@
+package nfs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxDuplicateRequests bounds the replies a server keeps for retransmits.
+const maxDuplicateRequests = 4096
+
+// duplicateRequests caches the replies to calls that modify the file system,
+// so that a client retransmitting one, as it does when a reply is lost with
+// its connection, is answered as the original was rather than having the
+// call run a second time.
+type duplicateRequests struct {
+	mu      sync.Mutex
+	entries map[duplicateKey]*duplicateEntry
+}
+
+// duplicateKey identifies a call by its client's address, which survives
+// the client reconnecting, the xid the client gave it and the credential
+// it was made with, which a retransmit repeats.
+type duplicateKey struct {
+	peer   string
+	xid    uint32
+	prog   uint32
+	vers   uint32
+	proc   uint32
+	flavor uint32
+	cred   string
+}
+
+type duplicateEntry struct {
+	// done is closed once the original call has been answered.
+	done chan struct{}
+	// reply is the reply to the original call, or nil if it was not sent.
+	reply  []byte
+	expiry time.Time
+}
+
+// begin returns the entry for key, and whether it was created for this call
+// rather than left by an earlier one. No entry is returned once the cache
+// is full of replies too recent to expire.
+func (d *duplicateRequests) begin(key duplicateKey) (*duplicateEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	if e, ok := d.entries[key]; ok {
+		if !isClosed(e.done) || now.Before(e.expiry) {
+			return e, false
+		}
+		delete(d.entries, key)
+	}
+	if d.entries == nil {
+		d.entries = make(map[duplicateKey]*duplicateEntry)
+	}
+	if len(d.entries) >= maxDuplicateRequests {
+		for k, e := range d.entries {
+			if isClosed(e.done) && !now.Before(e.expiry) {
+				delete(d.entries, k)
+			}
+		}
+		if len(d.entries) >= maxDuplicateRequests {
+			return nil, false
+		}
+	}
+	e := &duplicateEntry{done: make(chan struct{})}
+	d.entries[key] = e
+	return e, true
+}
+
+// finish records reply as the answer to the call e was begun for, to be
+// replayed for ttl. A nil reply forgets the call, so that a retransmit of
+// it is run.
+func (d *duplicateRequests) finish(key duplicateKey, e *duplicateEntry, reply []byte, ttl time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if reply == nil {
+		delete(d.entries, key)
+	} else {
+		e.reply = append([]byte(nil), reply...)
+		e.expiry = time.Now().Add(ttl)
+	}
+	close(e.done)
+}
+
+// wait returns the reply to the original call of e once it is answered,
+// or nil if it went unanswered or ctx is done first.
+func (e *duplicateEntry) wait(ctx context.Context) []byte {
+	select {
+	case <-e.done:
+		return e.reply
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// duplicateKey returns the key of the call w answers, and whether its reply
+// is one to keep for retransmits.
+func (c *conn) duplicateKey(w *response) (duplicateKey, bool) {
+	if c.DuplicateRequestTTL <= 0 || w.req.Header.Prog != nfsServiceID ||
+		!modifiesFS(NFSProcedure(w.req.Header.Proc)) || c.RemoteAddr() == nil {
+		return duplicateKey{}, false
+	}
+	return duplicateKey{
+		peer:   peerOf(c.RemoteAddr()),
+		xid:    w.req.xid,
+		prog:   w.req.Header.Prog,
+		vers:   w.req.Header.Vers,
+		proc:   w.req.Header.Proc,
+		flavor: w.req.Header.Cred.Flavor,
+		cred:   string(w.req.Header.Cred.Body),
+	}, true
+}
+
+// handleOnce is handle, except that a retransmit of a call modifying the
+// file system is answered with the reply to the original while that is
+// kept, waiting for the original to finish if it is still running.
+func (c *conn) handleOnce(ctx context.Context, w *response) error {
+	key, ok := c.duplicateKey(w)
+	if !ok {
+		return c.handle(ctx, w)
+	}
+	e, original := c.Server.duplicates.begin(key)
+	if e == nil {
+		return c.handle(ctx, w)
+	}
+	if !original {
+		reply := e.wait(ctx)
+		if reply == nil {
+			return c.handle(ctx, w)
+		}
+		w.logger().Debugf("replaying the reply to a retransmitted call")
+		if err := w.drain(ctx); err != nil {
+			return err
+		}
+		w.responded = true
+		_, err := w.writer.Write(reply)
+		return err
+	}
+
+	err := c.handle(ctx, w)
+	var reply []byte
+	if err == nil && w.responded && w.file == nil {
+		reply = w.writer.Bytes()
+	}
+	c.Server.duplicates.finish(key, e, reply, c.DuplicateRequestTTL)
+	return err
+}
@@ -0,0 +1,138 @@
+package nfs_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// countingRemoveFS counts the removals reaching the backend.
+type countingRemoveFS struct {
+	billy.Filesystem
+	removes atomic.Int32
+}
+
+func (f *countingRemoveFS) Remove(filename string) error {
+	f.removes.Add(1)
+	return f.Filesystem.Remove(filename)
+}
+
+// callXid sends a REMOVE of name from dir on conn with the given xid, and
+// returns the reply, from its xid on.
+func callXid(t *testing.T, conn net.Conn, xid uint32, dir []byte, name string) []byte {
+	t.Helper()
+	call := new(bytes.Buffer)
+	if err := xdr.Write(call, &struct {
+		Xid  uint32
+		Type uint32
+		rpc.Header
+		Dir  []byte
+		Name string
+	}{
+		Xid: xid,
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfsc.Nfs3Prog,
+			Vers:    nfsc.Nfs3Vers,
+			Proc:    nfsc.NFSProc3Remove,
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		Dir:  dir,
+		Name: name,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var fragment [4]byte
+	binary.BigEndian.PutUint32(fragment[:], uint32(call.Len())|1<<31)
+	if _, err := conn.Write(append(fragment[:], call.Bytes()...)); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, fragment[:]); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, binary.BigEndian.Uint32(fragment[:])&^(1<<31))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+// removeStatus returns the nfs status of a REMOVE reply from callXid.
+func removeStatus(t *testing.T, reply []byte) uint32 {
+	t.Helper()
+	var head struct {
+		Xid       uint32
+		Type      uint32
+		ReplyStat uint32
+		Verf      rpc.Auth
+		Accept    uint32
+		Status    uint32
+	}
+	if err := xdr.Read(bytes.NewReader(reply), &head); err != nil {
+		t.Fatal(err)
+	}
+	return head.Status
+}
+
+func TestDuplicateRequestReplayed(t *testing.T) {
+	fs := &countingRemoveFS{Filesystem: newTestFS(t, map[string]string{"/a": "a", "/b": "b"})}
+	srv := &nfs.Server{
+		Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024),
+		ServerOptions: nfs.ServerOptions{DuplicateRequestTTL: time.Minute},
+	}
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		_ = srv.Serve(listener)
+	}()
+	target := &nfsc.Target{Client: dial(t, listener.Addr())}
+	_, root := mount(t, target, "/")
+
+	open := func() net.Conn {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		return conn
+	}
+	first := open()
+
+	original := callXid(t, first, 7, root, "a")
+	if status := removeStatus(t, original); status != nfsc.NFS3Ok {
+		t.Fatalf("remove failed with status %d", status)
+	}
+	// a retransmit, whether on the same connection or, after reconnecting,
+	// another, gets the original reply without the removal being repeated.
+	for _, conn := range []net.Conn{first, open()} {
+		if again := callXid(t, conn, 7, root, "a"); !bytes.Equal(again, original) {
+			t.Fatalf("retransmit was answered %x, not the original %x", again, original)
+		}
+	}
+	if n := fs.removes.Load(); n != 1 {
+		t.Fatalf("expected the backend to see one removal, saw %d", n)
+	}
+
+	// another call is run, even for the same name.
+	if status := removeStatus(t, callXid(t, first, 8, root, "a")); status != nfsc.NFS3ErrNoEnt {
+		t.Fatalf("expected NOENT removing a removed file, got %d", status)
+	}
+}
@@ -5,7 +5,10 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"os"
 	"syscall"
+
+	"github.com/go-git/go-billy/v5"
 )
 
 // RPCError provides the error interface for errors thrown by
@@ -91,7 +94,7 @@ func (a *AuthError) Error() string {
 // MarshalBinary sends the specific auth status
 func (a *AuthError) MarshalBinary() (data []byte, err error) {
 	var resp [4]byte
-	binary.LittleEndian.PutUint32(resp[:], uint32(a.AuthStat))
+	binary.BigEndian.PutUint32(resp[:], uint32(a.AuthStat))
 	return resp[:], nil
 }
 
@@ -113,8 +116,8 @@ func (r *RPCMismatchError) Error() string {
 // MarshalBinary sends the specific rpc mismatch range
 func (r *RPCMismatchError) MarshalBinary() (data []byte, err error) {
 	var resp [8]byte
-	binary.LittleEndian.PutUint32(resp[0:4], uint32(r.Low))
-	binary.LittleEndian.PutUint32(resp[4:8], uint32(r.High))
+	binary.BigEndian.PutUint32(resp[0:4], uint32(r.Low))
+	binary.BigEndian.PutUint32(resp[4:8], uint32(r.High))
 	return resp[:], nil
 }
 
@@ -136,6 +139,24 @@ func (r *ResponseCodeProcUnavailableError) MarshalBinary() (data []byte, err err
 	return []byte{}, nil
 }
 
+// ResponseCodeGarbageArgsError is an RPCError
+type ResponseCodeGarbageArgsError struct {
+}
+
+// Code for ResponseCodeGarbageArgsError
+func (r *ResponseCodeGarbageArgsError) Code() ResponseCode {
+	return ResponseCodeGarbageArgs
+}
+
+func (r *ResponseCodeGarbageArgsError) Error() string {
+	return "The arguments could not be decoded"
+}
+
+// MarshalBinary - this error has no associated body
+func (r *ResponseCodeGarbageArgsError) MarshalBinary() (data []byte, err error) {
+	return []byte{}, nil
+}
+
 // ResponseCodeSystemError is an RPCError
 type ResponseCodeSystemError struct {
 }
@@ -230,19 +251,75 @@ var (
 	wccDataErrorFormatter = errFormatterWithBody(wccDataErrorBody[:])
 )
 
-// statusFromWriteError maps write errors to NFS status codes
-func statusFromWriteError(err error) NFSStatus {
-	if err == nil {
-		return NFSStatusOk
+// errorFormatterFor provides the formatter matching the failure body of an
+// NFS procedure's reply, for errors raised before the procedure is run.
+func errorFormatterFor(proc NFSProcedure) func(error) RPCError {
+	switch proc {
+	case NFSProcedureNull, NFSProcedureGetAttr:
+		return basicErrorFormatter
+	case NFSProcedureLookup, NFSProcedureAccess, NFSProcedureReadlink, NFSProcedureRead,
+		NFSProcedureReadDir, NFSProcedureReadDirPlus, NFSProcedureFSStat, NFSProcedureFSInfo,
+		NFSProcedurePathConf:
+		return opAttrErrorFormatter
+	case NFSProcedureRename:
+		return errFormatterWithBody(doubleWccErrorBody[:])
+	case NFSProcedureLink:
+		return errFormatterWithBody(linkErrorBody[:])
+	default:
+		return wccDataErrorFormatter
 	}
-	if errors.Is(err, syscall.ENOSPC) {
+}
+
+// mapError maps an error from a billy backend to the NFS status that
+// describes it to clients, falling back to NFSStatusIO.
+func mapError(err error) NFSStatus {
+	var nerr *NFSStatusError
+	switch {
+	case err == nil:
+		return NFSStatusOk
+	case errors.As(err, &nerr):
+		return nerr.NFSStatus
+	case errors.Is(err, billy.ErrNotSupported), errors.Is(err, syscall.ENOTSUP), errors.Is(err, syscall.ENOSYS):
+		return NFSStatusNotSupp
+	case os.IsNotExist(err):
+		return NFSStatusNoEnt
+	case os.IsPermission(err):
+		return NFSStatusAccess
+	case errors.Is(err, syscall.ENOTEMPTY):
+		// checked before EEXIST, as os.IsExist matches ENOTEMPTY too.
+		return NFSStatusNotEmpty
+	case os.IsExist(err):
+		return NFSStatusExist
+	case errors.Is(err, syscall.ENOSPC):
 		return NFSStatusNoSPC
-	}
-	if errors.Is(err, syscall.EDQUOT) {
+	case errors.Is(err, syscall.EDQUOT):
 		return NFSStatusDQuot
-	}
-	if errors.Is(err, syscall.EFBIG) {
+	case errors.Is(err, syscall.EFBIG):
 		return NFSStatusFBig
+	case errors.Is(err, syscall.ENOTDIR):
+		return NFSStatusNotDir
+	case errors.Is(err, syscall.EISDIR):
+		return NFSStatusIsDir
+	case errors.Is(err, syscall.EROFS):
+		return NFSStatusROFS
+	case errors.Is(err, syscall.EXDEV):
+		return NFSStatusXDev
+	case errors.Is(err, syscall.EMLINK):
+		return NFSStatusMlink
+	case errors.Is(err, syscall.ENAMETOOLONG):
+		return NFSStatusNameTooLong
+	case errors.Is(err, syscall.ELOOP):
+		return NFSStatusLoop
 	}
 	return NFSStatusIO
 }
+
+// statusFromRemoveError maps errors removing a file or directory to NFS
+// status codes
+func statusFromRemoveError(err error) NFSStatus {
+	if errors.Is(err, syscall.EEXIST) {
+		// some systems report a directory that is not empty as EEXIST.
+		return NFSStatusNotEmpty
+	}
+	return mapError(err)
+}
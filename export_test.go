@@ -0,0 +1,8 @@
+package nfs
+
+import "time"
+
+// SetClock has s tell the time by now, for tests to move it by hand.
+func SetClock(s *Server, now func() time.Time) {
+	s.now = now
+}
@@ -0,0 +1,139 @@
+package nfs
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// ExtensionProgram is the RPC program of the sideband procedures this server
+// offers cooperating clients alongside NFSv3, numbered from the range RFC
+// 5531 leaves to users. Clients unaware of it are unaffected.
+const ExtensionProgram = 0x2000_6e66
+
+// ExtensionVersion is the version of ExtensionProgram served.
+const ExtensionVersion = 1
+
+// ExtensionProcedure is a procedure of ExtensionProgram.
+type ExtensionProcedure uint32
+
+// ExtensionProcedure Codes
+const (
+	ExtensionProcNull ExtensionProcedure = iota
+	// ExtensionProcReadIfModified is READ, except that it takes the mtime
+	// of the client's cached copy of the file after the READ arguments,
+	// and returns no data while the file's mtime is unchanged. Its
+	// successful reply holds the file's post_op_attr and a bool telling
+	// whether the file was modified, followed by the READ results if so.
+	ExtensionProcReadIfModified
+	// ExtensionProcGetxattr takes a file handle and the name of an extended
+	// attribute, and its successful reply holds the file's post_op_attr
+	// and the attribute's value as opaque data.
+	ExtensionProcGetxattr
+	// ExtensionProcSetxattr takes a file handle, the name of an extended
+	// attribute and its value as opaque data, and sets it. Its reply holds
+	// the file's post_op_attr.
+	ExtensionProcSetxattr
+	// ExtensionProcListxattr takes a file handle, and its successful reply
+	// holds the file's post_op_attr and the names of its extended
+	// attributes, as an array of strings.
+	ExtensionProcListxattr
+)
+
+func (e ExtensionProcedure) String() string {
+	switch e {
+	case ExtensionProcNull:
+		return "Null"
+	case ExtensionProcReadIfModified:
+		return "ReadIfModified"
+	case ExtensionProcGetxattr:
+		return "Getxattr"
+	case ExtensionProcSetxattr:
+		return "Setxattr"
+	case ExtensionProcListxattr:
+		return "Listxattr"
+	default:
+		return "Unknown"
+	}
+}
+
+// standsFor returns the NFS procedure whose policy an extension procedure
+// is held to, as it reads or changes what that procedure does. NULL has
+// none.
+func (e ExtensionProcedure) standsFor() (NFSProcedure, bool) {
+	switch e {
+	case ExtensionProcReadIfModified:
+		return NFSProcedureRead, true
+	case ExtensionProcGetxattr, ExtensionProcListxattr:
+		return NFSProcedureGetAttr, true
+	case ExtensionProcSetxattr:
+		return NFSProcedureSetAttr, true
+	default:
+		return NFSProcedureNull, false
+	}
+}
+
+func init() {
+	_ = RegisterMessageHandler(ExtensionProgram, uint32(ExtensionProcNull), onNull)
+	_ = RegisterMessageHandler(ExtensionProgram, uint32(ExtensionProcReadIfModified), onReadIfModified)
+	_ = RegisterMessageHandler(ExtensionProgram, uint32(ExtensionProcGetxattr), onGetxattr)
+	_ = RegisterMessageHandler(ExtensionProgram, uint32(ExtensionProcSetxattr), onSetxattr)
+	_ = RegisterMessageHandler(ExtensionProgram, uint32(ExtensionProcListxattr), onListxattr)
+}
+
+type readIfModifiedArgs struct {
+	Handle []byte
+	Offset uint64
+	Count  uint32
+	Mtime  FileTime
+}
+
+func onReadIfModified(ctx context.Context, w *response, userHandle Handler) error {
+	w.errorFmt = opAttrErrorFormatter
+	var obj readIfModifiedArgs
+	if err := xdr.Read(w.req.Body, &obj); err != nil {
+		return &NFSStatusError{NFSStatusInval, err}
+	}
+	fs, path, err := w.fromHandle(ctx, userHandle, obj.Handle)
+	if err != nil {
+		return &NFSStatusError{NFSStatusStale, err}
+	}
+
+	writer := bytes.NewBuffer([]byte{})
+	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	if attrs := w.tryStat(userHandle, fs, path); attrs != nil && attrs.Mtime == obj.Mtime {
+		if err := WritePostOpAttrs(writer, attrs); err != nil {
+			return &NFSStatusError{NFSStatusServerFault, err}
+		}
+		if err := xdr.Write(writer, false); err != nil {
+			return &NFSStatusError{NFSStatusServerFault, err}
+		}
+		if err := w.Write(writer.Bytes()); err != nil {
+			return &NFSStatusError{NFSStatusServerFault, err}
+		}
+		return nil
+	}
+
+	read := nfsReadArgs{Handle: obj.Handle, Offset: obj.Offset, Count: obj.Count}
+	postOp, resp, err := w.readFile(ctx, userHandle, fs, path, read)
+	if err != nil {
+		return err
+	}
+	defer w.Server.putBuffer(resp.Data)
+	if err := WritePostOpAttrs(writer, postOp); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	if err := xdr.Write(writer, true); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	if err := xdr.Write(writer, resp); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	if err := w.Write(writer.Bytes()); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	return nil
+}
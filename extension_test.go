@@ -0,0 +1,108 @@
+package nfs_test
+
+import (
+	"testing"
+
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+type readIfModifiedReply struct {
+	Status   uint32
+	Attrs    nfsc.PostOpAttr
+	Modified bool
+	Count    uint32
+	EOF      bool
+	Data     []byte
+}
+
+// readIfModified reads count bytes at offset unless the file's mtime is
+// still mtime.
+func readIfModified(t *testing.T, target *nfsc.Target, fh []byte, offset uint64, count uint32, mtime nfsc.NFS3Time) readIfModifiedReply {
+	t.Helper()
+	res, err := target.Call(&struct {
+		rpc.Header
+		FH     []byte
+		Offset uint64
+		Count  uint32
+		Mtime  nfsc.NFS3Time
+	}{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfs.ExtensionProgram,
+			Vers:    nfs.ExtensionVersion,
+			Proc:    uint32(nfs.ExtensionProcReadIfModified),
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		FH:     fh,
+		Offset: offset,
+		Count:  count,
+		Mtime:  mtime,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply readIfModifiedReply
+	if reply.Status, err = xdr.ReadUint32(res); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Status != nfsc.NFS3Ok {
+		t.Fatalf("read failed with status %d", reply.Status)
+	}
+	if err := xdr.Read(res, &reply.Attrs); err != nil {
+		t.Fatal(err)
+	}
+	modified, err := xdr.ReadUint32(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply.Modified = modified != 0
+	if !reply.Modified {
+		return reply
+	}
+	var data struct {
+		Count uint32
+		EOF   bool
+		Data  []byte
+	}
+	if err := xdr.Read(res, &data); err != nil {
+		t.Fatal(err)
+	}
+	reply.Count, reply.EOF, reply.Data = data.Count, data.EOF, data.Data
+	return reply
+}
+
+func TestReadIfModified(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/file": "hello"})
+	srv := &nfs.Server{Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+
+	_, fh, err := target.Lookup("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := target.GetAttr(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current := readIfModified(t, target, fh, 0, 100, attrs.Mtime)
+	if current.Modified || len(current.Data) != 0 {
+		t.Fatalf("expected no data for an unchanged file, got %q", current.Data)
+	}
+	if !current.Attrs.IsSet || current.Attrs.Attr.Mtime != attrs.Mtime {
+		t.Fatal("expected the file's attributes with a not-modified reply")
+	}
+
+	stale := attrs.Mtime
+	stale.Seconds--
+	changed := readIfModified(t, target, fh, 0, 100, stale)
+	if !changed.Modified || string(changed.Data) != "hello" || !changed.EOF {
+		t.Fatalf("expected the file's data for a stale mtime, got %q (modified %v, eof %v)", changed.Data, changed.Modified, changed.EOF)
+	}
+}
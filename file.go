@@ -6,6 +6,8 @@ import (
 	"io"
 	"math"
 	"os"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-git/go-billy/v5"
@@ -87,9 +89,24 @@ func (f FileAttribute) AsCache() *FileCacheAttribute {
 
 // ToFileAttribute creates an NFS fattr3 struct from an OS.FileInfo
 func ToFileAttribute(info os.FileInfo, filePath string) *FileAttribute {
+	return toFileAttribute(info, filePath, 0)
+}
+
+// toFileAttribute is ToFileAttribute, reporting defaultPerm as the
+// permissions of files info gives none, as backends without modes do.
+// Directories are also given execute permission wherever defaultPerm
+// grants read.
+func toFileAttribute(info os.FileInfo, filePath string, defaultPerm os.FileMode) *FileAttribute {
 	f := FileAttribute{}
 
 	m := info.Mode()
+	if m.Perm() == 0 && defaultPerm != 0 {
+		perm := defaultPerm.Perm()
+		if info.IsDir() {
+			perm |= (perm & 0444) >> 2
+		}
+		m |= perm
+	}
 	f.FileMode = uint32(m)
 	if info.IsDir() {
 		f.Type = FileTypeDirectory
@@ -115,10 +132,11 @@ func ToFileAttribute(info os.FileInfo, filePath string) *FileAttribute {
 		f.GID = a.GID
 		f.SpecData = [2]uint32{a.Major, a.Minor}
 		f.Fileid = a.Fileid
-	} else {
-		hasher := fnv.New64()
-		_, _ = hasher.Write([]byte(filePath))
-		f.Fileid = hasher.Sum64()
+	}
+	if f.Fileid == 0 {
+		// Clients key their caches on the fileid, so one must never be
+		// zero or vary between calls for the same file.
+		f.Fileid = PathFileID(filePath)
 	}
 
 	f.Filesize = uint64(info.Size())
@@ -129,18 +147,171 @@ func ToFileAttribute(info os.FileInfo, filePath string) *FileAttribute {
 	return &f
 }
 
+// rootInfo describes the root of an export as a directory, whatever else
+// its backend made of it: clients refuse to mount a root of any other type.
+type rootInfo struct {
+	os.FileInfo
+}
+
+func (r rootInfo) IsDir() bool { return true }
+
+func (r rootInfo) Mode() os.FileMode {
+	perm := r.FileInfo.Mode().Perm()
+	if perm == 0 {
+		perm = 0755
+	}
+	return os.ModeDir | perm
+}
+
+// exportRoot returns info, the FileInfo of the root of an export, as a
+// directory.
+func exportRoot(info os.FileInfo) os.FileInfo {
+	if info.IsDir() && info.Mode().IsDir() {
+		return info
+	}
+	return rootInfo{info}
+}
+
+// fileAttribute is ToFileAttribute for the file at path, numbered by
+// userHandle if it implements FileIDHandler, with times truncated to its
+// TimeGranularity, and given the server's DefaultFileMode if it has no
+// permissions. Its fsid is that of fs, never
+// one of the file's own, so that clients see no boundaries within fs.
+func (w *response) fileAttribute(userHandle Handler, fs billy.Filesystem, info os.FileInfo, path []string) *FileAttribute {
+	if len(path) == 0 {
+		info = exportRoot(info)
+	}
+	attrs := toFileAttribute(info, fs.Join(path...), w.DefaultFileMode)
+	if ids, ok := userHandle.(FSIDHandler); ok {
+		attrs.FSID = ids.FSIDFor(fs)
+	}
+	if ids, ok := userHandle.(FileIDHandler); ok {
+		if id := ids.FileIDFor(fs, path); id != 0 {
+			attrs.Fileid = id
+		}
+	}
+	if d := timeGranularity(userHandle, fs); d > 0 {
+		attrs.Atime = attrs.Atime.Truncate(d)
+		attrs.Mtime = attrs.Mtime.Truncate(d)
+		attrs.Ctime = attrs.Ctime.Truncate(d)
+	}
+	return attrs
+}
+
+// TimeGranularityHandler is implemented by handlers whose backends keep
+// times more coarsely than they report them, such as those reporting
+// second-resolution mtimes with noise below the second. The times of the
+// files in fs are reported truncated to the granularity, and FSINFO gives
+// it as the server's time_delta, so that clients caching attributes see no
+// changes that are not there.
+type TimeGranularityHandler interface {
+	TimeGranularity(fs billy.Filesystem) time.Duration
+}
+
+// timeGranularity returns the granularity of the times of files in fs, or
+// zero if they are reported as they are.
+func timeGranularity(userHandle Handler, fs billy.Filesystem) time.Duration {
+	if th, ok := userHandle.(TimeGranularityHandler); ok {
+		return th.TimeGranularity(fs)
+	}
+	return 0
+}
+
+// PathFileID derives a stable fileid from the joined path of a file, for
+// backends that do not report inode numbers.
+func PathFileID(filePath string) uint64 {
+	hasher := fnv.New64()
+	_, _ = hasher.Write([]byte(filePath))
+	return hasher.Sum64()
+}
+
 // tryStat attempts to create a FileAttribute from a path.
-func tryStat(fs billy.Filesystem, path []string) *FileAttribute {
+func (w *response) tryStat(userHandle Handler, fs billy.Filesystem, path []string) *FileAttribute {
 	fullPath := fs.Join(path...)
 	attrs, err := fs.Lstat(fullPath)
 	if err != nil || attrs == nil {
-		Log.Errorf("err loading attrs for %s: %v", fs.Join(path...), err)
+		w.logger().Errorf("err loading attrs for %s: %v", fs.Join(path...), err)
 		return nil
 	}
-	return ToFileAttribute(attrs, fullPath)
+	return w.fileAttribute(userHandle, fs, attrs, path)
+}
+
+// resolvePath follows the symlinks along path, returning the path of the
+// object it leads to. Absolute symlinks resolve from the root of fs. As a
+// cycle of symlinks would never resolve, it fails with NFSStatusLoop once
+// it has followed maxHops of them.
+func resolvePath(fs billy.Filesystem, path []string, maxHops int) ([]string, error) {
+	resolved := make([]string, 0, len(path))
+	pending := append([]string{}, path...)
+	hops := 0
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) > 0 {
+				resolved = resolved[:len(resolved)-1]
+			}
+			continue
+		}
+
+		next := joinPath(resolved, name)
+		info, err := fs.Lstat(fs.Join(next...))
+		if err != nil {
+			return nil, &NFSStatusError{mapError(err), err}
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			if len(pending) > 0 && !info.IsDir() {
+				return nil, &NFSStatusError{NFSStatusNotDir, nil}
+			}
+			resolved = next
+			continue
+		}
+
+		hops++
+		if hops > maxHops {
+			return nil, &NFSStatusError{NFSStatusLoop, syscall.ELOOP}
+		}
+		target, err := fs.Readlink(fs.Join(next...))
+		if err != nil {
+			return nil, &NFSStatusError{NFSStatusIO, err}
+		}
+		if strings.HasPrefix(target, "/") {
+			resolved = resolved[:0]
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+	return resolved, nil
+}
+
+// statDir stats the directory that a handle names, for operations on its
+// children. A directory that no longer exists makes the handle stale, which
+// clients must be able to tell apart from a missing child.
+func statDir(fs billy.Filesystem, path []string) (os.FileInfo, error) {
+	info, err := fs.Stat(fs.Join(path...))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &NFSStatusError{NFSStatusStale, err}
+		}
+		if os.IsPermission(err) {
+			return nil, &NFSStatusError{NFSStatusAccess, err}
+		}
+		return nil, &NFSStatusError{NFSStatusIO, err}
+	}
+	if len(path) == 0 {
+		info = exportRoot(info)
+	}
+	if !info.IsDir() {
+		return nil, &NFSStatusError{NFSStatusNotDir, nil}
+	}
+	return info, nil
 }
 
-// WriteWcc writes the `wcc_data` representation of an object.
+// WriteWcc writes the `wcc_data` representation of an object. Either
+// attributes may be nil, as when the object could not be stat'd before or
+// after the operation, and are then sent as absent.
 func WriteWcc(writer io.Writer, pre *FileCacheAttribute, post *FileAttribute) error {
 	if pre == nil {
 		if err := xdr.Write(writer, uint32(0)); err != nil {
@@ -186,6 +357,18 @@ func WritePostOpAttrs(writer io.Writer, post *FileAttribute) error {
 	return nil
 }
 
+// writePostOpHandle writes the `post_op_fh3` representation of fh, which
+// follows only if it is not nil.
+func writePostOpHandle(writer io.Writer, fh []byte) error {
+	if fh == nil {
+		return xdr.Write(writer, uint32(0))
+	}
+	if err := xdr.Write(writer, uint32(1)); err != nil {
+		return err
+	}
+	return xdr.Write(writer, fh)
+}
+
 // SetFileAttributes represents a command to update some metadata
 // about a file.
 type SetFileAttributes struct {
@@ -206,7 +389,7 @@ func (s *SetFileAttributes) Apply(changer billy.Change, fs billy.Filesystem, fil
 	} else if errors.Is(err, os.ErrPermission) {
 		return &NFSStatusError{NFSStatusAccess, os.ErrPermission}
 	} else if err != nil {
-		return nil
+		return &NFSStatusError{NFSStatusIO, err}
 	}
 	curr := ToFileAttribute(curOS, file)
 
@@ -217,10 +400,7 @@ func (s *SetFileAttributes) Apply(changer billy.Change, fs billy.Filesystem, fil
 				return &NFSStatusError{NFSStatusNotSupp, os.ErrPermission}
 			}
 			if err := changer.Chmod(file, mode); err != nil {
-				if errors.Is(err, os.ErrPermission) {
-					return &NFSStatusError{NFSStatusAccess, os.ErrPermission}
-				}
-				return err
+				return setAttrError(err)
 			}
 		}
 	}
@@ -238,10 +418,7 @@ func (s *SetFileAttributes) Apply(changer billy.Change, fs billy.Filesystem, fil
 				return &NFSStatusError{NFSStatusNotSupp, os.ErrPermission}
 			}
 			if err := changer.Lchown(file, int(euid), int(egid)); err != nil {
-				if errors.Is(err, os.ErrPermission) {
-					return &NFSStatusError{NFSStatusAccess, os.ErrPermission}
-				}
-				return err
+				return setAttrError(err)
 			}
 		}
 	}
@@ -249,20 +426,23 @@ func (s *SetFileAttributes) Apply(changer billy.Change, fs billy.Filesystem, fil
 		if curr.Mode()&os.ModeSymlink != 0 {
 			return &NFSStatusError{NFSStatusNotSupp, os.ErrInvalid}
 		}
-		fp, err := fs.OpenFile(file, os.O_WRONLY|os.O_EXCL, 0)
-		if errors.Is(err, os.ErrPermission) {
-			return &NFSStatusError{NFSStatusAccess, err}
-		} else if err != nil {
-			return err
+		if !curr.Mode().IsRegular() {
+			// only regular files have a size that can be set.
+			return &NFSStatusError{NFSStatusInval, os.ErrInvalid}
 		}
 		if *s.SetSize > math.MaxInt64 {
-			return &NFSStatusError{NFSStatusInval, os.ErrInvalid}
+			return &NFSStatusError{NFSStatusFBig, os.ErrInvalid}
+		}
+		fp, err := fs.OpenFile(file, os.O_WRONLY, 0)
+		if err != nil {
+			return setAttrError(err)
 		}
 		if err := fp.Truncate(int64(*s.SetSize)); err != nil {
-			return err
+			_ = fp.Close()
+			return setAttrError(err)
 		}
 		if err := fp.Close(); err != nil {
-			return err
+			return setAttrError(err)
 		}
 	}
 
@@ -280,16 +460,25 @@ func (s *SetFileAttributes) Apply(changer billy.Change, fs billy.Filesystem, fil
 				return &NFSStatusError{NFSStatusNotSupp, os.ErrPermission}
 			}
 			if err := changer.Chtimes(file, *atime, *mtime); err != nil {
-				if errors.Is(err, os.ErrPermission) {
-					return &NFSStatusError{NFSStatusAccess, err}
-				}
-				return err
+				return setAttrError(err)
 			}
 		}
 	}
 	return nil
 }
 
+// setAttrError maps a failure to apply an attribute to an NFS status.
+func setAttrError(err error) error {
+	var nerr *NFSStatusError
+	if errors.As(err, &nerr) {
+		return err
+	}
+	if errors.Is(err, os.ErrPermission) {
+		return &NFSStatusError{NFSStatusAccess, err}
+	}
+	return &NFSStatusError{mapError(err), err}
+}
+
 // Mode returns a mode if specified or the provided default mode.
 func (s *SetFileAttributes) Mode(def os.FileMode) os.FileMode {
 	if s.SetMode != nil {
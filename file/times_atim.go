@@ -0,0 +1,13 @@
+//go:build dragonfly || linux || openbsd || solaris
+
+package file
+
+import (
+	"syscall"
+	"time"
+)
+
+// statTimes returns the access and change times of a stat result.
+func statTimes(s *syscall.Stat_t) (time.Time, time.Time) {
+	return time.Unix(s.Atim.Unix()), time.Unix(s.Ctim.Unix())
+}
@@ -0,0 +1,13 @@
+//go:build darwin || freebsd || netbsd
+
+package file
+
+import (
+	"syscall"
+	"time"
+)
+
+// statTimes returns the access and change times of a stat result.
+func statTimes(s *syscall.Stat_t) (time.Time, time.Time) {
+	return time.Unix(s.Atimespec.Unix()), time.Unix(s.Ctimespec.Unix())
+}
@@ -0,0 +1,54 @@
+package nfs_test
+
+import (
+	"bytes"
+	"testing"
+
+	nfs "github.com/willscott/go-nfs"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+func TestWriteWcc(t *testing.T) {
+	pre := &nfs.FileCacheAttribute{Filesize: 3, Mtime: nfs.FileTime{Seconds: 10}, Ctime: nfs.FileTime{Seconds: 11}}
+	post := &nfs.FileAttribute{Type: nfs.FileTypeDirectory, Filesize: 5, Mtime: nfs.FileTime{Seconds: 20}}
+
+	for _, tc := range []struct {
+		name      string
+		pre       *nfs.FileCacheAttribute
+		post      *nfs.FileAttribute
+		wantBytes int
+	}{
+		{"none", nil, nil, 8},
+		{"no pre", nil, post, 8 + 84},
+		{"no post", pre, nil, 8 + 24},
+		{"both", pre, post, 8 + 24 + 84},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			if err := nfs.WriteWcc(buf, tc.pre, tc.post); err != nil {
+				t.Fatal(err)
+			}
+			if buf.Len() != tc.wantBytes {
+				t.Fatalf("wrote %d bytes of wcc_data, want %d", buf.Len(), tc.wantBytes)
+			}
+			var wcc nfsc.WccData
+			if err := xdr.Read(buf, &wcc); err != nil {
+				t.Fatal(err)
+			}
+			if wcc.Before.IsSet != (tc.pre != nil) || wcc.After.IsSet != (tc.post != nil) {
+				t.Fatalf("decoded pre set %v and post set %v", wcc.Before.IsSet, wcc.After.IsSet)
+			}
+			if tc.pre != nil && (wcc.Before.Size != 3 || wcc.Before.MTime.Seconds != 10 || wcc.Before.CTime.Seconds != 11) {
+				t.Fatalf("decoded pre-op attributes %+v", wcc.Before)
+			}
+			if tc.post != nil && (wcc.After.Attr.Filesize != 5 || wcc.After.Attr.Mtime.Seconds != 20 || !wcc.After.Attr.IsDir()) {
+				t.Fatalf("decoded post-op attributes %+v", wcc.After.Attr)
+			}
+			if buf.Len() != 0 {
+				t.Fatalf("%d bytes left over after the wcc_data", buf.Len())
+			}
+		})
+	}
+}
@@ -13,3 +13,20 @@ type FSStat struct {
 	// CacheHint is called "invarsec" in the nfs standard
 	CacheHint time.Duration
 }
+
+// clamp makes the statistics self-consistent, as clients expect
+// avail <= free <= total for both space and file counts.
+func (s *FSStat) clamp() {
+	if s.FreeSize > s.TotalSize {
+		s.FreeSize = s.TotalSize
+	}
+	if s.AvailableSize > s.FreeSize {
+		s.AvailableSize = s.FreeSize
+	}
+	if s.FreeFiles > s.TotalFiles {
+		s.FreeFiles = s.TotalFiles
+	}
+	if s.AvailableFiles > s.FreeFiles {
+		s.AvailableFiles = s.FreeFiles
+	}
+}
@@ -0,0 +1,524 @@
+package nfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// GSSProvider supplies the security mechanism, such as Kerberos V5, of the
+// RPCSEC_GSS flavor of rfc2203, which the server authenticates calls with
+// when ServerOptions.GSS is set. The server runs the flavor's protocol,
+// and the provider the GSS-API calls it makes.
+type GSSProvider interface {
+	// NewContext starts accepting a security context a client is
+	// establishing.
+	NewContext() (GSSContext, error)
+}
+
+// GSSContext is the acceptor's side of a GSS-API security context.
+type GSSContext interface {
+	// Accept takes a token the client sent to establish the context, as
+	// GSS_Accept_sec_context does, and returns the token to send back and
+	// whether the context is established or awaits another token. An error
+	// fails the establishment, with the status of a *GSSError, or
+	// GSS_S_FAILURE for any other.
+	Accept(token []byte) (reply []byte, complete bool, err error)
+	// Principal names the client of an established context, as
+	// PrincipalFromContext reports it; "alice@EXAMPLE.COM" for instance.
+	Principal() string
+	// GetMIC returns a checksum of msg, as GSS_GetMIC does.
+	GetMIC(msg []byte) ([]byte, error)
+	// VerifyMIC checks mic is a checksum of msg, as GSS_VerifyMIC does.
+	VerifyMIC(msg, mic []byte) error
+	// Wrap encrypts msg for the client, as GSS_Wrap does when asked for
+	// confidentiality. It is only used by the privacy service.
+	Wrap(msg []byte) ([]byte, error)
+	// Unwrap decrypts a message the client wrapped, as GSS_Unwrap does.
+	Unwrap(msg []byte) ([]byte, error)
+	// Delete releases the context once the client destroys it, or the
+	// server discards it.
+	Delete()
+}
+
+// GSS-API major status codes, per rfc2744 section 3.9.1, which a reply to
+// context creation carries.
+const (
+	GSSComplete       uint32 = 0
+	GSSContinueNeeded uint32 = 1
+	GSSDefectiveToken uint32 = 9 << 16
+	GSSContextExpired uint32 = 12 << 16
+	GSSFailure        uint32 = 13 << 16
+)
+
+// gssRoutineErrorMask selects the routine error of a major status, which
+// is zero unless the call failed.
+const gssRoutineErrorMask = 0xff << 16
+
+// GSSError is a failure of the GSS-API, with its major and minor status.
+type GSSError struct {
+	Major, Minor uint32
+}
+
+func (e *GSSError) Error() string {
+	return fmt.Sprintf("gss failure: major status %#x, minor status %d", e.Major, e.Minor)
+}
+
+// gssStatus returns the major and minor status to report err with.
+func gssStatus(err error) (uint32, uint32) {
+	var gssErr *GSSError
+	if errors.As(err, &gssErr) && gssErr.Major&gssRoutineErrorMask != 0 {
+		return gssErr.Major, gssErr.Minor
+	}
+	return GSSFailure, 0
+}
+
+// GSSService is the protection RPCSEC_GSS gives the arguments and results
+// of a call, per rfc2203 section 5.3.1.
+type GSSService uint32
+
+// GSSService Codes
+const (
+	GSSServiceNone      GSSService = 1
+	GSSServiceIntegrity GSSService = 2
+	GSSServicePrivacy   GSSService = 3
+)
+
+// The control procedures of RPCSEC_GSS, rpc_gss_proc_t.
+const (
+	gssProcData         = 0
+	gssProcInit         = 1
+	gssProcContinueInit = 2
+	gssProcDestroy      = 3
+)
+
+const (
+	// gssMaxSeq is the sequence number from which a context cannot be
+	// used, and must be replaced.
+	gssMaxSeq = 0x80000000
+	// gssSeqWindow is the spread of sequence numbers accepted out of
+	// order on a context, as calls in flight together may arrive.
+	gssSeqWindow = 128
+	// maxGSSContexts bounds the established contexts the server keeps,
+	// those unused longest being discarded to make room for others as
+	// they are established.
+	maxGSSContexts = 4096
+	// maxPendingGSSContexts bounds the contexts being established, which
+	// any client can start, those unused longest being discarded to make
+	// room.
+	maxPendingGSSContexts = 64
+)
+
+// gssCredential is the body of an RPCSEC_GSS credential, rpc_gss_cred_t.
+type gssCredential struct {
+	Version uint32
+	Proc    uint32
+	Seq     uint32
+	Service GSSService
+	Handle  []byte
+}
+
+// gssInitResult is the reply to context creation, rpc_gss_init_res.
+type gssInitResult struct {
+	Handle    []byte
+	Major     uint32
+	Minor     uint32
+	SeqWindow uint32
+	Token     []byte
+}
+
+// gssSession is a context a client established, or is establishing, with
+// the server.
+type gssSession struct {
+	GSSContext
+	handle string
+
+	mu        sync.Mutex
+	complete  bool
+	principal string
+	used      time.Time
+	// seqMax is the highest sequence number seen, and seen the sequence
+	// numbers in the window below it that have been, by seq%gssSeqWindow.
+	seqMax  uint32
+	started bool
+	seen    [gssSeqWindow]bool
+}
+
+// established returns the principal of the context, once it is.
+func (s *gssSession) established() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.principal, s.complete
+}
+
+// admit reports whether seq is neither a replay nor too old for the window.
+func (s *gssSession) admit(seq uint32) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case !s.started || seq > s.seqMax:
+		if !s.started || seq-s.seqMax >= gssSeqWindow {
+			s.seen = [gssSeqWindow]bool{}
+		} else {
+			for i := s.seqMax + 1; i < seq; i++ {
+				s.seen[i%gssSeqWindow] = false
+			}
+		}
+		s.started = true
+		s.seqMax = seq
+	case s.seqMax-seq >= gssSeqWindow || s.seen[seq%gssSeqWindow]:
+		return false
+	}
+	s.seen[seq%gssSeqWindow] = true
+	return true
+}
+
+// gssSessions are the contexts of a server, by handle. Contexts being
+// established are kept apart from those that are, so that clients starting
+// contexts they do not finish cannot displace those others have.
+type gssSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*gssSession
+	pending  map[string]*gssSession
+}
+
+// add creates a pending session for ctx, discarding the pending session
+// unused longest if there are too many.
+func (t *gssSessions) add(ctx GSSContext) (*gssSession, error) {
+	var handle [8]byte
+	if _, err := rand.Read(handle[:]); err != nil {
+		return nil, err
+	}
+	s := &gssSession{GSSContext: ctx, handle: string(handle[:]), used: time.Now()}
+
+	t.mu.Lock()
+	if t.pending == nil {
+		t.pending = make(map[string]*gssSession)
+	}
+	evicted := evictOldest(t.pending, maxPendingGSSContexts)
+	t.pending[s.handle] = s
+	t.mu.Unlock()
+
+	if evicted != nil {
+		evicted.Delete()
+	}
+	return s, nil
+}
+
+// establish records that the pending session s has been established,
+// discarding the established session unused longest if there are too
+// many. It returns false if s was discarded before it could be.
+func (t *gssSessions) establish(s *gssSession) bool {
+	principal := s.Principal()
+	t.mu.Lock()
+	if _, ok := t.pending[s.handle]; !ok {
+		t.mu.Unlock()
+		return false
+	}
+	delete(t.pending, s.handle)
+	if t.sessions == nil {
+		t.sessions = make(map[string]*gssSession)
+	}
+	evicted := evictOldest(t.sessions, maxGSSContexts)
+	s.mu.Lock()
+	s.complete = true
+	s.principal = principal
+	s.mu.Unlock()
+	t.sessions[s.handle] = s
+	t.mu.Unlock()
+
+	if evicted != nil {
+		evicted.Delete()
+	}
+	return true
+}
+
+// evictOldest removes the session of sessions unused longest if it holds
+// max of them, returning it. It expects the table's lock to be held.
+func evictOldest(sessions map[string]*gssSession, max int) *gssSession {
+	if len(sessions) < max {
+		return nil
+	}
+	var evicted *gssSession
+	for _, o := range sessions {
+		if evicted == nil || o.used.Before(evicted.used) {
+			evicted = o
+		}
+	}
+	delete(sessions, evicted.handle)
+	return evicted
+}
+
+// get returns the session of handle, established or pending, if there is
+// one.
+func (t *gssSessions) get(handle []byte) *gssSession {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.sessions[string(handle)]
+	if s == nil {
+		s = t.pending[string(handle)]
+	}
+	if s != nil {
+		s.used = time.Now()
+	}
+	return s
+}
+
+// remove discards s, deleting its context.
+func (t *gssSessions) remove(s *gssSession) {
+	t.mu.Lock()
+	_, ok := t.sessions[s.handle]
+	_, pending := t.pending[s.handle]
+	delete(t.sessions, s.handle)
+	delete(t.pending, s.handle)
+	t.mu.Unlock()
+	if ok || pending {
+		s.Delete()
+	}
+}
+
+// gssCall is the RPCSEC_GSS context a call is made in.
+type gssCall struct {
+	session *gssSession
+	seq     uint32
+	service GSSService
+}
+
+// authenticateGSS authenticates a call made with an RPCSEC_GSS credential,
+// returning the AuthError to reject it with if it is not acceptable.
+// Context creation and destruction are answered here, and calls outside
+// the sequence window dropped; otherwise the call's arguments are
+// unprotected for its handler, and w made to protect its results.
+func (c *conn) authenticateGSS(w *response) error {
+	var cred gssCredential
+	if err := xdr.Read(bytes.NewReader(w.req.Header.Cred.Body), &cred); err != nil || cred.Version != 1 {
+		return &AuthError{AuthStatBadCred}
+	}
+	switch cred.Proc {
+	case gssProcInit, gssProcContinueInit:
+		return c.initGSS(w, cred)
+	case gssProcData, gssProcDestroy:
+	default:
+		return &AuthError{AuthStatBadCred}
+	}
+
+	sessions := &c.Server.gssSessions
+	session := sessions.get(cred.Handle)
+	if session == nil {
+		return &AuthError{AuthStatRPCGSSCredProblem}
+	}
+	if _, ok := session.established(); !ok {
+		return &AuthError{AuthStatRPCGSSCredProblem}
+	}
+	if cred.Seq >= gssMaxSeq {
+		sessions.remove(session)
+		return &AuthError{AuthStatRPCGSSCTXProblem}
+	}
+	if AuthFlavor(w.req.Header.Verf.Flavor) != AuthFlavorRPCSECGSS {
+		return &AuthError{AuthStatBadVerifier}
+	}
+	if err := session.VerifyMIC(gssHeader(w.req), w.req.Header.Verf.Body); err != nil {
+		if major, _ := gssStatus(err); major == GSSContextExpired {
+			sessions.remove(session)
+			return &AuthError{AuthStatRPCGSSCTXProblem}
+		}
+		return &AuthError{AuthStatBadVerifier}
+	}
+	if !session.admit(cred.Seq) {
+		w.logger().Debugf("dropping call with sequence number %d outside the window", cred.Seq)
+		w.dropped = true
+		return nil
+	}
+	verf, err := session.GetMIC(xdrUint32(cred.Seq))
+	if err != nil {
+		return &AuthError{AuthStatRPCGSSCTXProblem}
+	}
+	w.verf = rpc.Auth{Flavor: uint32(AuthFlavorRPCSECGSS), Body: verf}
+
+	if cred.Proc == gssProcDestroy {
+		if w.req.Header.Proc != 0 {
+			return &AuthError{AuthStatBadCred}
+		}
+		sessions.remove(session)
+		return w.Write(nil)
+	}
+	if cred.Service < c.Server.RequireGSSService && w.req.Header.Proc != 0 {
+		return &AuthError{AuthStatTooWeak}
+	}
+	w.gss = &gssCall{session, cred.Seq, cred.Service}
+	return w.unprotectArguments()
+}
+
+// initGSS answers a call creating a context, or continuing to.
+func (c *conn) initGSS(w *response, cred gssCredential) error {
+	if w.req.Header.Proc != 0 {
+		return &AuthError{AuthStatBadCred}
+	}
+	if AuthFlavor(w.req.Header.Verf.Flavor) != AuthFlavorNull {
+		return &AuthError{AuthStatBadVerifier}
+	}
+	token, err := xdr.ReadOpaque(w.req.Body)
+	if err != nil {
+		return &ResponseCodeGarbageArgsError{}
+	}
+
+	sessions := &c.Server.gssSessions
+	var session *gssSession
+	if cred.Proc == gssProcInit {
+		ctx, err := c.Server.GSS.NewContext()
+		if err != nil {
+			major, minor := gssStatus(err)
+			return w.writeGSSInit(gssInitResult{Major: major, Minor: minor})
+		}
+		if session, err = sessions.add(ctx); err != nil {
+			ctx.Delete()
+			return &ResponseCodeSystemError{}
+		}
+	} else if session = sessions.get(cred.Handle); session == nil {
+		return &AuthError{AuthStatRPCGSSCredProblem}
+	} else if _, ok := session.established(); ok {
+		return &AuthError{AuthStatRPCGSSCredProblem}
+	}
+
+	reply, complete, err := session.Accept(token)
+	var window []byte
+	if err == nil && complete {
+		window, err = session.GetMIC(xdrUint32(gssSeqWindow))
+	}
+	if err != nil {
+		w.logger().Debugf("failed to establish a gss context: %v", err)
+		sessions.remove(session)
+		major, minor := gssStatus(err)
+		return w.writeGSSInit(gssInitResult{Major: major, Minor: minor, Token: reply})
+	}
+	res := gssInitResult{Handle: []byte(session.handle), SeqWindow: gssSeqWindow, Token: reply}
+	if complete {
+		if !sessions.establish(session) {
+			return w.writeGSSInit(gssInitResult{Major: GSSFailure})
+		}
+		w.verf = rpc.Auth{Flavor: uint32(AuthFlavorRPCSECGSS), Body: window}
+	} else {
+		res.Major = GSSContinueNeeded
+	}
+	return w.writeGSSInit(res)
+}
+
+// writeGSSInit replies to context creation with res.
+func (w *response) writeGSSInit(res gssInitResult) error {
+	if res.Handle == nil {
+		res.Handle = []byte{}
+	}
+	if res.Token == nil {
+		res.Token = []byte{}
+	}
+	writer := bytes.NewBuffer([]byte{})
+	if err := xdr.Write(writer, &res); err != nil {
+		return err
+	}
+	return w.Write(writer.Bytes())
+}
+
+// unprotectArguments replaces the body of a call made with the integrity
+// or privacy service by the arguments it protects.
+func (w *response) unprotectArguments() error {
+	var body []byte
+	switch w.gss.service {
+	case GSSServiceNone:
+		return nil
+	case GSSServiceIntegrity:
+		var integ struct {
+			Body     []byte
+			Checksum []byte
+		}
+		if err := xdr.Read(w.req.Body, &integ); err != nil {
+			return &ResponseCodeGarbageArgsError{}
+		}
+		if err := w.gss.session.VerifyMIC(integ.Body, integ.Checksum); err != nil {
+			return &ResponseCodeGarbageArgsError{}
+		}
+		body = integ.Body
+	case GSSServicePrivacy:
+		sealed, err := xdr.ReadOpaque(w.req.Body)
+		if err != nil {
+			return &ResponseCodeGarbageArgsError{}
+		}
+		if body, err = w.gss.session.Unwrap(sealed); err != nil {
+			return &ResponseCodeGarbageArgsError{}
+		}
+	default:
+		return &AuthError{AuthStatBadCred}
+	}
+	// the protected arguments lead with the call's sequence number.
+	if len(body) < 4 || binary.BigEndian.Uint32(body) != w.gss.seq {
+		return &ResponseCodeGarbageArgsError{}
+	}
+	args := body[4:]
+	w.req.Body = &io.LimitedReader{R: bytes.NewReader(args), N: int64(len(args))}
+	return nil
+}
+
+// protectResults protects the results of a successful reply with the
+// integrity or privacy service of the call.
+func (w *response) protectResults() error {
+	if w.gss == nil || w.gss.service == GSSServiceNone || w.resultsAt == 0 {
+		return nil
+	}
+	results := w.writer.Bytes()[w.resultsAt:]
+	body := make([]byte, 4, 4+len(results))
+	binary.BigEndian.PutUint32(body, w.gss.seq)
+	body = append(body, results...)
+
+	sealed := bytes.NewBuffer([]byte{})
+	switch w.gss.service {
+	case GSSServiceIntegrity:
+		checksum, err := w.gss.session.GetMIC(body)
+		if err != nil {
+			return err
+		}
+		if err := xdr.Write(sealed, &struct{ Body, Checksum []byte }{body, checksum}); err != nil {
+			return err
+		}
+	case GSSServicePrivacy:
+		wrapped, err := w.gss.session.Wrap(body)
+		if err != nil {
+			return err
+		}
+		if err := xdr.Write(sealed, wrapped); err != nil {
+			return err
+		}
+	}
+	w.writer.Truncate(w.resultsAt)
+	_, err := w.writer.Write(sealed.Bytes())
+	return err
+}
+
+// gssHeader returns the part of a call's header the verifier of an
+// RPCSEC_GSS call is a checksum of, from its xid to its credential.
+func gssHeader(req *request) []byte {
+	header := bytes.NewBuffer([]byte{})
+	_ = xdr.Write(header, &struct {
+		Xid     uint32
+		Type    uint32
+		Rpcvers uint32
+		Prog    uint32
+		Vers    uint32
+		Proc    uint32
+		Cred    rpc.Auth
+	}{req.xid, 0, req.Header.Rpcvers, req.Header.Prog, req.Header.Vers, req.Header.Proc, req.Header.Cred})
+	return header.Bytes()
+}
+
+// xdrUint32 is the XDR encoding of v.
+func xdrUint32(v uint32) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	return b[:]
+}
@@ -0,0 +1,519 @@
+package nfs_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// The control procedures of RPCSEC_GSS.
+const (
+	gssData         = 0
+	gssInit         = 1
+	gssContinueInit = 2
+	gssDestroy      = 3
+)
+
+var testGSSKey = []byte("test session key")
+
+// testMIC is the checksum of msg in the test mechanism.
+func testMIC(msg []byte) []byte {
+	h := hmac.New(sha256.New, testGSSKey)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// testSeal scrambles msg in the test mechanism, and unscrambles it again.
+func testSeal(msg []byte) []byte {
+	sealed := make([]byte, len(msg))
+	for i, b := range msg {
+		sealed[i] = b ^ testGSSKey[i%len(testGSSKey)]
+	}
+	return sealed
+}
+
+// testGSS is a mechanism establishing contexts in two round trips, the
+// client sending "hello" and then "response".
+type testGSS struct {
+	deleted atomic.Int32
+}
+
+func (p *testGSS) NewContext() (nfs.GSSContext, error) {
+	return &testGSSContext{p: p}, nil
+}
+
+type testGSSContext struct {
+	p    *testGSS
+	step int
+}
+
+func (c *testGSSContext) Accept(token []byte) ([]byte, bool, error) {
+	switch {
+	case c.step == 0 && string(token) == "hello":
+		c.step++
+		return []byte("challenge"), false, nil
+	case c.step == 1 && string(token) == "response":
+		c.step++
+		return []byte("welcome"), true, nil
+	}
+	return nil, false, &nfs.GSSError{Major: nfs.GSSDefectiveToken}
+}
+
+func (c *testGSSContext) Principal() string { return "alice@EXAMPLE.COM" }
+
+func (c *testGSSContext) GetMIC(msg []byte) ([]byte, error) { return testMIC(msg), nil }
+
+func (c *testGSSContext) VerifyMIC(msg, mic []byte) error {
+	if !hmac.Equal(testMIC(msg), mic) {
+		return &nfs.GSSError{Major: 6 << 16} // GSS_S_BAD_SIG
+	}
+	return nil
+}
+
+func (c *testGSSContext) Wrap(msg []byte) ([]byte, error) { return testSeal(msg), nil }
+
+func (c *testGSSContext) Unwrap(msg []byte) ([]byte, error) { return testSeal(msg), nil }
+
+func (c *testGSSContext) Delete() { c.p.deleted.Add(1) }
+
+// gssHandler records who makes the mounts it is asked for.
+type gssHandler struct {
+	nfs.Handler
+	principal string
+	service   nfs.GSSService
+}
+
+func (h *gssHandler) Mount(ctx context.Context, conn net.Conn, req nfs.MountRequest) (nfs.MountStatus, billy.Filesystem, []nfs.AuthFlavor) {
+	h.principal, _ = nfs.PrincipalFromContext(ctx)
+	h.service, _ = nfs.GSSServiceFromContext(ctx)
+	return h.Handler.Mount(ctx, conn, req)
+}
+
+// gssClient makes calls with RPCSEC_GSS credentials on a connection.
+type gssClient struct {
+	t    *testing.T
+	conn net.Conn
+	xid  uint32
+	// forge, if set, makes the header checksums of calls wrong.
+	forge bool
+}
+
+func dialGSS(t *testing.T, srv *nfs.Server) *gssClient {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		_ = srv.Serve(listener)
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return &gssClient{t: t, conn: conn}
+}
+
+// send makes the call proc of the mount program with a credential of
+// gssProc, signed by the test mechanism unless it creates a context, and
+// returns its xid.
+func (g *gssClient) send(gssProc, seq uint32, service nfs.GSSService, handle []byte, proc uint32, args []byte) uint32 {
+	g.t.Helper()
+	g.xid++
+	credBody := new(bytes.Buffer)
+	if err := xdr.Write(credBody, &struct {
+		Version, Proc, Seq, Service uint32
+		Handle                      []byte
+	}{1, gssProc, seq, uint32(service), handle}); err != nil {
+		g.t.Fatal(err)
+	}
+	header := rpc.Header{
+		Rpcvers: 2,
+		Prog:    nfsc.MountProg,
+		Vers:    nfsc.MountVers,
+		Proc:    proc,
+		Cred:    rpc.Auth{Flavor: uint32(nfs.AuthFlavorRPCSECGSS), Body: credBody.Bytes()},
+		Verf:    rpc.AuthNull,
+	}
+	if gssProc != gssInit && gssProc != gssContinueInit {
+		signed := new(bytes.Buffer)
+		if err := xdr.Write(signed, &struct {
+			Xid, Type, Rpcvers, Prog, Vers, Proc uint32
+			Cred                                 rpc.Auth
+		}{g.xid, 0, header.Rpcvers, header.Prog, header.Vers, header.Proc, header.Cred}); err != nil {
+			g.t.Fatal(err)
+		}
+		mic := testMIC(signed.Bytes())
+		if g.forge {
+			mic[0] ^= 1
+		}
+		header.Verf = rpc.Auth{Flavor: uint32(nfs.AuthFlavorRPCSECGSS), Body: mic}
+	}
+	call := new(bytes.Buffer)
+	if err := xdr.Write(call, &struct {
+		Xid  uint32
+		Type uint32
+		rpc.Header
+	}{Xid: g.xid, Header: header}); err != nil {
+		g.t.Fatal(err)
+	}
+	call.Write(args)
+	var fragment [4]byte
+	binary.BigEndian.PutUint32(fragment[:], uint32(call.Len())|1<<31)
+	if _, err := g.conn.Write(append(fragment[:], call.Bytes()...)); err != nil {
+		g.t.Fatal(err)
+	}
+	return g.xid
+}
+
+// recv reads a reply, returning its xid and what follows.
+func (g *gssClient) recv() (uint32, io.Reader) {
+	g.t.Helper()
+	_ = g.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var fragment [4]byte
+	if _, err := io.ReadFull(g.conn, fragment[:]); err != nil {
+		g.t.Fatal(err)
+	}
+	reply := make([]byte, binary.BigEndian.Uint32(fragment[:])&^(1<<31))
+	if _, err := io.ReadFull(g.conn, reply); err != nil {
+		g.t.Fatal(err)
+	}
+	return binary.BigEndian.Uint32(reply), bytes.NewReader(reply[4:])
+}
+
+// accepted reads the head of a reply that must have been accepted,
+// returning its verifier.
+func (g *gssClient) accepted(res io.Reader) rpc.Auth {
+	g.t.Helper()
+	var head struct {
+		Type       uint32
+		ReplyStat  uint32
+		Verf       rpc.Auth
+		AcceptStat uint32
+	}
+	if err := xdr.Read(res, &head); err != nil {
+		g.t.Fatal(err)
+	}
+	if head.ReplyStat != rpc.MsgAccepted || head.AcceptStat != 0 {
+		g.t.Fatalf("expected a successful reply, got stat %d accept_stat %d", head.ReplyStat, head.AcceptStat)
+	}
+	return head.Verf
+}
+
+// denied reads a reply that must have been denied for authentication,
+// returning why.
+func (g *gssClient) denied(res io.Reader) nfs.AuthStat {
+	g.t.Helper()
+	var head struct {
+		Type       uint32
+		ReplyStat  uint32
+		RejectStat uint32
+		AuthStat   uint32
+	}
+	if err := xdr.Read(res, &head); err != nil {
+		g.t.Fatal(err)
+	}
+	if head.ReplyStat != rpc.MsgDenied || head.RejectStat != 1 {
+		g.t.Fatalf("expected an AUTH_ERROR reply, got stat %d reject_stat %d", head.ReplyStat, head.RejectStat)
+	}
+	return nfs.AuthStat(head.AuthStat)
+}
+
+type gssInitResult struct {
+	Handle                  []byte
+	Major, Minor, SeqWindow uint32
+	Token                   []byte
+}
+
+// init sends a token creating a context, and returns the reply's verifier
+// and result.
+func (g *gssClient) init(gssProc uint32, handle []byte, token string) (rpc.Auth, gssInitResult) {
+	g.t.Helper()
+	args := new(bytes.Buffer)
+	_ = xdr.Write(args, []byte(token))
+	g.send(gssProc, 0, nfs.GSSServiceNone, handle, 0, args.Bytes())
+	_, res := g.recv()
+	verf := g.accepted(res)
+	var result gssInitResult
+	if err := xdr.Read(res, &result); err != nil {
+		g.t.Fatal(err)
+	}
+	return verf, result
+}
+
+// protect encodes args as service sends them in the call seq.
+func protect(seq uint32, service nfs.GSSService, args []byte) []byte {
+	body := binary.BigEndian.AppendUint32(nil, seq)
+	body = append(body, args...)
+	protected := new(bytes.Buffer)
+	switch service {
+	case nfs.GSSServiceIntegrity:
+		_ = xdr.Write(protected, &struct{ Body, Checksum []byte }{body, testMIC(body)})
+	case nfs.GSSServicePrivacy:
+		_ = xdr.Write(protected, testSeal(body))
+	default:
+		return args
+	}
+	return protected.Bytes()
+}
+
+// unprotect returns the results service sent for the call seq in res.
+func unprotect(seq uint32, service nfs.GSSService, res io.Reader) (io.Reader, error) {
+	var body []byte
+	switch service {
+	case nfs.GSSServiceIntegrity:
+		var integ struct{ Body, Checksum []byte }
+		if err := xdr.Read(res, &integ); err != nil {
+			return nil, err
+		}
+		if !hmac.Equal(testMIC(integ.Body), integ.Checksum) {
+			return nil, errors.New("results fail their checksum")
+		}
+		body = integ.Body
+	case nfs.GSSServicePrivacy:
+		sealed, err := xdr.ReadOpaque(res)
+		if err != nil {
+			return nil, err
+		}
+		body = testSeal(sealed)
+	default:
+		return res, nil
+	}
+	if len(body) < 4 || binary.BigEndian.Uint32(body) != seq {
+		return nil, errors.New("results are not of the call")
+	}
+	return bytes.NewReader(body[4:]), nil
+}
+
+// mount mounts "/" in the context of handle with service, returning the
+// status of the reply after checking its verifier.
+func (g *gssClient) mount(handle []byte, seq uint32, service nfs.GSSService) uint32 {
+	g.t.Helper()
+	args := new(bytes.Buffer)
+	_ = xdr.Write(args, "/")
+	g.send(gssData, seq, service, handle, nfsc.MountProc3MNT, protect(seq, service, args.Bytes()))
+	_, res := g.recv()
+	verf := g.accepted(res)
+	if !bytes.Equal(verf.Body, testMIC(binary.BigEndian.AppendUint32(nil, seq))) {
+		g.t.Fatalf("reply verifier %x is not a checksum of the sequence number", verf.Body)
+	}
+	res, err := unprotect(seq, service, res)
+	if err != nil {
+		g.t.Fatal(err)
+	}
+	status, err := xdr.ReadUint32(res)
+	if err != nil {
+		g.t.Fatal(err)
+	}
+	return status
+}
+
+func TestRPCSECGSS(t *testing.T) {
+	provider := &testGSS{}
+	handler := &gssHandler{Handler: helpers.NewNullAuthHandler(newTestFS(t, nil))}
+	srv := &nfs.Server{Handler: handler, ServerOptions: nfs.ServerOptions{GSS: provider}}
+	g := dialGSS(t, srv)
+
+	verf, res := g.init(gssInit, nil, "hello")
+	if res.Major != nfs.GSSContinueNeeded || string(res.Token) != "challenge" || len(res.Handle) == 0 {
+		t.Fatalf("unexpected reply to the first token: %+v", res)
+	}
+	if verf.Flavor != uint32(nfs.AuthFlavorNull) {
+		t.Fatalf("expected no verifier before the context is established, got flavor %d", verf.Flavor)
+	}
+	handle := res.Handle
+	verf, res = g.init(gssContinueInit, handle, "response")
+	if res.Major != nfs.GSSComplete || string(res.Token) != "welcome" || !bytes.Equal(res.Handle, handle) {
+		t.Fatalf("unexpected reply to the second token: %+v", res)
+	}
+	if !bytes.Equal(verf.Body, testMIC(binary.BigEndian.AppendUint32(nil, res.SeqWindow))) {
+		t.Fatalf("verifier %x is not a checksum of the window %d", verf.Body, res.SeqWindow)
+	}
+
+	for i, service := range []nfs.GSSService{nfs.GSSServiceNone, nfs.GSSServiceIntegrity, nfs.GSSServicePrivacy} {
+		if status := g.mount(handle, uint32(i+1), service); status != nfsc.MNT3Ok {
+			t.Fatalf("mount with service %d failed with status %d", service, status)
+		}
+		if handler.principal != "alice@EXAMPLE.COM" || handler.service != service {
+			t.Fatalf("handler saw principal %q and service %d", handler.principal, handler.service)
+		}
+	}
+
+	// a replayed call is dropped, the next being answered instead.
+	args := new(bytes.Buffer)
+	_ = xdr.Write(args, "/")
+	g.send(gssData, 1, nfs.GSSServiceNone, handle, nfsc.MountProc3MNT, args.Bytes())
+	next := g.send(gssData, 4, nfs.GSSServiceNone, handle, 0, nil)
+	if xid, _ := g.recv(); xid != next {
+		t.Fatalf("expected the replay to go unanswered, got a reply to %d", xid)
+	}
+
+	// integrity protected arguments are checked.
+	tampered := protect(5, nfs.GSSServiceIntegrity, args.Bytes())
+	tampered[len(tampered)-1] ^= 1
+	g.send(gssData, 5, nfs.GSSServiceIntegrity, handle, nfsc.MountProc3MNT, tampered)
+	_, reply := g.recv()
+	var garbage struct {
+		Type, ReplyStat uint32
+		Verf            rpc.Auth
+		AcceptStat      uint32
+	}
+	if err := xdr.Read(reply, &garbage); err != nil {
+		t.Fatal(err)
+	}
+	if garbage.AcceptStat != rpc.GarbageArgs {
+		t.Fatalf("expected GARBAGE_ARGS for a bad checksum, got accept_stat %d", garbage.AcceptStat)
+	}
+
+	if _, reply := g.null(gssData, 6, []byte("unknown")); g.denied(reply) != nfs.AuthStatRPCGSSCredProblem {
+		t.Fatal("expected RPCSEC_GSS_CREDPROBLEM for an unknown context")
+	}
+
+	g.send(gssDestroy, 7, nfs.GSSServiceNone, handle, 0, nil)
+	_, reply = g.recv()
+	g.accepted(reply)
+	if n := provider.deleted.Load(); n != 1 {
+		t.Fatalf("expected the context to be deleted, %d were", n)
+	}
+	if _, reply := g.null(gssData, 8, handle); g.denied(reply) != nfs.AuthStatRPCGSSCredProblem {
+		t.Fatal("expected RPCSEC_GSS_CREDPROBLEM for a destroyed context")
+	}
+
+	// a failed establishment leaves no context.
+	_, res = g.init(gssInit, nil, "bogus")
+	if res.Major != nfs.GSSDefectiveToken || len(res.Handle) != 0 {
+		t.Fatalf("unexpected reply to a bad token: %+v", res)
+	}
+	if n := provider.deleted.Load(); n != 2 {
+		t.Fatalf("expected the failed context to be deleted, %d were", n)
+	}
+}
+
+// null makes a NULL call in the context of handle, returning its reply.
+func (g *gssClient) null(gssProc, seq uint32, handle []byte) (uint32, io.Reader) {
+	g.t.Helper()
+	g.send(gssProc, seq, nfs.GSSServiceNone, handle, 0, nil)
+	return g.recv()
+}
+
+func TestRPCSECGSSBadVerifier(t *testing.T) {
+	srv := &nfs.Server{
+		Handler:       helpers.NewNullAuthHandler(newTestFS(t, nil)),
+		ServerOptions: nfs.ServerOptions{GSS: &testGSS{}},
+	}
+	g := dialGSS(t, srv)
+	_, res := g.init(gssInit, nil, "hello")
+	_, res = g.init(gssContinueInit, res.Handle, "response")
+
+	g.forge = true
+	if _, reply := g.null(gssData, 1, res.Handle); g.denied(reply) != nfs.AuthStatBadVerifier {
+		t.Fatal("expected AUTH_BADVERF for a bad header checksum")
+	}
+	// the forged call does not use up its sequence number.
+	g.forge = false
+	_, reply := g.null(gssData, 1, res.Handle)
+	g.accepted(reply)
+}
+
+func TestRPCSECGSSPendingContexts(t *testing.T) {
+	provider := &testGSS{}
+	srv := &nfs.Server{
+		Handler:       helpers.NewNullAuthHandler(newTestFS(t, nil)),
+		ServerOptions: nfs.ServerOptions{GSS: provider},
+	}
+	g := dialGSS(t, srv)
+	_, res := g.init(gssInit, nil, "hello")
+	_, res = g.init(gssContinueInit, res.Handle, "response")
+	established := res.Handle
+
+	// contexts started and never finished displace one another, but not
+	// those already established.
+	_, res = g.init(gssInit, nil, "hello")
+	first := res.Handle
+	for i := 0; i < 200; i++ {
+		g.init(gssInit, nil, "hello")
+	}
+	if provider.deleted.Load() == 0 {
+		t.Fatal("expected unfinished contexts to be discarded")
+	}
+	_, reply := g.null(gssData, 1, established)
+	g.accepted(reply)
+	args := new(bytes.Buffer)
+	_ = xdr.Write(args, []byte("response"))
+	g.send(gssContinueInit, 0, nfs.GSSServiceNone, first, 0, args.Bytes())
+	if _, reply := g.recv(); g.denied(reply) != nfs.AuthStatRPCGSSCredProblem {
+		t.Fatal("expected RPCSEC_GSS_CREDPROBLEM for a discarded unfinished context")
+	}
+}
+
+func TestRequireGSSService(t *testing.T) {
+	srv := &nfs.Server{
+		Handler: helpers.NewNullAuthHandler(newTestFS(t, nil)),
+		ServerOptions: nfs.ServerOptions{
+			GSS:               &testGSS{},
+			RequireGSSService: nfs.GSSServiceIntegrity,
+		},
+	}
+	g := dialGSS(t, srv)
+	_, res := g.init(gssInit, nil, "hello")
+	_, res = g.init(gssContinueInit, res.Handle, "response")
+	handle := res.Handle
+
+	args := new(bytes.Buffer)
+	_ = xdr.Write(args, "/")
+	g.send(gssData, 1, nfs.GSSServiceNone, handle, nfsc.MountProc3MNT, args.Bytes())
+	if _, reply := g.recv(); g.denied(reply) != nfs.AuthStatTooWeak {
+		t.Fatal("expected AUTH_TOOWEAK for a call without integrity")
+	}
+	// NULL calls are still answered.
+	_, reply := g.null(gssData, 2, handle)
+	g.accepted(reply)
+
+	if status := g.mount(handle, 3, nfs.GSSServiceIntegrity); status != nfsc.MNT3Ok {
+		t.Fatalf("mount with integrity failed with status %d", status)
+	}
+	if status := g.mount(handle, 4, nfs.GSSServicePrivacy); status != nfsc.MNT3Ok {
+		t.Fatalf("mount with privacy failed with status %d", status)
+	}
+
+	// a tampered call is refused.
+	tampered := protect(5, nfs.GSSServiceIntegrity, args.Bytes())
+	tampered[8] ^= 1
+	g.send(gssData, 5, nfs.GSSServiceIntegrity, handle, nfsc.MountProc3MNT, tampered)
+	_, reply = g.recv()
+	var head struct {
+		Type, ReplyStat uint32
+		Verf            rpc.Auth
+		AcceptStat      uint32
+	}
+	if err := xdr.Read(reply, &head); err != nil {
+		t.Fatal(err)
+	}
+	if head.AcceptStat != rpc.GarbageArgs {
+		t.Fatalf("expected GARBAGE_ARGS for tampered arguments, got accept_stat %d", head.AcceptStat)
+	}
+
+	// other flavors can only call NULL.
+	unix := rpc.NewAuthUnix("host", 1000, 1000).Auth()
+	if stat := g.denied(callProcWithCredential(t, srv, nfsc.MountProg, nfsc.MountVers, nfsc.MountProc3MNT, unix, rpc.AuthNull, "/")); stat != nfs.AuthStatTooWeak {
+		t.Fatalf("expected AUTH_TOOWEAK mounting with AUTH_UNIX, got %d", stat)
+	}
+	g.accepted(callWithCredential(t, srv, unix, rpc.AuthNull))
+}
@@ -2,6 +2,7 @@ package nfs
 
 import (
 	"context"
+	"errors"
 	"io/fs"
 	"net"
 
@@ -47,6 +48,158 @@ type UnixChange interface {
 	Link(path string, link string) error
 }
 
+// WriteLockingHandler is implemented by handlers that serialize the writes
+// to each file, so that concurrent appends cannot interleave on backends
+// where a write is not atomic. LockWrites blocks until no other write to
+// the file at path is in progress, and returns the func that releases it,
+// or nil if it does not lock the file's writes. A WRITE under the lock that
+// starts at the end of the file opens it with O_APPEND, so that backends
+// supporting it extend the file atomically, even against writers that do
+// not go through the server.
+type WriteLockingHandler interface {
+	LockWrites(fs billy.Filesystem, path []string) (unlock func())
+}
+
+// RangeLockHandler is implemented by handlers that keep a table of the
+// byte-range locks held on files, such as one a lock manager fills, for the
+// server to enforce as mandatory locks. RangeLocked reports whether length
+// bytes at offset of the file at path are locked by an owner other than
+// the one making the request ctx belongs to, against reading, or against
+// writing if write is set. READ and WRITE of such ranges are refused with
+// NFSStatusAccess.
+type RangeLockHandler interface {
+	RangeLocked(ctx context.Context, fs billy.Filesystem, path []string, offset, length uint64, write bool) bool
+}
+
+// LockHandler is implemented by handlers that take and release the
+// byte-range locks clients request through NLM, for their RangeLocked to
+// enforce. The owner of a lock is the principal making the request ctx
+// belongs to, as PrincipalFromContext names it. A length of zero reaches
+// the end of the file. LockRange returns an error if the lock cannot be
+// taken, as when another owner holds a conflicting one.
+type LockHandler interface {
+	LockRange(ctx context.Context, fs billy.Filesystem, path []string, offset, length uint64, exclusive bool) error
+	UnlockRange(ctx context.Context, fs billy.Filesystem, path []string, offset, length uint64)
+}
+
+// FileIDHandler is implemented by handlers that number files themselves,
+// for instance from their handles. FileIDFor must return the same fileid
+// every time it is asked about the same file, or zero to leave the file
+// numbered by the backend's inode number, or else by PathFileID.
+type FileIDHandler interface {
+	FileIDFor(fs billy.Filesystem, path []string) uint64
+}
+
+// FSIDHandler is implemented by handlers that tell their file systems apart
+// for clients. Every file in fs is reported with the fsid FSIDFor returns,
+// so it must depend on nothing but fs. Without one, all files share fsid 0.
+type FSIDHandler interface {
+	FSIDFor(fs billy.Filesystem) uint64
+}
+
+// ClientHandleHandler is implemented by handlers that account the handles
+// they issue to the client asking for them. The server calls ToHandleFor in
+// place of ToHandle to look files up for a client, identified by its
+// address, and the handler may refuse with an NFSStatusError, such as
+// NFSStatusJukebox to have the client retry later.
+type ClientHandleHandler interface {
+	ToHandleFor(client string, fs billy.Filesystem, path []string) ([]byte, error)
+}
+
+// ExportingHandler is implemented by handlers that serve known exports,
+// which the mount protocol's EXPORT procedure lists for clients such as
+// showmount. Without one, the server lists only "/".
+type ExportingHandler interface {
+	Exports() []Export
+}
+
+// ContextHandleHandler is implemented by handlers that resolve handles in
+// the scope of the request making them, for instance to pick the file
+// system of the principal (see PrincipalFromContext) on whose behalf it is
+// made. The server calls FromHandleContext in place of FromHandle.
+type ContextHandleHandler interface {
+	FromHandleContext(ctx context.Context, fh []byte) (billy.Filesystem, []string, error)
+}
+
+// HandleSizer is implemented by handlers that bound the size of the
+// handles they mint. Handles may take up to FHSize bytes, but some
+// embedded clients reliably handle no more than 32.
+type HandleSizer interface {
+	MaxHandleSize() int
+}
+
+// ReleaseNotifier is implemented by handlers that can tell when no handle
+// to a file remains. A file REMOVE finds in use, the backend failing with
+// EBUSY or ETXTBSY, is then renamed aside to a hidden .nfs name (see
+// ServerOptions.SillyRenamePrefix), as clients do themselves, and
+// WhenReleased is asked to delete it once the handles still reaching it
+// are gone. WhenReleased calls release once no handle to path in fs
+// remains, which may be at once.
+type ReleaseNotifier interface {
+	WhenReleased(fs billy.Filesystem, path []string, release func())
+}
+
+// ShallowHandleHandler is implemented by handlers that can resolve a handle
+// more cheaply by refreshing only it, and not the handles of the
+// directories above it, in whatever cache they keep. GETATTR, the most
+// frequent call, resolves handles with FromHandleShallow, which resolves
+// them in the scope of the request ctx belongs to, as FromHandleContext
+// does, if the handler has one.
+type ShallowHandleHandler interface {
+	FromHandleShallow(ctx context.Context, fh []byte) (billy.Filesystem, []string, error)
+}
+
+// fromHandle resolves fh for the request ctx belongs to, through the
+// handler's FromHandleContext if it has one.
+func fromHandle(ctx context.Context, userHandle Handler, fh []byte) (billy.Filesystem, []string, error) {
+	if ch, ok := userHandle.(ContextHandleHandler); ok {
+		return ch.FromHandleContext(ctx, fh)
+	}
+	return userHandle.FromHandle(fh)
+}
+
+// fromHandle resolves fh for the request, which is then logged as acting
+// on the first handle it resolves.
+func (w *response) fromHandle(ctx context.Context, userHandle Handler, fh []byte) (billy.Filesystem, []string, error) {
+	if w.handle == nil {
+		w.handle = fh
+	}
+	fs, path, err := fromHandle(ctx, userHandle, fh)
+	if err != nil {
+		w.logger().Debugf("handle %s not resolved: %v", HandleString(fh), err)
+	}
+	return fs, path, err
+}
+
+// fromHandleShallow is fromHandle through the handler's FromHandleShallow,
+// where it has one.
+func (w *response) fromHandleShallow(ctx context.Context, userHandle Handler, fh []byte) (billy.Filesystem, []string, error) {
+	sh, ok := userHandle.(ShallowHandleHandler)
+	if !ok {
+		return w.fromHandle(ctx, userHandle, fh)
+	}
+	if w.handle == nil {
+		w.handle = fh
+	}
+	fs, path, err := sh.FromHandleShallow(ctx, fh)
+	if err != nil {
+		w.logger().Debugf("handle %s not resolved: %v", HandleString(fh), err)
+	}
+	return fs, path, err
+}
+
+// checkRangeLock refuses the request ctx belongs to access to length bytes
+// at offset of the file at path, if the handler knows them to be locked by
+// another owner.
+func checkRangeLock(ctx context.Context, userHandle Handler, fs billy.Filesystem, path []string, offset, length uint64, write bool) error {
+	if rl, ok := userHandle.(RangeLockHandler); ok && rl.RangeLocked(ctx, fs, path, offset, length, write) {
+		return &NFSStatusError{NFSStatusAccess, errRangeLocked}
+	}
+	return nil
+}
+
+var errRangeLocked = errors.New("range locked by another owner")
+
 // CachingHandler represents the optional caching work that a user may wish to over-ride with
 // their own implementations, but which can be otherwise provided through defaults.
 type CachingHandler interface {
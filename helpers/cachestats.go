@@ -0,0 +1,120 @@
+package helpers
+
+import (
+	"time"
+)
+
+// DefaultStatsWindow is how far back CachingHandler.Stats counts insertions
+// and evictions, unless set with WithStatsWindow.
+const DefaultStatsWindow = time.Minute
+
+// WithStatsWindow sets how far back Stats counts insertions and evictions.
+func WithStatsWindow(d time.Duration) CachingOption {
+	return func(c *CachingHandler) {
+		if d > 0 {
+			c.counts.length = d
+		}
+	}
+}
+
+// CacheStats describes the pressure on a CachingHandler's handle cache, so
+// that its limit (or the number of servers) can be raised before clients
+// start seeing NFS3ERR_STALE for evicted handles.
+type CacheStats struct {
+	// Handles is the number of active handles, out of Limit.
+	Handles, Limit int
+	// Insertions counts the handles issued over the stats window, and
+	// Evictions those of them that pushed another handle out of the cache.
+	Insertions, Evictions uint64
+	// OldestHandleAge is how long ago the handle next in line for eviction
+	// was issued, or zero if there are no handles.
+	OldestHandleAge time.Duration
+}
+
+// EvictionRatio is the share of recent insertions that evicted a handle.
+// It nears 1 once the cache is too small for the clients' working set.
+func (s CacheStats) EvictionRatio() float64 {
+	if s.Insertions == 0 {
+		return 0
+	}
+	return float64(s.Evictions) / float64(s.Insertions)
+}
+
+// Stats reports the current pressure on the handle cache.
+func (c *CachingHandler) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	recent := c.counts.since(now)
+	s := CacheStats{
+		Handles:    c.activeHandles.Len(),
+		Limit:      c.cacheLimit,
+		Insertions: recent.insertions,
+		Evictions:  recent.evictions,
+	}
+	if _, oldest, ok := c.activeHandles.GetOldest(); ok {
+		s.OldestHandleAge = now.Sub(oldest.added)
+	}
+	return s
+}
+
+type cacheCounts struct {
+	insertions, evictions uint64
+}
+
+// windowCounts counts cache insertions and evictions over a sliding window
+// of the given length, approximated by its current and previous intervals.
+type windowCounts struct {
+	length            time.Duration
+	start             time.Time
+	current, previous cacheCounts
+}
+
+// roll starts a new interval if the current one is over at now.
+func (w *windowCounts) roll(now time.Time) {
+	switch elapsed := now.Sub(w.start); {
+	case elapsed >= 2*w.length:
+		w.previous, w.current = cacheCounts{}, cacheCounts{}
+		w.start = now
+	case elapsed >= w.length:
+		w.previous, w.current = w.current, cacheCounts{}
+		w.start = w.start.Add(w.length)
+	}
+}
+
+// add records an insertion at now, which evicted a handle if evicted.
+func (w *windowCounts) add(now time.Time, evicted bool) {
+	w.roll(now)
+	w.current.insertions++
+	if evicted {
+		w.current.evictions++
+	}
+}
+
+// since returns the counts over the window ending at now.
+func (w *windowCounts) since(now time.Time) cacheCounts {
+	w.roll(now)
+	return cacheCounts{
+		insertions: w.previous.insertions + w.current.insertions,
+		evictions:  w.previous.evictions + w.current.evictions,
+	}
+}
+
+// HandleAges reports, for each active handle from the next in line for
+// eviction to the most recently used, how long ago it was last issued or
+// resolved, to help size the cache's limit: a full cache of recently used
+// handles is evicting ones clients still hold.
+func (c *CachingHandler) HandleAges() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	ages := make([]time.Duration, 0, c.activeHandles.Len())
+	for _, id := range c.activeHandles.Keys() {
+		if e, ok := c.activeHandles.Peek(id); ok {
+			ages = append(ages, now.Sub(e.accessed))
+		}
+	}
+	return ages
+}
@@ -0,0 +1,78 @@
+package helpers
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCacheStatsEvictionRatio(t *testing.T) {
+	c, mem := newTestCachingHandler(t, 4)
+
+	for i := 0; i < 4; i++ {
+		_ = c.ToHandle(mem, []string{strconv.Itoa(i)})
+	}
+	s := c.Stats()
+	if s.Handles != 4 || s.Limit != 4 || s.Insertions != 4 || s.EvictionRatio() != 0 {
+		t.Fatalf("expected a full cache without evictions, got %+v", s)
+	}
+	if s.OldestHandleAge <= 0 {
+		t.Fatalf("expected the oldest handle to have an age, got %v", s.OldestHandleAge)
+	}
+
+	// Each new path now pushes an older handle out.
+	for i := 4; i < 16; i++ {
+		_ = c.ToHandle(mem, []string{strconv.Itoa(i)})
+	}
+	s = c.Stats()
+	if s.Insertions != 16 || s.Evictions != 12 {
+		t.Fatalf("expected 12 of 16 insertions to evict, got %+v", s)
+	}
+	if r := s.EvictionRatio(); r != 0.75 {
+		t.Fatalf("expected an eviction ratio of 0.75, got %v", r)
+	}
+}
+
+func TestCacheStatsWindow(t *testing.T) {
+	w := windowCounts{length: time.Minute, start: time.Unix(0, 0)}
+	at := func(d time.Duration) time.Time { return time.Unix(0, 0).Add(d) }
+
+	w.add(at(0), true)
+	w.add(at(30*time.Second), false)
+	if got := w.since(at(90 * time.Second)); got != (cacheCounts{2, 1}) {
+		t.Fatalf("expected the previous interval to still count, got %+v", got)
+	}
+	w.add(at(100*time.Second), false)
+	if got := w.since(at(150 * time.Second)); got != (cacheCounts{1, 0}) {
+		t.Fatalf("expected only the last interval to count, got %+v", got)
+	}
+	if got := w.since(at(10 * time.Minute)); got != (cacheCounts{}) {
+		t.Fatalf("expected nothing after the window passed, got %+v", got)
+	}
+}
+
+func TestHandleAges(t *testing.T) {
+	c, mem := newTestCachingHandler(t, 4)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	a := c.ToHandle(mem, []string{"a"})
+	_ = c.ToHandle(mem, []string{"b"})
+	now = now.Add(time.Second)
+	if _, _, err := c.FromHandle(a); err != nil {
+		t.Fatal(err)
+	}
+
+	// b, untouched since it was issued, is now next in line for eviction.
+	ages := c.HandleAges()
+	if len(ages) != 2 || ages[0] != time.Second || ages[1] != 0 {
+		t.Fatalf("expected b a second older than the handle just resolved, got ages %v", ages)
+	}
+
+	// a reverse cache hit counts as an access too.
+	now = now.Add(time.Second)
+	_ = c.ToHandle(mem, []string{"b"})
+	if ages := c.HandleAges(); ages[0] != time.Second || ages[1] != 0 {
+		t.Fatalf("expected b's age reset on reissue, got ages %v", ages)
+	}
+}
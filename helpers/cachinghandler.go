@@ -1,10 +1,15 @@
 package helpers
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"io/fs"
 	"reflect"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/willscott/go-nfs"
 
@@ -14,95 +19,391 @@ import (
 )
 
 // NewCachingHandler wraps a handler to provide a basic to/from-file handle cache.
-func NewCachingHandler(h nfs.Handler, limit int) nfs.Handler {
-	return NewCachingHandlerWithVerifierLimit(h, limit, limit)
+func NewCachingHandler(h nfs.Handler, limit int, opts ...CachingOption) nfs.Handler {
+	return NewCachingHandlerWithVerifierLimit(h, limit, limit, opts...)
 }
 
 // NewCachingHandlerWithVerifierLimit provides a basic to/from-file handle cache that can be tuned with a smaller cache of active directory listings.
-func NewCachingHandlerWithVerifierLimit(h nfs.Handler, limit int, verifierLimit int) nfs.Handler {
-	if limit < 2 || verifierLimit < 2 {
-		nfs.Log.Warnf("Caching handler created with insufficient cache to support directory listing", "size", limit, "verifiers", verifierLimit)
-	}
+func NewCachingHandlerWithVerifierLimit(h nfs.Handler, limit int, verifierLimit int, opts ...CachingOption) nfs.Handler {
 	cache, _ := lru.New[uuid.UUID, entry](limit)
 	reverseCache := make(map[string][]uuid.UUID)
 	verifiers, _ := lru.New[uint64, verifier](verifierLimit)
-	return &CachingHandler{
+	c := &CachingHandler{
 		Handler:         h,
 		activeHandles:   cache,
 		reverseHandles:  reverseCache,
 		activeVerifiers: verifiers,
 		cacheLimit:      limit,
+		handleVersion:   HandleVersion1,
+		counts:          windowCounts{length: DefaultStatsWindow, start: time.Now()},
+		now:             time.Now,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if limit < 2 || verifierLimit < 2 {
+		c.Logger().Warnf("Caching handler created with insufficient cache to support directory listing: size %d, verifiers %d", limit, verifierLimit)
+	}
+	return c
+}
+
+// NewCachingHandlerNoReverse provides a to/from-file handle cache that skips
+// maintaining the reverse (path to handle) cache, which suits workloads that
+// create and delete many unique files. The tradeoff is that handles for the
+// same path are not deduplicated: every ToHandle call mints a new handle, so
+// repeated lookups of a path consume more of the cache.
+func NewCachingHandlerNoReverse(h nfs.Handler, limit int, opts ...CachingOption) nfs.Handler {
+	c := NewCachingHandlerWithVerifierLimit(h, limit, limit, opts...).(*CachingHandler)
+	c.noReverse = true
+	return c
+}
+
+// CachingOption configures a CachingHandler at construction.
+type CachingOption func(*CachingHandler)
+
+// WithHandleVersion sets the encoding of newly minted handles. Handles of
+// every known version are accepted regardless, so this can be used to keep
+// minting an older format until all servers understand the newer one.
+// Unknown versions are ignored.
+func WithHandleVersion(v byte) CachingOption {
+	return func(c *CachingHandler) {
+		if v == HandleVersionLegacy || v == HandleVersion1 {
+			c.handleVersion = v
+		}
+	}
+}
+
+// WithLogger sets the logger used by the caching handler, and by servers
+// using it that don't have their own.
+func WithLogger(l nfs.LeveledLogger) CachingOption {
+	return func(c *CachingHandler) {
+		c.logger = l
+	}
+}
+
+// WithMaxNameLength limits the file names accepted through the caching
+// handler to n bytes, or characters under WithNameLengthUnit, for backends
+// stricter than nfs.PathNameMax.
+func WithMaxNameLength(n int) CachingOption {
+	return func(c *CachingHandler) {
+		c.maxNameLength = n
+	}
+}
+
+// WithNameLengthUnit measures the length of file names accepted through the
+// caching handler, and advertised by PATHCONF, in unit, for backends
+// limiting names to a number of characters rather than bytes.
+func WithNameLengthUnit(unit nfs.NameLengthUnit) CachingOption {
+	return func(c *CachingHandler) {
+		c.nameLengthUnit = unit
+	}
+}
+
+// WithMaxSymlinkLength limits the targets of symlinks made through the
+// caching handler to n bytes, for backends stricter than nfs.SymlinkMax.
+func WithMaxSymlinkLength(n int) CachingOption {
+	return func(c *CachingHandler) {
+		c.maxSymlinkLength = n
+	}
+}
+
+// WithWriteLocking serializes writes to each file through the handler, for
+// backends where concurrent writers to one file can lose each other's data.
+// Writes at the end of a file are then made as appends, with O_APPEND.
+func WithWriteLocking() CachingOption {
+	return func(c *CachingHandler) {
+		c.writeLocks = make(map[string]*writeLock)
+	}
+}
+
+// WithRangeLocks has READ and WRITE through the handler refused where they
+// touch a range of a file that table records as locked by another owner,
+// and has the locks clients take through NLM recorded in table.
+func WithRangeLocks(table *LockTable) CachingOption {
+	return func(c *CachingHandler) {
+		c.rangeLocks = table
+	}
+}
+
+// WithOnStaleHandle has hook called with each handle FromHandle is given
+// but does not know, as when it has been evicted from the cache or was
+// issued before the server restarted. It is meant for tracing the
+// NFS3ERR_STALE errors clients see; nfs.HandleString renders the handle.
+func WithOnStaleHandle(hook func(fh []byte)) CachingOption {
+	return func(c *CachingHandler) {
+		c.onStaleHandle = hook
+	}
+}
+
+// WithLenientVerifier, when lenient, has READDIR and READDIRPLUS carry on
+// from a cookie sent with a verifier that does not match the directory's
+// listing, rather than refuse it with NFS3ERR_BAD_COOKIE, for clients that
+// send stale or garbage verifiers. A client listing a directory that
+// changes between its pages may then miss entries or see some twice.
+func WithLenientVerifier(lenient bool) CachingOption {
+	return func(c *CachingHandler) {
+		c.lenientVerifier = lenient
+	}
+}
+
+// WithTimeGranularity has the times of files reported truncated to d, for
+// backends keeping them more coarsely than they report them, so that
+// clients see no changes below d that are only noise.
+func WithTimeGranularity(d time.Duration) CachingOption {
+	return func(c *CachingHandler) {
+		c.timeGranularity = d
+	}
+}
+
+// WithAtimeUpdates has reads advance the access times of the files they
+// read as mode says, through the Chtimes of the file system's Change:
+// nfs.AtimeStrict on every read, nfs.AtimeRelative only once the file has
+// changed since it was last read or a day has passed, and nfs.AtimeNever,
+// the default, not at all.
+func WithAtimeUpdates(mode nfs.AtimeMode) CachingOption {
+	return func(c *CachingHandler) {
+		c.atimeMode = mode
 	}
 }
 
 // CachingHandler implements to/from handle via an LRU cache.
 type CachingHandler struct {
 	nfs.Handler
-	activeHandles   *lru.Cache[uuid.UUID, entry]
-	reverseHandles  map[string][]uuid.UUID
-	activeVerifiers *lru.Cache[uint64, verifier]
-	cacheLimit      int
+	// mu serializes updates spanning activeHandles and reverseHandles, so
+	// that concurrent renames and lookups observe a consistent mapping.
+	mu               sync.Mutex
+	activeHandles    *lru.Cache[uuid.UUID, entry]
+	reverseHandles   map[string][]uuid.UUID
+	activeVerifiers  *lru.Cache[uint64, verifier]
+	cacheLimit       int
+	noReverse        bool
+	logger           nfs.LeveledLogger
+	handleVersion    byte
+	maxNameLength    int
+	nameLengthUnit   nfs.NameLengthUnit
+	maxSymlinkLength int
+	// writeLocks holds a lock per file being written, when write locking
+	// is enabled. It is guarded by writeLocksMu.
+	writeLocksMu sync.Mutex
+	writeLocks   map[string]*writeLock
+	// rangeLocks is the table of byte-range locks enforced, if any.
+	rangeLocks *LockTable
+	// counts tracks recent insertions and evictions for Stats.
+	counts windowCounts
+	// now is the clock handles are stamped with as they are accessed.
+	now func() time.Time
+	// rates tracks the handles recently issued to each client, when
+	// WithHandleRateLimit is set. It is guarded by mu.
+	rates handleRates
+	// dryRun keeps the file systems mounted from changes, when WithDryRun
+	// is set.
+	dryRun bool
+	// onStaleHandle is told of the handles not found, if set.
+	onStaleHandle func(fh []byte)
+	// timeGranularity is what file times are truncated to, if set with
+	// WithTimeGranularity.
+	timeGranularity time.Duration
+	// atimeMode is when reads advance access times, if set with
+	// WithAtimeUpdates.
+	atimeMode nfs.AtimeMode
+	// lenientVerifier accepts cookies with mismatched verifiers, when set
+	// with WithLenientVerifier.
+	lenientVerifier bool
+	// releases holds the calls WhenReleased defers until no handle to a
+	// path remains. It is guarded by mu.
+	releases []pendingRelease
+}
+
+type pendingRelease struct {
+	f       billy.Filesystem
+	path    []string
+	release func()
+}
+
+type writeLock struct {
+	sync.Mutex
+	// waiters counts the writes holding or waiting for the lock, so it can
+	// be dropped once there are none.
+	waiters int
+}
+
+// Handle encoding versions. Versioned handles lead with their version byte,
+// so the format can evolve without misreading handles minted earlier.
+const (
+	// HandleVersionLegacy handles are a bare 16-byte uuid, as minted before
+	// handles carried a version.
+	HandleVersionLegacy byte = 0
+	// HandleVersion1 handles are the version byte followed by a 16-byte uuid.
+	HandleVersion1 byte = 1
+)
+
+var errUnknownHandleVersion = errors.New("unknown handle version")
+
+func (c *CachingHandler) encodeHandle(id uuid.UUID) []byte {
+	if c.handleVersion == HandleVersionLegacy {
+		return append([]byte{}, id[:]...)
+	}
+	return append([]byte{c.handleVersion}, id[:]...)
+}
+
+// MaxHandleSize is the size of the handles minted, those of every version
+// being a uuid after the version byte, if any.
+func (c *CachingHandler) MaxHandleSize() int {
+	if c.handleVersion == HandleVersionLegacy {
+		return len(uuid.UUID{})
+	}
+	return 1 + len(uuid.UUID{})
+}
+
+// decodeHandle accepts handles of any known version.
+func decodeHandle(fh []byte) (uuid.UUID, error) {
+	if len(fh) == len(uuid.UUID{}) {
+		return uuid.FromBytes(fh)
+	}
+	if len(fh) == 0 {
+		return uuid.UUID{}, nfs.ErrInputInvalid
+	}
+	switch fh[0] {
+	case HandleVersion1:
+		return uuid.FromBytes(fh[1:])
+	}
+	return uuid.UUID{}, errUnknownHandleVersion
 }
 
 type entry struct {
 	f billy.Filesystem
 	p []string
+	// added is when the handle was issued.
+	added time.Time
+	// accessed is when the handle was last issued or resolved.
+	accessed time.Time
 }
 
 // ToHandle takes a file and represents it with an opaque handle to reference it.
 // In stateless nfs (when it's serving a unix fs) this can be the device + inode
 // but we can generalize with a stateful local cache of handed out IDs.
 func (c *CachingHandler) ToHandle(f billy.Filesystem, path []string) []byte {
+	c.mu.Lock()
+	defer c.unlock()
+
 	joinedPath := f.Join(path...)
 
 	if handle := c.searchReverseCache(f, joinedPath); handle != nil {
 		return handle
 	}
+	return c.mintHandle(f, path, joinedPath)
+}
 
+// mintHandle issues a new handle to path. It expects c.mu to be held.
+func (c *CachingHandler) mintHandle(f billy.Filesystem, path []string, joinedPath string) []byte {
 	id := uuid.New()
 
 	newPath := make([]string, len(path))
 
 	copy(newPath, path)
+	now := c.now()
 	evictedKey, evictedPath, ok := c.activeHandles.GetOldest()
-	if evicted := c.activeHandles.Add(id, entry{f, newPath}); evicted && ok {
+	evicted := c.activeHandles.Add(id, entry{f, newPath, now, now})
+	if evicted && ok {
 		rk := evictedPath.f.Join(evictedPath.p...)
 		c.evictReverseCache(rk, evictedKey)
 	}
+	c.counts.add(now, evicted)
 
-	if _, ok := c.reverseHandles[joinedPath]; !ok {
-		c.reverseHandles[joinedPath] = []uuid.UUID{}
-	}
-	c.reverseHandles[joinedPath] = append(c.reverseHandles[joinedPath], id)
-	b, _ := id.MarshalBinary()
-
-	return b
+	c.addReverseCache(joinedPath, id)
+	return c.encodeHandle(id)
 }
 
 // FromHandle converts from an opaque handle to the file it represents
 func (c *CachingHandler) FromHandle(fh []byte) (billy.Filesystem, []string, error) {
-	id, err := uuid.FromBytes(fh)
+	id, err := decodeHandle(fh)
 	if err != nil {
-		return nil, []string{}, err
+		c.staleHandle(fh)
+		return nil, []string{}, &nfs.NFSStatusError{NFSStatus: nfs.NFSStatusStale, WrappedErr: err}
 	}
 
-	if f, ok := c.activeHandles.Get(id); ok {
+	c.mu.Lock()
+	if f, ok := c.activeHandles.Peek(id); ok {
+		now := c.now()
 		for _, k := range c.activeHandles.Keys() {
 			candidate, _ := c.activeHandles.Peek(k)
 			if hasPrefix(f.p, candidate.p) {
-				_, _ = c.activeHandles.Get(k)
+				c.touch(k, candidate, now)
 			}
 		}
-		if ok {
-			newP := make([]string, len(f.p))
-			copy(newP, f.p)
-			return f.f, newP, nil
-		}
+		c.mu.Unlock()
+		newP := make([]string, len(f.p))
+		copy(newP, f.p)
+		return f.f, newP, nil
+	}
+	c.mu.Unlock()
+	c.staleHandle(fh)
+	return nil, []string{}, &nfs.NFSStatusError{NFSStatus: nfs.NFSStatusStale}
+}
+
+// FromHandleShallow is FromHandle, refreshing only the handle itself and not
+// those of the directories above it, which costs a pass over the cache.
+// Handles are resolved alike for every request.
+func (c *CachingHandler) FromHandleShallow(_ context.Context, fh []byte) (billy.Filesystem, []string, error) {
+	id, err := decodeHandle(fh)
+	if err != nil {
+		c.staleHandle(fh)
+		return nil, []string{}, &nfs.NFSStatusError{NFSStatus: nfs.NFSStatusStale, WrappedErr: err}
+	}
+
+	c.mu.Lock()
+	if f, ok := c.activeHandles.Peek(id); ok {
+		c.touch(id, f, c.now())
+		c.mu.Unlock()
+		newP := make([]string, len(f.p))
+		copy(newP, f.p)
+		return f.f, newP, nil
 	}
+	c.mu.Unlock()
+	c.staleHandle(fh)
 	return nil, []string{}, &nfs.NFSStatusError{NFSStatus: nfs.NFSStatusStale}
 }
 
+// PathForHandle returns the path fh resolves to, for tooling and logs
+// mapping handles back to files. Unlike FromHandle it leaves the handle's
+// place in the cache alone, so that looking at a handle does not keep it
+// from being evicted.
+func (c *CachingHandler) PathForHandle(fh []byte) ([]string, bool) {
+	id, err := decodeHandle(fh)
+	if err != nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.activeHandles.Peek(id)
+	if !ok {
+		return nil, false
+	}
+	p := make([]string, len(f.p))
+	copy(p, f.p)
+	return p, true
+}
+
+// staleHandle tells the hook set with WithOnStaleHandle, if any, of fh
+// not being found.
+func (c *CachingHandler) staleHandle(fh []byte) {
+	if c.onStaleHandle != nil {
+		c.onStaleHandle(append([]byte(nil), fh...))
+	}
+}
+
+// touch marks the handle id, resolving to e, as accessed at now, moving it
+// to the back of the line for eviction. It expects c.mu to be held.
+func (c *CachingHandler) touch(id uuid.UUID, e entry, now time.Time) {
+	e.accessed = now
+	c.activeHandles.Add(id, e)
+}
+
+// searchReverseCache expects c.mu to be held.
 func (c *CachingHandler) searchReverseCache(f billy.Filesystem, path string) []byte {
+	if c.noReverse {
+		return nil
+	}
 	uuids, exists := c.reverseHandles[path]
 
 	if !exists {
@@ -110,9 +411,10 @@ func (c *CachingHandler) searchReverseCache(f billy.Filesystem, path string) []b
 	}
 
 	for _, id := range uuids {
-		if candidate, ok := c.activeHandles.Get(id); ok {
+		if candidate, ok := c.activeHandles.Peek(id); ok {
 			if reflect.DeepEqual(candidate.f, f) {
-				return id[:]
+				c.touch(id, candidate, c.now())
+				return c.encodeHandle(id)
 			}
 		}
 	}
@@ -120,6 +422,18 @@ func (c *CachingHandler) searchReverseCache(f billy.Filesystem, path string) []b
 	return nil
 }
 
+// addReverseCache expects c.mu to be held.
+func (c *CachingHandler) addReverseCache(path string, handle uuid.UUID) {
+	if c.noReverse {
+		return
+	}
+	if _, ok := c.reverseHandles[path]; !ok {
+		c.reverseHandles[path] = []uuid.UUID{}
+	}
+	c.reverseHandles[path] = append(c.reverseHandles[path], handle)
+}
+
+// evictReverseCache expects c.mu to be held.
 func (c *CachingHandler) evictReverseCache(path string, handle uuid.UUID) {
 	uuids, exists := c.reverseHandles[path]
 
@@ -129,15 +443,24 @@ func (c *CachingHandler) evictReverseCache(path string, handle uuid.UUID) {
 	for i, u := range uuids {
 		if u == handle {
 			uuids = append(uuids[:i], uuids[i+1:]...)
-			c.reverseHandles[path] = uuids
+			if len(uuids) == 0 {
+				delete(c.reverseHandles, path)
+			} else {
+				c.reverseHandles[path] = uuids
+			}
 			return
 		}
 	}
 }
 
 func (c *CachingHandler) InvalidateHandle(fs billy.Filesystem, handle []byte) error {
+	if _, ok := fs.(dryRunFS); ok {
+		return nil
+	}
 	//Remove from cache
-	id, _ := uuid.FromBytes(handle)
+	id, _ := decodeHandle(handle)
+	c.mu.Lock()
+	defer c.unlock()
 	entry, ok := c.activeHandles.Get(id)
 	if ok {
 		rk := entry.f.Join(entry.p...)
@@ -147,18 +470,56 @@ func (c *CachingHandler) InvalidateHandle(fs billy.Filesystem, handle []byte) er
 	return nil
 }
 
+// InvalidateSubtree removes every handle at or beneath the given path.
+// Like UpdateHandlesByPath, entries are matched by path regardless of which
+// filesystem instance they were created with.
+// Returns the number of handles invalidated.
+func (c *CachingHandler) InvalidateSubtree(fs billy.Filesystem, path []string) int {
+	if _, ok := fs.(dryRunFS); ok {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.unlock()
+
+	invalidated := 0
+	for _, id := range c.activeHandles.Keys() {
+		candidate, ok := c.activeHandles.Peek(id)
+		if !ok || !hasPrefix(candidate.p, path) {
+			continue
+		}
+		c.evictReverseCache(candidate.f.Join(candidate.p...), id)
+		c.activeHandles.Remove(id)
+		invalidated++
+	}
+	return invalidated
+}
+
 // UpdateHandle updates a handle's cached path after a rename operation.
 // This is critical for NFS silly rename support where files remain accessible
 // via their original handle even after being renamed.
 func (c *CachingHandler) UpdateHandle(fs billy.Filesystem, handle []byte, newPath []string) error {
-	id, err := uuid.FromBytes(handle)
+	_, err := c.UpdateHandleWithResult(fs, handle, newPath)
+	return err
+}
+
+// UpdateHandleWithResult is UpdateHandle, returning the handle in the
+// encoding this handler mints, which handles of older versions are
+// rewritten to.
+func (c *CachingHandler) UpdateHandleWithResult(fs billy.Filesystem, handle []byte, newPath []string) ([]byte, error) {
+	id, err := decodeHandle(handle)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	if _, ok := fs.(dryRunFS); ok {
+		return c.encodeHandle(id), nil
+	}
+
+	c.mu.Lock()
+	defer c.unlock()
 
 	oldEntry, ok := c.activeHandles.Get(id)
 	if !ok {
-		return &nfs.NFSStatusError{NFSStatus: nfs.NFSStatusStale}
+		return nil, &nfs.NFSStatusError{NFSStatus: nfs.NFSStatusStale}
 	}
 
 	// Remove from old reverse cache
@@ -168,60 +529,306 @@ func (c *CachingHandler) UpdateHandle(fs billy.Filesystem, handle []byte, newPat
 	// Update the entry with new path
 	newPathCopy := make([]string, len(newPath))
 	copy(newPathCopy, newPath)
-	c.activeHandles.Add(id, entry{f: fs, p: newPathCopy})
+	c.activeHandles.Add(id, entry{f: fs, p: newPathCopy, added: oldEntry.added, accessed: oldEntry.accessed})
 
 	// Add to new reverse cache
-	newPathJoined := fs.Join(newPath...)
-	if _, ok := c.reverseHandles[newPathJoined]; !ok {
-		c.reverseHandles[newPathJoined] = []uuid.UUID{}
-	}
-	c.reverseHandles[newPathJoined] = append(c.reverseHandles[newPathJoined], id)
+	c.addReverseCache(fs.Join(newPath...), id)
 
-	return nil
+	return c.encodeHandle(id), nil
 }
 
 // UpdateHandlesByPath updates ALL handles matching the old path to point to the new path.
 // This is used by rename operations to ensure all handles for a file are updated,
 // regardless of which filesystem instance they were created with.
+// Handles beneath the old path (when a directory is renamed) are moved along with it.
 func (c *CachingHandler) UpdateHandlesByPath(fs billy.Filesystem, oldPath []string, newPath []string) int {
-	oldPathJoined := fs.Join(oldPath...)
-	uuids, exists := c.reverseHandles[oldPathJoined]
-	if !exists || len(uuids) == 0 {
+	if _, ok := fs.(dryRunFS); ok {
 		return 0
 	}
+	c.mu.Lock()
+	defer c.unlock()
 
-	// Copy the slice since we'll modify reverseHandles
-	uuidsCopy := make([]uuid.UUID, len(uuids))
-	copy(uuidsCopy, uuids)
+	if len(oldPath) == 0 {
+		return 0
+	}
 
 	updated := 0
-	newPathJoined := fs.Join(newPath...)
-	newPathCopy := make([]string, len(newPath))
-	copy(newPathCopy, newPath)
-
-	for _, id := range uuidsCopy {
-		oldEntry, ok := c.activeHandles.Get(id)
-		if !ok {
+	for _, id := range c.activeHandles.Keys() {
+		oldEntry, ok := c.activeHandles.Peek(id)
+		if !ok || !hasPrefix(oldEntry.p, oldPath) {
 			continue
 		}
 
+		// Swap the renamed prefix, keeping anything beneath it.
+		updatedPath := make([]string, 0, len(newPath)+len(oldEntry.p)-len(oldPath))
+		updatedPath = append(updatedPath, newPath...)
+		updatedPath = append(updatedPath, oldEntry.p[len(oldPath):]...)
+
 		// Remove from old reverse cache
-		c.evictReverseCache(oldPathJoined, id)
+		c.evictReverseCache(oldEntry.f.Join(oldEntry.p...), id)
 
 		// Update the entry with new path (keep original filesystem)
-		c.activeHandles.Add(id, entry{f: oldEntry.f, p: newPathCopy})
+		c.activeHandles.Add(id, entry{f: oldEntry.f, p: updatedPath, added: oldEntry.added, accessed: oldEntry.accessed})
 
 		// Add to new reverse cache
-		if _, ok := c.reverseHandles[newPathJoined]; !ok {
-			c.reverseHandles[newPathJoined] = []uuid.UUID{}
-		}
-		c.reverseHandles[newPathJoined] = append(c.reverseHandles[newPathJoined], id)
+		c.addReverseCache(oldEntry.f.Join(updatedPath...), id)
 		updated++
 	}
 
 	return updated
 }
 
+// WhenReleased calls release once no handle to path remains, as handles
+// are invalidated, moved or evicted, or at once if none does. Handles are
+// matched by path regardless of which filesystem instance they were created
+// with, as UpdateHandlesByPath matches them.
+func (c *CachingHandler) WhenReleased(f billy.Filesystem, path []string, release func()) {
+	c.mu.Lock()
+	c.releases = append(c.releases, pendingRelease{f, append([]string(nil), path...), release})
+	c.unlock()
+}
+
+// heldPath reports whether any handle to path remains. It expects c.mu to
+// be held.
+func (c *CachingHandler) heldPath(f billy.Filesystem, path []string) bool {
+	if !c.noReverse {
+		return len(c.reverseHandles[f.Join(path...)]) > 0
+	}
+	for _, id := range c.activeHandles.Keys() {
+		if e, ok := c.activeHandles.Peek(id); ok && len(e.p) == len(path) && hasPrefix(e.p, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// unlock releases c.mu, then makes the calls WhenReleased deferred for the
+// paths no handle remains to.
+func (c *CachingHandler) unlock() {
+	var due []func()
+	pending := c.releases[:0]
+	for _, r := range c.releases {
+		if c.heldPath(r.f, r.path) {
+			pending = append(pending, r)
+		} else {
+			due = append(due, r.release)
+		}
+	}
+	c.releases = pending
+	c.mu.Unlock()
+	for _, release := range due {
+		release()
+	}
+}
+
+// Logger returns the handler's logger, defaulting to nfs.Log.
+func (c *CachingHandler) Logger() nfs.LeveledLogger {
+	if c.logger != nil {
+		return c.logger
+	}
+	return nfs.Log
+}
+
+// MaxNameLength returns the longest file name accepted, deferring to the
+// wrapped handler unless set with WithMaxNameLength.
+func (c *CachingHandler) MaxNameLength() int {
+	if c.maxNameLength > 0 {
+		return c.maxNameLength
+	}
+	if nh, ok := c.Handler.(nfs.NameLengthHandler); ok {
+		return nh.MaxNameLength()
+	}
+	return nfs.PathNameMax
+}
+
+// NameLengthUnit returns the unit file names are measured in, deferring to
+// the wrapped handler unless set with WithNameLengthUnit.
+func (c *CachingHandler) NameLengthUnit() nfs.NameLengthUnit {
+	if c.nameLengthUnit != nfs.NameLengthBytes {
+		return c.nameLengthUnit
+	}
+	if uh, ok := c.Handler.(nfs.NameLengthUnitHandler); ok {
+		return uh.NameLengthUnit()
+	}
+	return nfs.NameLengthBytes
+}
+
+// MaxSymlinkLength returns the longest symlink target accepted, deferring
+// to the wrapped handler unless set with WithMaxSymlinkLength.
+func (c *CachingHandler) MaxSymlinkLength() int {
+	if c.maxSymlinkLength > 0 {
+		return c.maxSymlinkLength
+	}
+	if sh, ok := c.Handler.(nfs.SymlinkLengthHandler); ok {
+		return sh.MaxSymlinkLength()
+	}
+	return nfs.SymlinkMax
+}
+
+// CaseInsensitive defers to the wrapped handler's CaseInsensitive, if it
+// has one.
+func (c *CachingHandler) CaseInsensitive(f billy.Filesystem) bool {
+	if ch, ok := c.Handler.(nfs.CaseInsensitiveHandler); ok {
+		return ch.CaseInsensitive(c.backend(f))
+	}
+	return false
+}
+
+// TimeGranularity is what file times are reported truncated to, as set
+// with WithTimeGranularity, or else that of the wrapped handler, if it is
+// an nfs.TimeGranularityHandler.
+func (c *CachingHandler) TimeGranularity(f billy.Filesystem) time.Duration {
+	if c.timeGranularity > 0 {
+		return c.timeGranularity
+	}
+	if th, ok := c.Handler.(nfs.TimeGranularityHandler); ok {
+		return th.TimeGranularity(c.backend(f))
+	}
+	return 0
+}
+
+// AtimeUpdates is when reads advance access times, as set with
+// WithAtimeUpdates, or else as the wrapped handler has it, if it is an
+// nfs.AtimeHandler.
+func (c *CachingHandler) AtimeUpdates(f billy.Filesystem) nfs.AtimeMode {
+	if c.atimeMode != nfs.AtimeNever {
+		return c.atimeMode
+	}
+	if ah, ok := c.Handler.(nfs.AtimeHandler); ok {
+		return ah.AtimeUpdates(c.backend(f))
+	}
+	return nfs.AtimeNever
+}
+
+// LenientVerifier reports whether listings carry on from cookies whose
+// verifier does not match, as set with WithLenientVerifier.
+func (c *CachingHandler) LenientVerifier(f billy.Filesystem) bool {
+	return c.lenientVerifier
+}
+
+// FileIDFor defers to the wrapped handler's FileIDFor, if it has one.
+func (c *CachingHandler) FileIDFor(f billy.Filesystem, path []string) uint64 {
+	if ih, ok := c.Handler.(nfs.FileIDHandler); ok {
+		return ih.FileIDFor(c.backend(f), path)
+	}
+	return 0
+}
+
+// FSIDFor defers to the wrapped handler's FSIDFor, if it has one.
+func (c *CachingHandler) FSIDFor(f billy.Filesystem) uint64 {
+	if ih, ok := c.Handler.(nfs.FSIDHandler); ok {
+		return ih.FSIDFor(c.backend(f))
+	}
+	return 0
+}
+
+// Exports defers to the wrapped handler's Exports, if it has one, or else
+// lists only the root.
+func (c *CachingHandler) Exports() []nfs.Export {
+	if eh, ok := c.Handler.(nfs.ExportingHandler); ok {
+		return eh.Exports()
+	}
+	return []nfs.Export{{Dir: "/"}}
+}
+
+// OnChange passes the change on to the wrapped handler, if it is an
+// nfs.ChangeNotifier and the change was not a dry run.
+func (c *CachingHandler) OnChange(op string, f billy.Filesystem, path []string) {
+	if _, ok := f.(dryRunFS); ok {
+		return
+	}
+	if n, ok := c.Handler.(nfs.ChangeNotifier); ok {
+		n.OnChange(op, f, path)
+	}
+}
+
+// OnRename passes the rename on to the wrapped handler, if it is an
+// nfs.RenameNotifier, or else as a change to the new path if it is an
+// nfs.ChangeNotifier, unless it was a dry run.
+func (c *CachingHandler) OnRename(f billy.Filesystem, from, to []string) {
+	if _, ok := f.(dryRunFS); ok {
+		return
+	}
+	if n, ok := c.Handler.(nfs.RenameNotifier); ok {
+		n.OnRename(f, from, to)
+	} else if n, ok := c.Handler.(nfs.ChangeNotifier); ok {
+		n.OnChange(nfs.ChangeRename, f, to)
+	}
+}
+
+// RangeLocked consults the handler's table of byte-range locks, if it has
+// one, then the wrapped handler, if it is an nfs.RangeLockHandler.
+func (c *CachingHandler) RangeLocked(ctx context.Context, f billy.Filesystem, path []string, offset, length uint64, write bool) bool {
+	if c.rangeLocks != nil && c.rangeLocks.RangeLocked(ctx, f, path, offset, length, write) {
+		return true
+	}
+	if rl, ok := c.Handler.(nfs.RangeLockHandler); ok {
+		return rl.RangeLocked(ctx, c.backend(f), path, offset, length, write)
+	}
+	return false
+}
+
+// errNoLockTable is returned by LockRange on a handler without a table of
+// byte-range locks to take them in.
+var errNoLockTable = errors.New("no table of byte-range locks")
+
+// LockRange takes the lock in the handler's table of byte-range locks, if
+// it has one, for the principal making the request ctx belongs to, or
+// else defers to the wrapped handler, if it is an nfs.LockHandler.
+func (c *CachingHandler) LockRange(ctx context.Context, f billy.Filesystem, path []string, offset, length uint64, exclusive bool) error {
+	if c.rangeLocks != nil {
+		owner, _ := nfs.PrincipalFromContext(ctx)
+		return c.rangeLocks.Lock(f, path, owner, offset, length, exclusive)
+	}
+	if lh, ok := c.Handler.(nfs.LockHandler); ok {
+		return lh.LockRange(ctx, c.backend(f), path, offset, length, exclusive)
+	}
+	return errNoLockTable
+}
+
+// UnlockRange releases what the principal making the request ctx belongs to
+// holds of the range, in the table LockRange takes locks in.
+func (c *CachingHandler) UnlockRange(ctx context.Context, f billy.Filesystem, path []string, offset, length uint64) {
+	if c.rangeLocks != nil {
+		owner, _ := nfs.PrincipalFromContext(ctx)
+		c.rangeLocks.Unlock(f, path, owner, offset, length)
+		return
+	}
+	if lh, ok := c.Handler.(nfs.LockHandler); ok {
+		lh.UnlockRange(ctx, c.backend(f), path, offset, length)
+	}
+}
+
+// LockWrites waits until no other write to the file at path is in progress
+// if write locking is enabled, returning the func that releases it, or nil
+// if it is not.
+func (c *CachingHandler) LockWrites(f billy.Filesystem, path []string) func() {
+	if c.writeLocks == nil {
+		return nil
+	}
+	key := f.Join(path...)
+
+	c.writeLocksMu.Lock()
+	l, ok := c.writeLocks[key]
+	if !ok {
+		l = &writeLock{}
+		c.writeLocks[key] = l
+	}
+	l.waiters++
+	c.writeLocksMu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		c.writeLocksMu.Lock()
+		l.waiters--
+		if l.waiters == 0 {
+			delete(c.writeLocks, key)
+		}
+		c.writeLocksMu.Unlock()
+	}
+}
+
 // HandleLimit exports how many file handles can be safely stored by this cache.
 func (c *CachingHandler) HandleLimit() int {
 	return c.cacheLimit
@@ -252,8 +859,17 @@ func hashPathAndContents(path string, contents []fs.FileInfo) uint64 {
 	vHash.Write(binary.BigEndian.AppendUint64([]byte{}, uint64(len(path))))
 	vHash.Write([]byte(path))
 
+	// Hash the names in order, each prefixed with its length, so that the
+	// verifier does not depend on the order of contents and no two
+	// listings run together into the same bytes.
+	names := make([]string, 0, len(contents))
 	for _, c := range contents {
-		vHash.Write([]byte(c.Name())) // Never fails according to the docs
+		names = append(names, c.Name())
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		vHash.Write(binary.BigEndian.AppendUint64([]byte{}, uint64(len(name)))) // Never fails according to the docs
+		vHash.Write([]byte(name))
 	}
 
 	verify := vHash.Sum(nil)[0:8]
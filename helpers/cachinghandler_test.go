@@ -0,0 +1,387 @@
+package helpers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/google/uuid"
+	"github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers/memfs"
+)
+
+func newTestCachingHandler(t *testing.T, limit int) (*CachingHandler, billy.Filesystem) {
+	t.Helper()
+	mem := memfs.New()
+	h, ok := NewCachingHandler(NewNullAuthHandler(mem), limit).(*CachingHandler)
+	if !ok {
+		t.Fatal("expected a *CachingHandler")
+	}
+	return h, mem
+}
+
+func TestInvalidateSubtree(t *testing.T) {
+	c, mem := newTestCachingHandler(t, 1024)
+
+	dir := c.ToHandle(mem, []string{"dir"})
+	a := c.ToHandle(mem, []string{"dir", "a"})
+	nested := c.ToHandle(mem, []string{"dir", "sub", "b"})
+	sibling := c.ToHandle(mem, []string{"dirx"})
+	other := c.ToHandle(mem, []string{"other", "c"})
+
+	if n := c.InvalidateSubtree(mem, []string{"dir"}); n != 3 {
+		t.Fatalf("expected 3 handles invalidated, got %d", n)
+	}
+
+	for _, h := range [][]byte{dir, a, nested} {
+		if _, _, err := c.FromHandle(h); err == nil {
+			t.Fatalf("handle %x should be stale after subtree invalidation", h)
+		}
+	}
+	for _, h := range [][]byte{sibling, other} {
+		if _, _, err := c.FromHandle(h); err != nil {
+			t.Fatalf("handle %x outside the subtree should still resolve: %v", h, err)
+		}
+	}
+
+	for _, p := range []string{"dir", mem.Join("dir", "a"), mem.Join("dir", "sub", "b")} {
+		if ids := c.reverseHandles[p]; len(ids) != 0 {
+			t.Fatalf("reverse cache for %q should be empty, got %v", p, ids)
+		}
+	}
+
+	// A fresh lookup after invalidation must not reuse the old handle.
+	if fresh := c.ToHandle(mem, []string{"dir", "a"}); bytes.Equal(fresh, a) {
+		t.Fatal("expected a new handle after invalidation")
+	}
+}
+
+func TestUpdateHandlesByPathRenameChain(t *testing.T) {
+	c, mem := newTestCachingHandler(t, 1024)
+
+	const files = 8
+	const hops = 16
+	name := func(file, hop int) []string {
+		return []string{"dir", fmt.Sprintf("f%d-%d", file, hop)}
+	}
+
+	handles := make([][]byte, files)
+	for i := range handles {
+		handles[i] = c.ToHandle(mem, name(i, 0))
+	}
+
+	// Each hop of each chain runs on its own goroutine, waiting for the
+	// previous hop so the renames happen in order but hand off between
+	// goroutines, while unrelated lookups contend for the cache.
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < files; i++ {
+		prev := make(chan struct{})
+		close(prev)
+		for hop := 0; hop < hops; hop++ {
+			next := make(chan struct{})
+			wg.Add(1)
+			go func(file, hop int, prev <-chan struct{}, next chan<- struct{}) {
+				defer wg.Done()
+				<-prev
+				if n := c.UpdateHandlesByPath(mem, name(file, hop), name(file, hop+1)); n != 1 {
+					t.Errorf("rename %d of file %d updated %d handles, expected 1", hop, file, n)
+				}
+				close(next)
+			}(i, hop, prev, next)
+			prev = next
+		}
+	}
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func(r int) {
+			defer readers.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				_ = c.ToHandle(mem, []string{"noise", fmt.Sprintf("%d-%d", r, n%32)})
+				for _, h := range handles {
+					_, _, _ = c.FromHandle(h)
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+	close(done)
+	readers.Wait()
+
+	for i, h := range handles {
+		_, p, err := c.FromHandle(h)
+		if err != nil {
+			t.Fatalf("handle for file %d no longer resolves: %v", i, err)
+		}
+		if !reflect.DeepEqual(p, name(i, hops)) {
+			t.Fatalf("handle for file %d resolves to %v, expected %v", i, p, name(i, hops))
+		}
+		for hop := 0; hop < hops; hop++ {
+			if ids := c.reverseHandles[mem.Join(name(i, hop)...)]; len(ids) != 0 {
+				t.Fatalf("intermediate path %v still has handles %v", name(i, hop), ids)
+			}
+		}
+	}
+}
+
+func TestUpdateHandlesByPathMovesDescendants(t *testing.T) {
+	c, mem := newTestCachingHandler(t, 1024)
+
+	dir := c.ToHandle(mem, []string{"dir"})
+	a := c.ToHandle(mem, []string{"dir", "a"})
+	b := c.ToHandle(mem, []string{"dir", "sub", "b"})
+	unrelated := c.ToHandle(mem, []string{"dirx", "c"})
+
+	if n := c.UpdateHandlesByPath(mem, []string{"dir"}, []string{"renamed"}); n != 3 {
+		t.Fatalf("expected 3 handles updated, got %d", n)
+	}
+
+	expected := map[string][]string{
+		string(dir):       {"renamed"},
+		string(a):         {"renamed", "a"},
+		string(b):         {"renamed", "sub", "b"},
+		string(unrelated): {"dirx", "c"},
+	}
+	for h, want := range expected {
+		_, p, err := c.FromHandle([]byte(h))
+		if err != nil {
+			t.Fatalf("handle for %v no longer resolves: %v", want, err)
+		}
+		if !reflect.DeepEqual(p, want) {
+			t.Fatalf("handle resolves to %v, expected %v", p, want)
+		}
+	}
+
+	// Lookups of the new names reuse the migrated handles.
+	if got := c.ToHandle(mem, []string{"renamed", "a"}); !bytes.Equal(got, a) {
+		t.Fatal("expected the migrated handle to be found by its new path")
+	}
+	if ids := c.reverseHandles[mem.Join("dir", "a")]; len(ids) != 0 {
+		t.Fatalf("old path still has handles %v", ids)
+	}
+}
+
+func TestNoReverseCache(t *testing.T) {
+	mem := memfs.New()
+	c := NewCachingHandlerNoReverse(NewNullAuthHandler(mem), 1024).(*CachingHandler)
+
+	first := c.ToHandle(mem, []string{"a"})
+	second := c.ToHandle(mem, []string{"a"})
+	if bytes.Equal(first, second) {
+		t.Fatal("expected a fresh handle for each ToHandle without the reverse cache")
+	}
+	if len(c.reverseHandles) != 0 {
+		t.Fatalf("reverse cache should stay empty, got %v", c.reverseHandles)
+	}
+
+	if n := c.UpdateHandlesByPath(mem, []string{"a"}, []string{"b"}); n != 2 {
+		t.Fatalf("expected both handles renamed, got %d", n)
+	}
+	for _, h := range [][]byte{first, second} {
+		if _, p, err := c.FromHandle(h); err != nil || !reflect.DeepEqual(p, []string{"b"}) {
+			t.Fatalf("handle resolved to %v (%v), expected [b]", p, err)
+		}
+	}
+}
+
+func BenchmarkToHandle(b *testing.B) {
+	mem := memfs.New()
+	handlers := map[string]*CachingHandler{
+		"reverse":   NewCachingHandler(NewNullAuthHandler(mem), 1024).(*CachingHandler),
+		"noReverse": NewCachingHandlerNoReverse(NewNullAuthHandler(mem), 1024).(*CachingHandler),
+	}
+	for _, name := range []string{"reverse", "noReverse"} {
+		c := handlers[name]
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				// Unique paths, as in a create-heavy workload.
+				_ = c.ToHandle(mem, []string{"dir", strconv.Itoa(i)})
+			}
+		})
+	}
+}
+
+type warnCapture struct {
+	nfs.LeveledLogger
+	warnings []string
+}
+
+func (w *warnCapture) Warnf(format string, args ...interface{}) {
+	w.warnings = append(w.warnings, fmt.Sprintf(format, args...))
+}
+
+func TestCachingHandlerLogger(t *testing.T) {
+	logs := &warnCapture{}
+	c := NewCachingHandler(NewNullAuthHandler(memfs.New()), 1, WithLogger(logs)).(*CachingHandler)
+	if len(logs.warnings) != 1 || !strings.Contains(logs.warnings[0], "size 1") {
+		t.Fatalf("expected a warning about the cache size, got %v", logs.warnings)
+	}
+	if c.Logger() != logs {
+		t.Fatal("expected the handler to expose its logger")
+	}
+}
+
+func TestHandleVersions(t *testing.T) {
+	c, mem := newTestCachingHandler(t, 1024)
+
+	h := c.ToHandle(mem, []string{"a"})
+	if len(h) != 17 || h[0] != HandleVersion1 {
+		t.Fatalf("expected a version 1 handle, got %x", h)
+	}
+	if _, p, err := c.FromHandle(h); err != nil || !reflect.DeepEqual(p, []string{"a"}) {
+		t.Fatalf("current-version handle resolved to %v (%v)", p, err)
+	}
+
+	// Handles minted before versioning are the bare uuid.
+	if _, p, err := c.FromHandle(h[1:]); err != nil || !reflect.DeepEqual(p, []string{"a"}) {
+		t.Fatalf("legacy handle resolved to %v (%v)", p, err)
+	}
+
+	unknown := append([]byte{}, h...)
+	unknown[0] = 0x7f
+	_, _, err := c.FromHandle(unknown)
+	var statusErr *nfs.NFSStatusError
+	if !errors.As(err, &statusErr) || statusErr.NFSStatus != nfs.NFSStatusStale {
+		t.Fatalf("expected an unknown version to be STALE, got %v", err)
+	}
+
+	legacy := NewCachingHandler(NewNullAuthHandler(mem), 1024, WithHandleVersion(HandleVersionLegacy)).(*CachingHandler)
+	if lh := legacy.ToHandle(mem, []string{"a"}); len(lh) != 16 {
+		t.Fatalf("expected a bare uuid handle, got %x", lh)
+	}
+}
+
+func TestUpdateHandleWithResult(t *testing.T) {
+	c, mem := newTestCachingHandler(t, 1024)
+
+	h := c.ToHandle(mem, []string{"a"})
+	updated, err := c.UpdateHandleWithResult(mem, h, []string{"dir", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(updated, h) {
+		t.Fatalf("expected the handle to survive the update, got %x for %x", updated, h)
+	}
+	if _, p, err := c.FromHandle(updated); err != nil || !reflect.DeepEqual(p, []string{"dir", "b"}) {
+		t.Fatalf("updated handle resolved to %v (%v)", p, err)
+	}
+
+	// a handle of an older version comes back in the current encoding.
+	legacy, err := c.UpdateHandleWithResult(mem, h[1:], []string{"c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(legacy, h) {
+		t.Fatalf("expected the legacy handle rewritten to %x, got %x", h, legacy)
+	}
+	if _, p, err := c.FromHandle(legacy); err != nil || !reflect.DeepEqual(p, []string{"c"}) {
+		t.Fatalf("rewritten handle resolved to %v (%v)", p, err)
+	}
+
+	if _, err := c.UpdateHandleWithResult(mem, c.encodeHandle(uuid.New()), []string{"d"}); err == nil {
+		t.Fatal("expected updating an unknown handle to fail")
+	}
+}
+
+func TestVerifierOrderIndependent(t *testing.T) {
+	mem := memfs.New()
+	for _, name := range []string{"a", "b", "ab", "c"} {
+		if err := mem.MkdirAll(name, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	infos := func(names ...string) []fs.FileInfo {
+		out := make([]fs.FileInfo, 0, len(names))
+		for _, n := range names {
+			info, err := mem.Stat(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, info)
+		}
+		return out
+	}
+
+	base := hashPathAndContents("/dir", infos("a", "b", "c"))
+	if v := hashPathAndContents("/dir", infos("c", "a", "b")); v != base {
+		t.Fatalf("reordered contents changed the verifier: %x != %x", v, base)
+	}
+	if v := hashPathAndContents("/dir", infos("a", "b", "c", "ab")); v == base {
+		t.Fatal("adding an entry left the verifier unchanged")
+	}
+	if v := hashPathAndContents("/dir", infos("a", "c")); v == base {
+		t.Fatal("removing an entry left the verifier unchanged")
+	}
+	// names must not run together: {"a", "b"} is not {"ab"}.
+	if hashPathAndContents("/dir", infos("a", "b")) == hashPathAndContents("/dir", infos("ab")) {
+		t.Fatal("distinct listings concatenating to the same names share a verifier")
+	}
+}
+
+func TestOnStaleHandle(t *testing.T) {
+	mem := memfs.New()
+	var stale [][]byte
+	c := NewCachingHandler(NewNullAuthHandler(mem), 1024, WithOnStaleHandle(func(fh []byte) {
+		stale = append(stale, fh)
+	})).(*CachingHandler)
+
+	known := c.ToHandle(mem, []string{"known"})
+	if _, _, err := c.FromHandle(known); err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 0 {
+		t.Fatalf("hook fired for a known handle: %x", stale)
+	}
+
+	missing := c.encodeHandle(uuid.New())
+	if _, _, err := c.FromHandle(missing); err == nil {
+		t.Fatal("expected an unknown handle to be stale")
+	}
+	if len(stale) != 1 || !bytes.Equal(stale[0], missing) {
+		t.Fatalf("expected the hook to be given %x, got %x", missing, stale)
+	}
+}
+
+func TestPathForHandle(t *testing.T) {
+	c, mem := newTestCachingHandler(t, 2)
+	older := c.ToHandle(mem, []string{"dir", "older"})
+	newer := c.ToHandle(mem, []string{"dir", "newer"})
+
+	p, ok := c.PathForHandle(older)
+	if !ok || !reflect.DeepEqual(p, []string{"dir", "older"}) {
+		t.Fatalf("expected the path of a live handle, got %v, %v", p, ok)
+	}
+	p[0] = "changed"
+	if p, _ := c.PathForHandle(older); p[0] != "dir" {
+		t.Fatalf("changing a returned path changed the cached one to %v", p)
+	}
+	if _, ok := c.PathForHandle(c.encodeHandle(uuid.New())); ok {
+		t.Fatal("expected no path for an unknown handle")
+	}
+	if _, ok := c.PathForHandle([]byte("garbage")); ok {
+		t.Fatal("expected no path for a malformed handle")
+	}
+
+	// looking at the older handle left it first in line for eviction.
+	c.ToHandle(mem, []string{"dir", "newest"})
+	if _, ok := c.PathForHandle(older); ok {
+		t.Fatal("expected the older handle evicted")
+	}
+	if _, ok := c.PathForHandle(newer); !ok {
+		t.Fatal("expected the newer handle kept")
+	}
+}
@@ -0,0 +1,28 @@
+package helpers
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"github.com/willscott/go-nfs"
+)
+
+// NewCaseInsensitiveHandler wraps h so that names are matched without
+// regard to case, as clients of Windows and macOS servers expect: PATHCONF
+// reports case_insensitive, and LOOKUP, CREATE and RENAME resolve a name
+// to the existing entry differing from it only in case. It is meant to
+// wrap a handler like NullAuthHandler, beneath a CachingHandler, since
+// other optional interfaces of h are not forwarded.
+func NewCaseInsensitiveHandler(h nfs.Handler) nfs.Handler {
+	return &CaseInsensitiveHandler{h}
+}
+
+// CaseInsensitiveHandler is a handler matching the names of its backend
+// without regard to case.
+type CaseInsensitiveHandler struct {
+	nfs.Handler
+}
+
+// CaseInsensitive reports that names in every filesystem are matched
+// without regard to case.
+func (h *CaseInsensitiveHandler) CaseInsensitive(fs billy.Filesystem) bool {
+	return true
+}
@@ -0,0 +1,272 @@
+package helpers
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/willscott/go-nfs"
+)
+
+// WithDryRun has the file systems mounted through the handler checked, but
+// not changed, by the calls that would change them: each gets the status
+// it would have, as far as the existence and kind of the files it names
+// tell, while the backend, and the handles issued for it, are left
+// untouched. Name lengths, export policy and read-only mode are applied
+// as usual, and reads behave normally. It lets an operator try a
+// configuration against real data.
+func WithDryRun(dryRun bool) CachingOption {
+	return func(c *CachingHandler) {
+		c.dryRun = dryRun
+	}
+}
+
+// Mount is the wrapped handler's Mount, with the file system mounted kept
+// from changes when the handler is a dry run.
+func (c *CachingHandler) Mount(ctx context.Context, conn net.Conn, req nfs.MountRequest) (nfs.MountStatus, billy.Filesystem, []nfs.AuthFlavor) {
+	status, fs, auths := c.Handler.Mount(ctx, conn, req)
+	if c.dryRun && fs != nil {
+		fs = dryRunFS{fs}
+	}
+	return status, fs, auths
+}
+
+// Change is the wrapped handler's Change, or for a dry run one that checks
+// but makes no change.
+func (c *CachingHandler) Change(f billy.Filesystem) billy.Change {
+	if d, ok := f.(dryRunFS); ok {
+		return dryRunChange{d}
+	}
+	return c.Handler.Change(f)
+}
+
+// FSStat is the wrapped handler's FSStat.
+func (c *CachingHandler) FSStat(ctx context.Context, f billy.Filesystem, s *nfs.FSStat) error {
+	return c.Handler.FSStat(ctx, c.backend(f), s)
+}
+
+// backend returns the file system the wrapped handler mounted as f.
+func (c *CachingHandler) backend(f billy.Filesystem) billy.Filesystem {
+	if d, ok := f.(dryRunFS); ok {
+		return d.Filesystem
+	}
+	return f
+}
+
+// dryRunFS is a file system that checks the changes asked of it could be
+// made, without making them. Reads go to the backend.
+type dryRunFS struct {
+	billy.Filesystem
+}
+
+// Capabilities are those of the backend.
+func (d dryRunFS) Capabilities() billy.Capability {
+	return billy.Capabilities(d.Filesystem)
+}
+
+// Prefetch is that of the backend, if it can prefetch, as prefetching
+// changes nothing.
+func (d dryRunFS) Prefetch(filename string, offset int64, length int) error {
+	return prefetch(d.Filesystem, filename, offset, length)
+}
+
+// checkDir fails unless there is a directory at dir.
+func (d dryRunFS) checkDir(dir string) error {
+	info, err := d.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return &os.PathError{Op: "open", Path: dir, Err: syscall.ENOTDIR}
+	}
+	return nil
+}
+
+// checkNew fails unless name could be created.
+func (d dryRunFS) checkNew(name string) error {
+	if _, err := d.Lstat(name); err == nil {
+		return &os.PathError{Op: "create", Path: name, Err: os.ErrExist}
+	}
+	return d.checkDir(filepath.Dir(name))
+}
+
+func (d dryRunFS) Create(filename string) (billy.File, error) {
+	return d.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (d dryRunFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return d.Filesystem.OpenFile(filename, flag, perm)
+	}
+	info, err := d.Lstat(filename)
+	if err != nil {
+		if !os.IsNotExist(err) || flag&os.O_CREATE == 0 {
+			return nil, err
+		}
+		if err := d.checkDir(filepath.Dir(filename)); err != nil {
+			return nil, err
+		}
+		return &dryRunFile{name: filename}, nil
+	}
+	if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+	}
+	if info.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: syscall.EISDIR}
+	}
+	// opening the file as asked, less the flags that would change it,
+	// checks it may be written.
+	check, err := d.Filesystem.OpenFile(filename, flag&^(os.O_CREATE|os.O_EXCL|os.O_TRUNC|os.O_APPEND), perm)
+	if err != nil {
+		return nil, err
+	}
+	if err := check.Close(); err != nil {
+		return nil, err
+	}
+	f, err := d.Filesystem.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &dryRunFile{name: filename, File: f}, nil
+}
+
+func (d dryRunFS) Rename(oldpath, newpath string) error {
+	if _, err := d.Lstat(oldpath); err != nil {
+		return err
+	}
+	return d.checkDir(filepath.Dir(newpath))
+}
+
+func (d dryRunFS) Remove(filename string) error {
+	info, err := d.Lstat(filename)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		contents, err := d.ReadDir(filename)
+		if err != nil {
+			return err
+		}
+		if len(contents) > 0 {
+			return &os.PathError{Op: "remove", Path: filename, Err: syscall.ENOTEMPTY}
+		}
+	}
+	return nil
+}
+
+func (d dryRunFS) MkdirAll(filename string, perm os.FileMode) error {
+	if info, err := d.Lstat(filename); err == nil {
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: filename, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	return d.checkDir(filepath.Dir(filename))
+}
+
+func (d dryRunFS) Symlink(target, link string) error {
+	return d.checkNew(link)
+}
+
+func (d dryRunFS) TempFile(dir, prefix string) (billy.File, error) {
+	return nil, billy.ErrNotSupported
+}
+
+func (d dryRunFS) Chroot(path string) (billy.Filesystem, error) {
+	fs, err := d.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	return dryRunFS{fs}, nil
+}
+
+// dryRunChange checks the changes of file attributes asked of it name
+// files that exist, and that the files it would create could be.
+type dryRunChange struct {
+	fs dryRunFS
+}
+
+func (c dryRunChange) exists(name string) error {
+	_, err := c.fs.Lstat(name)
+	return err
+}
+
+func (c dryRunChange) Chmod(name string, mode os.FileMode) error { return c.exists(name) }
+
+func (c dryRunChange) Lchown(name string, uid, gid int) error { return c.exists(name) }
+
+func (c dryRunChange) Chown(name string, uid, gid int) error { return c.exists(name) }
+
+func (c dryRunChange) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return c.exists(name)
+}
+
+func (c dryRunChange) Mknod(path string, mode uint32, major uint32, minor uint32) error {
+	return c.fs.checkNew(path)
+}
+
+func (c dryRunChange) Mkfifo(path string, mode uint32) error { return c.fs.checkNew(path) }
+
+func (c dryRunChange) Socket(path string) error { return c.fs.checkNew(path) }
+
+func (c dryRunChange) Link(path string, link string) error {
+	if err := c.exists(path); err != nil {
+		return err
+	}
+	return c.fs.checkNew(link)
+}
+
+// dryRunFile is a file opened for writing in a dry run, whose writes are
+// discarded. Reads are of the file as it is in the backend, if it is
+// there.
+type dryRunFile struct {
+	billy.File
+	name string
+}
+
+func (f *dryRunFile) Name() string { return f.name }
+
+func (f *dryRunFile) Write(p []byte) (int, error) { return len(p), nil }
+
+func (f *dryRunFile) WriteAt(p []byte, off int64) (int, error) { return len(p), nil }
+
+func (f *dryRunFile) Truncate(size int64) error { return nil }
+
+func (f *dryRunFile) Sync() error { return nil }
+
+func (f *dryRunFile) Read(p []byte) (int, error) {
+	if f.File == nil {
+		return 0, io.EOF
+	}
+	return f.File.Read(p)
+}
+
+func (f *dryRunFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.File == nil {
+		return 0, io.EOF
+	}
+	return f.File.ReadAt(p, off)
+}
+
+func (f *dryRunFile) Seek(offset int64, whence int) (int64, error) {
+	if f.File == nil {
+		return 0, nil
+	}
+	return f.File.Seek(offset, whence)
+}
+
+func (f *dryRunFile) Close() error {
+	if f.File == nil {
+		return nil
+	}
+	return f.File.Close()
+}
+
+func (f *dryRunFile) Lock() error { return nil }
+
+func (f *dryRunFile) Unlock() error { return nil }
@@ -0,0 +1,80 @@
+package helpers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/willscott/go-nfs"
+)
+
+var errHandleRateLimited = errors.New("too many new handles")
+
+// WithHandleRateLimit limits each client to perSecond new handles a
+// second, so that one client looking up files in a loop cannot evict the
+// handles of all others from the cache. Handles already issued are not
+// limited. Clients asking for more are told to retry with
+// NFS3ERR_JUKEBOX.
+func WithHandleRateLimit(perSecond int) CachingOption {
+	return func(c *CachingHandler) {
+		c.rates.perSecond = perSecond
+	}
+}
+
+// handleRates counts the handles issued to each client over the current
+// second.
+type handleRates struct {
+	perSecond int
+	clients   map[string]*handleRate
+}
+
+type handleRate struct {
+	start time.Time
+	count int
+}
+
+// allow counts a new handle for client, unless it has had its share.
+func (r *handleRates) allow(client string, now time.Time) bool {
+	if r.perSecond <= 0 {
+		return true
+	}
+	rate, ok := r.clients[client]
+	if !ok {
+		if r.clients == nil {
+			r.clients = make(map[string]*handleRate)
+		}
+		// forget clients that have been quiet for a while.
+		for k, v := range r.clients {
+			if now.Sub(v.start) >= time.Second {
+				delete(r.clients, k)
+			}
+		}
+		rate = &handleRate{start: now}
+		r.clients[client] = rate
+	}
+	if now.Sub(rate.start) >= time.Second {
+		rate.start, rate.count = now, 0
+	}
+	if rate.count >= r.perSecond {
+		return false
+	}
+	rate.count++
+	return true
+}
+
+// ToHandleFor is ToHandle on behalf of client, refusing with
+// NFSStatusJukebox to issue it more new handles than WithHandleRateLimit
+// allows.
+func (c *CachingHandler) ToHandleFor(client string, f billy.Filesystem, path []string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	joinedPath := f.Join(path...)
+	if handle := c.searchReverseCache(f, joinedPath); handle != nil {
+		return handle, nil
+	}
+	if !c.rates.allow(client, c.now()) {
+		return nil, &nfs.NFSStatusError{NFSStatus: nfs.NFSStatusJukebox, WrappedErr: errHandleRateLimited}
+	}
+	return c.mintHandle(f, path, joinedPath), nil
+}
@@ -0,0 +1,53 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers/memfs"
+)
+
+func TestHandleRateLimit(t *testing.T) {
+	mem := memfs.New()
+	c := NewCachingHandler(NewNullAuthHandler(mem), 1024, WithHandleRateLimit(10)).(*CachingHandler)
+
+	known, err := c.ToHandleFor("flood", mem, []string{"known"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var refused int
+	for i := 0; i < 100; i++ {
+		_, err := c.ToHandleFor("flood", mem, []string{fmt.Sprintf("f%d", i)})
+		var nerr *nfs.NFSStatusError
+		switch {
+		case err == nil:
+		case errors.As(err, &nerr) && nerr.NFSStatus == nfs.NFSStatusJukebox:
+			refused++
+		default:
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if refused != 91 {
+		t.Fatalf("expected 9 more handles issued and 91 refused, got %d refused", refused)
+	}
+	if h, err := c.ToHandleFor("flood", mem, []string{"known"}); err != nil || string(h) != string(known) {
+		t.Fatalf("handles already issued should not be limited: %x, %v", h, err)
+	}
+	if _, err := c.ToHandleFor("other", mem, []string{"other"}); err != nil {
+		t.Fatalf("another client should not be limited: %v", err)
+	}
+
+	// once the second is up, the flooding client may have more.
+	c.mu.Lock()
+	c.rates.clients["flood"].start = time.Now().Add(-time.Second)
+	c.mu.Unlock()
+	if _, err := c.ToHandleFor("flood", mem, []string{"later"}); err != nil {
+		t.Fatalf("expected the limit to reset after a second: %v", err)
+	}
+	if stats := c.Stats(); stats.Insertions != 12 {
+		t.Fatalf("expected 12 handles issued in all, got %d", stats.Insertions)
+	}
+}
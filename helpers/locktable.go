@@ -0,0 +1,127 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"reflect"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/willscott/go-nfs"
+)
+
+// ErrLockConflict is returned by LockTable.Lock when another owner holds a
+// conflicting lock on part of the range.
+var ErrLockConflict = errors.New("range locked by another owner")
+
+// LockTable records the byte-range locks owners hold on files, for a lock
+// manager to maintain and a CachingHandler given it by WithRangeLocks to
+// enforce on READ and WRITE. Owners are principals, as
+// nfs.PrincipalFromContext names them; requests that carry no principal
+// own no locks.
+type LockTable struct {
+	mu sync.Mutex
+	// locks holds the locks on each file, by joined path.
+	locks map[string][]rangeLock
+}
+
+type rangeLock struct {
+	fs        billy.Filesystem
+	owner     string
+	start     uint64
+	end       uint64
+	exclusive bool
+}
+
+// NewLockTable returns a LockTable holding no locks.
+func NewLockTable() *LockTable {
+	return &LockTable{locks: make(map[string][]rangeLock)}
+}
+
+// lockRange returns the bounds of length bytes at offset, where a length of
+// zero reaches the end of the file however far it grows.
+func lockRange(offset, length uint64) (uint64, uint64) {
+	if length == 0 || offset+length < offset {
+		return offset, math.MaxUint64
+	}
+	return offset, offset + length
+}
+
+// Lock records that owner holds length bytes at offset of the file at path,
+// a length of zero locking to the end of the file. An exclusive lock
+// conflicts with any lock another owner holds on the range, and a shared
+// lock with their exclusive ones.
+func (t *LockTable) Lock(fs billy.Filesystem, path []string, owner string, offset, length uint64, exclusive bool) error {
+	if owner == "" {
+		return os.ErrInvalid
+	}
+	start, end := lockRange(offset, length)
+	key := fs.Join(path...)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, l := range t.locks[key] {
+		if l.owner != owner && l.start < end && start < l.end && (exclusive || l.exclusive) && reflect.DeepEqual(l.fs, fs) {
+			return ErrLockConflict
+		}
+	}
+	t.locks[key] = append(t.locks[key], rangeLock{fs, owner, start, end, exclusive})
+	return nil
+}
+
+// Unlock releases what owner holds of length bytes at offset of the file at
+// path, a length of zero reaching the end of the file. Locks extending past
+// the range keep the parts outside it.
+func (t *LockTable) Unlock(fs billy.Filesystem, path []string, owner string, offset, length uint64) {
+	start, end := lockRange(offset, length)
+	key := fs.Join(path...)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var kept []rangeLock
+	for _, l := range t.locks[key] {
+		if l.owner != owner || l.end <= start || end <= l.start || !reflect.DeepEqual(l.fs, fs) {
+			kept = append(kept, l)
+			continue
+		}
+		if l.start < start {
+			before := l
+			before.end = start
+			kept = append(kept, before)
+		}
+		if end < l.end {
+			after := l
+			after.start = end
+			kept = append(kept, after)
+		}
+	}
+	if len(kept) == 0 {
+		delete(t.locks, key)
+		return
+	}
+	t.locks[key] = kept
+}
+
+// RangeLocked reports whether an owner other than the principal making the
+// request ctx belongs to holds a lock on length bytes at offset of the file
+// at path that forbids reading them or, if write is set, writing them. No
+// lock covers an empty range.
+func (t *LockTable) RangeLocked(ctx context.Context, fs billy.Filesystem, path []string, offset, length uint64, write bool) bool {
+	if length == 0 {
+		return false
+	}
+	start, end := lockRange(offset, length)
+	owner, _ := nfs.PrincipalFromContext(ctx)
+	key := fs.Join(path...)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, l := range t.locks[key] {
+		if l.owner != owner && l.start < end && start < l.end && (write || l.exclusive) && reflect.DeepEqual(l.fs, fs) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package helpers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+)
+
+func TestLockTableUnlockSplits(t *testing.T) {
+	fs := memfs.New()
+	path := []string{"file"}
+	table := NewLockTable()
+	if err := table.Lock(fs, path, "a", 0, 30, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Lock(fs, path, "b", 10, 5, true); err != ErrLockConflict {
+		t.Fatalf("expected an exclusive lock over a shared one to conflict, got %v", err)
+	}
+	if err := table.Lock(fs, path, "b", 10, 5, false); err != nil {
+		t.Fatalf("expected shared locks to coexist, got %v", err)
+	}
+
+	// releasing the middle of a lock keeps either end.
+	table.Unlock(fs, path, "a", 10, 10)
+	ctx := context.Background()
+	for _, tc := range []struct {
+		offset uint64
+		locked bool
+	}{
+		{0, true},
+		{12, true},
+		{17, false},
+		{25, true},
+	} {
+		if locked := table.RangeLocked(ctx, fs, path, tc.offset, 1, true); locked != tc.locked {
+			t.Fatalf("byte %d locked: %v, want %v", tc.offset, locked, tc.locked)
+		}
+	}
+	if table.RangeLocked(ctx, fs, path, 0, 30, false) {
+		t.Fatal("expected shared locks not to forbid reading")
+	}
+}
@@ -73,9 +73,18 @@ func (s *storage) createParent(path string, mode os.FileMode, f *file) error {
 	}
 
 	s.children[base][f.Name()] = f
+	s.touch(base)
 	return nil
 }
 
+// touch advances the modification time of the directory at path, as
+// adding or removing its entries does.
+func (s *storage) touch(path string) {
+	if dir, ok := s.files[path]; ok {
+		dir.mtime = time.Now()
+	}
+}
+
 func (s *storage) Children(path string) []*file {
 	path = clean(path)
 
@@ -148,6 +157,7 @@ func (s *storage) move(from, to string) error {
 		delete(s.children, from)
 		delete(s.files, from)
 		delete(s.children[filepath.Dir(from)], filepath.Base(from))
+		s.touch(filepath.Dir(from))
 	}()
 
 	return s.createParent(to, 0644, s.files[to])
@@ -170,6 +180,7 @@ func (s *storage) Remove(path string) error {
 
 	delete(s.children[base], file)
 	delete(s.files, path)
+	s.touch(base)
 	return nil
 }
 
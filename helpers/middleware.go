@@ -0,0 +1,389 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/willscott/go-nfs"
+)
+
+// Middleware wraps a handler with a concern cutting across its methods,
+// such as ReadOnly, Metrics or Logging.
+type Middleware func(nfs.Handler) nfs.Handler
+
+// Chain wraps h in mws, the first outermost, so that calls pass through the
+// middleware in the order given before reaching h. Middleware forwards the
+// methods of nfs.Handler and the optional interfaces a CachingHandler
+// consults on what it wraps, but not those a CachingHandler itself
+// provides, such as resolving handles, so a stack is meant to go beneath
+// one:
+//
+//	handler := helpers.NewCachingHandler(helpers.Chain(
+//		helpers.NewNullAuthHandler(fs),
+//		helpers.Logging(logger),
+//		helpers.ReadOnly(),
+//	), 1024)
+func Chain(h nfs.Handler, mws ...Middleware) nfs.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// ReadOnly exports the file systems of the handler it wraps without letting
+// them be changed: they are mounted without the write capability, and
+// have no Change, so that calls changing them fail with NFS3ERR_ROFS.
+// Unlike Server.SetReadOnly it applies to the handler's exports alone.
+func ReadOnly() Middleware {
+	return func(h nfs.Handler) nfs.Handler {
+		return &readOnlyHandler{h, forwarded{h, writable, func(fs billy.Filesystem) billy.Filesystem { return readOnlyFS{fs} }}}
+	}
+}
+
+type readOnlyHandler struct {
+	nfs.Handler
+	forwarded
+}
+
+func (h *readOnlyHandler) Mount(ctx context.Context, conn net.Conn, req nfs.MountRequest) (nfs.MountStatus, billy.Filesystem, []nfs.AuthFlavor) {
+	status, fs, auths := h.Handler.Mount(ctx, conn, req)
+	if fs != nil {
+		fs = readOnlyFS{fs}
+	}
+	return status, fs, auths
+}
+
+func (h *readOnlyHandler) Change(billy.Filesystem) billy.Change {
+	return nil
+}
+
+func (h *readOnlyHandler) FSStat(ctx context.Context, fs billy.Filesystem, s *nfs.FSStat) error {
+	return h.Handler.FSStat(ctx, writable(fs), s)
+}
+
+func (h *readOnlyHandler) ToHandle(fs billy.Filesystem, path []string) []byte {
+	return h.Handler.ToHandle(writable(fs), path)
+}
+
+func (h *readOnlyHandler) FromHandle(fh []byte) (billy.Filesystem, []string, error) {
+	fs, path, err := h.Handler.FromHandle(fh)
+	if fs != nil {
+		fs = readOnlyFS{fs}
+	}
+	return fs, path, err
+}
+
+func (h *readOnlyHandler) InvalidateHandle(fs billy.Filesystem, fh []byte) error {
+	return h.Handler.InvalidateHandle(writable(fs), fh)
+}
+
+func (h *readOnlyHandler) UpdateHandle(fs billy.Filesystem, fh []byte, path []string) error {
+	return h.Handler.UpdateHandle(writable(fs), fh, path)
+}
+
+// writable returns the file system a readOnlyFS was made of.
+func writable(fs billy.Filesystem) billy.Filesystem {
+	if r, ok := fs.(readOnlyFS); ok {
+		return r.Filesystem
+	}
+	return fs
+}
+
+// readOnlyFS is a file system refusing to be changed.
+type readOnlyFS struct {
+	billy.Filesystem
+}
+
+func (r readOnlyFS) Capabilities() billy.Capability {
+	return billy.Capabilities(r.Filesystem) &^ (billy.WriteCapability | billy.ReadAndWriteCapability)
+}
+
+func (r readOnlyFS) Create(string) (billy.File, error) {
+	return nil, billy.ErrReadOnly
+}
+
+func (r readOnlyFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, billy.ErrReadOnly
+	}
+	return r.Filesystem.OpenFile(filename, flag, perm)
+}
+
+func (r readOnlyFS) Rename(string, string) error { return billy.ErrReadOnly }
+
+func (r readOnlyFS) Remove(string) error { return billy.ErrReadOnly }
+
+func (r readOnlyFS) TempFile(string, string) (billy.File, error) { return nil, billy.ErrReadOnly }
+
+func (r readOnlyFS) MkdirAll(string, os.FileMode) error { return billy.ErrReadOnly }
+
+func (r readOnlyFS) Symlink(string, string) error { return billy.ErrReadOnly }
+
+func (r readOnlyFS) Chroot(path string) (billy.Filesystem, error) {
+	fs, err := r.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	return readOnlyFS{fs}, nil
+}
+
+func (r readOnlyFS) Prefetch(filename string, offset int64, length int) error {
+	return prefetch(r.Filesystem, filename, offset, length)
+}
+
+// Metrics reports each call made to the handler it wraps to sink, named
+// after the method, as "handler.Mount" or "handler.FromHandle" are. Failed
+// mounts and handle lookups are counted as errors. Beneath a
+// CachingHandler, which resolves handles itself, only the calls it passes
+// on, Mount and FSStat, are seen.
+func Metrics(sink nfs.MetricsSink) Middleware {
+	return func(h nfs.Handler) nfs.Handler {
+		return &metricsHandler{h, forwardTo(h), sink}
+	}
+}
+
+type metricsHandler struct {
+	nfs.Handler
+	forwarded
+	sink nfs.MetricsSink
+}
+
+// observe reports a call to method, started at start, that failed with err
+// if it is not nil.
+func (h *metricsHandler) observe(method string, start time.Time, err error) {
+	op := "handler." + method
+	h.sink.CountOp(op)
+	h.sink.ObserveLatency(op, time.Since(start))
+	if err != nil {
+		h.sink.CountError(op, err)
+	}
+}
+
+func (h *metricsHandler) Mount(ctx context.Context, conn net.Conn, req nfs.MountRequest) (nfs.MountStatus, billy.Filesystem, []nfs.AuthFlavor) {
+	start := time.Now()
+	status, fs, auths := h.Handler.Mount(ctx, conn, req)
+	var err error
+	if status != nfs.MountStatusOk {
+		err = fmt.Errorf("mount failed with status %d", status)
+	}
+	h.observe("Mount", start, err)
+	return status, fs, auths
+}
+
+func (h *metricsHandler) FSStat(ctx context.Context, fs billy.Filesystem, s *nfs.FSStat) error {
+	start := time.Now()
+	err := h.Handler.FSStat(ctx, fs, s)
+	h.observe("FSStat", start, err)
+	return err
+}
+
+func (h *metricsHandler) ToHandle(fs billy.Filesystem, path []string) []byte {
+	start := time.Now()
+	fh := h.Handler.ToHandle(fs, path)
+	h.observe("ToHandle", start, nil)
+	return fh
+}
+
+func (h *metricsHandler) FromHandle(fh []byte) (billy.Filesystem, []string, error) {
+	start := time.Now()
+	fs, path, err := h.Handler.FromHandle(fh)
+	h.observe("FromHandle", start, err)
+	return fs, path, err
+}
+
+func (h *metricsHandler) InvalidateHandle(fs billy.Filesystem, fh []byte) error {
+	start := time.Now()
+	err := h.Handler.InvalidateHandle(fs, fh)
+	h.observe("InvalidateHandle", start, err)
+	return err
+}
+
+func (h *metricsHandler) UpdateHandle(fs billy.Filesystem, fh []byte, path []string) error {
+	start := time.Now()
+	err := h.Handler.UpdateHandle(fs, fh, path)
+	h.observe("UpdateHandle", start, err)
+	return err
+}
+
+// Logging logs the mounts made through the handler it wraps, the handles
+// it fails to resolve and the changes to its handles, to logger at Debug.
+// Beneath a CachingHandler, which resolves handles itself, only mounts
+// are seen.
+func Logging(logger nfs.LeveledLogger) Middleware {
+	return func(h nfs.Handler) nfs.Handler {
+		return &loggingHandler{h, forwardTo(h), logger}
+	}
+}
+
+type loggingHandler struct {
+	nfs.Handler
+	forwarded
+	logger nfs.LeveledLogger
+}
+
+func (h *loggingHandler) Mount(ctx context.Context, conn net.Conn, req nfs.MountRequest) (nfs.MountStatus, billy.Filesystem, []nfs.AuthFlavor) {
+	status, fs, auths := h.Handler.Mount(ctx, conn, req)
+	var client net.Addr
+	if conn != nil {
+		client = conn.RemoteAddr()
+	}
+	h.logger.Debugf("mount of %q by %v: status %d", req.Dirpath, client, status)
+	return status, fs, auths
+}
+
+func (h *loggingHandler) FromHandle(fh []byte) (billy.Filesystem, []string, error) {
+	fs, path, err := h.Handler.FromHandle(fh)
+	if err != nil {
+		h.logger.Debugf("resolving handle %s: %v", nfs.HandleString(fh), err)
+	}
+	return fs, path, err
+}
+
+func (h *loggingHandler) InvalidateHandle(fs billy.Filesystem, fh []byte) error {
+	err := h.Handler.InvalidateHandle(fs, fh)
+	h.logger.Debugf("invalidated handle %s: %v", nfs.HandleString(fh), err)
+	return err
+}
+
+func (h *loggingHandler) UpdateHandle(fs billy.Filesystem, fh []byte, path []string) error {
+	err := h.Handler.UpdateHandle(fs, fh, path)
+	h.logger.Debugf("moved handle %s to %v: %v", nfs.HandleString(fh), path, err)
+	return err
+}
+
+// forwarded implements, for a Middleware, the optional interfaces a
+// CachingHandler consults on the handler it wraps, deferring to next where
+// it implements them and otherwise behaving as a CachingHandler does in
+// their absence. backend maps the file systems the middleware hands out
+// to those of next, and wrap the reverse.
+type forwarded struct {
+	next    nfs.Handler
+	backend func(billy.Filesystem) billy.Filesystem
+	wrap    func(billy.Filesystem) billy.Filesystem
+}
+
+// forwardTo forwards to next, whose file systems the middleware hands out
+// as they are.
+func forwardTo(next nfs.Handler) forwarded {
+	same := func(fs billy.Filesystem) billy.Filesystem { return fs }
+	return forwarded{next, same, same}
+}
+
+func (f forwarded) MaxNameLength() int {
+	if nh, ok := f.next.(nfs.NameLengthHandler); ok {
+		return nh.MaxNameLength()
+	}
+	return nfs.PathNameMax
+}
+
+func (f forwarded) NameLengthUnit() nfs.NameLengthUnit {
+	if uh, ok := f.next.(nfs.NameLengthUnitHandler); ok {
+		return uh.NameLengthUnit()
+	}
+	return nfs.NameLengthBytes
+}
+
+func (f forwarded) MaxSymlinkLength() int {
+	if sh, ok := f.next.(nfs.SymlinkLengthHandler); ok {
+		return sh.MaxSymlinkLength()
+	}
+	return nfs.SymlinkMax
+}
+
+func (f forwarded) CaseInsensitive(fs billy.Filesystem) bool {
+	if ch, ok := f.next.(nfs.CaseInsensitiveHandler); ok {
+		return ch.CaseInsensitive(f.backend(fs))
+	}
+	return false
+}
+
+func (f forwarded) TimeGranularity(fs billy.Filesystem) time.Duration {
+	if th, ok := f.next.(nfs.TimeGranularityHandler); ok {
+		return th.TimeGranularity(f.backend(fs))
+	}
+	return 0
+}
+
+func (f forwarded) AtimeUpdates(fs billy.Filesystem) nfs.AtimeMode {
+	if ah, ok := f.next.(nfs.AtimeHandler); ok {
+		return ah.AtimeUpdates(f.backend(fs))
+	}
+	return nfs.AtimeNever
+}
+
+func (f forwarded) FileIDFor(fs billy.Filesystem, path []string) uint64 {
+	if ih, ok := f.next.(nfs.FileIDHandler); ok {
+		return ih.FileIDFor(f.backend(fs), path)
+	}
+	return 0
+}
+
+func (f forwarded) FSIDFor(fs billy.Filesystem) uint64 {
+	if ih, ok := f.next.(nfs.FSIDHandler); ok {
+		return ih.FSIDFor(f.backend(fs))
+	}
+	return 0
+}
+
+func (f forwarded) Exports() []nfs.Export {
+	if eh, ok := f.next.(nfs.ExportingHandler); ok {
+		return eh.Exports()
+	}
+	return []nfs.Export{{Dir: "/"}}
+}
+
+func (f forwarded) RangeLocked(ctx context.Context, fs billy.Filesystem, path []string, offset, length uint64, write bool) bool {
+	if rl, ok := f.next.(nfs.RangeLockHandler); ok {
+		return rl.RangeLocked(ctx, f.backend(fs), path, offset, length, write)
+	}
+	return false
+}
+
+func (f forwarded) LockRange(ctx context.Context, fs billy.Filesystem, path []string, offset, length uint64, exclusive bool) error {
+	if lh, ok := f.next.(nfs.LockHandler); ok {
+		return lh.LockRange(ctx, f.backend(fs), path, offset, length, exclusive)
+	}
+	return errNoLockTable
+}
+
+func (f forwarded) UnlockRange(ctx context.Context, fs billy.Filesystem, path []string, offset, length uint64) {
+	if lh, ok := f.next.(nfs.LockHandler); ok {
+		lh.UnlockRange(ctx, f.backend(fs), path, offset, length)
+	}
+}
+
+func (f forwarded) OnChange(op string, fs billy.Filesystem, path []string) {
+	if n, ok := f.next.(nfs.ChangeNotifier); ok {
+		n.OnChange(op, f.backend(fs), path)
+	}
+}
+
+func (f forwarded) OnRename(fs billy.Filesystem, from, to []string) {
+	if n, ok := f.next.(nfs.RenameNotifier); ok {
+		n.OnRename(f.backend(fs), from, to)
+	} else if n, ok := f.next.(nfs.ChangeNotifier); ok {
+		n.OnChange(nfs.ChangeRename, f.backend(fs), to)
+	}
+}
+
+func (f forwarded) Watch(changed func(fs billy.Filesystem, path []string)) func() {
+	w, ok := f.next.(nfs.Watcher)
+	if !ok {
+		return func() {}
+	}
+	return w.Watch(func(fs billy.Filesystem, path []string) {
+		changed(f.wrap(fs), path)
+	})
+}
+
+// prefetch is fs's Prefetch, if it is an nfs.Prefetcher.
+func prefetch(fs billy.Filesystem, filename string, offset int64, length int) error {
+	if p, ok := fs.(nfs.Prefetcher); ok {
+		return p.Prefetch(filename, offset, length)
+	}
+	return billy.ErrNotSupported
+}
@@ -0,0 +1,180 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers/memfs"
+)
+
+// recordingHandler notes the mounts passing through it in calls.
+type recordingHandler struct {
+	nfs.Handler
+	name  string
+	calls *[]string
+}
+
+func (h *recordingHandler) Mount(ctx context.Context, conn net.Conn, req nfs.MountRequest) (nfs.MountStatus, billy.Filesystem, []nfs.AuthFlavor) {
+	*h.calls = append(*h.calls, h.name+" enters "+string(req.Dirpath))
+	defer func() { *h.calls = append(*h.calls, h.name+" leaves") }()
+	return h.Handler.Mount(ctx, conn, req)
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	record := func(name string) Middleware {
+		return func(h nfs.Handler) nfs.Handler {
+			return &recordingHandler{h, name, &calls}
+		}
+	}
+	h := Chain(NewNullAuthHandler(memfs.New()), record("outer"), record("inner"))
+	mountExport(t, h, "/export")
+	want := []string{"outer enters /export", "inner enters /export", "inner leaves", "outer leaves"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("middleware ran as %q, expected %q", calls, want)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	mem := memfs.New()
+	if err := util.WriteFile(mem, "/file", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := NewCachingHandler(Chain(NewNullAuthHandler(mem), ReadOnly()), 1024)
+	fs := mountExport(t, h, "/")
+
+	// a file system resolved from a handle is just as read-only.
+	fh := h.ToHandle(fs, []string{"file"})
+	fromHandle, _, err := h.FromHandle(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []billy.Filesystem{fs, fromHandle} {
+		if billy.CapabilityCheck(f, billy.WriteCapability) || h.Change(f) != nil {
+			t.Fatal("expected the export to be read-only")
+		}
+		if _, err := f.OpenFile("/file", os.O_WRONLY, 0); !errors.Is(err, billy.ErrReadOnly) {
+			t.Fatalf("expected opening for writing to fail, got %v", err)
+		}
+		if err := f.Remove("/file"); !errors.Is(err, billy.ErrReadOnly) {
+			t.Fatalf("expected removal to fail, got %v", err)
+		}
+		if got, err := util.ReadFile(f, "/file"); err != nil || string(got) != "hello" {
+			t.Fatalf("read %q, %v", got, err)
+		}
+		sub, err := f.Chroot("/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sub.Remove("/file"); !errors.Is(err, billy.ErrReadOnly) {
+			t.Fatalf("expected removal beneath a chroot to fail, got %v", err)
+		}
+	}
+}
+
+// optionalHandler implements some of the optional interfaces a
+// CachingHandler consults, recording the file systems it is given.
+type optionalHandler struct {
+	nfs.Handler
+	given []billy.Filesystem
+}
+
+func (h *optionalHandler) FileIDFor(fs billy.Filesystem, path []string) uint64 {
+	h.given = append(h.given, fs)
+	return 42
+}
+
+func (h *optionalHandler) RangeLocked(ctx context.Context, fs billy.Filesystem, path []string, offset, length uint64, write bool) bool {
+	h.given = append(h.given, fs)
+	return true
+}
+
+// prefetchFS counts the prefetches asked of it.
+type prefetchFS struct {
+	billy.Filesystem
+	prefetched int
+}
+
+func (p *prefetchFS) Prefetch(filename string, offset int64, length int) error {
+	p.prefetched++
+	return nil
+}
+
+func TestMiddlewareForwards(t *testing.T) {
+	sink := &countingSink{ops: map[string]int{}, errors: map[string]int{}}
+	backend := &prefetchFS{Filesystem: memfs.New()}
+	inner := &optionalHandler{Handler: NewNullAuthHandler(backend)}
+	h := NewCachingHandler(Chain(inner, Metrics(sink), Logging(nfs.Log), ReadOnly()), 1024)
+	fs := mountExport(t, h, "/")
+
+	c := h.(*CachingHandler)
+	if id := c.FileIDFor(fs, []string{"file"}); id != 42 {
+		t.Fatalf("expected the file id of the handler beneath, got %d", id)
+	}
+	if !c.RangeLocked(context.Background(), fs, []string{"file"}, 0, 1, true) {
+		t.Fatal("expected the range locks of the handler beneath")
+	}
+	for _, given := range inner.given {
+		if given != billy.Filesystem(backend) {
+			t.Fatalf("expected the handler beneath to be given its own file system, got %T", given)
+		}
+	}
+	if err := fs.(nfs.Prefetcher).Prefetch("file", 0, 1); err != nil || backend.prefetched != 1 {
+		t.Fatalf("expected the prefetch to reach the backend, got %v and %d prefetches", err, backend.prefetched)
+	}
+}
+
+func TestExportsForwardPrefetch(t *testing.T) {
+	backend := &prefetchFS{Filesystem: memfs.New()}
+	h := NewMultiExportHandler(map[string]nfs.Handler{
+		"/": NewCachingHandler(NewNullAuthHandler(backend), 1024, WithDryRun(true)),
+	})
+	fs := mountExport(t, h, "/")
+	p, ok := fs.(nfs.Prefetcher)
+	if !ok {
+		t.Fatalf("expected a dry run export to prefetch, got %T", fs)
+	}
+	if err := p.Prefetch("file", 0, 1); err != nil || backend.prefetched != 1 {
+		t.Fatalf("expected the prefetch to reach the backend, got %v and %d prefetches", err, backend.prefetched)
+	}
+}
+
+// countingSink counts the calls reported to it by op.
+type countingSink struct {
+	mu     sync.Mutex
+	ops    map[string]int
+	errors map[string]int
+}
+
+func (s *countingSink) CountOp(op string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ops[op]++
+}
+
+func (s *countingSink) ObserveLatency(string, time.Duration) {}
+
+func (s *countingSink) CountError(op string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors[op]++
+}
+
+func TestMetrics(t *testing.T) {
+	sink := &countingSink{ops: map[string]int{}, errors: map[string]int{}}
+	h := NewCachingHandler(Chain(NewNullAuthHandler(memfs.New()), Metrics(sink)), 1024)
+	mountExport(t, h, "/")
+	mountExport(t, h, "/")
+	if sink.ops["handler.Mount"] != 2 || sink.errors["handler.Mount"] != 0 {
+		t.Fatalf("expected two successful mounts, got %v and errors %v", sink.ops, sink.errors)
+	}
+}
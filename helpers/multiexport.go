@@ -0,0 +1,717 @@
+package helpers
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"math"
+	"net"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/willscott/go-nfs"
+)
+
+// exportIDLength is the length of the discriminator that leads each handle
+// minted by a MultiExportHandler.
+const exportIDLength = 4
+
+var errUnknownExport = errors.New("handle belongs to no export")
+
+// errNoRangeLocks is returned by LockRange for exports whose handlers take
+// no byte-range locks.
+var errNoRangeLocks = errors.New("export takes no byte-range locks")
+
+// NewMultiExportHandler serves each of exports, keyed by the path clients
+// mount it at, from one server. Each export's handler mints handles as
+// usual, which are prefixed with a discriminator derived from the export's
+// path, so that a handle from one export is never resolved by another and
+// handles stay valid across restarts serving the same exports.
+//
+// Exports are matched against the whole mount path: subdirectories of an
+// export cannot be mounted through a MultiExportHandler.
+func NewMultiExportHandler(exports map[string]nfs.Handler) nfs.Handler {
+	return newMultiExportHandler(exports, false)
+}
+
+// CompactHandleSize is the size NewCompactMultiExportHandler keeps handles
+// within, the most some embedded clients reliably handle.
+const CompactHandleSize = 32
+
+// NewCompactMultiExportHandler is NewMultiExportHandler, with handles led
+// by the export's number, in order of path, as a varint rather than by a
+// 4-byte discriminator, so that they fit within CompactHandleSize bytes.
+// It fails if the handler of an export may mint handles too long for that,
+// or does not tell (see CheckHandleSize). Handles stay valid across
+// restarts only while the same set of exports is served.
+func NewCompactMultiExportHandler(exports map[string]nfs.Handler) (nfs.Handler, error) {
+	m := newMultiExportHandler(exports, true)
+	if err := CheckHandleSize(m, CompactHandleSize); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func newMultiExportHandler(exports map[string]nfs.Handler, compact bool) *MultiExportHandler {
+	m := &MultiExportHandler{
+		byPath:  make(map[string]*export, len(exports)),
+		byID:    make(map[uint32]*export, len(exports)),
+		compact: compact,
+	}
+	paths := make([]string, 0, len(exports))
+	for p := range exports {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for i, p := range paths {
+		e := &export{path: cleanExportPath(p), Handler: exports[p]}
+		if compact {
+			e.id = uint32(i)
+		} else {
+			// resolve the rare collision deterministically, so that the
+			// same exports are always given the same ids.
+			e.id = exportID(e.path)
+			for m.byID[e.id] != nil {
+				e.id++
+			}
+		}
+		m.byPath[e.path] = e
+		m.byID[e.id] = e
+		m.exports = append(m.exports, e)
+	}
+	return m
+}
+
+// ErrHandleTooLong is returned by CheckHandleSize for a handler whose
+// handles may not fit.
+var ErrHandleTooLong = errors.New("handles may exceed the size allowed")
+
+// CheckHandleSize reports whether every handle h mints fits in budget
+// bytes, failing with ErrHandleTooLong unless h is an nfs.HandleSizer
+// bounding them within it.
+func CheckHandleSize(h nfs.Handler, budget int) error {
+	sizer, ok := h.(nfs.HandleSizer)
+	if !ok {
+		return fmt.Errorf("%w: the handler does not bound its handles", ErrHandleTooLong)
+	}
+	if n := sizer.MaxHandleSize(); n > budget {
+		return fmt.Errorf("%w: handles of up to %d bytes exceed %d", ErrHandleTooLong, n, budget)
+	}
+	return nil
+}
+
+// MultiExportHandler routes requests to the handler of the export they
+// concern.
+type MultiExportHandler struct {
+	byPath map[string]*export
+	byID   map[uint32]*export
+	// exports is ordered by path.
+	exports []*export
+	// compact handles lead with the export's id as a varint, as set up by
+	// NewCompactMultiExportHandler.
+	compact bool
+}
+
+type export struct {
+	nfs.Handler
+	path string
+	id   uint32
+}
+
+// exportFS is a file system mounted from an export, tagged with the export
+// so that requests on it can be routed back. The export's handler is only
+// ever given the file system it mounted.
+type exportFS struct {
+	billy.Filesystem
+	export *export
+}
+
+// Capabilities are those of the export's file system.
+func (f exportFS) Capabilities() billy.Capability {
+	return billy.Capabilities(f.Filesystem)
+}
+
+// Getxattr is that of the export's file system, if it keeps extended
+// attributes.
+func (f exportFS) Getxattr(path, name string) ([]byte, error) {
+	if xfs, ok := f.Filesystem.(nfs.XattrFilesystem); ok {
+		return xfs.Getxattr(path, name)
+	}
+	return nil, billy.ErrNotSupported
+}
+
+// Setxattr is that of the export's file system, if it keeps extended
+// attributes.
+func (f exportFS) Setxattr(path, name string, value []byte) error {
+	if xfs, ok := f.Filesystem.(nfs.XattrFilesystem); ok {
+		return xfs.Setxattr(path, name, value)
+	}
+	return billy.ErrNotSupported
+}
+
+// Listxattr is that of the export's file system, if it keeps extended
+// attributes.
+func (f exportFS) Listxattr(path string) ([]string, error) {
+	if xfs, ok := f.Filesystem.(nfs.XattrFilesystem); ok {
+		return xfs.Listxattr(path)
+	}
+	return nil, billy.ErrNotSupported
+}
+
+// ReadDirIter streams the listing of the export's file system, if it can,
+// or else leaves it to be read whole.
+func (f exportFS) ReadDirIter(path string) (nfs.DirEntries, error) {
+	if iter, ok := f.Filesystem.(nfs.ReadDirIterator); ok {
+		return iter.ReadDirIter(path)
+	}
+	return nil, billy.ErrNotSupported
+}
+
+// Prefetch is that of the export's file system, if it can prefetch.
+func (f exportFS) Prefetch(filename string, offset int64, length int) error {
+	return prefetch(f.Filesystem, filename, offset, length)
+}
+
+func cleanExportPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+func exportID(p string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(p))
+	return h.Sum32()
+}
+
+// route finds the export serving fs, and the file system its handler knows
+// fs as.
+func (m *MultiExportHandler) route(fs billy.Filesystem) (*export, billy.Filesystem, bool) {
+	if ef, ok := fs.(exportFS); ok {
+		return ef.export, ef.Filesystem, true
+	}
+	return nil, fs, false
+}
+
+// Mount mounts the export at the requested path.
+func (m *MultiExportHandler) Mount(ctx context.Context, conn net.Conn, req nfs.MountRequest) (nfs.MountStatus, billy.Filesystem, []nfs.AuthFlavor) {
+	e, ok := m.byPath[cleanExportPath(string(req.Dirpath))]
+	if !ok {
+		return nfs.MountStatusErrNoEnt, nil, nil
+	}
+	status, fs, auths := e.Mount(ctx, conn, req)
+	if status != nfs.MountStatusOk {
+		return status, fs, auths
+	}
+	return status, exportFS{fs, e}, auths
+}
+
+// Exports lists the path of each export, which any client may mount.
+func (m *MultiExportHandler) Exports() []nfs.Export {
+	exports := make([]nfs.Export, 0, len(m.exports))
+	for _, e := range m.exports {
+		exports = append(exports, nfs.Export{Dir: e.path})
+	}
+	return exports
+}
+
+// Change provides the export's interface for updating file attributes.
+func (m *MultiExportHandler) Change(fs billy.Filesystem) billy.Change {
+	e, inner, ok := m.route(fs)
+	if !ok {
+		return nil
+	}
+	return e.Change(inner)
+}
+
+// FSStat provides the export's file system statistics.
+func (m *MultiExportHandler) FSStat(ctx context.Context, fs billy.Filesystem, s *nfs.FSStat) error {
+	e, inner, ok := m.route(fs)
+	if !ok {
+		return errUnknownExport
+	}
+	return e.FSStat(ctx, inner, s)
+}
+
+// ToHandle prefixes the export's handle with the export's discriminator.
+func (m *MultiExportHandler) ToHandle(fs billy.Filesystem, path []string) []byte {
+	e, inner, ok := m.route(fs)
+	if !ok {
+		return []byte{}
+	}
+	return append(m.prefix(e), e.ToHandle(inner, path)...)
+}
+
+// ToHandleFor is ToHandle on behalf of client, through the export's
+// ToHandleFor if it has one.
+func (m *MultiExportHandler) ToHandleFor(client string, fs billy.Filesystem, path []string) ([]byte, error) {
+	e, inner, ok := m.route(fs)
+	if !ok {
+		return []byte{}, nil
+	}
+	ch, ok := e.Handler.(nfs.ClientHandleHandler)
+	if !ok {
+		return m.ToHandle(fs, path), nil
+	}
+	handle, err := ch.ToHandleFor(client, inner, path)
+	if err != nil {
+		return nil, err
+	}
+	return append(m.prefix(e), handle...), nil
+}
+
+// FromHandle resolves a handle through the export that minted it.
+func (m *MultiExportHandler) FromHandle(fh []byte) (billy.Filesystem, []string, error) {
+	e, inner, err := m.split(fh)
+	if err != nil {
+		return nil, []string{}, err
+	}
+	fs, p, err := e.FromHandle(inner)
+	if err != nil {
+		return nil, []string{}, err
+	}
+	return exportFS{fs, e}, p, nil
+}
+
+// FromHandleContext is FromHandle in the scope of ctx, through the export's
+// FromHandleContext if it has one.
+func (m *MultiExportHandler) FromHandleContext(ctx context.Context, fh []byte) (billy.Filesystem, []string, error) {
+	e, inner, err := m.split(fh)
+	if err != nil {
+		return nil, []string{}, err
+	}
+	ch, ok := e.Handler.(nfs.ContextHandleHandler)
+	if !ok {
+		return m.FromHandle(fh)
+	}
+	fs, p, err := ch.FromHandleContext(ctx, inner)
+	if err != nil {
+		return nil, []string{}, err
+	}
+	return exportFS{fs, e}, p, nil
+}
+
+// FromHandleShallow is FromHandleContext through the export's
+// FromHandleShallow, if it has one, so that GETATTR refreshes only the
+// handle it asks about in the export's cache.
+func (m *MultiExportHandler) FromHandleShallow(ctx context.Context, fh []byte) (billy.Filesystem, []string, error) {
+	e, inner, err := m.split(fh)
+	if err != nil {
+		return nil, []string{}, err
+	}
+	sh, ok := e.Handler.(nfs.ShallowHandleHandler)
+	if !ok {
+		return m.FromHandleContext(ctx, fh)
+	}
+	fs, p, err := sh.FromHandleShallow(ctx, inner)
+	if err != nil {
+		return nil, []string{}, err
+	}
+	return exportFS{fs, e}, p, nil
+}
+
+// prefix returns the discriminator leading the handles of e.
+func (m *MultiExportHandler) prefix(e *export) []byte {
+	if m.compact {
+		return binary.AppendUvarint(nil, uint64(e.id))
+	}
+	fh := make([]byte, exportIDLength)
+	binary.BigEndian.PutUint32(fh, e.id)
+	return fh
+}
+
+// split separates a handle into its export and the export's own handle.
+func (m *MultiExportHandler) split(fh []byte) (*export, []byte, error) {
+	var id uint64
+	n := exportIDLength
+	if m.compact {
+		id, n = binary.Uvarint(fh)
+	} else if len(fh) >= exportIDLength {
+		id = uint64(binary.BigEndian.Uint32(fh))
+	} else {
+		n = 0
+	}
+	if n <= 0 || id > math.MaxUint32 {
+		return nil, nil, &nfs.NFSStatusError{NFSStatus: nfs.NFSStatusStale, WrappedErr: nfs.ErrInputInvalid}
+	}
+	e, ok := m.byID[uint32(id)]
+	if !ok {
+		return nil, nil, &nfs.NFSStatusError{NFSStatus: nfs.NFSStatusStale, WrappedErr: errUnknownExport}
+	}
+	return e, fh[n:], nil
+}
+
+// MaxHandleSize bounds the handles of every export with their
+// discriminator, taking a handler that does not bound its own to mint
+// handles of up to nfs.FHSize bytes.
+func (m *MultiExportHandler) MaxHandleSize() int {
+	max := 0
+	for _, e := range m.exports {
+		n := nfs.FHSize
+		if sizer, ok := e.Handler.(nfs.HandleSizer); ok {
+			n = sizer.MaxHandleSize()
+		}
+		if n += len(m.prefix(e)); n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+// InvalidateHandle invalidates the handle in the export that minted it.
+func (m *MultiExportHandler) InvalidateHandle(fs billy.Filesystem, fh []byte) error {
+	e, inner, err := m.split(fh)
+	if err != nil {
+		return err
+	}
+	_, innerFS, _ := m.route(fs)
+	return e.InvalidateHandle(innerFS, inner)
+}
+
+// UpdateHandle updates the handle in the export that minted it.
+func (m *MultiExportHandler) UpdateHandle(fs billy.Filesystem, fh []byte, newPath []string) error {
+	e, inner, err := m.split(fh)
+	if err != nil {
+		return err
+	}
+	_, innerFS, _ := m.route(fs)
+	return e.UpdateHandle(innerFS, inner, newPath)
+}
+
+// handleUpdater is implemented by handlers that can report the handle that
+// refers to a file once UpdateHandle has moved it to newPath, in its
+// canonical form, which need not be the bytes passed in.
+type handleUpdater interface {
+	UpdateHandleWithResult(fs billy.Filesystem, handle []byte, newPath []string) ([]byte, error)
+}
+
+// UpdateHandleWithResult is UpdateHandle, returning the handle that now
+// refers to the file, through the export's UpdateHandleWithResult if it
+// has one.
+func (m *MultiExportHandler) UpdateHandleWithResult(fs billy.Filesystem, fh []byte, newPath []string) ([]byte, error) {
+	e, inner, err := m.split(fh)
+	if err != nil {
+		return nil, err
+	}
+	_, innerFS, _ := m.route(fs)
+	uh, ok := e.Handler.(handleUpdater)
+	if !ok {
+		if err := e.UpdateHandle(innerFS, inner, newPath); err != nil {
+			return nil, err
+		}
+		return fh, nil
+	}
+	updated, err := uh.UpdateHandleWithResult(innerFS, inner, newPath)
+	if err != nil {
+		return nil, err
+	}
+	return append(m.prefix(e), updated...), nil
+}
+
+// InvalidateSubtree drops the handles to path and anything beneath it, if
+// the export's handler can, or else just the handle to path.
+func (m *MultiExportHandler) InvalidateSubtree(fs billy.Filesystem, path []string) int {
+	e, inner, ok := m.route(fs)
+	if !ok {
+		return 0
+	}
+	if invalidator, ok := e.Handler.(interface {
+		InvalidateSubtree(billy.Filesystem, []string) int
+	}); ok {
+		return invalidator.InvalidateSubtree(inner, path)
+	}
+	if err := e.InvalidateHandle(inner, e.ToHandle(inner, path)); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// UpdateHandlesByPath moves the handles under oldPath to newPath, if the
+// export's handler can, or else just the handle to oldPath.
+func (m *MultiExportHandler) UpdateHandlesByPath(fs billy.Filesystem, oldPath []string, newPath []string) int {
+	e, inner, ok := m.route(fs)
+	if !ok {
+		return 0
+	}
+	if updater, ok := e.Handler.(interface {
+		UpdateHandlesByPath(billy.Filesystem, []string, []string) int
+	}); ok {
+		return updater.UpdateHandlesByPath(inner, oldPath, newPath)
+	}
+	oldHandle := e.ToHandle(inner, oldPath)
+	if err := e.UpdateHandle(inner, oldHandle, newPath); err != nil {
+		_ = e.InvalidateHandle(inner, oldHandle)
+		return 0
+	}
+	return 1
+}
+
+// WhenReleased defers release to the export's handler, if it can tell
+// when the handles to path are gone, or else calls it at once.
+func (m *MultiExportHandler) WhenReleased(fs billy.Filesystem, path []string, release func()) {
+	e, inner, ok := m.route(fs)
+	if !ok {
+		release()
+		return
+	}
+	if notifier, ok := e.Handler.(nfs.ReleaseNotifier); ok {
+		notifier.WhenReleased(inner, path, release)
+		return
+	}
+	release()
+}
+
+// HandleLimit is the smallest limit of any export.
+func (m *MultiExportHandler) HandleLimit() int {
+	limit := -1
+	for _, e := range m.exports {
+		if l := e.HandleLimit(); l > 0 && (limit < 0 || l < limit) {
+			limit = l
+		}
+	}
+	return limit
+}
+
+// MaxNameLength is the shortest maximum name length of any export.
+func (m *MultiExportHandler) MaxNameLength() int {
+	max := nfs.PathNameMax
+	for _, e := range m.exports {
+		if nh, ok := e.Handler.(nfs.NameLengthHandler); ok && nh.MaxNameLength() < max {
+			max = nh.MaxNameLength()
+		}
+	}
+	return max
+}
+
+// NameLengthUnit measures names in runes only if every export does: a name
+// is never fewer bytes than runes, so bytes are the stricter measure.
+func (m *MultiExportHandler) NameLengthUnit() nfs.NameLengthUnit {
+	for _, e := range m.exports {
+		if uh, ok := e.Handler.(nfs.NameLengthUnitHandler); !ok || uh.NameLengthUnit() != nfs.NameLengthRunes {
+			return nfs.NameLengthBytes
+		}
+	}
+	if len(m.exports) == 0 {
+		return nfs.NameLengthBytes
+	}
+	return nfs.NameLengthRunes
+}
+
+// MaxSymlinkLength is the shortest maximum symlink target length of any
+// export.
+func (m *MultiExportHandler) MaxSymlinkLength() int {
+	max := nfs.SymlinkMax
+	for _, e := range m.exports {
+		if sh, ok := e.Handler.(nfs.SymlinkLengthHandler); ok && sh.MaxSymlinkLength() < max {
+			max = sh.MaxSymlinkLength()
+		}
+	}
+	return max
+}
+
+// CaseInsensitive defers to the export's handler, if it is an
+// nfs.CaseInsensitiveHandler.
+func (m *MultiExportHandler) CaseInsensitive(fs billy.Filesystem) bool {
+	e, inner, ok := m.route(fs)
+	if !ok {
+		return false
+	}
+	if ch, ok := e.Handler.(nfs.CaseInsensitiveHandler); ok {
+		return ch.CaseInsensitive(inner)
+	}
+	return false
+}
+
+// TimeGranularity defers to the export's handler, if it is an
+// nfs.TimeGranularityHandler.
+func (m *MultiExportHandler) TimeGranularity(fs billy.Filesystem) time.Duration {
+	e, inner, ok := m.route(fs)
+	if !ok {
+		return 0
+	}
+	if th, ok := e.Handler.(nfs.TimeGranularityHandler); ok {
+		return th.TimeGranularity(inner)
+	}
+	return 0
+}
+
+// AtimeUpdates defers to the export's handler, if it is an
+// nfs.AtimeHandler.
+func (m *MultiExportHandler) AtimeUpdates(fs billy.Filesystem) nfs.AtimeMode {
+	e, inner, ok := m.route(fs)
+	if !ok {
+		return nfs.AtimeNever
+	}
+	if ah, ok := e.Handler.(nfs.AtimeHandler); ok {
+		return ah.AtimeUpdates(inner)
+	}
+	return nfs.AtimeNever
+}
+
+// LenientVerifier defers to the export's handler, if it is an
+// nfs.LenientVerifierHandler.
+func (m *MultiExportHandler) LenientVerifier(fs billy.Filesystem) bool {
+	e, inner, ok := m.route(fs)
+	if !ok {
+		return false
+	}
+	if lh, ok := e.Handler.(nfs.LenientVerifierHandler); ok {
+		return lh.LenientVerifier(inner)
+	}
+	return false
+}
+
+// FileIDFor defers to the export's handler, if it is an nfs.FileIDHandler.
+func (m *MultiExportHandler) FileIDFor(fs billy.Filesystem, path []string) uint64 {
+	e, inner, ok := m.route(fs)
+	if !ok {
+		return 0
+	}
+	if ih, ok := e.Handler.(nfs.FileIDHandler); ok {
+		return ih.FileIDFor(inner, path)
+	}
+	return 0
+}
+
+// FSIDFor defers to the export's handler, if it is an nfs.FSIDHandler
+// giving a non-zero fsid, or else tells exports apart by their
+// discriminators.
+func (m *MultiExportHandler) FSIDFor(fs billy.Filesystem) uint64 {
+	e, inner, ok := m.route(fs)
+	if !ok {
+		return 0
+	}
+	if ih, ok := e.Handler.(nfs.FSIDHandler); ok {
+		if id := ih.FSIDFor(inner); id != 0 {
+			return id
+		}
+	}
+	return uint64(e.id)
+}
+
+// OnChange passes the change on to the export's handler, if it is an
+// nfs.ChangeNotifier.
+func (m *MultiExportHandler) OnChange(op string, fs billy.Filesystem, path []string) {
+	e, inner, ok := m.route(fs)
+	if !ok {
+		return
+	}
+	if n, ok := e.Handler.(nfs.ChangeNotifier); ok {
+		n.OnChange(op, inner, path)
+	}
+}
+
+// OnRename passes the rename on to the export's handler, if it is an
+// nfs.RenameNotifier, or else as a change to the new path if it is an
+// nfs.ChangeNotifier.
+func (m *MultiExportHandler) OnRename(fs billy.Filesystem, from, to []string) {
+	e, inner, ok := m.route(fs)
+	if !ok {
+		return
+	}
+	if n, ok := e.Handler.(nfs.RenameNotifier); ok {
+		n.OnRename(inner, from, to)
+	} else if n, ok := e.Handler.(nfs.ChangeNotifier); ok {
+		n.OnChange(nfs.ChangeRename, inner, to)
+	}
+}
+
+// Watch watches each export whose handler is an nfs.Watcher, reporting
+// changes on the file systems mounted from it.
+func (m *MultiExportHandler) Watch(changed func(fs billy.Filesystem, path []string)) func() {
+	var stops []func()
+	for _, e := range m.exports {
+		w, ok := e.Handler.(nfs.Watcher)
+		if !ok {
+			continue
+		}
+		e := e
+		stops = append(stops, w.Watch(func(fs billy.Filesystem, path []string) {
+			changed(exportFS{fs, e}, path)
+		}))
+	}
+	return func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+}
+
+// LockWrites defers to the export's handler, if it is an
+// nfs.WriteLockingHandler.
+func (m *MultiExportHandler) LockWrites(fs billy.Filesystem, path []string) func() {
+	e, inner, ok := m.route(fs)
+	if !ok {
+		return nil
+	}
+	if locker, ok := e.Handler.(nfs.WriteLockingHandler); ok {
+		return locker.LockWrites(inner, path)
+	}
+	return nil
+}
+
+// RangeLocked defers to the export's handler, if it is an
+// nfs.RangeLockHandler.
+func (m *MultiExportHandler) RangeLocked(ctx context.Context, fs billy.Filesystem, path []string, offset, length uint64, write bool) bool {
+	e, inner, ok := m.route(fs)
+	if !ok {
+		return false
+	}
+	if rl, ok := e.Handler.(nfs.RangeLockHandler); ok {
+		return rl.RangeLocked(ctx, inner, path, offset, length, write)
+	}
+	return false
+}
+
+// LockRange defers to the export's handler, if it is an nfs.LockHandler.
+func (m *MultiExportHandler) LockRange(ctx context.Context, fs billy.Filesystem, path []string, offset, length uint64, exclusive bool) error {
+	e, inner, ok := m.route(fs)
+	if !ok {
+		return errUnknownExport
+	}
+	if lh, ok := e.Handler.(nfs.LockHandler); ok {
+		return lh.LockRange(ctx, inner, path, offset, length, exclusive)
+	}
+	return errNoRangeLocks
+}
+
+// UnlockRange defers to the export's handler, if it is an nfs.LockHandler.
+func (m *MultiExportHandler) UnlockRange(ctx context.Context, fs billy.Filesystem, path []string, offset, length uint64) {
+	e, inner, ok := m.route(fs)
+	if !ok {
+		return
+	}
+	if lh, ok := e.Handler.(nfs.LockHandler); ok {
+		lh.UnlockRange(ctx, inner, path, offset, length)
+	}
+}
+
+// verifiers returns the first export able to remember directory listings.
+// Verifiers are not tied to a file system, so one export keeps them for all.
+func (m *MultiExportHandler) verifiers() nfs.CachingHandler {
+	for _, e := range m.exports {
+		if vh, ok := e.Handler.(nfs.CachingHandler); ok {
+			return vh
+		}
+	}
+	return nil
+}
+
+// VerifierFor remembers a directory listing, if any export is able to.
+func (m *MultiExportHandler) VerifierFor(path string, contents []fs.FileInfo) uint64 {
+	if vh := m.verifiers(); vh != nil {
+		return vh.VerifierFor(path, contents)
+	}
+	return 0
+}
+
+// DataForVerifier recalls a directory listing remembered by VerifierFor.
+func (m *MultiExportHandler) DataForVerifier(path string, verifier uint64) []fs.FileInfo {
+	if vh := m.verifiers(); vh != nil {
+		return vh.DataForVerifier(path, verifier)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers/memfs"
+)
+
+func mountExport(t *testing.T, h nfs.Handler, dirpath string) billy.Filesystem {
+	t.Helper()
+	status, fs, _ := h.Mount(context.Background(), nil, nfs.MountRequest{Dirpath: []byte(dirpath)})
+	if status != nfs.MountStatusOk {
+		t.Fatalf("mounting %s: status %d", dirpath, status)
+	}
+	return fs
+}
+
+func TestMultiExportHandler(t *testing.T) {
+	a, b := memfs.New(), memfs.New()
+	h := NewMultiExportHandler(map[string]nfs.Handler{
+		"/a":  NewCachingHandler(NewNullAuthHandler(a), 1024),
+		"/b/": NewCachingHandler(NewNullAuthHandler(b), 1024),
+	})
+
+	fsA := mountExport(t, h, "/a")
+	fsB := mountExport(t, h, "/b")
+	if status, _, _ := h.Mount(context.Background(), nil, nfs.MountRequest{Dirpath: []byte("/c")}); status != nfs.MountStatusErrNoEnt {
+		t.Fatalf("expected an unknown export to be refused, got status %d", status)
+	}
+
+	if h.(*MultiExportHandler).FSIDFor(fsA) == h.(*MultiExportHandler).FSIDFor(fsB) {
+		t.Fatal("exports share an fsid")
+	}
+
+	// the same path in each export must get distinct handles, each
+	// resolving to its own export.
+	path := []string{"dir", "file"}
+	handleA := h.ToHandle(fsA, path)
+	handleB := h.ToHandle(fsB, path)
+	if reflect.DeepEqual(handleA, handleB) {
+		t.Fatalf("exports share handle %x", handleA)
+	}
+	for _, tc := range []struct {
+		handle []byte
+		fs     billy.Filesystem
+	}{{handleA, a}, {handleB, b}} {
+		fs, p, err := h.FromHandle(tc.handle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(p, path) {
+			t.Fatalf("handle %x resolved to %v", tc.handle, p)
+		}
+		if fs.(exportFS).Filesystem != tc.fs {
+			t.Fatalf("handle %x resolved in the wrong export", tc.handle)
+		}
+		if again := h.ToHandle(fs, p); !reflect.DeepEqual(again, tc.handle) {
+			t.Fatalf("resolved handle %x re-minted as %x", tc.handle, again)
+		}
+	}
+
+	// an export's own handle, or one naming an export not served, must
+	// not resolve.
+	if _, _, err := h.FromHandle(handleA[exportIDLength:]); err == nil {
+		t.Fatal("expected a handle without a discriminator to be rejected")
+	}
+	unknown := append([]byte{}, handleA...)
+	unknown[0] ^= 0xff
+	if _, _, err := h.FromHandle(unknown); err == nil {
+		t.Fatal("expected a handle for an unknown export to be rejected")
+	}
+
+	// invalidating a handle in one export leaves the other's alone.
+	fs, _, _ := h.FromHandle(handleA)
+	if err := h.InvalidateHandle(fs, handleA); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := h.FromHandle(handleA); err == nil {
+		t.Fatal("expected the invalidated handle to be stale")
+	}
+	if _, _, err := h.FromHandle(handleB); err != nil {
+		t.Fatalf("the other export's handle should still resolve: %v", err)
+	}
+}
+
+func TestCompactMultiExportHandler(t *testing.T) {
+	exports := make(map[string]nfs.Handler)
+	for i := 0; i < 200; i++ {
+		exports[fmt.Sprintf("/export%03d", i)] = NewCachingHandler(NewNullAuthHandler(memfs.New()), 1024)
+	}
+	h, err := NewCompactMultiExportHandler(exports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := h.(nfs.HandleSizer).MaxHandleSize(); n > CompactHandleSize {
+		t.Fatalf("compact handles may take %d bytes", n)
+	}
+
+	// exports past the 128th take a second byte of varint.
+	for _, p := range []string{"/export000", "/export199"} {
+		fs := mountExport(t, h, p)
+		fh := h.ToHandle(fs, []string{"dir", "file"})
+		if len(fh) > CompactHandleSize {
+			t.Fatalf("handle %x of %s exceeds %d bytes", fh, p, CompactHandleSize)
+		}
+		resolved, path, err := h.FromHandle(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resolved.(exportFS).export.path != p || !reflect.DeepEqual(path, []string{"dir", "file"}) {
+			t.Fatalf("handle %x of %s resolved to %v in %s", fh, p, path, resolved.(exportFS).export.path)
+		}
+	}
+
+	// handles that can't be shown to fit are refused.
+	nested := NewMultiExportHandler(map[string]nfs.Handler{"/n": NewCachingHandler(NewNullAuthHandler(memfs.New()), 1024)})
+	if _, err := NewCompactMultiExportHandler(map[string]nfs.Handler{"/a": nested, "/b": NewCachingHandler(NewNullAuthHandler(memfs.New()), 1024)}); err != nil {
+		t.Fatalf("expected a nested export of 21-byte handles to fit, got %v", err)
+	}
+	if _, err := NewCompactMultiExportHandler(map[string]nfs.Handler{"/a": NewNullAuthHandler(memfs.New())}); !errors.Is(err, ErrHandleTooLong) {
+		t.Fatalf("expected an export not bounding its handles refused, got %v", err)
+	}
+	deep := nfs.Handler(NewCachingHandler(NewNullAuthHandler(memfs.New()), 1024))
+	for i := 0; i < 4; i++ {
+		deep = NewMultiExportHandler(map[string]nfs.Handler{"/d": deep})
+	}
+	if _, err := NewCompactMultiExportHandler(map[string]nfs.Handler{"/a": deep}); !errors.Is(err, ErrHandleTooLong) {
+		t.Fatalf("expected exports nested too deep to fit refused, got %v", err)
+	}
+}
+
+func TestMultiExportFromHandleShallow(t *testing.T) {
+	mem := memfs.New()
+	inner := NewCachingHandler(NewNullAuthHandler(mem), 1024).(*CachingHandler)
+	now := time.Now()
+	inner.now = func() time.Time { return now }
+	h := NewMultiExportHandler(map[string]nfs.Handler{"/a": inner}).(*MultiExportHandler)
+	fs := mountExport(t, h, "/a")
+
+	_ = h.ToHandle(fs, []string{"dir"})
+	fh := h.ToHandle(fs, []string{"dir", "file"})
+	now = now.Add(time.Second)
+	resolved, p, err := h.FromHandleShallow(context.Background(), fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.(exportFS).Filesystem != mem || !reflect.DeepEqual(p, []string{"dir", "file"}) {
+		t.Fatalf("handle resolved to %v in the wrong file system", p)
+	}
+	// only the file's handle is refreshed, and not its directory's.
+	if ages := inner.HandleAges(); len(ages) != 2 || ages[0] != time.Second || ages[1] != 0 {
+		t.Fatalf("expected the directory's handle left a second old, got ages %v", ages)
+	}
+}
@@ -0,0 +1,61 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/willscott/go-nfs"
+)
+
+var errNotImplemented = errors.New("not implemented")
+
+// NullHandler implements every method of nfs.Handler without doing
+// anything useful, so that it can be embedded in a handler that overrides
+// only the methods it cares about. Operations it cannot perform fail with
+// NFSStatusNotSupp, and the file system it exports is read-only.
+type NullHandler struct{}
+
+var _ nfs.Handler = NullHandler{}
+
+// Mount refuses all mount requests.
+func (NullHandler) Mount(context.Context, net.Conn, nfs.MountRequest) (nfs.MountStatus, billy.Filesystem, []nfs.AuthFlavor) {
+	return nfs.MountStatusErrNotSupp, nil, nil
+}
+
+// Change returns nil, leaving the file system read-only.
+func (NullHandler) Change(billy.Filesystem) billy.Change {
+	return nil
+}
+
+// FSStat leaves the server's default statistics unchanged.
+func (NullHandler) FSStat(context.Context, billy.Filesystem, *nfs.FSStat) error {
+	return nil
+}
+
+// ToHandle returns an empty handle; wrap with a CachingHandler for real ones.
+func (NullHandler) ToHandle(billy.Filesystem, []string) []byte {
+	return []byte{}
+}
+
+// FromHandle resolves no handles.
+func (NullHandler) FromHandle([]byte) (billy.Filesystem, []string, error) {
+	return nil, []string{}, &nfs.NFSStatusError{NFSStatus: nfs.NFSStatusNotSupp, WrappedErr: errNotImplemented}
+}
+
+// InvalidateHandle has nothing to invalidate.
+func (NullHandler) InvalidateHandle(billy.Filesystem, []byte) error {
+	return nil
+}
+
+// UpdateHandle tracks no handles, so callers fall back to InvalidateHandle.
+func (NullHandler) UpdateHandle(billy.Filesystem, []byte, []string) error {
+	return &nfs.NFSStatusError{NFSStatus: nfs.NFSStatusNotSupp, WrappedErr: os.ErrNotExist}
+}
+
+// HandleLimit reports that no handles are kept.
+func (NullHandler) HandleLimit() int {
+	return 0
+}
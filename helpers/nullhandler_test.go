@@ -0,0 +1,35 @@
+package helpers_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+)
+
+// readOnlyHandler exports fs to anyone, read-only. Everything else comes
+// from the embedded NullHandler, and handles from the CachingHandler that
+// wraps it.
+type readOnlyHandler struct {
+	helpers.NullHandler
+	fs billy.Filesystem
+}
+
+func (h readOnlyHandler) Mount(context.Context, net.Conn, nfs.MountRequest) (nfs.MountStatus, billy.Filesystem, []nfs.AuthFlavor) {
+	return nfs.MountStatusOk, h.fs, []nfs.AuthFlavor{nfs.AuthFlavorNull}
+}
+
+func ExampleNullHandler() {
+	handler := helpers.NewCachingHandler(readOnlyHandler{fs: memfs.New()}, 1024)
+
+	status, fs, _ := handler.Mount(context.Background(), nil, nfs.MountRequest{})
+	fmt.Println("mount ok:", status == nfs.MountStatusOk)
+	fmt.Println("writable:", handler.Change(fs) != nil)
+	// Output:
+	// mount ok: true
+	// writable: false
+}
@@ -0,0 +1,332 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// The procedures of version 2 of the portmapper program, RFC 1833.
+const (
+	pmapProcNull    = 0
+	pmapProcSet     = 1
+	pmapProcUnset   = 2
+	pmapProcGetPort = 3
+	pmapProcDump    = 4
+)
+
+// ErrPortmapperRefused is returned by RegisterWithPortmapper when the
+// portmapper declines the mapping, as it does when the program, version and
+// protocol are already mapped to another port.
+var ErrPortmapperRefused = errors.New("portmapper refused the mapping")
+
+// portmapperTimeout bounds the exchange of one call with a portmapper.
+const portmapperTimeout = 5 * time.Second
+
+var portmapperXid uint32
+
+// RegisterWithPortmapper maps version of program over proto, rpc.IPProtoTCP
+// or rpc.IPProtoUDP, to port with the portmapper (rpcbind) of this host,
+// so that clients asking it for the program find the server's port. It
+// sends a PMAPPROC_SET call, which rpcbind accepts only over the loopback.
+func RegisterWithPortmapper(program, version, proto, port uint32) error {
+	return RegisterWithPortmapperAt(fmt.Sprintf("127.0.0.1:%d", rpc.PmapPort), program, version, proto, port)
+}
+
+// RegisterWithPortmapperAt is RegisterWithPortmapper with the portmapper
+// listening for TCP connections at addr.
+func RegisterWithPortmapperAt(addr string, program, version, proto, port uint32) error {
+	ok, err := callPortmapper(addr, pmapProcSet, rpc.Mapping{Prog: program, Vers: version, Prot: proto, Port: port})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrPortmapperRefused
+	}
+	return nil
+}
+
+// UnregisterFromPortmapperAt removes the mappings of version of program
+// from the portmapper at addr, as a server does when it stops.
+func UnregisterFromPortmapperAt(addr string, program, version uint32) error {
+	_, err := callPortmapper(addr, pmapProcUnset, rpc.Mapping{Prog: program, Vers: version})
+	return err
+}
+
+// callPortmapper makes the call proc of m to the portmapper at addr, and
+// returns the boolean it replies with.
+func callPortmapper(addr string, proc uint32, m rpc.Mapping) (bool, error) {
+	conn, err := net.DialTimeout("tcp", addr, portmapperTimeout)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(portmapperTimeout))
+
+	xid := atomic.AddUint32(&portmapperXid, 1)
+	call := new(bytes.Buffer)
+	if err := xdr.Write(call, &struct {
+		Xid  uint32
+		Type uint32
+		rpc.Header
+		rpc.Mapping
+	}{
+		Xid: xid,
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    rpc.PmapProg,
+			Vers:    rpc.PmapVers,
+			Proc:    proc,
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		Mapping: m,
+	}); err != nil {
+		return false, err
+	}
+	if err := writeRecord(conn, call.Bytes()); err != nil {
+		return false, err
+	}
+	reply, err := readRecord(conn)
+	if err != nil {
+		return false, err
+	}
+
+	res := bytes.NewReader(reply)
+	var head struct {
+		Xid       uint32
+		Type      uint32
+		ReplyStat uint32
+	}
+	if err := xdr.Read(res, &head); err != nil {
+		return false, err
+	}
+	if head.Xid != xid || head.Type != 1 {
+		return false, errors.New("portmapper sent an unexpected reply")
+	}
+	if head.ReplyStat != rpc.MsgAccepted {
+		return false, errors.New("portmapper denied the call")
+	}
+	var accepted struct {
+		Verf   rpc.Auth
+		Accept uint32
+		Result uint32
+	}
+	if err := xdr.Read(res, &accepted); err != nil {
+		return false, err
+	}
+	if accepted.Accept != rpc.Success {
+		return false, fmt.Errorf("portmapper failed the call with accept status %d", accepted.Accept)
+	}
+	return accepted.Result != 0, nil
+}
+
+// maxPortmapRecord bounds the calls a Portmapper reads, which are short.
+const maxPortmapRecord = 1024
+
+// writeRecord sends msg on w as the only fragment of a record.
+func writeRecord(w io.Writer, msg []byte) error {
+	var fragment [4]byte
+	binary.BigEndian.PutUint32(fragment[:], uint32(len(msg))|1<<31)
+	_, err := w.Write(append(fragment[:], msg...))
+	return err
+}
+
+// readRecord reads a record of a single fragment from r.
+func readRecord(r io.Reader) ([]byte, error) {
+	var fragment [4]byte
+	if _, err := io.ReadFull(r, fragment[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(fragment[:])
+	if n&(1<<31) == 0 || n&^(1<<31) > maxPortmapRecord {
+		return nil, errors.New("unsupported record")
+	}
+	msg := make([]byte, n&^(1<<31))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Portmapper is a minimal portmapper for hosts without rpcbind, answering
+// the NULL, SET, UNSET, GETPORT and DUMP procedures of version 2 from the
+// mappings it holds. It serves over TCP with Serve and over UDP with
+// ServeUDP, usually on port 111. Unlike rpcbind, it accepts mappings from
+// any address it is reached on.
+type Portmapper struct {
+	mu       sync.Mutex
+	mappings []rpc.Mapping
+}
+
+// NewPortmapper returns a Portmapper holding no mappings.
+func NewPortmapper() *Portmapper {
+	return &Portmapper{}
+}
+
+// Set maps version of program over proto to port, as PMAPPROC_SET does,
+// reporting false if it is already mapped.
+func (p *Portmapper) Set(program, version, proto, port uint32) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range p.mappings {
+		if m.Prog == program && m.Vers == version && m.Prot == proto {
+			return false
+		}
+	}
+	p.mappings = append(p.mappings, rpc.Mapping{Prog: program, Vers: version, Prot: proto, Port: port})
+	return true
+}
+
+// Unset removes the mappings of version of program, reporting whether
+// there were any.
+func (p *Portmapper) Unset(program, version uint32) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	kept := p.mappings[:0]
+	for _, m := range p.mappings {
+		if m.Prog != program || m.Vers != version {
+			kept = append(kept, m)
+		}
+	}
+	removed := len(kept) < len(p.mappings)
+	p.mappings = kept
+	return removed
+}
+
+// Port returns the port version of program over proto is mapped to, or 0.
+func (p *Portmapper) Port(program, version, proto uint32) uint32 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range p.mappings {
+		if m.Prog == program && m.Vers == version && m.Prot == proto {
+			return m.Port
+		}
+	}
+	return 0
+}
+
+// Serve answers the calls made on the connections l accepts, until l is
+// closed.
+func (p *Portmapper) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				call, err := readRecord(conn)
+				if err != nil {
+					return
+				}
+				reply := p.reply(call)
+				if reply == nil {
+					return
+				}
+				if err := writeRecord(conn, reply); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// ServeUDP answers the calls arriving as datagrams on pc, until pc is
+// closed.
+func (p *Portmapper) ServeUDP(pc net.PacketConn) error {
+	buf := make([]byte, maxPortmapRecord)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if reply := p.reply(buf[:n]); reply != nil {
+			_, _ = pc.WriteTo(reply, addr)
+		}
+	}
+}
+
+// reply returns the reply to call, or nil if it is not one to answer.
+func (p *Portmapper) reply(call []byte) []byte {
+	req := bytes.NewReader(call)
+	var head struct {
+		Xid  uint32
+		Type uint32
+		rpc.Header
+	}
+	if err := xdr.Read(req, &head); err != nil || head.Type != 0 {
+		return nil
+	}
+
+	reply := new(bytes.Buffer)
+	accept := uint32(rpc.Success)
+	var result interface{}
+	var list []byte
+	switch {
+	case head.Prog != rpc.PmapProg:
+		accept = rpc.ProgUnavail
+	case head.Vers != rpc.PmapVers:
+		accept = rpc.ProgMismatch
+		result = [2]uint32{rpc.PmapVers, rpc.PmapVers}
+	case head.Proc == pmapProcNull:
+	case head.Proc == pmapProcSet || head.Proc == pmapProcUnset || head.Proc == pmapProcGetPort:
+		var m rpc.Mapping
+		if err := xdr.Read(req, &m); err != nil {
+			accept = rpc.GarbageArgs
+			break
+		}
+		switch head.Proc {
+		case pmapProcSet:
+			result = p.Set(m.Prog, m.Vers, m.Prot, m.Port)
+		case pmapProcUnset:
+			result = p.Unset(m.Prog, m.Vers)
+		default:
+			result = p.Port(m.Prog, m.Vers, m.Prot)
+		}
+	case head.Proc == pmapProcDump:
+		list = p.dump()
+	default:
+		accept = rpc.ProcUnavail
+	}
+
+	if err := xdr.Write(reply, &struct {
+		Xid       uint32
+		Type      uint32
+		ReplyStat uint32
+		Verf      rpc.Auth
+		Accept    uint32
+	}{head.Xid, 1, rpc.MsgAccepted, rpc.AuthNull, accept}); err != nil {
+		return nil
+	}
+	if result != nil {
+		if err := xdr.Write(reply, result); err != nil {
+			return nil
+		}
+	}
+	reply.Write(list)
+	return reply.Bytes()
+}
+
+// dump encodes the mappings as the list PMAPPROC_DUMP replies with.
+func (p *Portmapper) dump() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	list := new(bytes.Buffer)
+	for _, m := range p.mappings {
+		_ = xdr.Write(list, uint32(1))
+		_ = xdr.Write(list, m)
+	}
+	_ = xdr.Write(list, uint32(0))
+	return list.Bytes()
+}
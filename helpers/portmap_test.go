@@ -0,0 +1,123 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// stubPortmapper accepts one call at a listener's address, sending what it
+// was asked on calls and replying with result.
+func stubPortmapper(t *testing.T, result bool) (string, chan []byte) {
+	t.Helper()
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	calls := make(chan []byte, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		call, err := readRecord(conn)
+		if err != nil {
+			return
+		}
+		calls <- call
+		reply := new(bytes.Buffer)
+		_ = xdr.Write(reply, &struct {
+			Xid       uint32
+			Type      uint32
+			ReplyStat uint32
+			Verf      rpc.Auth
+			Accept    uint32
+			Result    bool
+		}{
+			Xid:       binary.BigEndian.Uint32(call),
+			Type:      1,
+			ReplyStat: rpc.MsgAccepted,
+			Verf:      rpc.AuthNull,
+			Result:    result,
+		})
+		_ = writeRecord(conn, reply.Bytes())
+	}()
+	return l.Addr().String(), calls
+}
+
+func TestRegisterWithPortmapper(t *testing.T) {
+	addr, calls := stubPortmapper(t, true)
+	if err := RegisterWithPortmapperAt(addr, 100003, 3, rpc.IPProtoTCP, 2049); err != nil {
+		t.Fatal(err)
+	}
+	var call struct {
+		Xid  uint32
+		Type uint32
+		rpc.Header
+		rpc.Mapping
+	}
+	if err := xdr.Read(bytes.NewReader(<-calls), &call); err != nil {
+		t.Fatal(err)
+	}
+	if call.Type != 0 || call.Rpcvers != 2 || call.Header.Prog != rpc.PmapProg || call.Header.Vers != rpc.PmapVers || call.Proc != 1 {
+		t.Fatalf("expected a PMAPPROC_SET call, got %+v", call.Header)
+	}
+	if want := (rpc.Mapping{Prog: 100003, Vers: 3, Prot: rpc.IPProtoTCP, Port: 2049}); call.Mapping != want {
+		t.Fatalf("sent mapping %+v, want %+v", call.Mapping, want)
+	}
+
+	addr, _ = stubPortmapper(t, false)
+	if err := RegisterWithPortmapperAt(addr, 100003, 3, rpc.IPProtoTCP, 2049); err != ErrPortmapperRefused {
+		t.Fatalf("expected a refused mapping to fail, got %v", err)
+	}
+}
+
+func TestPortmapper(t *testing.T) {
+	pm := NewPortmapper()
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		_ = pm.Serve(l)
+	}()
+	addr := l.Addr().String()
+
+	if err := RegisterWithPortmapperAt(addr, 100003, 3, rpc.IPProtoTCP, 2049); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterWithPortmapperAt(addr, 100003, 3, rpc.IPProtoTCP, 2050); err != ErrPortmapperRefused {
+		t.Fatalf("expected a second mapping of the program to be refused, got %v", err)
+	}
+
+	c, err := rpc.DialTCP("tcp", addr, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client := &rpc.Portmapper{Client: c}
+	port, err := client.Getport(rpc.Mapping{Prog: 100003, Vers: 3, Prot: rpc.IPProtoTCP})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 2049 {
+		t.Fatalf("expected port 2049, got %d", port)
+	}
+	if port, err := client.Getport(rpc.Mapping{Prog: 100003, Vers: 3, Prot: rpc.IPProtoUDP}); err != nil || port != 0 {
+		t.Fatalf("expected no port over UDP, got %d, %v", port, err)
+	}
+
+	if err := UnregisterFromPortmapperAt(addr, 100003, 3); err != nil {
+		t.Fatal(err)
+	}
+	if port := pm.Port(100003, 3, rpc.IPProtoTCP); port != 0 {
+		t.Fatalf("expected the mapping to be removed, got port %d", port)
+	}
+}
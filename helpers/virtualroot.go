@@ -0,0 +1,300 @@
+package helpers
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/willscott/go-nfs"
+)
+
+// NewVirtualRootHandler serves each of backends, keyed by name, as a
+// directory of a synthesized root, so that one mount presents them all.
+// The root itself is read-only, listing only the names of the backends,
+// and a rename between backends fails with NFS3ERR_XDEV. As with
+// NewNullAuthHandler, the handler is meant to be wrapped in a
+// CachingHandler, whose handles then resolve within the right backend.
+// Backends reporting inode numbers should be on distinct devices, or files
+// in two of them may share a fileid.
+func NewVirtualRootHandler(backends map[string]billy.Filesystem) nfs.Handler {
+	return NewNullAuthHandler(newVirtualRootFS(backends))
+}
+
+// virtualRootFS is a file system whose root holds each of its backends as
+// a directory, routing paths beneath them to the backend.
+type virtualRootFS struct {
+	backends map[string]billy.Filesystem
+	names    []string
+	created  time.Time
+}
+
+func newVirtualRootFS(backends map[string]billy.Filesystem) *virtualRootFS {
+	v := &virtualRootFS{
+		backends: make(map[string]billy.Filesystem, len(backends)),
+		created:  time.Now(),
+	}
+	for name, fs := range backends {
+		name = strings.Trim(path.Clean("/"+name), "/")
+		if name == "" || strings.Contains(name, "/") {
+			continue
+		}
+		v.backends[name] = fs
+		v.names = append(v.names, name)
+	}
+	sort.Strings(v.names)
+	return v
+}
+
+// route finds the backend holding filename, and its path there. It
+// reports no backend for the root.
+func (v *virtualRootFS) route(filename string) (billy.Filesystem, string, error) {
+	clean := strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(filename)), "/")
+	if clean == "" {
+		return nil, "", nil
+	}
+	name, rest, _ := strings.Cut(clean, "/")
+	fs, ok := v.backends[name]
+	if !ok {
+		return nil, "", &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+	}
+	return fs, "/" + rest, nil
+}
+
+// routeChange is route for a call that would change filename, which the
+// read-only root refuses.
+func (v *virtualRootFS) routeChange(op, filename string) (billy.Filesystem, string, error) {
+	fs, rel, err := v.route(filename)
+	if err == nil && (fs == nil || rel == "/") {
+		err = &os.PathError{Op: op, Path: filename, Err: syscall.EROFS}
+	}
+	return fs, rel, err
+}
+
+func (v *virtualRootFS) Create(filename string) (billy.File, error) {
+	fs, rel, err := v.routeChange("create", filename)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Create(rel)
+}
+
+func (v *virtualRootFS) Open(filename string) (billy.File, error) {
+	return v.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (v *virtualRootFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	fs, rel, err := v.route(filename)
+	if err != nil {
+		return nil, err
+	}
+	if fs == nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: syscall.EISDIR}
+	}
+	return fs.OpenFile(rel, flag, perm)
+}
+
+func (v *virtualRootFS) Stat(filename string) (os.FileInfo, error) {
+	return v.stat(filename, billy.Filesystem.Stat)
+}
+
+func (v *virtualRootFS) Lstat(filename string) (os.FileInfo, error) {
+	return v.stat(filename, billy.Filesystem.Lstat)
+}
+
+func (v *virtualRootFS) stat(filename string, stat func(billy.Filesystem, string) (os.FileInfo, error)) (os.FileInfo, error) {
+	fs, rel, err := v.route(filename)
+	if err != nil {
+		return nil, err
+	}
+	if fs == nil {
+		return virtualRootInfo{v.created}, nil
+	}
+	info, err := stat(fs, rel)
+	if err != nil {
+		return nil, err
+	}
+	if rel == "/" {
+		// a backend's root is named for the backend.
+		return namedInfo{info, path.Base(path.Clean("/" + filepath.ToSlash(filename)))}, nil
+	}
+	return info, nil
+}
+
+func (v *virtualRootFS) Rename(oldpath, newpath string) error {
+	from, oldRel, err := v.routeChange("rename", oldpath)
+	if err != nil {
+		return err
+	}
+	to, newRel, err := v.routeChange("rename", newpath)
+	if err != nil {
+		return err
+	}
+	if from != to {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+	}
+	return from.Rename(oldRel, newRel)
+}
+
+func (v *virtualRootFS) Remove(filename string) error {
+	fs, rel, err := v.routeChange("remove", filename)
+	if err != nil {
+		return err
+	}
+	return fs.Remove(rel)
+}
+
+func (v *virtualRootFS) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (v *virtualRootFS) TempFile(dir, prefix string) (billy.File, error) {
+	fs, rel, err := v.routeChange("create", dir)
+	if err != nil {
+		return nil, err
+	}
+	return fs.TempFile(rel, prefix)
+}
+
+func (v *virtualRootFS) ReadDir(dirname string) ([]os.FileInfo, error) {
+	fs, rel, err := v.route(dirname)
+	if err != nil {
+		return nil, err
+	}
+	if fs != nil {
+		return fs.ReadDir(rel)
+	}
+	contents := make([]os.FileInfo, 0, len(v.names))
+	for _, name := range v.names {
+		info, err := v.backends[name].Stat("/")
+		if err != nil {
+			continue
+		}
+		contents = append(contents, namedInfo{info, name})
+	}
+	return contents, nil
+}
+
+func (v *virtualRootFS) MkdirAll(filename string, perm os.FileMode) error {
+	fs, rel, err := v.route(filename)
+	if err != nil {
+		return err
+	}
+	if fs == nil || rel == "/" {
+		return nil
+	}
+	return fs.MkdirAll(rel, perm)
+}
+
+func (v *virtualRootFS) Symlink(target, link string) error {
+	fs, rel, err := v.routeChange("symlink", link)
+	if err != nil {
+		return err
+	}
+	return fs.Symlink(target, rel)
+}
+
+func (v *virtualRootFS) Readlink(link string) (string, error) {
+	fs, rel, err := v.route(link)
+	if err != nil {
+		return "", err
+	}
+	if fs == nil {
+		return "", &os.PathError{Op: "readlink", Path: link, Err: os.ErrInvalid}
+	}
+	return fs.Readlink(rel)
+}
+
+func (v *virtualRootFS) Chroot(p string) (billy.Filesystem, error) {
+	fs, rel, err := v.route(p)
+	if err != nil {
+		return nil, err
+	}
+	if fs == nil {
+		return v, nil
+	}
+	return fs.Chroot(rel)
+}
+
+func (v *virtualRootFS) Root() string {
+	return "/"
+}
+
+// Capabilities are those any of the backends has.
+func (v *virtualRootFS) Capabilities() billy.Capability {
+	var c billy.Capability
+	for _, fs := range v.backends {
+		c |= billy.Capabilities(fs)
+	}
+	return c
+}
+
+// change returns the billy.Change of the backend holding filename, and its
+// path there.
+func (v *virtualRootFS) change(op, filename string) (billy.Change, string, error) {
+	fs, rel, err := v.routeChange(op, filename)
+	if err != nil {
+		return nil, "", err
+	}
+	c, ok := fs.(billy.Change)
+	if !ok {
+		return nil, "", billy.ErrNotSupported
+	}
+	return c, rel, nil
+}
+
+func (v *virtualRootFS) Chmod(name string, mode os.FileMode) error {
+	c, rel, err := v.change("chmod", name)
+	if err != nil {
+		return err
+	}
+	return c.Chmod(rel, mode)
+}
+
+func (v *virtualRootFS) Lchown(name string, uid, gid int) error {
+	c, rel, err := v.change("lchown", name)
+	if err != nil {
+		return err
+	}
+	return c.Lchown(rel, uid, gid)
+}
+
+func (v *virtualRootFS) Chown(name string, uid, gid int) error {
+	c, rel, err := v.change("chown", name)
+	if err != nil {
+		return err
+	}
+	return c.Chown(rel, uid, gid)
+}
+
+func (v *virtualRootFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	c, rel, err := v.change("chtimes", name)
+	if err != nil {
+		return err
+	}
+	return c.Chtimes(rel, atime, mtime)
+}
+
+// virtualRootInfo describes the synthesized root.
+type virtualRootInfo struct {
+	created time.Time
+}
+
+func (i virtualRootInfo) Name() string       { return "/" }
+func (i virtualRootInfo) Size() int64        { return 0 }
+func (i virtualRootInfo) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (i virtualRootInfo) ModTime() time.Time { return i.created }
+func (i virtualRootInfo) IsDir() bool        { return true }
+func (i virtualRootInfo) Sys() interface{}   { return nil }
+
+// namedInfo is a FileInfo under another name.
+type namedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (i namedInfo) Name() string { return i.name }
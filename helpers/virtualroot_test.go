@@ -0,0 +1,67 @@
+package helpers
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/willscott/go-nfs/helpers/memfs"
+)
+
+func TestVirtualRootHandler(t *testing.T) {
+	a, b := memfs.New(), memfs.New()
+	for fs, content := range map[billy.Filesystem]string{a: "in a", b: "in b"} {
+		if err := util.WriteFile(fs, "/file", []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewCachingHandler(NewVirtualRootHandler(map[string]billy.Filesystem{"a": a, "/b/": b}), 1024)
+	fs := mountExport(t, h, "/")
+
+	contents, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range contents {
+		if !c.IsDir() {
+			t.Fatalf("%s is listed as other than a directory", c.Name())
+		}
+		names = append(names, c.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Fatalf("root lists %v", names)
+	}
+
+	// a handle beneath a backend resolves there.
+	handle := h.ToHandle(fs, []string{"b", "file"})
+	resolved, p, err := h.FromHandle(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := resolved.Open(resolved.Join(p...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if data, err := io.ReadAll(f); err != nil || string(data) != "in b" {
+		t.Fatalf("read %q, %v through the handle of b/file", data, err)
+	}
+
+	// the root itself cannot be changed, nor files moved between backends.
+	if _, err := fs.Create("/new"); err == nil {
+		t.Fatal("created a file in the root")
+	}
+	if err := fs.Remove("/a"); err == nil {
+		t.Fatal("removed a backend from the root")
+	}
+	if err := fs.Rename("/a/file", "/b/moved"); err == nil {
+		t.Fatal("renamed a file between backends")
+	}
+	if _, err := fs.Stat("/c/file"); !os.IsNotExist(err) {
+		t.Fatalf("expected a missing backend not to exist, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package helpers
+
+import (
+	"os"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/willscott/go-nfs"
+)
+
+// Watch watches the wrapped handler, if it is an nfs.Watcher, keeping the
+// handler's caches in step with each change before passing it on to
+// changed. The listings remembered for cookie verifiers of the path and of
+// its directory are dropped. The handles to a path found gone, and to
+// anything beneath it, are invalidated; a path still there keeps its
+// handles, since they still name it.
+func (c *CachingHandler) Watch(changed func(f billy.Filesystem, path []string)) func() {
+	w, ok := c.Handler.(nfs.Watcher)
+	if !ok {
+		return func() {}
+	}
+	return w.Watch(func(f billy.Filesystem, path []string) {
+		if len(path) > 0 {
+			if _, err := f.Lstat(f.Join(path...)); os.IsNotExist(err) {
+				c.InvalidateSubtree(f, path)
+			}
+		}
+		if c.dryRun {
+			f = dryRunFS{f}
+		}
+		c.invalidateVerifiers(f, path)
+		changed(f, path)
+	})
+}
+
+// invalidateVerifiers drops the listings of the directory at path and of
+// the directory holding it.
+func (c *CachingHandler) invalidateVerifiers(f billy.Filesystem, path []string) {
+	dir := f.Join(path...)
+	parent := dir
+	if len(path) > 0 {
+		parent = f.Join(path[:len(path)-1]...)
+	}
+	for _, id := range c.activeVerifiers.Keys() {
+		if v, ok := c.activeVerifiers.Peek(id); ok && (v.path == dir || v.path == parent) {
+			c.activeVerifiers.Remove(id)
+		}
+	}
+}
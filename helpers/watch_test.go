@@ -0,0 +1,103 @@
+package helpers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers/memfs"
+)
+
+// mockWatcher reports the changes it is told of to whoever watches it.
+type mockWatcher struct {
+	nfs.Handler
+	changed func(billy.Filesystem, []string)
+}
+
+func (w *mockWatcher) Watch(changed func(billy.Filesystem, []string)) func() {
+	w.changed = changed
+	return func() { w.changed = nil }
+}
+
+func TestWatchInvalidatesVerifiers(t *testing.T) {
+	mem := memfs.New()
+	if err := mem.MkdirAll("dir/sub/leaf", 0755); err != nil {
+		t.Fatal(err)
+	}
+	watcher := &mockWatcher{Handler: NewNullAuthHandler(mem)}
+	c := NewCachingHandler(watcher, 1024).(*CachingHandler)
+
+	var reported [][]string
+	stop := c.Watch(func(_ billy.Filesystem, path []string) {
+		reported = append(reported, path)
+	})
+	defer stop()
+
+	contents, err := mem.ReadDir("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirVerifier := c.VerifierFor(mem.Join("dir"), contents)
+	if contents, err = mem.ReadDir("dir/sub"); err != nil {
+		t.Fatal(err)
+	}
+	otherVerifier := c.VerifierFor(mem.Join("dir", "sub"), contents)
+
+	watcher.changed(mem, []string{"dir", "new"})
+	if c.DataForVerifier(mem.Join("dir"), dirVerifier) != nil {
+		t.Fatal("expected the listing of the changed file's directory to be dropped")
+	}
+	if c.DataForVerifier(mem.Join("dir", "sub"), otherVerifier) == nil {
+		t.Fatal("expected the listing of an unaffected directory to be kept")
+	}
+	if !reflect.DeepEqual(reported, [][]string{{"dir", "new"}}) {
+		t.Fatalf("expected the change to be passed on, got %v", reported)
+	}
+
+	stop()
+	if watcher.changed != nil {
+		t.Fatal("expected stopping to stop the wrapped watcher")
+	}
+}
+
+func TestWatchInvalidatesHandles(t *testing.T) {
+	mem := memfs.New()
+	watcher := &mockWatcher{Handler: NewNullAuthHandler(mem)}
+	c := NewCachingHandler(watcher, 1024).(*CachingHandler)
+	for _, name := range []string{"/kept/file", "/gone/a", "/gone/sub/b"} {
+		f, err := mem.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = f.Close()
+	}
+	kept := c.ToHandle(mem, []string{"kept", "file"})
+	gone := c.ToHandle(mem, []string{"gone"})
+	nested := c.ToHandle(mem, []string{"gone", "sub", "b"})
+
+	var passed [][]string
+	stop := c.Watch(func(f billy.Filesystem, path []string) {
+		passed = append(passed, path)
+	})
+	defer stop()
+
+	// the files are changed behind the handler's back.
+	if err := mem.Rename("/gone", "/moved"); err != nil {
+		t.Fatal(err)
+	}
+	watcher.changed(mem, []string{"kept", "file"})
+	watcher.changed(mem, []string{"gone"})
+
+	if _, _, err := c.FromHandle(kept); err != nil {
+		t.Fatalf("expected the handle to a file still there kept, got %v", err)
+	}
+	for _, fh := range [][]byte{gone, nested} {
+		if _, _, err := c.FromHandle(fh); err == nil {
+			t.Fatal("expected the handles to and beneath a path gone invalidated")
+		}
+	}
+	if len(passed) != 2 {
+		t.Fatalf("expected both changes passed on, got %v", passed)
+	}
+}
@@ -0,0 +1,49 @@
+package nfs
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// Listen opens a TCP listener on addr with the server's Backlog and
+// ReuseAddr options applied, for Serve to accept connections from.
+func (s *Server) Listen(addr string) (net.Listener, error) {
+	var lc net.ListenConfig
+	if s.ReuseAddr {
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			if err := c.Control(func(fd uintptr) {
+				sockErr = setReuseAddr(fd)
+			}); err != nil {
+				return err
+			}
+			return sockErr
+		}
+	}
+	baseCtx := context.Background()
+	if s.Context != nil {
+		baseCtx = s.Context
+	}
+	l, err := lc.Listen(baseCtx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.Backlog > 0 {
+		if err := setBacklog(l.(*net.TCPListener), s.Backlog); err != nil {
+			_ = l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// ListenAndServe listens on the TCP address addr, as Listen, and serves the
+// connections made to it, as Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := s.Listen(addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package nfs
+
+import "net"
+
+// The listening sockets of other systems keep their defaults: Windows gives
+// SO_REUSEADDR the meaning of letting another socket take over the port.
+
+func setReuseAddr(fd uintptr) error {
+	return nil
+}
+
+func setBacklog(l *net.TCPListener, n int) error {
+	return nil
+}
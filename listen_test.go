@@ -0,0 +1,68 @@
+package nfs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+)
+
+// TestListenRestart rebinds the port of a server whose connection lingers
+// in TIME_WAIT. It does so without ReuseAddr, which Go's listeners have no
+// need of to rebind.
+func TestListenRestart(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/file": "hello"})
+	newServer := func() *nfs.Server {
+		return &nfs.Server{
+			Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024),
+			ServerOptions: nfs.ServerOptions{Backlog: 64},
+		}
+	}
+
+	first := newServer()
+	listener, err := first.Listen("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr()
+	served := make(chan error, 1)
+	go func() {
+		served <- first.Serve(listener)
+	}()
+	c := dial(t, addr)
+	t.Cleanup(c.Close)
+	if status, _ := mount(t, &nfsc.Target{Client: c}, "/"); status != 0 {
+		t.Fatalf("mount failed with status %d", status)
+	}
+
+	// shutting down closes the connection from the server's end, leaving
+	// the port in TIME_WAIT.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := first.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; !errors.Is(err, nfs.ErrServerClosed) {
+		t.Fatalf("expected Serve to return ErrServerClosed, got %v", err)
+	}
+
+	second := newServer()
+	listener, err = second.Listen(addr.String())
+	if err != nil {
+		t.Fatalf("restarted server failed to bind %v: %v", addr, err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		_ = second.Serve(listener)
+	}()
+	again := dial(t, addr)
+	t.Cleanup(again.Close)
+	if status, _ := mount(t, &nfsc.Target{Client: again}, "/"); status != 0 {
+		t.Fatalf("mount of the restarted server failed with status %d", status)
+	}
+}
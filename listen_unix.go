@@ -0,0 +1,28 @@
+//go:build unix
+
+package nfs
+
+import (
+	"net"
+	"syscall"
+)
+
+func setReuseAddr(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+}
+
+// setBacklog has l queue up to n connections not yet accepted, by listening
+// on its socket again: the backlog of a listening socket can be changed so.
+func setBacklog(l *net.TCPListener, n int) error {
+	raw, err := l.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var listenErr error
+	if err := raw.Control(func(fd uintptr) {
+		listenErr = syscall.Listen(int(fd), n)
+	}); err != nil {
+		return err
+	}
+	return listenErr
+}
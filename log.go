@@ -2,6 +2,7 @@ package nfs
 
 import (
 	"fmt"
+	"hash/fnv"
 	"log"
 	"os"
 )
@@ -54,6 +55,69 @@ type Logger interface {
 	Printf(format string, args ...interface{})
 }
 
+// LeveledLogger is the subset of Logger used to report on request handling.
+// One can be set per server (ServerOptions.Logger) or provided by the
+// handler (see LoggingHandler); otherwise the package-level Log is used.
+type LeveledLogger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// LoggingHandler is implemented by handlers that carry their own logger.
+type LoggingHandler interface {
+	Logger() LeveledLogger
+}
+
+// HandleString renders the file handle fh for logs, as a short fingerprint
+// of its bytes followed by the bytes in hex, such as "5d0a1c3e(01c0ffee...)".
+// The fingerprint of a handle is always the same, so the events concerning
+// one, like its going stale, are easily matched up.
+func HandleString(fh []byte) string {
+	h := fnv.New32a()
+	_, _ = h.Write(fh)
+	return fmt.Sprintf("%08x(%x)", h.Sum32(), fh)
+}
+
+// requestLogger prefixes messages with the request they concern.
+type requestLogger struct {
+	LeveledLogger
+	w *response
+}
+
+func (l *requestLogger) prefix() string {
+	if l.w.handle != nil {
+		return fmt.Sprintf("%v handle=%s: ", l.w.req, HandleString(l.w.handle))
+	}
+	return fmt.Sprintf("%v: ", l.w.req)
+}
+
+func (l *requestLogger) Debugf(format string, args ...interface{}) {
+	l.LeveledLogger.Debugf(l.prefix()+format, args...)
+}
+
+func (l *requestLogger) Infof(format string, args ...interface{}) {
+	l.LeveledLogger.Infof(l.prefix()+format, args...)
+}
+
+func (l *requestLogger) Warnf(format string, args ...interface{}) {
+	l.LeveledLogger.Warnf(l.prefix()+format, args...)
+}
+
+func (l *requestLogger) Errorf(format string, args ...interface{}) {
+	l.LeveledLogger.Errorf(l.prefix()+format, args...)
+}
+
+// Tracef logs at trace level, if the underlying logger has one.
+func (l *requestLogger) Tracef(format string, args ...interface{}) {
+	if tl, ok := l.LeveledLogger.(interface {
+		Tracef(format string, args ...interface{})
+	}); ok {
+		tl.Tracef(l.prefix()+format, args...)
+	}
+}
+
 type DefaultLogger struct {
 	Level LogLevel
 }
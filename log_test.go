@@ -0,0 +1,173 @@
+package nfs_test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+)
+
+// captureLogger records the lines logged through it, tagged by level.
+type captureLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (c *captureLogger) logf(level, format string, args ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lines = append(c.lines, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (c *captureLogger) Debugf(format string, args ...interface{}) { c.logf("DEBUG", format, args...) }
+func (c *captureLogger) Infof(format string, args ...interface{})  { c.logf("INFO", format, args...) }
+func (c *captureLogger) Warnf(format string, args ...interface{})  { c.logf("WARN", format, args...) }
+func (c *captureLogger) Errorf(format string, args ...interface{}) { c.logf("ERROR", format, args...) }
+
+// find returns the first logged line containing substr.
+func (c *captureLogger) find(substr string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, l := range c.lines {
+		if strings.Contains(l, substr) {
+			return l
+		}
+	}
+	return ""
+}
+
+// fullFS fails every write as if the disk were full.
+type fullFS struct {
+	billy.Filesystem
+}
+
+func (f *fullFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	file, err := f.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &fullFile{file}, nil
+}
+
+type fullFile struct {
+	billy.File
+}
+
+func (f *fullFile) Write(p []byte) (int, error) {
+	return 0, syscall.ENOSPC
+}
+
+func TestServerLogger(t *testing.T) {
+	mem := &fullFS{newTestFS(t, map[string]string{"/test": "hello"})}
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	handlerLogs := &captureLogger{}
+	serverLogs := &captureLogger{}
+
+	// Without its own logger, the server uses the handler's.
+	target := serveAndMount(t, &nfs.Server{
+		Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024, helpers.WithLogger(handlerLogs)),
+	}, rpc.AuthNull)
+	f, err := target.OpenFile("/test", 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("HELLO")); nfsStatus(err) != nfsc.NFS3ErrNoSpc {
+		t.Fatalf("expected NOSPC, got %v", err)
+	}
+	if handlerLogs.find("Error writing") == "" {
+		t.Fatalf("expected the write failure in the handler's log, got %v", handlerLogs.lines)
+	}
+
+	target = serveAndMount(t, &nfs.Server{
+		Handler:       handler,
+		ServerOptions: nfs.ServerOptions{Logger: serverLogs},
+	}, rpc.AuthNull)
+	_, fh, err := target.Lookup("/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err = target.OpenFile("/test", 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("HELLO")); nfsStatus(err) != nfsc.NFS3ErrNoSpc {
+		t.Fatalf("expected NOSPC, got %v", err)
+	}
+
+	line := serverLogs.find("Error writing")
+	if line == "" {
+		t.Fatalf("expected the write failure to be logged, got %v", serverLogs.lines)
+	}
+	for _, want := range []string{"ERROR", "nfs.Write", "handle=" + nfs.HandleString(fh), "no space left"} {
+		if !strings.Contains(line, want) {
+			t.Fatalf("log line is missing %q: %s", want, line)
+		}
+	}
+}
+
+// lstatFailFS fails to stat every file once failing is set.
+type lstatFailFS struct {
+	billy.Filesystem
+	failing atomic.Bool
+}
+
+func (l *lstatFailFS) Lstat(filename string) (os.FileInfo, error) {
+	if l.failing.Load() {
+		return nil, syscall.EIO
+	}
+	return l.Filesystem.Lstat(filename)
+}
+
+func TestRequestLogsHandle(t *testing.T) {
+	logs := &captureLogger{}
+	mem := &lstatFailFS{Filesystem: newTestFS(t, map[string]string{"/test": "hello"})}
+	srv := &nfs.Server{
+		Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024),
+		ServerOptions: nfs.ServerOptions{Logger: logs},
+	}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+	_, fh, err := target.Lookup("/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mem.failing.Store(true)
+	if _, err := target.Call(&struct {
+		rpc.Header
+		FH   []byte
+		Mask uint32
+	}{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfsc.Nfs3Prog,
+			Vers:    nfsc.Nfs3Vers,
+			Proc:    nfsc.NFSProc3Access,
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		FH:   fh,
+		Mask: 1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	line := logs.find("err loading attrs")
+	if line == "" {
+		t.Fatalf("expected the failed stat to be logged, got %v", logs.lines)
+	}
+	for _, want := range []string{"nfs.Access", "handle=" + nfs.HandleString(fh)} {
+		if !strings.Contains(line, want) {
+			t.Fatalf("log line is missing %q: %s", want, line)
+		}
+	}
+}
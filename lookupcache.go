@@ -0,0 +1,125 @@
+package nfs
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+// maxNegativeLookups bounds the names a server remembers as missing.
+const maxNegativeLookups = 4096
+
+// negativeLookups remembers the names LOOKUP recently found missing, keyed
+// by the file system and path of the directory they were looked up in, so
+// that clients probing for them repeatedly do not each reach the backend.
+type negativeLookups struct {
+	mu      sync.Mutex
+	expires map[negativeLookup][]negativeEntry
+}
+
+type negativeLookup struct {
+	dir  string
+	name string
+}
+
+type negativeEntry struct {
+	fs     billy.Filesystem
+	expiry time.Time
+}
+
+// findNegative returns the index of the entry for fs among entries, or -1.
+func findNegative(entries []negativeEntry, fs billy.Filesystem) int {
+	for i, e := range entries {
+		if reflect.DeepEqual(e.fs, fs) {
+			return i
+		}
+	}
+	return -1
+}
+
+// missing reports whether name was found missing from the directory at dir
+// of fs in the ttl before now.
+func (n *negativeLookups) missing(now time.Time, ttl time.Duration, fs billy.Filesystem, dir []string, name string) bool {
+	if ttl <= 0 {
+		return false
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	key := negativeLookup{fs.Join(dir...), name}
+	entries := n.expires[key]
+	i := findNegative(entries, fs)
+	if i < 0 {
+		return false
+	}
+	if now.After(entries[i].expiry) {
+		n.remove(key, i)
+		return false
+	}
+	return true
+}
+
+// add remembers for ttl from now that name is missing from the directory at
+// dir of fs. Expired entries are swept once the table fills; while it stays full,
+// nothing more is added.
+func (n *negativeLookups) add(now time.Time, ttl time.Duration, fs billy.Filesystem, dir []string, name string) {
+	if ttl <= 0 {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.expires == nil {
+		n.expires = make(map[negativeLookup][]negativeEntry)
+	}
+	key := negativeLookup{fs.Join(dir...), name}
+	if i := findNegative(n.expires[key], fs); i >= 0 {
+		n.expires[key][i].expiry = now.Add(ttl)
+		return
+	}
+	if len(n.expires) >= maxNegativeLookups {
+		for k, entries := range n.expires {
+			for i := len(entries) - 1; i >= 0; i-- {
+				if now.After(entries[i].expiry) {
+					n.remove(k, i)
+				}
+			}
+		}
+		if len(n.expires) >= maxNegativeLookups {
+			return
+		}
+	}
+	n.expires[key] = append(n.expires[key], negativeEntry{fs, now.Add(ttl)})
+}
+
+// remove drops the i'th entry under key. It expects n.mu to be held.
+func (n *negativeLookups) remove(key negativeLookup, i int) {
+	entries := append(n.expires[key][:i:i], n.expires[key][i+1:]...)
+	if len(entries) == 0 {
+		delete(n.expires, key)
+		return
+	}
+	n.expires[key] = entries
+}
+
+// forget drops what is remembered of name in the directory at dir of fs,
+// once a client creates it.
+func (n *negativeLookups) forget(fs billy.Filesystem, dir []string, name string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	key := negativeLookup{fs.Join(dir...), name}
+	if i := findNegative(n.expires[key], fs); i >= 0 {
+		n.remove(key, i)
+	}
+}
+
+// ForgetMissing drops every name remembered as missing under
+// NegativeLookupTTL, so that files added to the file system other than
+// through the server are found by the next LOOKUP. Embedders learning of
+// such files other than through a Watcher can call it rather than wait out
+// the TTL.
+func (s *Server) ForgetMissing() {
+	s.negativeLookups.mu.Lock()
+	defer s.negativeLookups.mu.Unlock()
+	s.negativeLookups.expires = nil
+}
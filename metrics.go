@@ -0,0 +1,34 @@
+package nfs
+
+import "time"
+
+// MetricsSink receives a measure of each call the server answers, for
+// embedders to export through OpenTelemetry, StatsD, Prometheus or
+// whatever else they use. Calls are named by program and procedure, as in
+// "nfs.Read" or "mount.Mount". Its methods are called concurrently, before
+// the reply is sent, so they should be quick.
+type MetricsSink interface {
+	// CountOp counts a call to op.
+	CountOp(op string)
+	// ObserveLatency records how long a call to op took to handle.
+	ObserveLatency(op string, d time.Duration)
+	// CountError counts a call to op that failed with err: an
+	// *NFSStatusError for calls failed with an NFS status, or another
+	// RPCError for those refused by the RPC layer.
+	CountError(op string, err error)
+}
+
+// recordMetrics reports the call w answered, which started at start, to the
+// server's MetricsSink.
+func (w *response) recordMetrics(start time.Time) {
+	sink := w.Server.Metrics
+	if sink == nil {
+		return
+	}
+	op := w.req.op()
+	sink.CountOp(op)
+	sink.ObserveLatency(op, time.Since(start))
+	if w.err != nil {
+		sink.CountError(op, w.err)
+	}
+}
@@ -0,0 +1,99 @@
+package nfs_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+)
+
+// memorySink records the metrics it is given.
+type memorySink struct {
+	mu        sync.Mutex
+	ops       map[string]int
+	latencies map[string]int
+	errors    map[string][]nfs.NFSStatus
+}
+
+func newMemorySink() *memorySink {
+	return &memorySink{
+		ops:       make(map[string]int),
+		latencies: make(map[string]int),
+		errors:    make(map[string][]nfs.NFSStatus),
+	}
+}
+
+func (s *memorySink) CountOp(op string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ops[op]++
+}
+
+func (s *memorySink) ObserveLatency(op string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d > 0 {
+		s.latencies[op]++
+	}
+}
+
+func (s *memorySink) CountError(op string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var status *nfs.NFSStatusError
+	if errors.As(err, &status) {
+		s.errors[op] = append(s.errors[op], status.NFSStatus)
+	} else {
+		s.errors[op] = append(s.errors[op], 0)
+	}
+}
+
+func TestMetricsSink(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/file": "hello"})
+	sink := newMemorySink()
+	srv := &nfs.Server{
+		Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024),
+		ServerOptions: nfs.ServerOptions{Metrics: sink},
+	}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+	_, root := mount(t, target, "/")
+
+	sink.mu.Lock()
+	if sink.ops["mount.Mount"] != 2 {
+		t.Fatalf("expected two mounts, counted %d", sink.ops["mount.Mount"])
+	}
+	sink.ops, sink.latencies = make(map[string]int), make(map[string]int)
+	sink.mu.Unlock()
+
+	if status := lookupIn(t, target, root, "file"); status != 0 {
+		t.Fatalf("lookup failed with status %d", status)
+	}
+	if status := lookupIn(t, target, root, "missing"); status != uint32(nfs.NFSStatusNoEnt) {
+		t.Fatalf("expected NOENT looking up a missing file, got %d", status)
+	}
+	if _, err := target.FSInfo(); err != nil {
+		t.Fatal(err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.ops) != 2 || sink.ops["nfs.Lookup"] != 2 || sink.ops["nfs.FSInfo"] != 1 {
+		t.Fatalf("expected 2 lookups and an fsinfo, counted %v", sink.ops)
+	}
+	for op, n := range sink.ops {
+		if sink.latencies[op] != n {
+			t.Fatalf("%s: counted %d calls but observed %d latencies", op, n, sink.latencies[op])
+		}
+	}
+	if got := sink.errors["nfs.Lookup"]; len(got) != 1 || got[0] != nfs.NFSStatusNoEnt {
+		t.Fatalf("expected one NOENT lookup, counted %v", got)
+	}
+	if len(sink.errors) != 1 {
+		t.Fatalf("expected only the lookup to fail, counted %v", sink.errors)
+	}
+}
@@ -3,7 +3,12 @@ package nfs
 import (
 	"bytes"
 	"context"
+	"errors"
+	"path"
+	"sort"
+	"strings"
 
+	"github.com/go-git/go-billy/v5"
 	"github.com/willscott/go-nfs-client/nfs/xdr"
 )
 
@@ -14,7 +19,10 @@ const (
 func init() {
 	_ = RegisterMessageHandler(mountServiceID, uint32(MountProcNull), onMountNull)
 	_ = RegisterMessageHandler(mountServiceID, uint32(MountProcMount), onMount)
+	_ = RegisterMessageHandler(mountServiceID, uint32(MountProcDump), onDump)
 	_ = RegisterMessageHandler(mountServiceID, uint32(MountProcUmnt), onUMount)
+	_ = RegisterMessageHandler(mountServiceID, uint32(MountProcUmntAll), onUMountAll)
+	_ = RegisterMessageHandler(mountServiceID, uint32(MountProcExport), onExport)
 }
 
 func onMountNull(ctx context.Context, w *response, userHandle Handler) error {
@@ -29,6 +37,14 @@ func onMount(ctx context.Context, w *response, userHandle Handler) error {
 	}
 	mountReq := MountRequest{Header: w.req.Header, Dirpath: dirpath}
 	status, handle, flavors := userHandle.Mount(ctx, w.conn, mountReq)
+	rootPath := []string{}
+	if status == MountStatusOk && w.Server.ExportSubdirectories {
+		rootPath, status = w.Server.mountPath(handle, string(dirpath))
+	}
+	if status == MountStatusOk && !w.Server.admitMount(w.mountClient(), string(dirpath)) {
+		w.logger().Debugf("refusing mount of %s: too many mounts", dirpath)
+		status = MountStatusErrAcces
+	}
 
 	if err := w.writeHeader(ResponseCodeSuccess); err != nil {
 		return err
@@ -39,7 +55,7 @@ func onMount(ctx context.Context, w *response, userHandle Handler) error {
 		return err
 	}
 
-	rootHndl := userHandle.ToHandle(handle, []string{})
+	rootHndl := userHandle.ToHandle(handle, rootPath)
 
 	if status == MountStatusOk {
 		_ = xdr.Write(writer, rootHndl)
@@ -48,11 +64,172 @@ func onMount(ctx context.Context, w *response, userHandle Handler) error {
 	return w.Write(writer.Bytes())
 }
 
+// mountPath resolves the directory that a MNT request for dirpath exports
+// from fs.
+func (s *Server) mountPath(fs billy.Filesystem, dirpath string) ([]string, MountStatus) {
+	p, err := resolvePath(fs, strings.Split(dirpath, "/"), s.maxSymlinkHops())
+	if err != nil {
+		var nerr *NFSStatusError
+		if errors.As(err, &nerr) {
+			// the mountstat3 codes are errno values, as are these nfsstat3 ones.
+			return nil, MountStatus(nerr.NFSStatus)
+		}
+		return nil, MountStatusErrServerFault
+	}
+	info, err := fs.Stat(fs.Join(p...))
+	if err != nil {
+		return nil, MountStatusErrIO
+	}
+	if len(p) == 0 {
+		info = exportRoot(info)
+	}
+	if !info.IsDir() {
+		return nil, MountStatusErrNotDir
+	}
+	return p, MountStatusOk
+}
+
+// admitMount records a mount of dirpath by client, unless the export
+// already has MaxMountsPerExport mounts.
+func (s *Server) admitMount(client, dirpath string) bool {
+	entry := MountEntry{Client: client, Dir: path.Clean("/" + dirpath)}
+	s.mountsMu.Lock()
+	defer s.mountsMu.Unlock()
+	if s.MaxMountsPerExport > 0 {
+		held := 0
+		for e, n := range s.mounts {
+			if e.Dir == entry.Dir {
+				held += n
+			}
+		}
+		if held >= s.MaxMountsPerExport {
+			return false
+		}
+	}
+	if s.mounts == nil {
+		s.mounts = make(map[MountEntry]int)
+	}
+	s.mounts[entry]++
+	return true
+}
+
+// releaseMount forgets a mount of dirpath by client.
+func (s *Server) releaseMount(client, dirpath string) {
+	entry := MountEntry{Client: client, Dir: path.Clean("/" + dirpath)}
+	s.mountsMu.Lock()
+	defer s.mountsMu.Unlock()
+	if s.mounts[entry] > 1 {
+		s.mounts[entry]--
+	} else {
+		delete(s.mounts, entry)
+	}
+}
+
+// releaseMounts forgets every mount by client.
+func (s *Server) releaseMounts(client string) {
+	s.mountsMu.Lock()
+	defer s.mountsMu.Unlock()
+	for e := range s.mounts {
+		if e.Client == client {
+			delete(s.mounts, e)
+		}
+	}
+}
+
+// ActiveMounts lists the exports each client has mounted and not since
+// unmounted, ordered by client and then export. A client mounting an
+// export more than once is listed once.
+func (s *Server) ActiveMounts() []MountEntry {
+	s.mountsMu.Lock()
+	entries := make([]MountEntry, 0, len(s.mounts))
+	for e := range s.mounts {
+		entries = append(entries, e)
+	}
+	s.mountsMu.Unlock()
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Client != entries[j].Client {
+			return entries[i].Client < entries[j].Client
+		}
+		return entries[i].Dir < entries[j].Dir
+	})
+	return entries
+}
+
+// mountClient identifies the client making a mount request.
+func (w *response) mountClient() string {
+	if w.conn == nil || w.conn.RemoteAddr() == nil {
+		return ""
+	}
+	return peerOf(w.conn.RemoteAddr())
+}
+
 func onUMount(ctx context.Context, w *response, userHandle Handler) error {
-	_, err := xdr.ReadOpaque(w.req.Body)
+	dirpath, err := xdr.ReadOpaque(w.req.Body)
 	if err != nil {
 		return err
 	}
+	w.Server.releaseMount(w.mountClient(), string(dirpath))
+
+	return w.writeHeader(ResponseCodeSuccess)
+}
+
+func onUMountAll(ctx context.Context, w *response, userHandle Handler) error {
+	w.Server.releaseMounts(w.mountClient())
 
 	return w.writeHeader(ResponseCodeSuccess)
 }
+
+func onDump(ctx context.Context, w *response, userHandle Handler) error {
+	// the mount list is a list of entries led by a bool telling whether
+	// another follows.
+	writer := bytes.NewBuffer([]byte{})
+	for _, e := range w.Server.ActiveMounts() {
+		if err := xdr.Write(writer, true); err != nil {
+			return err
+		}
+		if err := xdr.Write(writer, e.Client); err != nil {
+			return err
+		}
+		if err := xdr.Write(writer, e.Dir); err != nil {
+			return err
+		}
+	}
+	if err := xdr.Write(writer, false); err != nil {
+		return err
+	}
+	return w.Write(writer.Bytes())
+}
+
+func onExport(ctx context.Context, w *response, userHandle Handler) error {
+	exports := []Export{{Dir: "/"}}
+	if eh, ok := userHandle.(ExportingHandler); ok {
+		exports = eh.Exports()
+	}
+
+	// exports and groups are each a list of entries led by a bool telling
+	// whether another follows.
+	writer := bytes.NewBuffer([]byte{})
+	for _, e := range exports {
+		if err := xdr.Write(writer, true); err != nil {
+			return err
+		}
+		if err := xdr.Write(writer, e.Dir); err != nil {
+			return err
+		}
+		for _, g := range e.Groups {
+			if err := xdr.Write(writer, true); err != nil {
+				return err
+			}
+			if err := xdr.Write(writer, g); err != nil {
+				return err
+			}
+		}
+		if err := xdr.Write(writer, false); err != nil {
+			return err
+		}
+	}
+	if err := xdr.Write(writer, false); err != nil {
+		return err
+	}
+	return w.Write(writer.Bytes())
+}
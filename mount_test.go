@@ -0,0 +1,282 @@
+package nfs_test
+
+import (
+	"reflect"
+	"testing"
+
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// mount issues a MNT of dirpath, returning its status and root handle.
+func mount(t *testing.T, target *nfsc.Target, dirpath string) (uint32, []byte) {
+	t.Helper()
+	res, err := target.Call(&struct {
+		rpc.Header
+		Dirpath string
+	}{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfsc.MountProg,
+			Vers:    nfsc.MountVers,
+			Proc:    nfsc.MountProc3MNT,
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		Dirpath: dirpath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := xdr.ReadUint32(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != nfsc.MNT3Ok {
+		return status, nil
+	}
+	fh, err := xdr.ReadOpaque(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status, fh
+}
+
+func TestMountSubdirectory(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/sub/file": "hello"})
+	for link, target := range map[string]string{
+		"/link":  "dir",
+		"/loop1": "/loop2",
+		"/loop2": "loop1",
+	} {
+		if err := mem.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	srv := &nfs.Server{
+		Handler:       handler,
+		ServerOptions: nfs.ServerOptions{ExportSubdirectories: true},
+	}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+
+	status, fh := mount(t, target, "/link/sub")
+	if status != nfsc.MNT3Ok {
+		t.Fatalf("mounting through a symlink: status %d", status)
+	}
+	sub, err := nfsc.NewTargetWithClient(target.Client, rpc.AuthNull, fh, "/link/sub", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := sub.Lookup("/file"); err != nil {
+		t.Fatalf("expected the mount to export /dir/sub: %v", err)
+	}
+
+	if status, _ := mount(t, target, "/loop1/x"); status != uint32(nfs.MountStatusErrLoop) {
+		t.Fatalf("expected LOOP mounting through a symlink cycle, got %d", status)
+	}
+	if status, _ := mount(t, target, "/dir/sub/file"); status != uint32(nfs.MountStatusErrNotDir) {
+		t.Fatalf("expected NOTDIR mounting a file, got %d", status)
+	}
+	if status, _ := mount(t, target, "/missing"); status != uint32(nfs.MountStatusErrNoEnt) {
+		t.Fatalf("expected NOENT mounting a missing path, got %d", status)
+	}
+}
+
+// umount issues a UMNT of dirpath.
+func umount(t *testing.T, target *nfsc.Target, dirpath string) {
+	t.Helper()
+	_, err := target.Call(&struct {
+		rpc.Header
+		Dirpath string
+	}{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfsc.MountProg,
+			Vers:    nfsc.MountVers,
+			Proc:    nfsc.MountProc3UMNT,
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		Dirpath: dirpath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxMountsPerExport(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/file": "hello"})
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	srv := &nfs.Server{
+		Handler:       handler,
+		ServerOptions: nfs.ServerOptions{MaxMountsPerExport: 3},
+	}
+	// the first mount of "/" is made connecting.
+	target := serveAndMount(t, srv, rpc.AuthNull)
+	for i := 1; i < 3; i++ {
+		if status, _ := mount(t, target, "/"); status != nfsc.MNT3Ok {
+			t.Fatalf("mount %d of 3: status %d", i+1, status)
+		}
+	}
+	if status, _ := mount(t, target, "/"); status != uint32(nfs.MountStatusErrAcces) {
+		t.Fatalf("expected ACCES beyond the cap, got %d", status)
+	}
+
+	umount(t, target, "/")
+	if status, _ := mount(t, target, "/"); status != nfsc.MNT3Ok {
+		t.Fatalf("expected a mount once another was released, got %d", status)
+	}
+	if status, _ := mount(t, target, "/"); status != uint32(nfs.MountStatusErrAcces) {
+		t.Fatalf("expected ACCES at the cap again, got %d", status)
+	}
+}
+
+// exports issues an EXPORT, returning the listed exports.
+func exports(t *testing.T, target *nfsc.Target) []nfs.Export {
+	t.Helper()
+	res, err := target.Call(&rpc.Header{
+		Rpcvers: 2,
+		Prog:    nfsc.MountProg,
+		Vers:    nfsc.MountVers,
+		Proc:    nfsc.MountProc3Export,
+		Cred:    rpc.AuthNull,
+		Verf:    rpc.AuthNull,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []nfs.Export
+	for {
+		more, err := xdr.ReadUint32(res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if more == 0 {
+			return list
+		}
+		var e nfs.Export
+		if err := xdr.Read(res, &e.Dir); err != nil {
+			t.Fatal(err)
+		}
+		for {
+			more, err := xdr.ReadUint32(res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if more == 0 {
+				break
+			}
+			var group string
+			if err := xdr.Read(res, &group); err != nil {
+				t.Fatal(err)
+			}
+			e.Groups = append(e.Groups, group)
+		}
+		list = append(list, e)
+	}
+}
+
+func TestMountExport(t *testing.T) {
+	single := helpers.NewCachingHandler(helpers.NewNullAuthHandler(newTestFS(t, nil)), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: single}, rpc.AuthNull)
+	if got := exports(t, target); !reflect.DeepEqual(got, []nfs.Export{{Dir: "/"}}) {
+		t.Fatalf("expected only the root exported, got %+v", got)
+	}
+
+	multi := helpers.NewMultiExportHandler(map[string]nfs.Handler{
+		"/":     helpers.NewCachingHandler(helpers.NewNullAuthHandler(newTestFS(t, nil)), 1024),
+		"/data": helpers.NewCachingHandler(helpers.NewNullAuthHandler(newTestFS(t, nil)), 1024),
+		"/logs": helpers.NewCachingHandler(helpers.NewNullAuthHandler(newTestFS(t, nil)), 1024),
+	})
+	target = serveAndMount(t, &nfs.Server{Handler: multi}, rpc.AuthNull)
+	want := []nfs.Export{{Dir: "/"}, {Dir: "/data"}, {Dir: "/logs"}}
+	if got := exports(t, target); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected exports %+v, got %+v", want, got)
+	}
+}
+
+// dump issues a DUMP, returning the listed mounts.
+func dump(t *testing.T, target *nfsc.Target) []nfs.MountEntry {
+	t.Helper()
+	res, err := target.Call(&rpc.Header{
+		Rpcvers: 2,
+		Prog:    nfsc.MountProg,
+		Vers:    nfsc.MountVers,
+		Proc:    uint32(nfs.MountProcDump),
+		Cred:    rpc.AuthNull,
+		Verf:    rpc.AuthNull,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []nfs.MountEntry
+	for {
+		more, err := xdr.ReadUint32(res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if more == 0 {
+			return list
+		}
+		var e nfs.MountEntry
+		if err := xdr.Read(res, &e); err != nil {
+			t.Fatal(err)
+		}
+		list = append(list, e)
+	}
+}
+
+func TestActiveMounts(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/file": "hello"})
+	srv := &nfs.Server{Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)}
+	// the first mount of "/" is made connecting.
+	target := serveAndMount(t, srv, rpc.AuthNull)
+	mounted := []nfs.MountEntry{{Client: "127.0.0.1", Dir: "/"}}
+	if got := srv.ActiveMounts(); !reflect.DeepEqual(got, mounted) {
+		t.Fatalf("expected mounts %+v, got %+v", mounted, got)
+	}
+	if got := dump(t, target); !reflect.DeepEqual(got, mounted) {
+		t.Fatalf("expected DUMP to list %+v, got %+v", mounted, got)
+	}
+
+	// a second mount is listed once, and held until both are unmounted.
+	if status, _ := mount(t, target, "/"); status != nfsc.MNT3Ok {
+		t.Fatalf("mount failed with status %d", status)
+	}
+	if got := srv.ActiveMounts(); !reflect.DeepEqual(got, mounted) {
+		t.Fatalf("expected mounts %+v, got %+v", mounted, got)
+	}
+	umount(t, target, "/")
+	if got := srv.ActiveMounts(); !reflect.DeepEqual(got, mounted) {
+		t.Fatalf("expected mounts %+v after one unmount, got %+v", mounted, got)
+	}
+	umount(t, target, "/")
+	if got := srv.ActiveMounts(); len(got) != 0 {
+		t.Fatalf("expected no mounts, got %+v", got)
+	}
+
+	// UMNTALL drops all of the client's mounts at once.
+	for i := 0; i < 2; i++ {
+		if status, _ := mount(t, target, "/"); status != nfsc.MNT3Ok {
+			t.Fatalf("mount failed with status %d", status)
+		}
+	}
+	if _, err := target.Call(&rpc.Header{
+		Rpcvers: 2,
+		Prog:    nfsc.MountProg,
+		Vers:    nfsc.MountVers,
+		Proc:    uint32(nfs.MountProcUmntAll),
+		Cred:    rpc.AuthNull,
+		Verf:    rpc.AuthNull,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.ActiveMounts(); len(got) != 0 {
+		t.Fatalf("expected no mounts after UMNTALL, got %+v", got)
+	}
+}
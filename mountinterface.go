@@ -28,6 +28,7 @@ const (
 	MountStatusErrAcces       MountStatus = 13
 	MountStatusErrNotDir      MountStatus = 20
 	MountStatusErrInval       MountStatus = 22
+	MountStatusErrLoop        MountStatus = 40 // not part of RFC 1813
 	MountStatusErrNameTooLong MountStatus = 63
 	MountStatusErrNotSupp     MountStatus = 10004
 	MountStatusErrServerFault MountStatus = 10006
@@ -74,6 +75,16 @@ const (
 	AuthFlavorUnix  AuthFlavor = 1
 	AuthFlavorShort AuthFlavor = 2
 	AuthFlavorDES   AuthFlavor = 3
+	// AuthFlavorRPCSECGSS is the flavor of rfc2203, served when
+	// ServerOptions.GSS is set.
+	AuthFlavorRPCSECGSS AuthFlavor = 6
+
+	// The pseudo-flavors a MOUNT reply lists for RPCSEC_GSS with Kerberos
+	// V5, per rfc2623 section 2.3: krb5 authenticates calls, krb5i also
+	// protects their integrity, and krb5p their privacy.
+	AuthFlavorKrb5  AuthFlavor = 390003
+	AuthFlavorKrb5i AuthFlavor = 390004
+	AuthFlavorKrb5p AuthFlavor = 390005
 )
 
 // MountRequest contains the format of a client request to open a mount.
@@ -88,3 +99,22 @@ type MountResponse struct {
 	FileHandle
 	AuthFlavors []int
 }
+
+// Export describes a directory clients may mount, as listed by the mount
+// protocol's EXPORT procedure.
+type Export struct {
+	// Dir is the path clients mount.
+	Dir string
+	// Groups names the clients allowed to mount Dir. An empty list allows
+	// every client.
+	Groups []string
+}
+
+// MountEntry records a mount a client holds, as listed by the mount
+// protocol's DUMP procedure.
+type MountEntry struct {
+	// Client is the address of the host holding the mount.
+	Client string
+	// Dir is the export path mounted.
+	Dir string
+}
@@ -0,0 +1,97 @@
+package nfs
+
+import (
+	"os"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+// equivalentName looks in the directory dir for an entry whose name is not
+// name but which NormalizeName maps to the same form, and returns it. It
+// finds nothing when the policy is off or name itself exists, since an
+// exact match takes the usual course.
+func (o *ServerOptions) equivalentName(fs billy.Filesystem, dir []string, name string) (string, bool) {
+	if o.NormalizeName == nil {
+		return "", false
+	}
+	normal := o.NormalizeName(name)
+	return findName(fs, dir, name, func(entry string) bool {
+		return o.NormalizeName(entry) == normal
+	})
+}
+
+// foldedName looks in the directory dir for an entry whose name differs
+// from name only in case, and returns it, when userHandle matches names in
+// fs without regard to case. Like equivalentName, it finds nothing when
+// name itself exists.
+func foldedName(userHandle Handler, fs billy.Filesystem, dir []string, name string) (string, bool) {
+	if !caseInsensitive(userHandle, fs) {
+		return "", false
+	}
+	return findName(fs, dir, name, func(entry string) bool {
+		return strings.EqualFold(entry, name)
+	})
+}
+
+// resolveName returns the name of the entry in dir that name refers to
+// when userHandle matches names without regard to case, and otherwise name.
+func resolveName(userHandle Handler, fs billy.Filesystem, dir []string, name string) string {
+	if existing, ok := foldedName(userHandle, fs, dir, name); ok {
+		return existing
+	}
+	return name
+}
+
+// findName returns the first entry of dir other than name that same
+// accepts, unless name itself is there.
+func findName(fs billy.Filesystem, dir []string, name string, same func(entry string) bool) (string, bool) {
+	contents, err := fs.ReadDir(fs.Join(dir...))
+	if err != nil {
+		return "", false
+	}
+	match, found := "", false
+	for _, entry := range contents {
+		if entry.Name() == name {
+			return "", false
+		}
+		if !found && same(entry.Name()) {
+			match, found = entry.Name(), true
+		}
+	}
+	return match, found
+}
+
+// entryName returns the name of the entry in dir that name refers to: one
+// equivalent to it under NormalizeName, or differing from it only in case
+// when userHandle matches names without regard to case, if name itself is
+// not there, and otherwise name.
+func (o *ServerOptions) entryName(userHandle Handler, fs billy.Filesystem, dir []string, name string) string {
+	if existing, ok := o.equivalentName(fs, dir, name); ok {
+		return existing
+	}
+	return resolveName(userHandle, fs, dir, name)
+}
+
+// listedName returns the form READDIR and READDIRPLUS report the entry
+// name in, which is the one NormalizeName maps it to, when set.
+func (o *ServerOptions) listedName(name string) string {
+	if o.NormalizeName == nil {
+		return name
+	}
+	return o.NormalizeName(name)
+}
+
+// checkNameCollision refuses to create name in dir when an entry equivalent
+// to it under NormalizeName, or differing only in case when userHandle
+// matches names without regard to case, is already there.
+func (o *ServerOptions) checkNameCollision(userHandle Handler, fs billy.Filesystem, dir []string, name string) error {
+	existing, ok := o.equivalentName(fs, dir, name)
+	if !ok {
+		existing, ok = foldedName(userHandle, fs, dir, name)
+	}
+	if ok {
+		return &NFSStatusError{NFSStatusExist, &os.PathError{Op: "create", Path: fs.Join(append(dir, existing)...), Err: os.ErrExist}}
+	}
+	return nil
+}
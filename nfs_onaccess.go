@@ -10,11 +10,13 @@ import (
 
 func onAccess(ctx context.Context, w *response, userHandle Handler) error {
 	w.errorFmt = opAttrErrorFormatter
-	roothandle, err := xdr.ReadOpaque(w.req.Body)
-	if err != nil {
+	// the mask that follows starts after the handle's padding, which
+	// xdr.ReadOpaque would leave unread.
+	var handle []byte
+	if err := xdr.Read(w.req.Body, &handle); err != nil {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
-	fs, path, err := userHandle.FromHandle(roothandle)
+	fs, path, err := w.fromHandle(ctx, userHandle, handle)
 	if err != nil {
 		return &NFSStatusError{NFSStatusStale, err}
 	}
@@ -27,7 +29,7 @@ func onAccess(ctx context.Context, w *response, userHandle Handler) error {
 	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
-	if err := WritePostOpAttrs(writer, tryStat(fs, path)); err != nil {
+	if err := WritePostOpAttrs(writer, w.tryStat(userHandle, fs, path)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
@@ -0,0 +1,60 @@
+package nfs_test
+
+import (
+	"testing"
+
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+func TestAccessVersionedHandle(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/file": "hello"})
+	srv := &nfs.Server{Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+	_, fh, err := target.Lookup("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fh)%4 == 0 {
+		t.Fatalf("expected a handle needing XDR padding, got %d bytes", len(fh))
+	}
+
+	const mask = 0x3f
+	res, err := target.Call(&struct {
+		rpc.Header
+		FH   []byte
+		Mask uint32
+	}{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfsc.Nfs3Prog,
+			Vers:    nfsc.Nfs3Vers,
+			Proc:    nfsc.NFSProc3Access,
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		FH:   fh,
+		Mask: mask,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply struct {
+		Status uint32
+		Attrs  nfsc.PostOpAttr
+		Access uint32
+	}
+	if err := xdr.Read(res, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Status != nfsc.NFS3Ok {
+		t.Fatalf("access failed with status %d", reply.Status)
+	}
+	if reply.Access != mask {
+		t.Fatalf("access granted %#x, want %#x", reply.Access, mask)
+	}
+}
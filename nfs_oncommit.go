@@ -9,16 +9,28 @@ import (
 	"github.com/willscott/go-nfs-client/nfs/xdr"
 )
 
-// onCommit - note this is a no-op, as we always push writes to the backing store.
+type commitArgs struct {
+	Handle []byte
+	Offset uint64
+	Count  uint32
+}
+
+// syncer is implemented by billy files that can flush their data to stable storage.
+type syncer interface {
+	Sync() error
+}
+
+// onCommit flushes a file's unstable writes. Writes are pushed to the backing
+// store as they arrive, so this only needs to ask files that buffer on their
+// own to sync. The whole file is flushed, which covers any offset/count range.
 func onCommit(ctx context.Context, w *response, userHandle Handler) error {
 	w.errorFmt = wccDataErrorFormatter
-	handle, err := xdr.ReadOpaque(w.req.Body)
-	if err != nil {
+	var req commitArgs
+	if err := xdr.Read(w.req.Body, &req); err != nil {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
-	// The conn will drain the unread offset and count arguments.
 
-	fs, path, err := userHandle.FromHandle(handle)
+	fs, path, err := w.fromHandle(ctx, userHandle, req.Handle)
 	if err != nil {
 		return &NFSStatusError{NFSStatusStale, err}
 	}
@@ -26,20 +38,46 @@ func onCommit(ctx context.Context, w *response, userHandle Handler) error {
 		return &NFSStatusError{NFSStatusServerFault, os.ErrPermission}
 	}
 
+	fullPath := fs.Join(path...)
+	info, err := fs.Stat(fullPath)
+	if err != nil {
+		return &NFSStatusError{mapError(err), err}
+	}
+	if info.IsDir() {
+		return &NFSStatusError{NFSStatusIsDir, os.ErrInvalid}
+	}
+	preOpCache := w.fileAttribute(userHandle, fs, info, path).AsCache()
+
+	if info.Mode().IsRegular() {
+		// syncing writes nothing, so a file the caller may not open for
+		// writing is synced all the same.
+		file, err := fs.Open(fullPath)
+		if err != nil {
+			return &NFSStatusError{mapError(err), err}
+		}
+		if s, ok := file.(syncer); ok {
+			if err := s.Sync(); err != nil {
+				_ = file.Close()
+				w.logger().Errorf("error syncing: %v", err)
+				return &NFSStatusError{mapError(err), err}
+			}
+		}
+		if err := file.Close(); err != nil {
+			return &NFSStatusError{mapError(err), err}
+		}
+	}
+
 	writer := bytes.NewBuffer([]byte{})
 	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
 		return err
 	}
 
-	// no pre-op cache data.
-	if err := xdr.Write(writer, uint32(0)); err != nil {
-		return &NFSStatusError{NFSStatusServerFault, err}
-	}
-	if err := WritePostOpAttrs(writer, tryStat(fs, path)); err != nil {
+	if err := WriteWcc(writer, preOpCache, w.tryStat(userHandle, fs, path)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
-	// write the 8 bytes of write verification.
-	if err := xdr.Write(writer, w.Server.ID); err != nil {
+	// write the 8 bytes of write verification, which change when the
+	// server restarts, telling clients to resend writes never committed.
+	if err := xdr.Write(writer, w.Server.WriteVerifier()); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
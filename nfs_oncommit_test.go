@@ -0,0 +1,137 @@
+package nfs_test
+
+import (
+	"encoding/binary"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// syncCountingFS counts Sync calls on the files it opens.
+type syncCountingFS struct {
+	billy.Filesystem
+	syncs int32
+}
+
+func (s *syncCountingFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	file, err := s.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &syncCountingFile{file, &s.syncs}, nil
+}
+
+func (s *syncCountingFS) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+type syncCountingFile struct {
+	billy.File
+	syncs *int32
+}
+
+func (f *syncCountingFile) Sync() error {
+	atomic.AddInt32(f.syncs, 1)
+	return nil
+}
+
+// commit issues a COMMIT of the whole file and returns the write verifier.
+func commit(t *testing.T, target *nfsc.Target, fh []byte) [8]byte {
+	t.Helper()
+	type commitArgs struct {
+		rpc.Header
+		FH     []byte
+		Offset uint64
+		Count  uint32
+	}
+	res, err := target.Call(&commitArgs{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfsc.Nfs3Prog,
+			Vers:    nfsc.Nfs3Vers,
+			Proc:    nfsc.NFSProc3Commit,
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		FH: fh,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply struct {
+		Status uint32
+		Wcc    nfsc.WccData
+		Verf   [8]byte
+	}
+	if err := xdr.Read(res, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Status != nfsc.NFS3Ok {
+		t.Fatalf("commit failed with status %d", reply.Status)
+	}
+	return reply.Verf
+}
+
+func TestCommitVerifier(t *testing.T) {
+	mem := &syncCountingFS{Filesystem: newTestFS(t, map[string]string{"/test": "hello"})}
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+
+	srv := &nfs.Server{Handler: handler}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+	_, fh, err := target.Lookup("/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := commit(t, target, fh)
+	if second := commit(t, target, fh); second != first {
+		t.Fatalf("verifier changed between commits: %x then %x", first, second)
+	}
+	if binary.BigEndian.Uint64(first[:]) != srv.WriteVerifier() {
+		t.Fatalf("commit verifier %x is not the server's %x", first, srv.WriteVerifier())
+	}
+	if reply := write(t, target, fh, []byte("HELLO"), 0); reply.Verf != first {
+		t.Fatalf("write verifier %x does not match commit verifier %x", reply.Verf, first)
+	}
+	if n := atomic.LoadInt32(&mem.syncs); n != 2 {
+		t.Fatalf("expected each commit to sync the file, got %d syncs", n)
+	}
+
+	// A new server over the same handler stands in for a restart.
+	restartedSrv := &nfs.Server{Handler: handler}
+	if restartedSrv.WriteVerifier() == srv.WriteVerifier() {
+		t.Fatalf("a new server reports the same write verifier %x", srv.WriteVerifier())
+	}
+	restarted := serveAndMount(t, restartedSrv, rpc.AuthNull)
+	after := commit(t, restarted, fh)
+	if after == first {
+		t.Fatalf("verifier %x did not change across a restart", after)
+	}
+	if binary.BigEndian.Uint64(after[:]) != restartedSrv.WriteVerifier() {
+		t.Fatalf("commit verifier %x is not the restarted server's %x", after, restartedSrv.WriteVerifier())
+	}
+
+	// A server given its ID keeps its verifier across restarts.
+	id := [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	if a, b := (&nfs.Server{Handler: handler, ID: id}).WriteVerifier(), (&nfs.Server{Handler: handler, ID: id}).WriteVerifier(); a != b || a != binary.BigEndian.Uint64(id[:]) {
+		t.Fatalf("servers with ID %x report write verifiers %x and %x", id, a, b)
+	}
+}
+
+func TestCommitUnwritable(t *testing.T) {
+	fs := &unwritableFS{Filesystem: newTestFS(t, map[string]string{"/locked": "hello"}), locked: "locked"}
+	target := serveAndMount(t, &nfs.Server{Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024)}, rpc.AuthNull)
+	_, fh, err := target.Lookup("/locked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// commit fails the test unless the file is synced.
+	commit(t, target, fh)
+}
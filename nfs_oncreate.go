@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"os"
+	"reflect"
+	"sync"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/willscott/go-nfs-client/nfs/xdr"
@@ -17,8 +19,7 @@ const (
 
 func onCreate(ctx context.Context, w *response, userHandle Handler) error {
 	w.errorFmt = wccDataErrorFormatter
-	obj := DirOpArg{}
-	err := xdr.Read(w.req.Body, &obj)
+	obj, err := ReadDirOpArg(w.req.Body)
 	if err != nil {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
@@ -27,6 +28,7 @@ func onCreate(ctx context.Context, w *response, userHandle Handler) error {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
 	var attrs *SetFileAttributes
+	var verf [8]byte
 	if how == createModeUnchecked || how == createModeGuarded {
 		sattr, err := ReadSetFileAttributes(w.req.Body)
 		if err != nil {
@@ -35,19 +37,15 @@ func onCreate(ctx context.Context, w *response, userHandle Handler) error {
 		attrs = sattr
 	} else if how == createModeExclusive {
 		// read createverf3
-		var verf [8]byte
 		if err := xdr.Read(w.req.Body, &verf); err != nil {
 			return &NFSStatusError{NFSStatusInval, err}
 		}
-		Log.Errorf("failing create to indicate lack of support for 'exclusive' mode.")
-		// TODO: support 'exclusive' mode.
-		return &NFSStatusError{NFSStatusNotSupp, os.ErrPermission}
 	} else {
 		// invalid
 		return &NFSStatusError{NFSStatusNotSupp, os.ErrInvalid}
 	}
 
-	fs, path, err := userHandle.FromHandle(obj.Handle)
+	fs, path, err := w.fromHandle(ctx, userHandle, obj.Handle)
 	if err != nil {
 		return &NFSStatusError{NFSStatusStale, err}
 	}
@@ -55,12 +53,27 @@ func onCreate(ctx context.Context, w *response, userHandle Handler) error {
 		return &NFSStatusError{NFSStatusROFS, os.ErrPermission}
 	}
 
-	if len(string(obj.Filename)) > PathNameMax {
+	if nameTooLong(userHandle, obj.Filename) {
 		return &NFSStatusError{NFSStatusNameTooLong, nil}
 	}
 
-	newFile := append(path, string(obj.Filename))
+	dirInfo, err := statDir(fs, path)
+	if err != nil {
+		return err
+	}
+	preOpDir := w.fileAttribute(userHandle, fs, dirInfo, path).AsCache()
+	// on a backend matching names without regard to case, a create of
+	// another case of an existing name is a create of that file.
+	name := resolveName(userHandle, fs, path, string(obj.Filename))
+	if err := w.Server.checkNameCollision(userHandle, fs, path, name); err != nil {
+		return err
+	}
+
+	newFile := append(path, name)
 	newFilePath := fs.Join(newFile...)
+	// An exclusive create of a file that exists succeeds only as the retry
+	// of the create that made it, which the client's verifier identifies.
+	retried := false
 	if s, err := fs.Stat(newFilePath); err == nil {
 		if s.IsDir() {
 			return &NFSStatusError{NFSStatusExist, nil}
@@ -68,52 +81,66 @@ func onCreate(ctx context.Context, w *response, userHandle Handler) error {
 		if how == createModeGuarded {
 			return &NFSStatusError{NFSStatusExist, os.ErrPermission}
 		}
-	} else {
-		if s, err := fs.Stat(fs.Join(path...)); err != nil {
-			return &NFSStatusError{NFSStatusAccess, err}
-		} else if !s.IsDir() {
-			return &NFSStatusError{NFSStatusNotDir, nil}
+		if how == createModeExclusive {
+			if !w.Server.createVerifiers.matches(fs, newFilePath, verf) {
+				return &NFSStatusError{NFSStatusExist, os.ErrExist}
+			}
+			retried = true
 		}
 	}
 
-	file, err := fs.Create(newFilePath)
-	if err != nil {
-		Log.Errorf("Error Creating: %v", err)
-		return &NFSStatusError{NFSStatusAccess, err}
-	}
-	if err := file.Close(); err != nil {
-		Log.Errorf("Error Creating: %v", err)
-		return &NFSStatusError{NFSStatusAccess, err}
+	if !retried {
+		flag := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+		if how == createModeExclusive {
+			flag = os.O_RDWR | os.O_CREATE | os.O_EXCL
+		}
+		file, err := fs.OpenFile(newFilePath, flag, 0666)
+		if err != nil {
+			w.logger().Errorf("Error Creating: %v", err)
+			return &NFSStatusError{mapError(err), err}
+		}
+		if err := file.Close(); err != nil {
+			w.logger().Errorf("Error Creating: %v", err)
+			return &NFSStatusError{mapError(err), err}
+		}
+		if how == createModeExclusive {
+			w.Server.createVerifiers.add(fs, newFilePath, verf)
+		}
 	}
 
-	fp := userHandle.ToHandle(fs, newFile)
-	changer := userHandle.Change(fs)
-	if err := attrs.Apply(changer, fs, newFilePath); err != nil {
-		Log.Errorf("Error applying attributes: %v\n", err)
-		return &NFSStatusError{NFSStatusIO, err}
+	// a file that is not there once created, as in a dry run, has no
+	// attributes to set, and is given no handle; the client looks it up if
+	// it needs one.
+	postOp := w.tryStat(userHandle, fs, newFile)
+	if attrs != nil && postOp != nil {
+		changer := userHandle.Change(fs)
+		if err := attrs.Apply(changer, fs, newFilePath); err != nil {
+			w.logger().Errorf("Error applying attributes: %v\n", err)
+			return &NFSStatusError{mapError(err), err}
+		}
+		postOp = w.tryStat(userHandle, fs, newFile)
 	}
 
+	w.Server.negativeLookups.forget(fs, path, name)
+	w.notifyChange(userHandle, ChangeCreate, fs, newFile)
+
 	writer := bytes.NewBuffer([]byte{})
 	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
-	// "handle follows"
-	if err := xdr.Write(writer, uint32(1)); err != nil {
-		return &NFSStatusError{NFSStatusServerFault, err}
+	var fp []byte
+	if postOp != nil {
+		fp = w.createdHandle(userHandle, fs, newFile)
 	}
-	if err := xdr.Write(writer, fp); err != nil {
+	if err := writePostOpHandle(writer, fp); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
-	if err := WritePostOpAttrs(writer, tryStat(fs, []string{file.Name()})); err != nil {
+	if err := WritePostOpAttrs(writer, postOp); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
-	// dir_wcc (we don't include pre_op_attr)
-	if err := xdr.Write(writer, uint32(0)); err != nil {
-		return &NFSStatusError{NFSStatusServerFault, err}
-	}
-	if err := WritePostOpAttrs(writer, tryStat(fs, path)); err != nil {
+	if err := WriteWcc(writer, preOpDir, w.tryStat(userHandle, fs, path)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
@@ -122,3 +149,83 @@ func onCreate(ctx context.Context, w *response, userHandle Handler) error {
 	}
 	return nil
 }
+
+// maxCreateVerifiers bounds how many exclusive creates are remembered for
+// recognizing retries.
+const maxCreateVerifiers = 1024
+
+// createVerifierTable remembers the verifiers of recent exclusive creates,
+// by the file system and path of the file each created. They are kept apart
+// from the files' attributes, rather than in their times as some servers
+// do, so that setting the attributes cannot corrupt them.
+type createVerifierTable struct {
+	mu      sync.Mutex
+	byPath  map[string][]createdFile
+	ordered []createdFile
+}
+
+type createdFile struct {
+	fs   billy.Filesystem
+	path string
+	verf [8]byte
+}
+
+func (t *createVerifierTable) add(fs billy.Filesystem, path string, verf [8]byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.byPath == nil {
+		t.byPath = make(map[string][]createdFile)
+	}
+	t.remove(fs, path)
+	created := createdFile{fs, path, verf}
+	t.byPath[path] = append(t.byPath[path], created)
+	t.ordered = append(t.ordered, created)
+	if len(t.ordered) > maxCreateVerifiers {
+		t.remove(t.ordered[0].fs, t.ordered[0].path)
+	}
+}
+
+// forget drops the verifier of the file at path, once the client has gone
+// on to set its attributes and so will not retry its create, or the file
+// has been removed or renamed.
+func (t *createVerifierTable) forget(fs billy.Filesystem, path string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.remove(fs, path)
+}
+
+// remove drops the verifier of the file at path. It expects t.mu to be held.
+func (t *createVerifierTable) remove(fs billy.Filesystem, path string) {
+	entries, ok := t.byPath[path]
+	if !ok {
+		return
+	}
+	for i, c := range entries {
+		if reflect.DeepEqual(c.fs, fs) {
+			entries = append(entries[:i], entries[i+1:]...)
+			break
+		}
+	}
+	if len(entries) == 0 {
+		delete(t.byPath, path)
+	} else {
+		t.byPath[path] = entries
+	}
+	for i, c := range t.ordered {
+		if c.path == path && reflect.DeepEqual(c.fs, fs) {
+			t.ordered = append(t.ordered[:i], t.ordered[i+1:]...)
+			break
+		}
+	}
+}
+
+func (t *createVerifierTable) matches(fs billy.Filesystem, path string, verf [8]byte) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, c := range t.byPath[path] {
+		if reflect.DeepEqual(c.fs, fs) {
+			return c.verf == verf
+		}
+	}
+	return false
+}
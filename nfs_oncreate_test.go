@@ -0,0 +1,337 @@
+package nfs_test
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+const (
+	createUnchecked = 0
+	createGuarded   = 1
+	createExclusive = 2
+)
+
+type createReply struct {
+	Status uint32
+	Handle []byte
+	Attrs  nfsc.PostOpAttr
+	DirWcc nfsc.WccData
+}
+
+// create issues a CREATE of name in dir. Unchecked and guarded creates set
+// no attributes; exclusive creates pass verf.
+func create(t *testing.T, target *nfsc.Target, dir []byte, name string, how uint32, verf [8]byte) createReply {
+	t.Helper()
+	header := rpc.Header{
+		Rpcvers: 2,
+		Prog:    nfsc.Nfs3Prog,
+		Vers:    nfsc.Nfs3Vers,
+		Proc:    nfsc.NFSProc3Create,
+		Cred:    rpc.AuthNull,
+		Verf:    rpc.AuthNull,
+	}
+	type createArgs struct {
+		rpc.Header
+		Dir  []byte
+		Name string
+		How  uint32
+		// an empty sattr3: none of mode, uid, gid, size, atime or mtime set.
+		Attrs [6]uint32
+	}
+	type exclusiveCreateArgs struct {
+		rpc.Header
+		Dir  []byte
+		Name string
+		How  uint32
+		Verf [8]byte
+	}
+	var args interface{} = &createArgs{Header: header, Dir: dir, Name: name, How: how}
+	if how == createExclusive {
+		args = &exclusiveCreateArgs{Header: header, Dir: dir, Name: name, How: how, Verf: verf}
+	}
+	res, err := target.Call(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply createReply
+	if reply.Status, err = xdr.ReadUint32(res); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Status != nfsc.NFS3Ok {
+		return reply
+	}
+	var ok struct {
+		HasHandle bool   `xdr:"union"`
+		Handle    []byte `xdr:"unioncase=1"`
+		Attrs     nfsc.PostOpAttr
+		DirWcc    nfsc.WccData
+	}
+	if err := xdr.Read(res, &ok); err != nil {
+		t.Fatal(err)
+	}
+	reply.Handle, reply.Attrs, reply.DirWcc = ok.Handle, ok.Attrs, ok.DirWcc
+	return reply
+}
+
+func TestCreateModes(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/existing": "hello"})
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+	_, dir, err := target.Lookup("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var noVerf [8]byte
+
+	// UNCHECKED creates or truncates.
+	if r := create(t, target, dir, "existing", createUnchecked, noVerf); r.Status != nfsc.NFS3Ok {
+		t.Fatalf("unchecked create of an existing file failed with %d", r.Status)
+	} else if !r.Attrs.IsSet || r.Attrs.Attr.Filesize != 0 {
+		t.Fatalf("expected unchecked create to truncate, got attributes %+v", r.Attrs)
+	} else if !r.DirWcc.Before.IsSet || !r.DirWcc.After.IsSet {
+		t.Fatal("expected directory wcc from create")
+	}
+
+	// GUARDED fails if the file exists.
+	if r := create(t, target, dir, "existing", createGuarded, noVerf); r.Status != nfsc.NFS3ErrExist {
+		t.Fatalf("expected EXIST from a guarded create of an existing file, got %d", r.Status)
+	}
+	if r := create(t, target, dir, "guarded", createGuarded, noVerf); r.Status != nfsc.NFS3Ok || len(r.Handle) == 0 {
+		t.Fatalf("guarded create of a new file failed with %d", r.Status)
+	}
+
+	// EXCLUSIVE succeeds again only when retried with the same verifier.
+	verf := [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	first := create(t, target, dir, "exclusive", createExclusive, verf)
+	if first.Status != nfsc.NFS3Ok || len(first.Handle) == 0 {
+		t.Fatalf("exclusive create failed with %d", first.Status)
+	}
+	retry := create(t, target, dir, "exclusive", createExclusive, verf)
+	if retry.Status != nfsc.NFS3Ok {
+		t.Fatalf("expected a retried exclusive create to succeed, got %d", retry.Status)
+	}
+	if !bytes.Equal(retry.Handle, first.Handle) {
+		t.Fatalf("retried exclusive create returned handle %x, expected %x", retry.Handle, first.Handle)
+	}
+	other := [8]byte{8, 7, 6, 5, 4, 3, 2, 1}
+	if r := create(t, target, dir, "exclusive", createExclusive, other); r.Status != nfsc.NFS3ErrExist {
+		t.Fatalf("expected EXIST from an exclusive create with another verifier, got %d", r.Status)
+	}
+	if r := create(t, target, dir, "existing", createExclusive, verf); r.Status != nfsc.NFS3ErrExist {
+		t.Fatalf("expected EXIST from an exclusive create of a file it didn't make, got %d", r.Status)
+	}
+}
+
+func TestCreateExclusiveVerifierCleared(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/existing": "hello"})
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+	_, dir, err := target.Lookup("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verf := [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	r := create(t, target, dir, "exclusive", createExclusive, verf)
+	if r.Status != nfsc.NFS3Ok {
+		t.Fatalf("exclusive create failed with %d", r.Status)
+	}
+
+	// Clients follow an exclusive create with a SETATTR of the attributes
+	// they could not send with it.
+	sattr := nfsc.Sattr3{Size: nfsc.SetSize{SetIt: true, Size: 4}}
+	if reply := setAttr(t, target, r.Handle, sattr, nil); reply.Status != nfsc.NFS3Ok {
+		t.Fatalf("setattr after exclusive create failed with %d", reply.Status)
+	}
+	if info, err := mem.Stat("/dir/exclusive"); err != nil || info.Size() != 4 {
+		t.Fatalf("expected the setattr to apply, got %v, %v", info, err)
+	}
+
+	if r := create(t, target, dir, "exclusive", createExclusive, verf); r.Status != nfsc.NFS3ErrExist {
+		t.Fatalf("expected EXIST from an exclusive create after setattr, got %d", r.Status)
+	}
+}
+
+func TestCreateExclusiveVerifierScope(t *testing.T) {
+	multi := helpers.NewMultiExportHandler(map[string]nfs.Handler{
+		"/":     helpers.NewCachingHandler(helpers.NewNullAuthHandler(newTestFS(t, map[string]string{"/keep": ""})), 1024),
+		"/data": helpers.NewCachingHandler(helpers.NewNullAuthHandler(newTestFS(t, map[string]string{"/keep": ""})), 1024),
+	})
+	target := serveAndMount(t, &nfs.Server{Handler: multi}, rpc.AuthNull)
+	_, root, err := target.Lookup("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, data := mount(t, target, "/data")
+	if status != nfsc.MNT3Ok {
+		t.Fatalf("mount of /data failed with %d", status)
+	}
+	verf := [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	var noVerf [8]byte
+
+	if r := create(t, target, root, "file", createExclusive, verf); r.Status != nfsc.NFS3Ok {
+		t.Fatalf("exclusive create failed with %d", r.Status)
+	}
+	// the same path in another export is another file.
+	if r := create(t, target, data, "file", createUnchecked, noVerf); r.Status != nfsc.NFS3Ok {
+		t.Fatalf("unchecked create failed with %d", r.Status)
+	}
+	if r := create(t, target, data, "file", createExclusive, verf); r.Status != nfsc.NFS3ErrExist {
+		t.Fatalf("expected EXIST from an exclusive create in another export, got %d", r.Status)
+	}
+
+	// nor is a file made in place of a removed one the one created.
+	if err := target.Remove("/file"); err != nil {
+		t.Fatal(err)
+	}
+	if r := create(t, target, root, "file", createUnchecked, noVerf); r.Status != nfsc.NFS3Ok {
+		t.Fatalf("unchecked create failed with %d", r.Status)
+	}
+	if r := create(t, target, root, "file", createExclusive, verf); r.Status != nfsc.NFS3ErrExist {
+		t.Fatalf("expected EXIST from an exclusive create after remove, got %d", r.Status)
+	}
+}
+
+// composeAcute stands in for NFC normalization of the names under test,
+// composing e and a combining acute accent.
+func composeAcute(name string) string {
+	return strings.ReplaceAll(name, "e\u0301", "\u00e9")
+}
+
+func TestCreateNormalizationCollision(t *testing.T) {
+	const nfc, nfd = "caf\u00e9", "cafe\u0301"
+	var noVerf [8]byte
+
+	for _, policy := range []bool{false, true} {
+		mem := newTestFS(t, map[string]string{"/dir/" + nfc: "hello"})
+		handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+		srv := &nfs.Server{Handler: handler}
+		if policy {
+			srv.NormalizeName = composeAcute
+		}
+		target := serveAndMount(t, srv, rpc.AuthNull)
+
+		_, dir, err := target.Lookup("/dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := create(t, target, dir, nfd, createUnchecked, noVerf)
+		if !policy {
+			if r.Status != nfsc.NFS3Ok {
+				t.Fatalf("create of %q beside %q failed with %d", nfd, nfc, r.Status)
+			}
+			continue
+		}
+		if r.Status != nfsc.NFS3ErrExist {
+			t.Fatalf("expected EXIST creating %q beside %q, got %d", nfd, nfc, r.Status)
+		}
+		if _, err := mem.Stat("/dir/" + nfd); err == nil {
+			t.Fatalf("%q was created", nfd)
+		}
+		// the exact name still takes the usual course.
+		if r := create(t, target, dir, nfc, createUnchecked, noVerf); r.Status != nfsc.NFS3Ok {
+			t.Fatalf("unchecked create of %q failed with %d", nfc, r.Status)
+		}
+
+		attrs, _, err := target.Lookup("/dir/" + nfd)
+		if err != nil {
+			t.Fatalf("expected %q to find %q: %v", nfd, nfc, err)
+		}
+		if attrs.IsDir() {
+			t.Fatalf("%q resolved to a directory", nfd)
+		}
+	}
+}
+
+func TestCreateFileID(t *testing.T) {
+	for _, numbered := range []bool{false, true} {
+		mem := newTestFS(t, map[string]string{"/dir/existing": "hello"})
+		var inner nfs.Handler = helpers.NewNullAuthHandler(mem)
+		if numbered {
+			inner = numberingHandler{inner}
+		}
+		handler := helpers.NewCachingHandler(inner, 1024)
+		target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+		_, dir, err := target.Lookup("/dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for how, name := range []string{"unchecked", "guarded", "exclusive"} {
+			reply := create(t, target, dir, name, uint32(how), [8]byte{byte(how + 1)})
+			if reply.Status != nfsc.NFS3Ok {
+				t.Fatalf("numbered=%v: %s create failed with status %d", numbered, name, reply.Status)
+			}
+			if !reply.Attrs.IsSet || reply.Attrs.Attr.Fileid == 0 {
+				t.Fatalf("numbered=%v: %s create reported no fileid", numbered, name)
+			}
+			attr, err := target.GetAttr(reply.Handle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attr.Fileid != reply.Attrs.Attr.Fileid {
+				t.Fatalf("numbered=%v: %s create reported fileid %d, getattr %d", numbered, name, reply.Attrs.Attr.Fileid, attr.Fileid)
+			}
+		}
+	}
+}
+
+// unwritableFS refuses to open the file named locked for writing.
+type unwritableFS struct {
+	billy.Filesystem
+	locked string
+}
+
+func (f *unwritableFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if filename == f.locked && flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrPermission}
+	}
+	return f.Filesystem.OpenFile(filename, flag, perm)
+}
+
+func TestCreateDryRun(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/locked": "hello"})
+	fs := &unwritableFS{Filesystem: mem, locked: "dir/locked"}
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024, helpers.WithDryRun(true))
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+	_, dir, err := target.Lookup("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a create that would succeed says so, without making the file or
+	// issuing a handle for it.
+	r := create(t, target, dir, "new", createUnchecked, [8]byte{})
+	if r.Status != nfsc.NFS3Ok {
+		t.Fatalf("create failed with status %d", r.Status)
+	}
+	if r.Handle != nil || r.Attrs.IsSet {
+		t.Fatalf("expected no handle or attributes for a file not made, got %x and %+v", r.Handle, r.Attrs)
+	}
+	if _, err := mem.Stat("/dir/new"); !os.IsNotExist(err) {
+		t.Fatalf("expected /dir/new not to be made, got %v", err)
+	}
+	if stats := handler.(*helpers.CachingHandler).Stats(); stats.Handles != 2 {
+		t.Fatalf("expected handles only for what was looked up, got %d", stats.Handles)
+	}
+
+	// writing a file that may not be written fails as it would for real.
+	_, locked, err := target.Lookup("/dir/locked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply := tryWriteAt(t, target, locked, 0, []byte("data"), 2); reply.Status != nfsc.NFS3ErrAcces {
+		t.Fatalf("expected a write of an unwritable file refused, got status %d", reply.Status)
+	}
+}
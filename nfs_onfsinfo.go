@@ -3,6 +3,7 @@ package nfs
 import (
 	"bytes"
 	"context"
+	"time"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/willscott/go-nfs-client/nfs/xdr"
@@ -20,11 +21,11 @@ const (
 )
 
 func onFSInfo(ctx context.Context, w *response, userHandle Handler) error {
-	roothandle, err := xdr.ReadOpaque(w.req.Body)
-	if err != nil {
+	var handle []byte
+	if err := xdr.Read(w.req.Body, &handle); err != nil {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
-	fs, path, err := userHandle.FromHandle(roothandle)
+	fs, path, err := w.fromHandle(ctx, userHandle, handle)
 	if err != nil {
 		return &NFSStatusError{NFSStatusStale, err}
 	}
@@ -33,7 +34,7 @@ func onFSInfo(ctx context.Context, w *response, userHandle Handler) error {
 	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
-	if err := WritePostOpAttrs(writer, tryStat(fs, path)); err != nil {
+	if err := WritePostOpAttrs(writer, w.tryStat(userHandle, fs, path)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
@@ -50,12 +51,13 @@ func onFSInfo(ctx context.Context, w *response, userHandle Handler) error {
 		Properties  uint32
 	}
 
+	maxTransfer := w.maxTransferSize()
 	res := fsinfores{
-		Rtmax:       1 << 30,
-		Rtpref:      1 << 30,
+		Rtmax:       maxTransfer,
+		Rtpref:      maxTransfer,
 		Rtmult:      4096,
-		Wtmax:       1 << 30,
-		Wtpref:      1 << 30,
+		Wtmax:       maxTransfer,
+		Wtpref:      maxTransfer,
 		Wtmult:      4096,
 		Dtpref:      8192,
 		Maxfilesize: 1 << 62, // wild guess. this seems big.
@@ -63,6 +65,10 @@ func onFSInfo(ctx context.Context, w *response, userHandle Handler) error {
 		Properties:  0,
 	}
 
+	if d := timeGranularity(userHandle, fs); d > 0 {
+		res.TimeDelta = uint64(d/time.Second)<<32 | uint64(d%time.Second)
+	}
+
 	// TODO: these aren't great indications of support, really.
 	if _, ok := fs.(billy.Symlink); ok {
 		res.Properties |= FSInfoPropertyLink
@@ -9,11 +9,11 @@ import (
 )
 
 func onFSStat(ctx context.Context, w *response, userHandle Handler) error {
-	roothandle, err := xdr.ReadOpaque(w.req.Body)
-	if err != nil {
+	var handle []byte
+	if err := xdr.Read(w.req.Body, &handle); err != nil {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
-	fs, path, err := userHandle.FromHandle(roothandle)
+	fs, path, err := w.fromHandle(ctx, userHandle, handle)
 	if err != nil {
 		return &NFSStatusError{NFSStatusStale, err}
 	}
@@ -39,12 +39,15 @@ func onFSStat(ctx context.Context, w *response, userHandle Handler) error {
 		}
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
+	// Backends may report values that don't add up, e.g. counting reserved
+	// blocks as available but not free.
+	defaults.clamp()
 
 	writer := bytes.NewBuffer([]byte{})
 	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
-	if err := WritePostOpAttrs(writer, tryStat(fs, path)); err != nil {
+	if err := WritePostOpAttrs(writer, w.tryStat(userHandle, fs, path)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
@@ -0,0 +1,81 @@
+package nfs_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// inconsistentStatHandler reports statfs values that violate
+// avail <= free <= total.
+type inconsistentStatHandler struct {
+	nfs.Handler
+}
+
+func (h *inconsistentStatHandler) FSStat(ctx context.Context, f billy.Filesystem, s *nfs.FSStat) error {
+	s.TotalSize = 1000
+	s.FreeSize = 2000
+	s.AvailableSize = 3000
+	s.TotalFiles = 10
+	s.FreeFiles = 20
+	s.AvailableFiles = 30
+	return nil
+}
+
+func TestFSStatClampsInconsistentValues(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/test": "hello"})
+	handler := helpers.NewCachingHandler(&inconsistentStatHandler{helpers.NewNullAuthHandler(mem)}, 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+	_, fh, err := target.Lookup("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type fsstatArgs struct {
+		rpc.Header
+		FH []byte
+	}
+	res, err := target.Call(&fsstatArgs{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfsc.Nfs3Prog,
+			Vers:    nfsc.Nfs3Vers,
+			Proc:    uint32(nfs.NFSProcedureFSStat),
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		FH: fh,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply struct {
+		Status uint32
+		Attr   nfsc.PostOpAttr
+		TBytes uint64
+		FBytes uint64
+		ABytes uint64
+		TFiles uint64
+		FFiles uint64
+		AFiles uint64
+	}
+	if err := xdr.Read(res, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Status != nfsc.NFS3Ok {
+		t.Fatalf("fsstat failed with status %d", reply.Status)
+	}
+	if reply.TBytes != 1000 || reply.FBytes != 1000 || reply.ABytes != 1000 {
+		t.Fatalf("space not clamped: total %d free %d avail %d", reply.TBytes, reply.FBytes, reply.ABytes)
+	}
+	if reply.TFiles != 10 || reply.FFiles != 10 || reply.AFiles != 10 {
+		t.Fatalf("files not clamped: total %d free %d avail %d", reply.TFiles, reply.FFiles, reply.AFiles)
+	}
+}
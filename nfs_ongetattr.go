@@ -3,18 +3,17 @@ package nfs
 import (
 	"bytes"
 	"context"
-	"os"
 
 	"github.com/willscott/go-nfs-client/nfs/xdr"
 )
 
 func onGetAttr(ctx context.Context, w *response, userHandle Handler) error {
-	handle, err := xdr.ReadOpaque(w.req.Body)
-	if err != nil {
+	var handle []byte
+	if err := xdr.Read(w.req.Body, &handle); err != nil {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
 
-	fs, path, err := userHandle.FromHandle(handle)
+	fs, path, err := w.fromHandleShallow(ctx, userHandle, handle)
 	if err != nil {
 		return &NFSStatusError{NFSStatusStale, err}
 	}
@@ -22,12 +21,9 @@ func onGetAttr(ctx context.Context, w *response, userHandle Handler) error {
 	fullPath := fs.Join(path...)
 	info, err := fs.Lstat(fullPath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return &NFSStatusError{NFSStatusNoEnt, err}
-		}
-		return &NFSStatusError{NFSStatusIO, err}
+		return &NFSStatusError{mapError(err), err}
 	}
-	attr := ToFileAttribute(info, fullPath)
+	attr := w.fileAttribute(userHandle, fs, info, path)
 
 	writer := bytes.NewBuffer([]byte{})
 	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
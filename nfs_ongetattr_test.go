@@ -0,0 +1,410 @@
+package nfs_test
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+)
+
+// numberingHandler gives each file a fileid of one more than its depth.
+type numberingHandler struct {
+	nfs.Handler
+}
+
+func (numberingHandler) FileIDFor(fs billy.Filesystem, path []string) uint64 {
+	return uint64(len(path)) + 1
+}
+
+func TestFileIDStable(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/a": "a", "/dir/b": "b"})
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+	_, fh, err := target.Lookup("/dir/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := target.GetAttr(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Fileid == 0 {
+		t.Fatal("expected a non-zero fileid")
+	}
+	for i := 0; i < 3; i++ {
+		again, err := target.GetAttr(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.Fileid != first.Fileid {
+			t.Fatalf("fileid changed between calls: %d then %d", first.Fileid, again.Fileid)
+		}
+	}
+
+	entries, err := target.ReadDirPlus("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]uint64)
+	for _, e := range entries {
+		ids[e.FileName] = e.FileId
+	}
+	if ids["a"] != first.Fileid {
+		t.Fatalf("readdirplus numbered the file %d, getattr %d", ids["a"], first.Fileid)
+	}
+	if ids["b"] == ids["a"] {
+		t.Fatalf("distinct files share fileid %d", ids["a"])
+	}
+}
+
+func TestFileIDHandler(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/a": "a"})
+	handler := helpers.NewCachingHandler(numberingHandler{helpers.NewNullAuthHandler(mem)}, 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+	_, fh, err := target.Lookup("/dir/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	attr, err := target.GetAttr(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attr.Fileid != 3 {
+		t.Fatalf("expected the handler's fileid 3, got %d", attr.Fileid)
+	}
+}
+
+// bareRootFS describes its root as a file with no mode bits, as some
+// object-store backends do.
+type bareRootFS struct {
+	billy.Filesystem
+}
+
+type bareInfo struct {
+	os.FileInfo
+}
+
+func (bareInfo) IsDir() bool       { return false }
+func (bareInfo) Mode() os.FileMode { return 0 }
+
+func isRoot(name string) bool {
+	return name == "" || name == "/" || name == "."
+}
+
+func (fs bareRootFS) Stat(name string) (os.FileInfo, error) {
+	info, err := fs.Filesystem.Stat(name)
+	if err == nil && isRoot(name) {
+		info = bareInfo{info}
+	}
+	return info, err
+}
+
+func (fs bareRootFS) Lstat(name string) (os.FileInfo, error) {
+	info, err := fs.Filesystem.Lstat(name)
+	if err == nil && isRoot(name) {
+		info = bareInfo{info}
+	}
+	return info, err
+}
+
+func TestRootIsDirectory(t *testing.T) {
+	mem := bareRootFS{newTestFS(t, map[string]string{"/a": "a"})}
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+	_, fh, err := target.Lookup("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	attr, err := target.GetAttr(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !attr.IsDir() {
+		t.Fatalf("expected the root to be a directory, got mode %v", attr.Mode())
+	}
+	if attr.Mode().Perm() == 0 {
+		t.Fatal("expected the root to have permission bits")
+	}
+
+	entries, err := target.ReadDirPlus("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].FileName != "a" {
+		t.Fatalf("unexpected root listing: %v", entries)
+	}
+}
+
+// fsidHandler gives its file system a fixed fsid.
+type fsidHandler struct {
+	nfs.Handler
+}
+
+func (fsidHandler) FSIDFor(fs billy.Filesystem) uint64 {
+	return 7
+}
+
+func TestFSIDPerFilesystem(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/file": "hello"})
+	handler := helpers.NewCachingHandler(fsidHandler{helpers.NewNullAuthHandler(mem)}, 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+	for _, p := range []string{"/dir/file", "/dir", "/"} {
+		_, fh, err := target.Lookup(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		attr, err := target.GetAttr(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attr.FSID != 7 {
+			t.Fatalf("%s: expected the file system's fsid 7, got %d", p, attr.FSID)
+		}
+	}
+	entries, err := target.ReadDirPlus("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Attr.IsSet && e.Attr.Attr.FSID != 7 {
+			t.Fatalf("readdirplus gave %s fsid %d", e.FileName, e.Attr.Attr.FSID)
+		}
+	}
+}
+
+// modelessFS reports no permissions for any file, as backends without modes
+// do.
+type modelessFS struct {
+	billy.Filesystem
+}
+
+type modelessInfo struct {
+	os.FileInfo
+}
+
+func (i modelessInfo) Mode() os.FileMode { return i.FileInfo.Mode() &^ os.ModePerm }
+
+func (fs modelessFS) Stat(name string) (os.FileInfo, error) {
+	info, err := fs.Filesystem.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return modelessInfo{info}, nil
+}
+
+func (fs modelessFS) Lstat(name string) (os.FileInfo, error) {
+	info, err := fs.Filesystem.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	return modelessInfo{info}, nil
+}
+
+func (fs modelessFS) ReadDir(path string) ([]os.FileInfo, error) {
+	contents, err := fs.Filesystem.ReadDir(path)
+	for i, info := range contents {
+		contents[i] = modelessInfo{info}
+	}
+	return contents, err
+}
+
+func TestDefaultFileMode(t *testing.T) {
+	mem := modelessFS{newTestFS(t, map[string]string{"/dir/file": "hello"})}
+	for _, tc := range []struct {
+		defaultMode   os.FileMode
+		file, dirPerm os.FileMode
+	}{
+		{0, 0, 0},
+		{0644, 0644, 0755},
+		{0600, 0600, 0700},
+	} {
+		srv := &nfs.Server{
+			Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024),
+			ServerOptions: nfs.ServerOptions{DefaultFileMode: tc.defaultMode},
+		}
+		target := serveAndMount(t, srv, rpc.AuthNull)
+		for name, want := range map[string]os.FileMode{"/dir/file": tc.file, "/dir": tc.dirPerm} {
+			_, fh, err := target.Lookup(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			attr, err := target.GetAttr(fh)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attr.Mode().Perm() != want {
+				t.Fatalf("default %v: %s reported %v, want %v", tc.defaultMode, name, attr.Mode().Perm(), want)
+			}
+		}
+
+		// listings report the same.
+		entries, err := target.ReadDirPlus("/dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if e.FileName == "file" && e.Mode().Perm() != tc.file {
+				t.Fatalf("default %v: readdirplus reported %v, want %v", tc.defaultMode, e.Mode().Perm(), tc.file)
+			}
+		}
+	}
+}
+
+// deepHandler hides the handler's FromHandleShallow, so that GETATTR
+// resolves handles as other calls do.
+type deepHandler struct {
+	nfs.Handler
+}
+
+func TestGetAttrShallowParity(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/file": "hello", "/dir/sub/other": "hi"})
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	shallow := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+	deep := serveAndMount(t, &nfs.Server{Handler: deepHandler{handler}}, rpc.AuthNull)
+
+	for _, p := range []string{"/", "/dir", "/dir/file", "/dir/sub/other"} {
+		_, fh, err := shallow.Lookup(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := deep.GetAttr(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := shallow.GetAttr(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("attributes of %s differ: %+v resolved shallowly, %+v otherwise", p, got, want)
+		}
+	}
+
+	_, fh, err := shallow.Lookup("/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, _, err := handler.FromHandle(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.InvalidateHandle(fs, fh); err != nil {
+		t.Fatal(err)
+	}
+	_, deepErr := deep.GetAttr(fh)
+	_, shallowErr := shallow.GetAttr(fh)
+	if nfsStatus(deepErr) != nfsc.NFS3ErrStale || nfsStatus(shallowErr) != nfsStatus(deepErr) {
+		t.Fatalf("expected both to find the handle stale, got %v resolved shallowly, %v otherwise", shallowErr, deepErr)
+	}
+}
+
+func BenchmarkGetAttr(b *testing.B) {
+	for _, tc := range []struct {
+		name string
+		wrap func(nfs.Handler) nfs.Handler
+	}{
+		{"shallow", func(h nfs.Handler) nfs.Handler { return h }},
+		{"deep", func(h nfs.Handler) nfs.Handler { return deepHandler{h} }},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			mem := newTestFS(b, map[string]string{"/dir/file": "hello"})
+			handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1<<14)
+			// a cache holding the handles of a working set, as a busy
+			// server's does.
+			for i := 0; i < 10000; i++ {
+				_ = handler.ToHandle(mem, []string{"dir", fmt.Sprintf("f%d", i)})
+			}
+			target := serveAndMount(b, &nfs.Server{Handler: tc.wrap(handler)}, rpc.AuthNull)
+			_, fh, err := target.Lookup("/dir/file")
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := target.GetAttr(fh); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// noisyTimeFS reports the modification times in mtimes for the files named
+// there.
+type noisyTimeFS struct {
+	billy.Filesystem
+	mtimes map[string]time.Time
+}
+
+type mtimeInfo struct {
+	os.FileInfo
+	mtime time.Time
+}
+
+func (i mtimeInfo) ModTime() time.Time { return i.mtime }
+
+func (fs noisyTimeFS) Lstat(name string) (os.FileInfo, error) {
+	info, err := fs.Filesystem.Lstat(name)
+	if t, ok := fs.mtimes[name]; ok && err == nil {
+		return mtimeInfo{info, t}, nil
+	}
+	return info, err
+}
+
+func TestTimeGranularity(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	mtimes := map[string]time.Time{"a": base.Add(300 * time.Millisecond), "b": base.Add(700 * time.Millisecond)}
+	for _, granularity := range []time.Duration{0, time.Second} {
+		t.Run(fmt.Sprintf("granularity=%v", granularity), func(t *testing.T) {
+			fs := noisyTimeFS{newTestFS(t, map[string]string{"/a": "a", "/b": "b"}), mtimes}
+			handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024, helpers.WithTimeGranularity(granularity))
+			target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+			mtime := func(name string) nfsc.NFS3Time {
+				_, fh, err := target.Lookup("/" + name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				attr, err := target.GetAttr(fh)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return attr.Mtime
+			}
+			a, b := mtime("a"), mtime("b")
+			info, err := target.FSInfo()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if granularity == 0 {
+				if a == b || a.Nseconds != 300000000 {
+					t.Fatalf("expected the times reported as they are, got %+v and %+v", a, b)
+				}
+				if info.TimeDelta != (nfsc.NFS3Time{Nseconds: 1}) {
+					t.Fatalf("expected a time delta of 1ns, got %+v", info.TimeDelta)
+				}
+				return
+			}
+			if a != b || a.Seconds != uint32(base.Unix()) || a.Nseconds != 0 {
+				t.Fatalf("expected both times truncated to %v, got %+v and %+v", base, a, b)
+			}
+			if info.TimeDelta != (nfsc.NFS3Time{Seconds: 1}) {
+				t.Fatalf("expected a time delta of 1s, got %+v", info.TimeDelta)
+			}
+		})
+	}
+}
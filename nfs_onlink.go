@@ -9,11 +9,14 @@ import (
 	"github.com/willscott/go-nfs-client/nfs/xdr"
 )
 
+// linkErrorBody is the failure body of a LINK reply: the file's post_op_attr
+// followed by the wcc_data of the directory the link was to be made in.
+var linkErrorBody = [12]byte{}
+
 // Backing billy.FS doesn't support hard links
 func onLink(ctx context.Context, w *response, userHandle Handler) error {
-	w.errorFmt = wccDataErrorFormatter
-	obj := DirOpArg{}
-	err := xdr.Read(w.req.Body, &obj)
+	w.errorFmt = errFormatterWithBody(linkErrorBody[:])
+	obj, err := ReadDirOpArg(w.req.Body)
 	if err != nil {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
@@ -27,7 +30,7 @@ func onLink(ctx context.Context, w *response, userHandle Handler) error {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
 
-	fs, path, err := userHandle.FromHandle(obj.Handle)
+	fs, path, err := w.fromHandle(ctx, userHandle, obj.Handle)
 	if err != nil {
 		return &NFSStatusError{NFSStatusStale, err}
 	}
@@ -35,7 +38,7 @@ func onLink(ctx context.Context, w *response, userHandle Handler) error {
 		return &NFSStatusError{NFSStatusROFS, os.ErrPermission}
 	}
 
-	if len(string(obj.Filename)) > PathNameMax {
+	if nameTooLong(userHandle, obj.Filename) {
 		return &NFSStatusError{NFSStatusNameTooLong, os.ErrInvalid}
 	}
 
@@ -43,13 +46,13 @@ func onLink(ctx context.Context, w *response, userHandle Handler) error {
 	if _, err := fs.Stat(newFilePath); err == nil {
 		return &NFSStatusError{NFSStatusExist, os.ErrExist}
 	}
-	if s, err := fs.Stat(fs.Join(path...)); err != nil {
-		return &NFSStatusError{NFSStatusAccess, err}
-	} else if !s.IsDir() {
-		return &NFSStatusError{NFSStatusNotDir, nil}
+	if _, err := statDir(fs, path); err != nil {
+		return err
+	}
+	if err := w.Server.checkNameCollision(userHandle, fs, path, string(obj.Filename)); err != nil {
+		return err
 	}
 
-	fp := userHandle.ToHandle(fs, append(path, string(obj.Filename)))
 	changer := userHandle.Change(fs)
 	if changer == nil {
 		return &NFSStatusError{NFSStatusAccess, err}
@@ -61,29 +64,28 @@ func onLink(ctx context.Context, w *response, userHandle Handler) error {
 
 	err = cos.Link(string(target), newFilePath)
 	if err != nil {
-		return &NFSStatusError{NFSStatusAccess, err}
+		return &NFSStatusError{mapError(err), err}
 	}
 	if err := attrs.Apply(changer, fs, newFilePath); err != nil {
 		return &NFSStatusError{NFSStatusIO, err}
 	}
 
+	w.Server.negativeLookups.forget(fs, path, string(obj.Filename))
+	w.notifyChange(userHandle, ChangeCreate, fs, append(path, string(obj.Filename)))
+
 	writer := bytes.NewBuffer([]byte{})
 	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
-	// "handle follows"
-	if err := xdr.Write(writer, uint32(1)); err != nil {
-		return &NFSStatusError{NFSStatusServerFault, err}
-	}
-	if err := xdr.Write(writer, fp); err != nil {
+	if err := writePostOpHandle(writer, w.createdHandle(userHandle, fs, append(path, string(obj.Filename)))); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
-	if err := WritePostOpAttrs(writer, tryStat(fs, append(path, string(obj.Filename)))); err != nil {
+	if err := WritePostOpAttrs(writer, w.tryStat(userHandle, fs, append(path, string(obj.Filename)))); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
-	if err := WriteWcc(writer, nil, tryStat(fs, path)); err != nil {
+	if err := WriteWcc(writer, nil, w.tryStat(userHandle, fs, path)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
@@ -9,7 +9,12 @@ import (
 	"github.com/willscott/go-nfs-client/nfs/xdr"
 )
 
-func lookupSuccessResponse(handle []byte, entPath, dirPath []string, fs billy.Filesystem) ([]byte, error) {
+func (w *response) lookupSuccessResponse(userHandle Handler, handle []byte, entPath, dirPath []string, fs billy.Filesystem) ([]byte, error) {
+	return lookupResponse(handle, w.tryStat(userHandle, fs, entPath), w.tryStat(userHandle, fs, dirPath))
+}
+
+// lookupResponse is the successful reply to a LOOKUP finding handle.
+func lookupResponse(handle []byte, objAttrs, dirAttrs *FileAttribute) ([]byte, error) {
 	writer := bytes.NewBuffer([]byte{})
 	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
 		return nil, err
@@ -17,10 +22,10 @@ func lookupSuccessResponse(handle []byte, entPath, dirPath []string, fs billy.Fi
 	if err := xdr.Write(writer, handle); err != nil {
 		return nil, err
 	}
-	if err := WritePostOpAttrs(writer, tryStat(fs, entPath)); err != nil {
+	if err := WritePostOpAttrs(writer, objAttrs); err != nil {
 		return nil, err
 	}
-	if err := WritePostOpAttrs(writer, tryStat(fs, dirPath)); err != nil {
+	if err := WritePostOpAttrs(writer, dirAttrs); err != nil {
 		return nil, err
 	}
 	return writer.Bytes(), nil
@@ -28,24 +33,32 @@ func lookupSuccessResponse(handle []byte, entPath, dirPath []string, fs billy.Fi
 
 func onLookup(ctx context.Context, w *response, userHandle Handler) error {
 	w.errorFmt = opAttrErrorFormatter
-	obj := DirOpArg{}
-	err := xdr.Read(w.req.Body, &obj)
+	obj, err := ReadDirOpArg(w.req.Body)
 	if err != nil {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
 
-	fs, p, err := userHandle.FromHandle(obj.Handle)
+	fs, p, err := w.fromHandle(ctx, userHandle, obj.Handle)
 	if err != nil {
 		return &NFSStatusError{NFSStatusStale, err}
 	}
 	dirInfo, err := fs.Lstat(fs.Join(p...))
+	if os.IsNotExist(err) {
+		return &NFSStatusError{NFSStatusStale, err}
+	}
+	if err == nil && len(p) == 0 {
+		dirInfo = exportRoot(dirInfo)
+	}
 	if err != nil || !dirInfo.IsDir() {
 		return &NFSStatusError{NFSStatusNotDir, err}
 	}
 
-	// Special cases for "." and ".."
-	if bytes.Equal(obj.Filename, []byte(".")) {
-		resp, err := lookupSuccessResponse(obj.Handle, p, p, fs)
+	// "." is the directory itself, as is ".." of the export's root, so
+	// both are answered from the handle and attributes already at hand.
+	// Other ".." are the parent, found by trimming the path.
+	if bytes.Equal(obj.Filename, []byte(".")) || (bytes.Equal(obj.Filename, []byte("..")) && len(p) == 0) {
+		dirAttrs := w.fileAttribute(userHandle, fs, dirInfo, p)
+		resp, err := lookupResponse(obj.Handle, dirAttrs, dirAttrs)
 		if err != nil {
 			return &NFSStatusError{NFSStatusServerFault, err}
 		}
@@ -55,12 +68,12 @@ func onLookup(ctx context.Context, w *response, userHandle Handler) error {
 		return nil
 	}
 	if bytes.Equal(obj.Filename, []byte("..")) {
-		if len(p) == 0 {
-			return &NFSStatusError{NFSStatusAccess, os.ErrPermission}
-		}
 		pPath := p[0 : len(p)-1]
-		pHandle := userHandle.ToHandle(fs, pPath)
-		resp, err := lookupSuccessResponse(pHandle, pPath, p, fs)
+		pHandle, err := w.toHandle(userHandle, fs, pPath)
+		if err != nil {
+			return &NFSStatusError{mapError(err), err}
+		}
+		resp, err := lookupResponse(pHandle, w.tryStat(userHandle, fs, pPath), w.fileAttribute(userHandle, fs, dirInfo, p))
 		if err != nil {
 			return &NFSStatusError{NFSStatusServerFault, err}
 		}
@@ -70,13 +83,29 @@ func onLookup(ctx context.Context, w *response, userHandle Handler) error {
 		return nil
 	}
 
-	reqPath := append(p, string(obj.Filename))
-	if _, err = fs.Lstat(fs.Join(reqPath...)); err != nil {
+	name := string(obj.Filename)
+	if w.Server.negativeLookups.missing(w.Server.clock(), w.Server.NegativeLookupTTL, fs, p, name) {
+		return &NFSStatusError{NFSStatusNoEnt, os.ErrNotExist}
+	}
+	reqPath := append(p, name)
+	if _, err := fs.Lstat(fs.Join(reqPath...)); err != nil {
+		if existing, ok := w.Server.equivalentName(fs, p, name); ok {
+			reqPath[len(reqPath)-1] = existing
+		} else if existing, ok := foldedName(userHandle, fs, p, name); ok {
+			reqPath[len(reqPath)-1] = existing
+		} else if os.IsNotExist(err) {
+			w.Server.negativeLookups.add(w.Server.clock(), w.Server.NegativeLookupTTL, fs, p, name)
+		}
+	}
+	if info, err := fs.Lstat(fs.Join(reqPath...)); err != nil || w.Server.hides(info) {
 		return &NFSStatusError{NFSStatusNoEnt, os.ErrNotExist}
 	}
 
-	newHandle := userHandle.ToHandle(fs, reqPath)
-	resp, err := lookupSuccessResponse(newHandle, reqPath, p, fs)
+	newHandle, err := w.toHandle(userHandle, fs, reqPath)
+	if err != nil {
+		return &NFSStatusError{mapError(err), err}
+	}
+	resp, err := w.lookupSuccessResponse(userHandle, newHandle, reqPath, p, fs)
 	if err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
@@ -0,0 +1,262 @@
+package nfs_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+func TestNegativeLookupTTL(t *testing.T) {
+	const ttl = time.Minute
+
+	mem := newTestFS(t, map[string]string{"/dir/test": "hello"})
+	srv := &nfs.Server{
+		Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024),
+		ServerOptions: nfs.ServerOptions{NegativeLookupTTL: ttl},
+	}
+	clock := newTestClock(srv)
+	target := serveAndMount(t, srv, rpc.AuthNull)
+
+	if _, _, err := target.Lookup("/dir/new"); nfsStatus(err) != nfsc.NFS3ErrNoEnt {
+		t.Fatalf("expected NOENT looking up a missing file, got %v", err)
+	}
+
+	// a file added behind the server's back is hidden until the TTL passes.
+	if err := util.WriteFile(mem, "/dir/new", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(ttl)
+	if _, _, err := target.Lookup("/dir/new"); nfsStatus(err) != nfsc.NFS3ErrNoEnt {
+		t.Fatalf("expected the missing lookup to be remembered, got %v", err)
+	}
+	clock.Advance(time.Nanosecond)
+	if _, _, err := target.Lookup("/dir/new"); err != nil {
+		t.Fatalf("expected the file to be found after the TTL: %v", err)
+	}
+
+	// a file created through the server is found at once.
+	if _, _, err := target.Lookup("/dir/created"); nfsStatus(err) != nfsc.NFS3ErrNoEnt {
+		t.Fatalf("expected NOENT looking up a missing file, got %v", err)
+	}
+	_, dir, err := target.Lookup("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := create(t, target, dir, "created", createUnchecked, [8]byte{}); r.Status != nfsc.NFS3Ok {
+		t.Fatalf("create failed with %d", r.Status)
+	}
+	if _, _, err := target.Lookup("/dir/created"); err != nil {
+		t.Fatalf("expected a file created through the server to be found: %v", err)
+	}
+
+	// as is one added elsewhere, once the server is told to forget.
+	if _, _, err := target.Lookup("/dir/watched"); nfsStatus(err) != nfsc.NFS3ErrNoEnt {
+		t.Fatalf("expected NOENT looking up a missing file, got %v", err)
+	}
+	if err := util.WriteFile(mem, "/dir/watched", []byte("watched"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv.ForgetMissing()
+	if _, _, err := target.Lookup("/dir/watched"); err != nil {
+		t.Fatalf("expected the file to be found after ForgetMissing: %v", err)
+	}
+}
+
+// lookupIn issues a LOOKUP of name in the directory dir, returning its
+// status.
+func lookupIn(t *testing.T, target *nfsc.Target, dir []byte, name string) uint32 {
+	t.Helper()
+	status, _ := lookupHandle(t, target, dir, name)
+	return status
+}
+
+func TestNegativeLookupOtherHandle(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/test": "hello"})
+	srv := &nfs.Server{
+		// every lookup of the directory mints it another handle.
+		Handler:       helpers.NewCachingHandlerNoReverse(helpers.NewNullAuthHandler(mem), 1024),
+		ServerOptions: nfs.ServerOptions{NegativeLookupTTL: time.Minute},
+	}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+
+	_, dir, err := target.Lookup("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := lookupIn(t, target, dir, "created"); status != nfsc.NFS3ErrNoEnt {
+		t.Fatalf("expected NOENT looking up a missing file, got %d", status)
+	}
+	_, other, err := target.Lookup("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(dir, other) {
+		t.Fatal("expected another handle for the directory")
+	}
+	if r := create(t, target, other, "created", createUnchecked, [8]byte{}); r.Status != nfsc.NFS3Ok {
+		t.Fatalf("create failed with %d", r.Status)
+	}
+	if status := lookupIn(t, target, dir, "created"); status != nfsc.NFS3Ok {
+		t.Fatalf("expected a file created through another handle of its directory to be found, got %d", status)
+	}
+}
+
+// watchingHandler hands out the func the server watches it with.
+type watchingHandler struct {
+	nfs.Handler
+	watched chan func(billy.Filesystem, []string)
+}
+
+func (h *watchingHandler) Watch(changed func(billy.Filesystem, []string)) func() {
+	h.watched <- changed
+	return func() {}
+}
+
+func TestNegativeLookupWatched(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/test": "hello", "/other/test": "hello"})
+	handler := &watchingHandler{helpers.NewNullAuthHandler(mem), make(chan func(billy.Filesystem, []string), 1)}
+	srv := &nfs.Server{
+		Handler:       helpers.NewCachingHandler(handler, 1024),
+		ServerOptions: nfs.ServerOptions{NegativeLookupTTL: time.Hour},
+	}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+	changed := <-handler.watched
+
+	for _, name := range []string{"/dir/new", "/other/new"} {
+		if _, _, err := target.Lookup(name); nfsStatus(err) != nfsc.NFS3ErrNoEnt {
+			t.Fatalf("expected NOENT looking up a missing file, got %v", err)
+		}
+		if err := util.WriteFile(mem, name, []byte("new"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	changed(mem, []string{"dir", "new"})
+	if _, _, err := target.Lookup("/dir/new"); err != nil {
+		t.Fatalf("expected a file the watcher reported to be found: %v", err)
+	}
+	// the same name elsewhere is still remembered missing.
+	if _, _, err := target.Lookup("/other/new"); nfsStatus(err) != nfsc.NFS3ErrNoEnt {
+		t.Fatalf("expected NOENT for a file the watcher did not report, got %v", err)
+	}
+}
+
+func TestCaseInsensitiveLookup(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/Foo.txt": "hello"})
+	handler := helpers.NewCachingHandler(helpers.NewCaseInsensitiveHandler(helpers.NewNullAuthHandler(mem)), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+	_, fh, err := target.Lookup("/dir/Foo.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, folded, err := target.Lookup("/dir/foo.TXT")
+	if err != nil {
+		t.Fatalf("expected foo.TXT to find Foo.txt: %v", err)
+	}
+	if !bytes.Equal(fh, folded) {
+		t.Fatalf("expected the handle of Foo.txt, got %x for %x", folded, fh)
+	}
+	if conf := pathconf(t, target, fh); conf.CaseInsensitive != 1 {
+		t.Fatalf("expected case_insensitive, got %+v", conf)
+	}
+
+	// a create of another case opens the existing file, and a rename may
+	// change the case of a name.
+	_, dir, err := target.Lookup("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := create(t, target, dir, "FOO.TXT", createUnchecked, [8]byte{}); r.Status != nfsc.NFS3Ok {
+		t.Fatalf("create failed with %d", r.Status)
+	}
+	if err := target.Rename("/dir/foo.txt", "/dir/FOO.txt"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := mem.ReadDir("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "FOO.txt" {
+		t.Fatalf("expected only FOO.txt in the directory, got %v", entries)
+	}
+}
+
+// lookupHandle issues a LOOKUP of name in the directory dir, returning its
+// status and the handle found.
+func lookupHandle(t *testing.T, target *nfsc.Target, dir []byte, name string) (uint32, []byte) {
+	t.Helper()
+	res, err := target.Call(&struct {
+		rpc.Header
+		Dir  []byte
+		Name string
+	}{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfsc.Nfs3Prog,
+			Vers:    nfsc.Nfs3Vers,
+			Proc:    nfsc.NFSProc3Lookup,
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		Dir:  dir,
+		Name: name,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := xdr.ReadUint32(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != nfsc.NFS3Ok {
+		return status, nil
+	}
+	fh, err := xdr.ReadOpaque(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status, fh
+}
+
+func TestLookupDotEntries(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/sub/file": "hello"})
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+	_, root := mount(t, target, "/")
+	_, dir, err := target.Lookup("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, sub, err := target.Lookup("/dir/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		desc string
+		dir  []byte
+		name string
+		want []byte
+	}{
+		{"dot", dir, ".", dir},
+		{"dot-dot", sub, "..", dir},
+		{"dot-dot of the root", root, "..", root},
+	} {
+		status, fh := lookupHandle(t, target, tc.dir, tc.name)
+		if status != nfsc.NFS3Ok {
+			t.Fatalf("%s: lookup failed with %d", tc.desc, status)
+		}
+		if !bytes.Equal(fh, tc.want) {
+			t.Fatalf("%s: got handle %x, want %x", tc.desc, fh, tc.want)
+		}
+	}
+}
@@ -10,13 +10,12 @@ import (
 )
 
 const (
-	mkdirDefaultMode = 755
+	mkdirDefaultMode = 0755
 )
 
 func onMkdir(ctx context.Context, w *response, userHandle Handler) error {
 	w.errorFmt = wccDataErrorFormatter
-	obj := DirOpArg{}
-	err := xdr.Read(w.req.Body, &obj)
+	obj, err := ReadDirOpArg(w.req.Body)
 	if err != nil {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
@@ -26,7 +25,7 @@ func onMkdir(ctx context.Context, w *response, userHandle Handler) error {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
 
-	fs, path, err := userHandle.FromHandle(obj.Handle)
+	fs, path, err := w.fromHandle(ctx, userHandle, obj.Handle)
 	if err != nil {
 		return &NFSStatusError{NFSStatusStale, err}
 	}
@@ -34,32 +33,34 @@ func onMkdir(ctx context.Context, w *response, userHandle Handler) error {
 		return &NFSStatusError{NFSStatusROFS, os.ErrPermission}
 	}
 
-	if len(string(obj.Filename)) > PathNameMax {
+	if nameTooLong(userHandle, obj.Filename) {
 		return &NFSStatusError{NFSStatusNameTooLong, os.ErrInvalid}
 	}
 	if string(obj.Filename) == "." || string(obj.Filename) == ".." {
 		return &NFSStatusError{NFSStatusExist, os.ErrExist}
 	}
 
+	dirInfo, err := statDir(fs, path)
+	if err != nil {
+		return err
+	}
+	preOpData := w.fileAttribute(userHandle, fs, dirInfo, path).AsCache()
+
 	newFolder := append(path, string(obj.Filename))
 	newFolderPath := fs.Join(newFolder...)
-	if s, err := fs.Stat(newFolderPath); err == nil {
-		if s.IsDir() {
-			return &NFSStatusError{NFSStatusExist, nil}
-		}
-	} else {
-		if s, err := fs.Stat(fs.Join(path...)); err != nil {
-			return &NFSStatusError{NFSStatusAccess, err}
-		} else if !s.IsDir() {
-			return &NFSStatusError{NFSStatusNotDir, nil}
-		}
+	// whatever the name already refers to, a directory or not, is in the
+	// way of the new directory.
+	if _, err := fs.Lstat(newFolderPath); err == nil {
+		return &NFSStatusError{NFSStatusExist, os.ErrExist}
+	}
+	if err := w.Server.checkNameCollision(userHandle, fs, path, string(obj.Filename)); err != nil {
+		return err
 	}
 
 	if err := fs.MkdirAll(newFolderPath, attrs.Mode(mkdirDefaultMode)); err != nil {
-		return &NFSStatusError{NFSStatusAccess, err}
+		return &NFSStatusError{mapError(err), err}
 	}
 
-	fp := userHandle.ToHandle(fs, newFolder)
 	changer := userHandle.Change(fs)
 	if changer != nil {
 		if err := attrs.Apply(changer, fs, newFolderPath); err != nil {
@@ -67,23 +68,22 @@ func onMkdir(ctx context.Context, w *response, userHandle Handler) error {
 		}
 	}
 
+	w.Server.negativeLookups.forget(fs, path, string(obj.Filename))
+	w.notifyChange(userHandle, ChangeCreate, fs, newFolder)
+
 	writer := bytes.NewBuffer([]byte{})
 	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
-	// "handle follows"
-	if err := xdr.Write(writer, uint32(1)); err != nil {
-		return &NFSStatusError{NFSStatusServerFault, err}
-	}
-	if err := xdr.Write(writer, fp); err != nil {
+	if err := writePostOpHandle(writer, w.createdHandle(userHandle, fs, newFolder)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
-	if err := WritePostOpAttrs(writer, tryStat(fs, newFolder)); err != nil {
+	if err := WritePostOpAttrs(writer, w.tryStat(userHandle, fs, newFolder)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
-	if err := WriteWcc(writer, nil, tryStat(fs, path)); err != nil {
+	if err := WriteWcc(writer, preOpData, w.tryStat(userHandle, fs, path)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
@@ -0,0 +1,78 @@
+package nfs_test
+
+import (
+	"fmt"
+	"testing"
+
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+)
+
+func TestMkdir(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/file": "hello"})
+	srv := &nfs.Server{Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+
+	if _, err := target.Mkdir("/dir", 0700); err != nil {
+		t.Fatal(err)
+	}
+	info, err := mem.Stat("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() || info.Mode().Perm() != 0700 {
+		t.Fatalf("created %v, expected a directory with mode 0700", info.Mode())
+	}
+
+	// any entry already under the name is in the way.
+	for _, name := range []string{"/dir", "/file"} {
+		if _, err := target.Mkdir(name, 0755); nfsStatus(err) != nfsc.NFS3ErrExist {
+			t.Fatalf("expected EXIST creating %s, got %v", name, err)
+		}
+	}
+}
+
+func TestMkdirReadOnly(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/file": "hello"})
+	srv := &nfs.Server{Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+	srv.SetReadOnly(true)
+	if _, err := target.Mkdir("/dir", 0755); nfsStatus(err) != nfsc.NFS3ErrROFS {
+		t.Fatalf("expected ROFS from a read-only server, got %v", err)
+	}
+
+	srv = &nfs.Server{Handler: helpers.NewCachingHandler(helpers.Chain(helpers.NewNullAuthHandler(mem), helpers.ReadOnly()), 1024)}
+	target = serveAndMount(t, srv, rpc.AuthNull)
+	if _, err := target.Mkdir("/dir", 0755); nfsStatus(err) != nfsc.NFS3ErrROFS {
+		t.Fatalf("expected ROFS from a read-only export, got %v", err)
+	}
+	if _, err := mem.Stat("/dir"); err == nil {
+		t.Fatal("expected no directory to be created")
+	}
+}
+
+func TestMkdirHandleRateLimit(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/file": "hello"})
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024, helpers.WithHandleRateLimit(1))
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+	before := handler.(*helpers.CachingHandler).Stats().Insertions
+
+	// directories are made whether or not the client is allowed their
+	// handles.
+	const n = 5
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("/dir%d", i)
+		if _, err := target.Mkdir(name, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mem.Stat(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if issued := handler.(*helpers.CachingHandler).Stats().Insertions - before; issued >= n {
+		t.Fatalf("issued %d new handles despite the rate limit", issued)
+	}
+}
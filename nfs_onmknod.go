@@ -26,8 +26,7 @@ const (
 // char, block, socket, or fifo pipe nodes
 func onMknod(ctx context.Context, w *response, userHandle Handler) error {
 	w.errorFmt = wccDataErrorFormatter
-	obj := DirOpArg{}
-	err := xdr.Read(w.req.Body, &obj)
+	obj, err := ReadDirOpArg(w.req.Body)
 	if err != nil {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
@@ -38,7 +37,7 @@ func onMknod(ctx context.Context, w *response, userHandle Handler) error {
 	}
 
 	// see if the filesystem supports mknod
-	fs, path, err := userHandle.FromHandle(obj.Handle)
+	fs, path, err := w.fromHandle(ctx, userHandle, obj.Handle)
 	if err != nil {
 		return &NFSStatusError{NFSStatusStale, err}
 	}
@@ -54,7 +53,7 @@ func onMknod(ctx context.Context, w *response, userHandle Handler) error {
 		return &NFSStatusError{NFSStatusAccess, os.ErrPermission}
 	}
 
-	if len(string(obj.Filename)) > PathNameMax {
+	if nameTooLong(userHandle, obj.Filename) {
 		return &NFSStatusError{NFSStatusNameTooLong, os.ErrInvalid}
 	}
 
@@ -62,13 +61,13 @@ func onMknod(ctx context.Context, w *response, userHandle Handler) error {
 	if _, err := fs.Stat(newFilePath); err == nil {
 		return &NFSStatusError{NFSStatusExist, os.ErrExist}
 	}
-	parent, err := fs.Stat(fs.Join(path...))
+	parent, err := statDir(fs, path)
 	if err != nil {
-		return &NFSStatusError{NFSStatusAccess, err}
-	} else if !parent.IsDir() {
-		return &NFSStatusError{NFSStatusNotDir, nil}
+		return err
+	}
+	if err := w.Server.checkNameCollision(userHandle, fs, path, string(obj.Filename)); err != nil {
+		return err
 	}
-	fp := userHandle.ToHandle(fs, append(path, string(obj.Filename)))
 
 	switch nfs_ftype(ftype) {
 	case FTYPE_NF3CHR, FTYPE_NF3BLK:
@@ -96,7 +95,7 @@ func onMknod(ctx context.Context, w *response, userHandle Handler) error {
 
 		err = cu.Mknod(newFilePath, mode, specData1, specData2)
 		if err != nil {
-			return &NFSStatusError{NFSStatusAccess, err}
+			return &NFSStatusError{mapError(err), err}
 		}
 		if err = attrs.Apply(cu, fs, newFilePath); err != nil {
 			return &NFSStatusError{NFSStatusServerFault, err}
@@ -109,7 +108,7 @@ func onMknod(ctx context.Context, w *response, userHandle Handler) error {
 			return &NFSStatusError{NFSStatusInval, err}
 		}
 		if err := cu.Socket(newFilePath); err != nil {
-			return &NFSStatusError{NFSStatusAccess, err}
+			return &NFSStatusError{mapError(err), err}
 		}
 		if err = attrs.Apply(cu, fs, newFilePath); err != nil {
 			return &NFSStatusError{NFSStatusServerFault, err}
@@ -125,7 +124,7 @@ func onMknod(ctx context.Context, w *response, userHandle Handler) error {
 		mode := uint32(attrs.Mode(parent.Mode())) | syscall.S_IFIFO
 		err = cu.Mkfifo(newFilePath, mode)
 		if err != nil {
-			return &NFSStatusError{NFSStatusAccess, err}
+			return &NFSStatusError{mapError(err), err}
 		}
 		if err = attrs.Apply(cu, fs, newFilePath); err != nil {
 			return &NFSStatusError{NFSStatusServerFault, err}
@@ -136,25 +135,24 @@ func onMknod(ctx context.Context, w *response, userHandle Handler) error {
 		// end of input.
 	}
 
+	w.Server.negativeLookups.forget(fs, path, string(obj.Filename))
+	w.notifyChange(userHandle, ChangeCreate, fs, append(path, string(obj.Filename)))
+
 	writer := bytes.NewBuffer([]byte{})
 	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
-	// "handle follows"
-	if err := xdr.Write(writer, uint32(1)); err != nil {
-		return &NFSStatusError{NFSStatusServerFault, err}
-	}
-	// fh3
-	if err := xdr.Write(writer, fp); err != nil {
+	// post_op_fh3
+	if err := writePostOpHandle(writer, w.createdHandle(userHandle, fs, append(path, string(obj.Filename)))); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 	// attr
-	if err := WritePostOpAttrs(writer, tryStat(fs, append(path, string(obj.Filename)))); err != nil {
+	if err := WritePostOpAttrs(writer, w.tryStat(userHandle, fs, append(path, string(obj.Filename)))); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 	// wcc
-	if err := WriteWcc(writer, nil, tryStat(fs, path)); err != nil {
+	if err := WriteWcc(writer, nil, w.tryStat(userHandle, fs, path)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
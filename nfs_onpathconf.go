@@ -3,19 +3,105 @@ package nfs
 import (
 	"bytes"
 	"context"
+	"unicode/utf8"
 
+	"github.com/go-git/go-billy/v5"
 	"github.com/willscott/go-nfs-client/nfs/xdr"
 )
 
-// PathNameMax is the maximum length for a file name
+// PathNameMax is the default maximum length for a file name
 const PathNameMax = 255
 
+// NameLengthHandler is implemented by handlers whose backend limits file
+// names to other than PathNameMax, in the handler's NameLengthUnit.
+type NameLengthHandler interface {
+	MaxNameLength() int
+}
+
+// maxNameLength returns the longest file name userHandle accepts.
+func maxNameLength(userHandle Handler) int {
+	if nh, ok := userHandle.(NameLengthHandler); ok {
+		if n := nh.MaxNameLength(); n > 0 {
+			return n
+		}
+	}
+	return PathNameMax
+}
+
+// NameLengthUnit is what the length of a file name is measured in, both
+// against the limit a NameLengthHandler sets and as PATHCONF advertises it
+// in name_max.
+type NameLengthUnit int
+
+// NameLengthUnit Codes
+const (
+	// NameLengthBytes measures names in bytes, as POSIX file systems limit
+	// them. It is the default.
+	NameLengthBytes NameLengthUnit = iota
+	// NameLengthRunes measures names in UTF-8 characters, as backends
+	// limiting names to a number of characters, such as NTFS or many object
+	// stores, do. A byte that is not valid UTF-8 counts as a character.
+	NameLengthRunes
+)
+
+// NameLengthUnitHandler is implemented by handlers whose backend measures
+// the length of file names in other than bytes.
+type NameLengthUnitHandler interface {
+	NameLengthUnit() NameLengthUnit
+}
+
+// nameTooLong reports whether name is longer than userHandle accepts, in
+// the unit it measures names in.
+func nameTooLong(userHandle Handler, name []byte) bool {
+	n := len(name)
+	if uh, ok := userHandle.(NameLengthUnitHandler); ok && uh.NameLengthUnit() == NameLengthRunes {
+		n = utf8.RuneCount(name)
+	}
+	return n > maxNameLength(userHandle)
+}
+
+// SymlinkMax is the default maximum length for the target of a symlink
+const SymlinkMax = 4096
+
+// SymlinkLengthHandler is implemented by handlers whose backend limits the
+// targets of symlinks to other than SymlinkMax bytes.
+type SymlinkLengthHandler interface {
+	MaxSymlinkLength() int
+}
+
+// maxSymlinkLength returns the longest symlink target userHandle accepts.
+func maxSymlinkLength(userHandle Handler) int {
+	if sh, ok := userHandle.(SymlinkLengthHandler); ok {
+		if n := sh.MaxSymlinkLength(); n > 0 {
+			return n
+		}
+	}
+	return SymlinkMax
+}
+
+// CaseInsensitiveHandler is implemented by handlers whose backends may
+// treat names differing only in case as the same name, as PATHCONF tells
+// clients. Such backends are taken to keep the case names are created
+// with. LOOKUP, CREATE and RENAME resolve a name to an existing entry
+// differing from it only in case, and other creates refuse such a name, so
+// that even a case-sensitive backend behaves as one that is not.
+type CaseInsensitiveHandler interface {
+	CaseInsensitive(fs billy.Filesystem) bool
+}
+
+// caseInsensitive reports whether userHandle matches names in fs without
+// regard to case.
+func caseInsensitive(userHandle Handler, fs billy.Filesystem) bool {
+	ch, ok := userHandle.(CaseInsensitiveHandler)
+	return ok && ch.CaseInsensitive(fs)
+}
+
 func onPathConf(ctx context.Context, w *response, userHandle Handler) error {
-	roothandle, err := xdr.ReadOpaque(w.req.Body)
-	if err != nil {
+	var handle []byte
+	if err := xdr.Read(w.req.Body, &handle); err != nil {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
-	fs, path, err := userHandle.FromHandle(roothandle)
+	fs, path, err := w.fromHandle(ctx, userHandle, handle)
 	if err != nil {
 		return &NFSStatusError{NFSStatusStale, err}
 	}
@@ -24,7 +110,7 @@ func onPathConf(ctx context.Context, w *response, userHandle Handler) error {
 	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
-	if err := WritePostOpAttrs(writer, tryStat(fs, path)); err != nil {
+	if err := WritePostOpAttrs(writer, w.tryStat(userHandle, fs, path)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
@@ -37,15 +123,20 @@ func onPathConf(ctx context.Context, w *response, userHandle Handler) error {
 		CasePreserving  uint32
 	}
 
-	defaults := PathConf{
+	// names longer than NameMax are refused with NFS3ERR_NAMETOOLONG,
+	// never truncated. NameMax is in the handler's NameLengthUnit, so
+	// that it is what names are checked against.
+	conf := PathConf{
 		LinkMax:         1,
-		NameMax:         PathNameMax,
+		NameMax:         uint32(maxNameLength(userHandle)),
 		NoTrunc:         1,
 		ChownRestricted: 0,
-		CaseInsensitive: 0,
 		CasePreserving:  1,
 	}
-	if err := xdr.Write(writer, defaults); err != nil {
+	if caseInsensitive(userHandle, fs) {
+		conf.CaseInsensitive = 1
+	}
+	if err := xdr.Write(writer, conf); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 	if err := w.Write(writer.Bytes()); err != nil {
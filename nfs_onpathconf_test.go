@@ -0,0 +1,231 @@
+package nfs_test
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+func TestMaxNameLength(t *testing.T) {
+	for _, limit := range []int{0, 8} {
+		max := limit
+		if max == 0 {
+			max = nfs.PathNameMax
+		}
+		mem := newTestFS(t, map[string]string{"/file": "hello"})
+		handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024, helpers.WithMaxNameLength(limit))
+		target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+		fits := strings.Repeat("a", max)
+		long := strings.Repeat("b", max+1)
+
+		if _, err := target.Create("/"+fits, 0666); err != nil {
+			t.Fatalf("limit %d: create at the limit failed: %v", max, err)
+		}
+		if _, err := target.Create("/"+long, 0666); nfsStatus(err) != nfsc.NFS3ErrNameTooLong {
+			t.Fatalf("limit %d: expected NAMETOOLONG creating a longer name, got %v", max, err)
+		}
+		if _, err := target.Mkdir("/d"+fits[1:], 0755); err != nil {
+			t.Fatalf("limit %d: mkdir at the limit failed: %v", max, err)
+		}
+		if _, err := target.Mkdir("/"+long, 0755); nfsStatus(err) != nfsc.NFS3ErrNameTooLong {
+			t.Fatalf("limit %d: expected NAMETOOLONG making a longer directory, got %v", max, err)
+		}
+		if err := target.Rename("/file", "/"+long); nfsStatus(err) != nfsc.NFS3ErrNameTooLong {
+			t.Fatalf("limit %d: expected NAMETOOLONG renaming to a longer name, got %v", max, err)
+		}
+		if err := target.Rename("/file", "/r"+fits[1:]); err != nil {
+			t.Fatalf("limit %d: rename to the limit failed: %v", max, err)
+		}
+	}
+}
+
+func TestNameLengthUnit(t *testing.T) {
+	for _, tc := range []struct {
+		unit     nfs.NameLengthUnit
+		accepted []string
+		refused  []string
+	}{
+		// 8 bytes fit four two-byte characters, but not four three-byte ones.
+		{nfs.NameLengthBytes, []string{"éééé", "aaaaaaaa", "日本a"}, []string{"ééééa", "日本語字", "aaaaaaaaa"}},
+		// 8 runes fit eight characters of any width.
+		{nfs.NameLengthRunes, []string{"ééééa", "日本語字日本語字", "aaaaaaaa"}, []string{"ééééééééé", "日本語字日本語字a", "aaaaaaaaa"}},
+	} {
+		mem := newTestFS(t, map[string]string{"/file": "hello"})
+		handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024,
+			helpers.WithMaxNameLength(8), helpers.WithNameLengthUnit(tc.unit))
+		target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+		_, fh, err := target.Lookup("/file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conf := pathconf(t, target, fh); conf.NameMax != 8 {
+			t.Fatalf("unit %d: advertised name_max %d, want 8", tc.unit, conf.NameMax)
+		}
+
+		for _, name := range tc.accepted {
+			if _, err := target.Create("/"+name, 0666); err != nil {
+				t.Fatalf("unit %d: create of %q failed: %v", tc.unit, name, err)
+			}
+			if err := target.Remove("/" + name); err != nil {
+				t.Fatalf("unit %d: remove of %q failed: %v", tc.unit, name, err)
+			}
+			if err := target.Rename("/file", "/"+name); err != nil {
+				t.Fatalf("unit %d: rename to %q failed: %v", tc.unit, name, err)
+			}
+			if err := target.Rename("/"+name, "/file"); err != nil {
+				t.Fatalf("unit %d: rename from %q failed: %v", tc.unit, name, err)
+			}
+		}
+		for _, name := range tc.refused {
+			if _, err := target.Create("/"+name, 0666); nfsStatus(err) != nfsc.NFS3ErrNameTooLong {
+				t.Fatalf("unit %d: expected NAMETOOLONG creating %q, got %v", tc.unit, name, err)
+			}
+			if _, err := target.Mkdir("/"+name, 0755); nfsStatus(err) != nfsc.NFS3ErrNameTooLong {
+				t.Fatalf("unit %d: expected NAMETOOLONG making directory %q, got %v", tc.unit, name, err)
+			}
+			if err := target.Rename("/file", "/"+name); nfsStatus(err) != nfsc.NFS3ErrNameTooLong {
+				t.Fatalf("unit %d: expected NAMETOOLONG renaming to %q, got %v", tc.unit, name, err)
+			}
+		}
+	}
+}
+
+// shortLinkFS refuses symlink targets longer than max, as a backend with a
+// smaller limit than it reports would.
+type shortLinkFS struct {
+	billy.Filesystem
+	max int
+}
+
+func (f shortLinkFS) Symlink(target, link string) error {
+	if len(target) > f.max {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: syscall.ENAMETOOLONG}
+	}
+	return f.Filesystem.Symlink(target, link)
+}
+
+func TestMaxSymlinkLength(t *testing.T) {
+	for _, limit := range []int{0, 16} {
+		max := limit
+		if max == 0 {
+			max = nfs.SymlinkMax
+		}
+		mem := newTestFS(t, map[string]string{"/file": "hello"})
+		handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024, helpers.WithMaxSymlinkLength(limit))
+		target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+		if err := target.Symlink(strings.Repeat("a", max), "/fits"); err != nil {
+			t.Fatalf("limit %d: symlink to a target at the limit failed: %v", max, err)
+		}
+		if err := target.Symlink(strings.Repeat("b", max+1), "/long"); nfsStatus(err) != nfsc.NFS3ErrNameTooLong {
+			t.Fatalf("limit %d: expected NAMETOOLONG for a longer target, got %v", max, err)
+		}
+	}
+
+	// the backend's own refusal is reported the same way.
+	mem := shortLinkFS{newTestFS(t, map[string]string{"/file": "hello"}), 16}
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+	if err := target.Symlink(strings.Repeat("c", 17), "/long"); nfsStatus(err) != nfsc.NFS3ErrNameTooLong {
+		t.Fatalf("expected NAMETOOLONG when the backend refuses a target, got %v", err)
+	}
+}
+
+// caseInsensitiveHandler reports its backend as matching names without
+// regard to case.
+type caseInsensitiveHandler struct {
+	nfs.Handler
+}
+
+func (caseInsensitiveHandler) CaseInsensitive(fs billy.Filesystem) bool {
+	return true
+}
+
+type pathConf struct {
+	Status          uint32
+	Attr            nfsc.PostOpAttr
+	LinkMax         uint32
+	NameMax         uint32
+	NoTrunc         uint32
+	ChownRestricted uint32
+	CaseInsensitive uint32
+	CasePreserving  uint32
+}
+
+// pathconf issues a PATHCONF of the file fh.
+func pathconf(t *testing.T, target *nfsc.Target, fh []byte) pathConf {
+	t.Helper()
+	res, err := target.Call(&struct {
+		rpc.Header
+		FH []byte
+	}{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfsc.Nfs3Prog,
+			Vers:    nfsc.Nfs3Vers,
+			Proc:    uint32(nfs.NFSProcedurePathConf),
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		FH: fh,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply pathConf
+	if err := xdr.Read(res, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Status != nfsc.NFS3Ok {
+		t.Fatalf("pathconf failed with status %d", reply.Status)
+	}
+	return reply
+}
+
+func TestPathConf(t *testing.T) {
+	for _, tc := range []struct {
+		limit       int
+		insensitive bool
+	}{
+		{0, false},
+		{8, false},
+		{0, true},
+	} {
+		mem := newTestFS(t, map[string]string{"/file": "hello"})
+		var inner nfs.Handler = helpers.NewNullAuthHandler(mem)
+		if tc.insensitive {
+			inner = caseInsensitiveHandler{inner}
+		}
+		handler := helpers.NewCachingHandler(inner, 1024, helpers.WithMaxNameLength(tc.limit))
+		target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+		_, fh, err := target.Lookup("/file")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conf := pathconf(t, target, fh)
+		max := tc.limit
+		if max == 0 {
+			max = nfs.PathNameMax
+		}
+		if conf.NameMax != uint32(max) {
+			t.Fatalf("advertised name_max %d, want %d", conf.NameMax, max)
+		}
+		if conf.NoTrunc != 1 || conf.CasePreserving != 1 {
+			t.Fatalf("expected no_trunc and case_preserving, got %+v", conf)
+		}
+		if (conf.CaseInsensitive == 1) != tc.insensitive {
+			t.Fatalf("advertised case_insensitive %d for an insensitive backend: %v", conf.CaseInsensitive, tc.insensitive)
+		}
+	}
+}
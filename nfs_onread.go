@@ -6,10 +6,104 @@ import (
 	"errors"
 	"io"
 	"os"
+	"time"
 
+	"github.com/go-git/go-billy/v5"
 	"github.com/willscott/go-nfs-client/nfs/xdr"
+	"github.com/willscott/go-nfs/file"
 )
 
+// AtimeMode is when READ advances the access time of the file it reads.
+type AtimeMode int
+
+// AtimeMode Codes
+const (
+	// AtimeNever leaves access times alone, as the noatime mount option
+	// does, sparing the backend a write for every read. It is the default.
+	AtimeNever AtimeMode = iota
+	// AtimeRelative advances the access time of a file only while it is no
+	// later than the file's modification or change time, or is a day old,
+	// as the relatime mount option does.
+	AtimeRelative
+	// AtimeStrict advances the access time on every read, as the
+	// strictatime mount option does.
+	AtimeStrict
+)
+
+// AtimeHandler is implemented by handlers choosing whether reads of the
+// files in fs advance their access times, which they do through the
+// Chtimes of fs's Change.
+type AtimeHandler interface {
+	AtimeUpdates(fs billy.Filesystem) AtimeMode
+}
+
+const (
+	// relatimeInterval is how old an access time may grow before a read
+	// advances it under AtimeRelative.
+	relatimeInterval = 24 * time.Hour
+	// relatimeCtimeSlack is how much later than the access time the change
+	// time must be to count as a change since the last read, as setting
+	// the access time through Chtimes moves the change time too.
+	relatimeCtimeSlack = time.Second
+)
+
+// atimeDue reports whether a read at now of a file whose stat is info
+// advances its access time under mode.
+func atimeDue(mode AtimeMode, info os.FileInfo, now time.Time) bool {
+	switch mode {
+	case AtimeStrict:
+		return true
+	case AtimeRelative:
+		// without the access time from stat, it is taken to be as old as
+		// the last write, which makes a read due to advance it.
+		mtime := info.ModTime()
+		atime, ctime := mtime, mtime
+		if a := file.GetInfo(info); a != nil && !a.Atime.IsZero() {
+			atime, ctime = a.Atime, a.Ctime
+		}
+		return !atime.After(mtime) ||
+			ctime.Sub(atime) > relatimeCtimeSlack ||
+			now.Sub(atime) >= relatimeInterval
+	}
+	return false
+}
+
+// updateAtime advances the access time of the file at path, whose
+// attributes attrs are, after it has been read, if userHandle's
+// AtimeUpdates has reads do so. attrs is updated to match.
+func (w *response) updateAtime(userHandle Handler, fs billy.Filesystem, path []string, attrs *FileAttribute) {
+	ah, ok := userHandle.(AtimeHandler)
+	if !ok || attrs == nil {
+		return
+	}
+	if w.Server.ReadOnly() || !billy.CapabilityCheck(fs, billy.WriteCapability) {
+		return
+	}
+	mode := ah.AtimeUpdates(fs)
+	if mode == AtimeNever {
+		return
+	}
+	// attrs may hold a truncated mtime, which is not to be written back.
+	p := fs.Join(path...)
+	info, err := fs.Stat(p)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	if !atimeDue(mode, info, now) {
+		return
+	}
+	changer := userHandle.Change(fs)
+	if changer == nil {
+		return
+	}
+	if err := changer.Chtimes(p, now, info.ModTime()); err != nil {
+		w.logger().Debugf("failed to update the access time of %s: %v", p, err)
+		return
+	}
+	attrs.Atime = ToNFSTime(now).Truncate(timeGranularity(userHandle, fs))
+}
+
 type nfsReadArgs struct {
 	Handle []byte
 	Offset uint64
@@ -22,7 +116,8 @@ type nfsReadResponse struct {
 	Data  []byte
 }
 
-// MaxRead is the advertised largest buffer the server is willing to read
+// MaxRead is the largest buffer the server is willing to read, whatever its
+// MaxTransferSize.
 const MaxRead = 1 << 24
 
 // CheckRead is a size where - if a request to read is larger than this,
@@ -30,6 +125,56 @@ const MaxRead = 1 << 24
 // a buffer to read into.
 const CheckRead = 1 << 15
 
+// SparseFile is implemented by billy files that can locate the holes in a
+// sparse file, letting READ fill known holes with zeros without reading
+// them from the backend. Both methods follow lseek's SEEK_DATA and
+// SEEK_HOLE, returning an error when there is no data (or hole) at or past
+// offset.
+type SparseFile interface {
+	io.ReaderAt
+	SeekData(offset int64) (int64, error)
+	SeekHole(offset int64) (int64, error)
+}
+
+// readSparse behaves like f.ReadAt for a file of the given size, but only
+// reads its data regions. buf must be zeroed.
+func readSparse(f SparseFile, size int64, buf []byte, off int64) (int, error) {
+	if off >= size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(buf))
+	if end > size {
+		end = size
+	}
+	for pos := off; pos < end; {
+		data, err := f.SeekData(pos)
+		if err != nil || data >= end {
+			// the rest of the range is a hole.
+			break
+		}
+		hole, err := f.SeekHole(data)
+		if err != nil || hole > end {
+			hole = end
+		}
+		if hole <= data {
+			break
+		}
+		n, err := f.ReadAt(buf[data-off:hole-off], data)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return int(data-off) + n, err
+		}
+		if int64(n) < hole-data {
+			// the file ended early.
+			return int(data-off) + n, io.EOF
+		}
+		pos = hole
+	}
+	if end == size {
+		return int(end - off), io.EOF
+	}
+	return int(end - off), nil
+}
+
 func onRead(ctx context.Context, w *response, userHandle Handler) error {
 	w.errorFmt = opAttrErrorFormatter
 	var obj nfsReadArgs
@@ -37,17 +182,52 @@ func onRead(ctx context.Context, w *response, userHandle Handler) error {
 	if err != nil {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
-	fs, path, err := userHandle.FromHandle(obj.Handle)
+	fs, path, err := w.fromHandle(ctx, userHandle, obj.Handle)
 	if err != nil {
 		return &NFSStatusError{NFSStatusStale, err}
 	}
+	if sent, err := w.readZeroCopy(ctx, userHandle, fs, path, obj); sent || err != nil {
+		return err
+	}
+	postOp, resp, err := w.readFile(ctx, userHandle, fs, path, obj)
+	if err != nil {
+		return err
+	}
+	defer w.Server.putBuffer(resp.Data)
+
+	writer := bytes.NewBuffer([]byte{})
+	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	if err := WritePostOpAttrs(writer, postOp); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+
+	if err := xdr.Write(writer, resp); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	if err := w.Write(writer.Bytes()); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	return nil
+}
+
+// readFile reads the range of the file at path that obj asks for,
+// returning it with the file's attributes after the read. The data is in a
+// buffer for putBuffer once it has been written out.
+func (w *response) readFile(ctx context.Context, userHandle Handler, fs billy.Filesystem, path []string, obj nfsReadArgs) (*FileAttribute, nfsReadResponse, error) {
+	if err := checkRangeLock(ctx, userHandle, fs, path, obj.Offset, uint64(obj.Count), false); err != nil {
+		return nil, nfsReadResponse{}, err
+	}
+	release, err := w.admit(ctx, fs, path)
+	if err != nil {
+		return nil, nfsReadResponse{}, err
+	}
+	defer release()
 
 	fh, err := fs.Open(fs.Join(path...))
 	if err != nil {
-		if os.IsNotExist(err) {
-			return &NFSStatusError{NFSStatusNoEnt, err}
-		}
-		return &NFSStatusError{NFSStatusAccess, err}
+		return nil, nfsReadResponse{}, &NFSStatusError{mapError(err), err}
 	}
 	defer fh.Close()
 
@@ -56,40 +236,64 @@ func onRead(ctx context.Context, w *response, userHandle Handler) error {
 	if obj.Count > CheckRead {
 		info, err := fs.Stat(fs.Join(path...))
 		if err != nil {
-			return &NFSStatusError{NFSStatusAccess, err}
+			return nil, resp, &NFSStatusError{mapError(err), err}
 		}
-		if info.Size()-int64(obj.Offset) < int64(obj.Count) {
+		if uint64(info.Size()) <= obj.Offset {
+			obj.Count = 0
+		} else if info.Size()-int64(obj.Offset) < int64(obj.Count) {
 			obj.Count = uint32(uint64(info.Size()) - obj.Offset)
 		}
 	}
-	if obj.Count > MaxRead {
-		obj.Count = MaxRead
+	if max := w.maxTransferSize(); obj.Count > max {
+		obj.Count = max
+	}
+	resp.Data = w.Server.getBuffer(obj.Count)
+	var cnt int
+	if sparse, ok := fh.(SparseFile); ok {
+		info, statErr := fs.Stat(fs.Join(path...))
+		if statErr != nil {
+			w.Server.putBuffer(resp.Data)
+			return nil, nfsReadResponse{}, &NFSStatusError{NFSStatusAccess, statErr}
+		}
+		for i := range resp.Data {
+			resp.Data[i] = 0
+		}
+		cnt, err = readSparse(sparse, info.Size(), resp.Data, int64(obj.Offset))
+	} else {
+		// todo: multiple reads if size isn't full
+		cnt, err = fh.ReadAt(resp.Data, int64(obj.Offset))
 	}
-	resp.Data = make([]byte, obj.Count)
-	// todo: multiple reads if size isn't full
-	cnt, err := fh.ReadAt(resp.Data, int64(obj.Offset))
 	if err != nil && !errors.Is(err, io.EOF) {
-		return &NFSStatusError{NFSStatusIO, err}
+		w.Server.putBuffer(resp.Data)
+		return nil, nfsReadResponse{}, &NFSStatusError{NFSStatusIO, err}
 	}
-	resp.Count = uint32(cnt)
-	resp.Data = resp.Data[:resp.Count]
 	if errors.Is(err, io.EOF) {
 		resp.EOF = 1
 	}
 
-	writer := bytes.NewBuffer([]byte{})
-	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
-		return &NFSStatusError{NFSStatusServerFault, err}
-	}
-	if err := WritePostOpAttrs(writer, tryStat(fs, path)); err != nil {
-		return &NFSStatusError{NFSStatusServerFault, err}
-	}
-
-	if err := xdr.Write(writer, resp); err != nil {
-		return &NFSStatusError{NFSStatusServerFault, err}
+	// The file may have been truncated since its size was checked; never
+	// return data beyond its current end.
+	postOp := w.tryStat(userHandle, fs, path)
+	w.updateAtime(userHandle, fs, path, postOp)
+	if postOp != nil {
+		if obj.Offset+uint64(cnt) > postOp.Filesize {
+			cnt = 0
+			if postOp.Filesize > obj.Offset {
+				cnt = int(postOp.Filesize - obj.Offset)
+			}
+		}
+		// backends need not report reading up to the end as io.EOF, nor
+		// do empty reads; clients rely on eof to stop reading.
+		resp.EOF = 0
+		if obj.Offset+uint64(cnt) >= postOp.Filesize {
+			resp.EOF = 1
+		}
 	}
-	if err := w.Write(writer.Bytes()); err != nil {
-		return &NFSStatusError{NFSStatusServerFault, err}
+	resp.Count = uint32(cnt)
+	resp.Data = resp.Data[:resp.Count]
+	if resp.EOF == 0 {
+		w.readAhead(fs, path, obj.Handle, obj.Offset, obj.Offset+uint64(cnt))
 	}
-	return nil
+	w.pace(ctx, cnt)
+	return postOp, resp, nil
 }
@@ -0,0 +1,820 @@
+package nfs_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/file"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// truncateOnReadFS truncates a file to truncateTo just before its contents
+// are read, as if a concurrent SETATTR landed between READ's size check and
+// the read itself. Reads beyond the new end are zero-filled rather than
+// short, as some sparse-file backends do.
+type truncateOnReadFS struct {
+	billy.Filesystem
+	truncateTo int64
+}
+
+func (t *truncateOnReadFS) Open(filename string) (billy.File, error) {
+	return t.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (t *truncateOnReadFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := t.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil || flag != os.O_RDONLY {
+		return f, err
+	}
+	return &truncateOnReadFile{File: f, fs: t.Filesystem, name: filename, size: t.truncateTo}, nil
+}
+
+type truncateOnReadFile struct {
+	billy.File
+	fs   billy.Filesystem
+	name string
+	size int64
+}
+
+func (f *truncateOnReadFile) ReadAt(p []byte, off int64) (int, error) {
+	w, err := f.fs.OpenFile(f.name, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	if err := w.Truncate(f.size); err != nil {
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+
+	n, err := f.File.ReadAt(p, off)
+	if err != nil && err != io.EOF {
+		return n, err
+	}
+	for i := n; i < len(p); i++ {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestReadConcurrentTruncate(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/file": "hello world"})
+	fs := &truncateOnReadFS{Filesystem: mem, truncateTo: 5}
+	srv := &nfs.Server{Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024)}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+
+	f, err := target.Open("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 100)
+	n, err := f.ReadAt(buf, 0)
+	if err != io.EOF {
+		t.Fatalf("expected eof reading a truncated file, got %v", err)
+	}
+	if !bytes.Equal(buf[:n], []byte("hello")) {
+		t.Fatalf("expected only the bytes left after truncation, got %q", buf[:n])
+	}
+}
+
+// sparseFS reports [holeStart, holeEnd) of every file it opens as a hole and
+// records the ranges actually read from the backend.
+type sparseFS struct {
+	billy.Filesystem
+	holeStart, holeEnd int64
+	reads              [][2]int64
+}
+
+func (s *sparseFS) Open(filename string) (billy.File, error) {
+	return s.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (s *sparseFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := s.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil || flag != os.O_RDONLY {
+		return f, err
+	}
+	info, err := s.Filesystem.Stat(filename)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &sparseFile{File: f, fs: s, size: info.Size()}, nil
+}
+
+type sparseFile struct {
+	billy.File
+	fs   *sparseFS
+	size int64
+}
+
+func (f *sparseFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.reads = append(f.fs.reads, [2]int64{off, off + int64(len(p))})
+	return f.File.ReadAt(p, off)
+}
+
+func (f *sparseFile) SeekData(offset int64) (int64, error) {
+	if offset >= f.fs.holeStart && offset < f.fs.holeEnd {
+		offset = f.fs.holeEnd
+	}
+	if offset >= f.size {
+		return 0, io.EOF
+	}
+	return offset, nil
+}
+
+func (f *sparseFile) SeekHole(offset int64) (int64, error) {
+	if offset < f.fs.holeStart {
+		return f.fs.holeStart, nil
+	}
+	if offset < f.fs.holeEnd {
+		return offset, nil
+	}
+	return f.size, nil
+}
+
+func TestReadSparse(t *testing.T) {
+	// The backend holds non-zero bytes inside the reported hole so that
+	// reading them would show up in the reply.
+	mem := newTestFS(t, map[string]string{"/file": "headXXXXXXXXtail"})
+	fs := &sparseFS{Filesystem: mem, holeStart: 4, holeEnd: 12}
+	srv := &nfs.Server{Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024)}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+
+	f, err := target.Open("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 100)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	expected := []byte("head\x00\x00\x00\x00\x00\x00\x00\x00tail")
+	if !bytes.Equal(buf[:n], expected) {
+		t.Fatalf("expected hole to read as zeros, got %q", buf[:n])
+	}
+	for _, r := range fs.reads {
+		if r[0] < fs.holeEnd && r[1] > fs.holeStart {
+			t.Fatalf("backend read [%d, %d) overlaps the hole", r[0], r[1])
+		}
+	}
+}
+
+type readReply struct {
+	Status uint32
+	Attrs  nfsc.PostOpAttr
+	Count  uint32
+	EOF    bool
+	Data   []byte
+}
+
+// read issues a READ of count bytes at offset.
+func read(t testing.TB, target *nfsc.Target, fh []byte, offset uint64, count uint32) readReply {
+	t.Helper()
+	reply, err := tryRead(target, fh, offset, count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+// tryRead is read, returning what fails rather than failing the test, for
+// reads made off the test's goroutine.
+func tryRead(target *nfsc.Target, fh []byte, offset uint64, count uint32) (readReply, error) {
+	res, err := target.Call(&struct {
+		rpc.Header
+		FH     []byte
+		Offset uint64
+		Count  uint32
+	}{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfsc.Nfs3Prog,
+			Vers:    nfsc.Nfs3Vers,
+			Proc:    nfsc.NFSProc3Read,
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		FH:     fh,
+		Offset: offset,
+		Count:  count,
+	})
+	if err != nil {
+		return readReply{}, err
+	}
+	var reply readReply
+	if err := xdr.Read(res, &reply); err != nil {
+		return readReply{}, err
+	}
+	if reply.Status != nfsc.NFS3Ok {
+		return readReply{}, fmt.Errorf("read failed with status %d", reply.Status)
+	}
+	return reply, nil
+}
+
+func TestReadEOF(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/file": "hello", "/empty": ""})
+	srv := &nfs.Server{Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+
+	_, file, err := target.Lookup("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, empty, err := target.Lookup("/empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		fh     []byte
+		offset uint64
+		count  uint32
+		data   string
+		eof    bool
+	}{
+		{"short of the end", file, 0, 3, "hel", false},
+		{"exactly to the end", file, 0, 5, "hello", true},
+		{"across the end", file, 3, 10, "lo", true},
+		{"at the end", file, 5, 10, "", true},
+		{"past the end", file, 9, 10, "", true},
+		{"large past the end", file, 9, 1 << 20, "", true},
+		{"empty file", empty, 0, 10, "", true},
+		{"nothing from an empty file", empty, 0, 0, "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reply := read(t, target, tc.fh, tc.offset, tc.count)
+			if string(reply.Data) != tc.data || reply.Count != uint32(len(tc.data)) {
+				t.Fatalf("expected %q, got %d bytes %q", tc.data, reply.Count, reply.Data)
+			}
+			if reply.EOF != tc.eof {
+				t.Fatalf("expected eof %v, got %v", tc.eof, reply.EOF)
+			}
+		})
+	}
+}
+
+// bufferSizeFS records the largest buffer any of its files is read into.
+type bufferSizeFS struct {
+	billy.Filesystem
+	mu      sync.Mutex
+	largest int
+}
+
+func (b *bufferSizeFS) Open(filename string) (billy.File, error) {
+	return b.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (b *bufferSizeFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := b.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &bufferSizeFile{File: f, fs: b}, nil
+}
+
+type bufferSizeFile struct {
+	billy.File
+	fs *bufferSizeFS
+}
+
+func (f *bufferSizeFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	if len(p) > f.fs.largest {
+		f.fs.largest = len(p)
+	}
+	f.fs.mu.Unlock()
+	return f.File.ReadAt(p, off)
+}
+
+func TestMaxTransferSize(t *testing.T) {
+	const limit = 4096
+	contents := strings.Repeat("x", 3*limit)
+	fs := &bufferSizeFS{Filesystem: newTestFS(t, map[string]string{"/file": contents})}
+	srv := &nfs.Server{
+		Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024),
+		ServerOptions: nfs.ServerOptions{MaxTransferSize: limit},
+	}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+
+	_, fh, err := target.Lookup("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := target.Call(&struct {
+		rpc.Header
+		FH []byte
+	}{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfsc.Nfs3Prog,
+			Vers:    nfsc.Nfs3Vers,
+			Proc:    nfsc.NFSProc3FSInfo,
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		FH: fh,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fsinfo struct {
+		Status                               uint32
+		Attrs                                nfsc.PostOpAttr
+		Rtmax, Rtpref, Rtmult, Wtmax, Wtpref uint32
+	}
+	if err := xdr.Read(res, &fsinfo); err != nil {
+		t.Fatal(err)
+	}
+	if fsinfo.Rtmax != limit || fsinfo.Wtmax != limit || fsinfo.Rtpref > limit || fsinfo.Wtpref > limit {
+		t.Fatalf("expected FSINFO to advertise %d, got %+v", limit, fsinfo)
+	}
+
+	reply := read(t, target, fh, 0, 1<<30)
+	if reply.Count != limit || reply.EOF {
+		t.Fatalf("expected the read clamped to %d bytes, got %d (eof %v)", limit, reply.Count, reply.EOF)
+	}
+	if fs.largest > limit {
+		t.Fatalf("read into a %d byte buffer", fs.largest)
+	}
+
+	if written := tryWriteAt(t, target, fh, 0, make([]byte, 2*limit), 2); written.Count != limit {
+		t.Fatalf("expected the write clamped to %d bytes, got %d", limit, written.Count)
+	}
+}
+
+func TestReadBufferReuse(t *testing.T) {
+	const clients, files, rounds = 4, 8, 20
+	contents := make(map[string]string, files)
+	for i := 0; i < files; i++ {
+		contents[fmt.Sprintf("/file%d", i)] = strings.Repeat(string(rune('a'+i)), 8192+i)
+	}
+	mem := newTestFS(t, contents)
+	srv := &nfs.Server{
+		Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024),
+		ServerOptions: nfs.ServerOptions{MaxTransferSize: 16384},
+	}
+
+	// each client has its own connection, so their reads are served at once.
+	var wg sync.WaitGroup
+	errs := make(chan error, clients)
+	for c := 0; c < clients; c++ {
+		target := serveAndMount(t, srv, rpc.AuthNull)
+		handles := make(map[string][]byte, files)
+		for name := range contents {
+			_, fh, err := target.Lookup(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			handles[name] = fh
+		}
+		wg.Add(1)
+		go func(target *nfsc.Target) {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				for name, fh := range handles {
+					reply, err := tryRead(target, fh, 0, 16384)
+					if err != nil {
+						errs <- err
+						return
+					}
+					if string(reply.Data) != contents[name] {
+						errs <- fmt.Errorf("%s read back corrupted", name)
+						return
+					}
+				}
+			}
+		}(target)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	mem := newTestFS(b, map[string]string{"/file": strings.Repeat("x", 64<<10)})
+	srv := &nfs.Server{Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)}
+	target := serveAndMount(b, srv, rpc.AuthNull)
+	_, fh, err := target.Lookup("/file")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		read(b, target, fh, 0, 64<<10)
+	}
+}
+
+// serveOSFile serves a directory holding file, with its contents, from the
+// operating system's file system.
+func serveOSFile(t testing.TB, contents []byte, zeroCopy bool) (*nfsc.Target, []byte) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), contents, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fs := osfs.New(dir, osfs.WithBoundOS())
+	srv := &nfs.Server{
+		Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024),
+		ServerOptions: nfs.ServerOptions{ZeroCopyRead: zeroCopy},
+	}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+	_, fh, err := target.Lookup("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return target, fh
+}
+
+func TestZeroCopyRead(t *testing.T) {
+	// an odd size, so that the data needs padding.
+	contents := make([]byte, 100001)
+	for i := range contents {
+		contents[i] = byte(i % 251)
+	}
+	target, fh := serveOSFile(t, contents, true)
+
+	for _, tc := range []struct {
+		offset uint64
+		count  uint32
+		eof    bool
+	}{
+		{0, 4096, false},
+		{1, 65536, false},
+		{65537, 65536, true},
+		{100001, 4096, true},
+		{200000, 4096, true},
+	} {
+		reply := read(t, target, fh, tc.offset, tc.count)
+		want := []byte{}
+		if tc.offset < uint64(len(contents)) {
+			want = contents[tc.offset:]
+			if len(want) > int(tc.count) {
+				want = want[:tc.count]
+			}
+		}
+		if reply.Count != uint32(len(want)) || !bytes.Equal(reply.Data, want) {
+			t.Fatalf("read of %d at %d: got %d bytes, want %d", tc.count, tc.offset, reply.Count, len(want))
+		}
+		if reply.EOF != tc.eof {
+			t.Fatalf("read of %d at %d: eof %v, want %v", tc.count, tc.offset, reply.EOF, tc.eof)
+		}
+	}
+}
+
+func TestZeroCopyReadDirectory(t *testing.T) {
+	var statuses [2]uint32
+	for i, zeroCopy := range []bool{false, true} {
+		target, fh := serveOSFile(t, []byte("hello"), zeroCopy)
+		_, root, err := target.Lookup("/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := target.Call(&struct {
+			rpc.Header
+			FH     []byte
+			Offset uint64
+			Count  uint32
+		}{
+			Header: rpc.Header{
+				Rpcvers: 2,
+				Prog:    nfsc.Nfs3Prog,
+				Vers:    nfsc.Nfs3Vers,
+				Proc:    nfsc.NFSProc3Read,
+				Cred:    rpc.AuthNull,
+				Verf:    rpc.AuthNull,
+			},
+			FH:    root,
+			Count: 4096,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if statuses[i], err = xdr.ReadUint32(res); err != nil {
+			t.Fatal(err)
+		}
+		// the connection survives the refused read.
+		if reply := read(t, target, fh, 0, 5); string(reply.Data) != "hello" {
+			t.Fatalf("zerocopy=%v: expected to read the file after the directory, got %q", zeroCopy, reply.Data)
+		}
+	}
+	if statuses[0] == nfsc.NFS3Ok || statuses[1] != statuses[0] {
+		t.Fatalf("expected a READ of a directory refused alike with and without zero copy, got %d and %d", statuses[0], statuses[1])
+	}
+}
+
+func BenchmarkReadZeroCopy(b *testing.B) {
+	contents := bytes.Repeat([]byte("x"), 1<<20)
+	for _, zeroCopy := range []bool{false, true} {
+		b.Run(fmt.Sprintf("zerocopy=%v", zeroCopy), func(b *testing.B) {
+			target, fh := serveOSFile(b, contents, zeroCopy)
+
+			b.ReportAllocs()
+			b.SetBytes(int64(len(contents)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				read(b, target, fh, 0, uint32(len(contents)))
+			}
+		})
+	}
+}
+
+// prefetchFS is a Prefetcher telling of the ranges it is asked to prefetch,
+// and failing to prefetch them.
+type prefetchFS struct {
+	billy.Filesystem
+	prefetches chan [2]int64
+}
+
+func (f *prefetchFS) Prefetch(filename string, offset int64, length int) error {
+	f.prefetches <- [2]int64{offset, int64(length)}
+	return errors.New("prefetch failed")
+}
+
+func TestReadAhead(t *testing.T) {
+	contents := make([]byte, 64<<10)
+	for i := range contents {
+		contents[i] = byte(i % 251)
+	}
+	fs := &prefetchFS{newTestFS(t, map[string]string{"/file": string(contents)}), make(chan [2]int64, 16)}
+	srv := &nfs.Server{
+		Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024),
+		ServerOptions: nfs.ServerOptions{ReadAhead: 8192},
+	}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+	_, fh, err := target.Lookup("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(offset, length int64) {
+		t.Helper()
+		select {
+		case got := <-fs.prefetches:
+			if got != [2]int64{offset, length} {
+				t.Fatalf("prefetched %d bytes at %d, want %d at %d", got[1], got[0], length, offset)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected a prefetch of %d bytes at %d", length, offset)
+		}
+	}
+	check := func(offset uint64, count uint32) {
+		t.Helper()
+		reply := read(t, target, fh, offset, count)
+		if !bytes.Equal(reply.Data, contents[offset:offset+uint64(count)]) {
+			t.Fatalf("read of %d at %d corrupted after a failed prefetch", count, offset)
+		}
+	}
+
+	// sequential reads prefetch the window past them, once.
+	check(0, 4096)
+	expect(4096, 8192)
+	check(4096, 4096)
+	expect(12288, 4096)
+	check(8192, 4096)
+	expect(16384, 4096)
+
+	// a read elsewhere in the file is not followed by a prefetch.
+	check(32768, 4096)
+	check(1000, 4096)
+	select {
+	case got := <-fs.prefetches:
+		t.Fatalf("unexpected prefetch of %d bytes at %d", got[1], got[0])
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestZeroCopyReadAhead(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), make([]byte, 64<<10), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fs := &prefetchFS{osfs.New(dir, osfs.WithBoundOS()), make(chan [2]int64, 16)}
+	srv := &nfs.Server{
+		Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024),
+		ServerOptions: nfs.ServerOptions{ReadAhead: 8192, ZeroCopyRead: true},
+	}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+	_, fh, err := target.Lookup("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read(t, target, fh, 0, 4096)
+	select {
+	case got := <-fs.prefetches:
+		if got != [2]int64{4096, 8192} {
+			t.Fatalf("prefetched %d bytes at %d, want 8192 at 4096", got[1], got[0])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a zero copy read to prefetch past it")
+	}
+}
+
+// slowFS is a backend whose reads take latency, unless of ranges
+// prefetched beforehand.
+type slowFS struct {
+	billy.Filesystem
+	latency time.Duration
+	mu      sync.Mutex
+	cached  map[int64]bool
+}
+
+func (f *slowFS) Prefetch(filename string, offset int64, length int) error {
+	time.Sleep(f.latency)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for o := offset; o < offset+int64(length); o += 4096 {
+		f.cached[o] = true
+	}
+	return nil
+}
+
+func (f *slowFS) Open(filename string) (billy.File, error) {
+	file, err := f.Filesystem.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &slowFile{file, f}, nil
+}
+
+type slowFile struct {
+	billy.File
+	fs *slowFS
+}
+
+func (f *slowFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	cached := true
+	for o := off; o < off+int64(len(p)); o += 4096 {
+		cached = cached && f.fs.cached[o]
+	}
+	f.fs.mu.Unlock()
+	if !cached {
+		time.Sleep(f.fs.latency)
+	}
+	return f.File.ReadAt(p, off)
+}
+
+func BenchmarkReadAhead(b *testing.B) {
+	const size, chunk = 1 << 20, 64 << 10
+	for _, ahead := range []int{0, 4 * chunk} {
+		b.Run(fmt.Sprintf("ahead=%d", ahead), func(b *testing.B) {
+			fs := &slowFS{Filesystem: newTestFS(b, map[string]string{"/file": strings.Repeat("x", size)}), latency: 2 * time.Millisecond}
+			srv := &nfs.Server{
+				Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024),
+				ServerOptions: nfs.ServerOptions{ReadAhead: ahead},
+			}
+			target := serveAndMount(b, srv, rpc.AuthNull)
+			_, fh, err := target.Lookup("/file")
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.SetBytes(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				fs.mu.Lock()
+				fs.cached = make(map[int64]bool)
+				fs.mu.Unlock()
+				for off := uint64(0); off < size; off += chunk {
+					read(b, target, fh, off, chunk)
+				}
+			}
+		})
+	}
+}
+
+// atimeFS reports the access, modification and change times in times for
+// the files named there, and records the access times Chtimes sets.
+type atimeFS struct {
+	billy.Filesystem
+	mu    sync.Mutex
+	times map[string]*fileTimes
+	sets  int
+}
+
+type fileTimes struct {
+	atime, mtime, ctime time.Time
+}
+
+type timesInfo struct {
+	os.FileInfo
+	times fileTimes
+}
+
+func (i timesInfo) ModTime() time.Time { return i.times.mtime }
+
+func (i timesInfo) Sys() interface{} {
+	return file.FileInfo{Nlink: 1, Atime: i.times.atime, Ctime: i.times.ctime}
+}
+
+func (fs *atimeFS) withTimes(name string, info os.FileInfo, err error) (os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if t, ok := fs.times[strings.TrimPrefix(name, "/")]; ok && err == nil {
+		return timesInfo{info, *t}, nil
+	}
+	return info, err
+}
+
+func (fs *atimeFS) Lstat(name string) (os.FileInfo, error) {
+	info, err := fs.Filesystem.Lstat(name)
+	return fs.withTimes(name, info, err)
+}
+
+func (fs *atimeFS) Stat(name string) (os.FileInfo, error) {
+	info, err := fs.Filesystem.Stat(name)
+	return fs.withTimes(name, info, err)
+}
+
+func (fs *atimeFS) Chtimes(name string, atime, mtime time.Time) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.sets++
+	if t, ok := fs.times[strings.TrimPrefix(name, "/")]; ok {
+		t.atime, t.mtime, t.ctime = atime, mtime, atime
+	}
+	return nil
+}
+
+func (fs *atimeFS) Chmod(string, os.FileMode) error { return nil }
+func (fs *atimeFS) Lchown(string, int, int) error   { return nil }
+func (fs *atimeFS) Chown(string, int, int) error    { return nil }
+
+func TestReadAtime(t *testing.T) {
+	now := time.Now()
+	hourAgo, weekAgo := now.Add(-time.Hour), now.Add(-7*24*time.Hour)
+	for _, tc := range []struct {
+		name     string
+		mode     nfs.AtimeMode
+		times    fileTimes
+		readOnly bool
+		updated  bool
+	}{
+		{"noatime", nfs.AtimeNever, fileTimes{weekAgo, weekAgo, weekAgo}, false, false},
+		{"strictatime", nfs.AtimeStrict, fileTimes{hourAgo, weekAgo, weekAgo}, false, true},
+		{"strictatime on a read-only server", nfs.AtimeStrict, fileTimes{hourAgo, weekAgo, weekAgo}, true, false},
+		{"relatime after the last change", nfs.AtimeRelative, fileTimes{hourAgo, weekAgo, weekAgo}, false, false},
+		{"relatime before the last write", nfs.AtimeRelative, fileTimes{weekAgo, hourAgo, hourAgo}, false, true},
+		{"relatime before the last change", nfs.AtimeRelative, fileTimes{hourAgo, weekAgo, now.Add(-time.Minute)}, false, true},
+		{"relatime a day old", nfs.AtimeRelative, fileTimes{now.Add(-25 * time.Hour), weekAgo, weekAgo}, false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			times := tc.times
+			fs := &atimeFS{
+				Filesystem: newTestFS(t, map[string]string{"/file": "hello"}),
+				times:      map[string]*fileTimes{"file": &times},
+			}
+			handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024, helpers.WithAtimeUpdates(tc.mode))
+			srv := &nfs.Server{Handler: handler}
+			srv.SetReadOnly(tc.readOnly)
+			target := serveAndMount(t, srv, rpc.AuthNull)
+			_, fh, err := target.Lookup("/file")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reply := read(t, target, fh, 0, 5)
+			fs.mu.Lock()
+			sets, atime, mtime := fs.sets, times.atime, times.mtime
+			fs.mu.Unlock()
+			if updated := sets != 0; updated != tc.updated {
+				t.Fatalf("expected the access time updated %v, got %d updates", tc.updated, sets)
+			}
+			if !mtime.Equal(tc.times.mtime) {
+				t.Fatalf("expected the modification time kept at %v, got %v", tc.times.mtime, mtime)
+			}
+			if !tc.updated {
+				return
+			}
+			if atime.Before(now) {
+				t.Fatalf("expected the access time advanced past %v, got %v", now, atime)
+			}
+			if got := reply.Attrs.Attr.Atime; int64(got.Seconds) != atime.Unix() {
+				t.Fatalf("expected the reply to report the new access time %v, got %+v", atime, got)
+			}
+		})
+	}
+}
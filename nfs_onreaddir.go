@@ -5,12 +5,15 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
+	"hash"
 	"io"
 	"io/fs"
 	"os"
-	"path"
 	"sort"
+	"sync"
 
+	"github.com/go-git/go-billy/v5"
 	"github.com/willscott/go-nfs-client/nfs/xdr"
 )
 
@@ -40,18 +43,15 @@ func onReadDir(ctx context.Context, w *response, userHandle Handler) error {
 		return &NFSStatusError{NFSStatusTooSmall, io.ErrShortBuffer}
 	}
 
-	fs, p, err := userHandle.FromHandle(obj.Handle)
+	fs, p, err := w.fromHandle(ctx, userHandle, obj.Handle)
 	if err != nil {
 		return &NFSStatusError{NFSStatusStale, err}
 	}
 
-	contents, verifier, err := getDirListingWithVerifier(userHandle, obj.Handle, obj.CookieVerif)
+	listing, err := w.Server.listDir(ctx, userHandle, obj.Handle, obj.CookieVerif)
 	if err != nil {
 		return err
 	}
-	if obj.Cookie > 0 && obj.CookieVerif > 0 && verifier != obj.CookieVerif {
-		return &NFSStatusError{NFSStatusBadCookie, nil}
-	}
 
 	entities := make([]readDirEntity, 0)
 	maxBytes := uint32(100) // conservative overhead measure
@@ -61,13 +61,13 @@ func onReadDir(ctx context.Context, w *response, userHandle Handler) error {
 		// add '.' and '..' to entities
 		dotdotFileID := uint64(0)
 		if len(p) > 0 {
-			dda := tryStat(fs, p[0:len(p)-1])
+			dda := w.tryStat(userHandle, fs, p[0:len(p)-1])
 			if dda != nil {
 				dotdotFileID = dda.Fileid
 			}
 		}
 		dotFileID := uint64(0)
-		da := tryStat(fs, p)
+		da := w.tryStat(userHandle, fs, p)
 		if da != nil {
 			dotFileID = da.Fileid
 		}
@@ -79,33 +79,41 @@ func onReadDir(ctx context.Context, w *response, userHandle Handler) error {
 
 	eof := true
 	maxEntities := userHandle.HandleLimit() / 2
-	for i, c := range contents {
+	err = listing.each(func(i int, c os.FileInfo) bool {
 		// cookie equates to index within contents + 2 (for '.' and '..')
 		cookie := uint64(i + 2)
 		if started {
 			maxBytes += 512 // TODO: better estimation.
 			if maxBytes > obj.Count || len(entities) > maxEntities {
 				eof = false
-				break
+				return false
 			}
 
-			attrs := ToFileAttribute(c, path.Join(append(p, c.Name())...))
+			attrs := w.fileAttribute(userHandle, fs, c, joinPath(p, c.Name()))
 			entities = append(entities, readDirEntity{
 				FileID: attrs.Fileid,
-				Name:   []byte(c.Name()),
+				Name:   []byte(w.Server.listedName(c.Name())),
 				Cookie: cookie,
 				Next:   true,
 			})
 		} else if cookie == obj.Cookie {
 			started = true
 		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	verifier := listing.verifier
+	if badCookie(userHandle, fs, obj.Cookie, obj.CookieVerif, verifier) {
+		return &NFSStatusError{NFSStatusBadCookie, nil}
 	}
 
 	writer := bytes.NewBuffer([]byte{})
 	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
-	if err := WritePostOpAttrs(writer, tryStat(fs, p)); err != nil {
+	if err := WritePostOpAttrs(writer, w.tryStat(userHandle, fs, p)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
@@ -137,14 +145,214 @@ func onReadDir(ctx context.Context, w *response, userHandle Handler) error {
 	return nil
 }
 
-func getDirListingWithVerifier(userHandle Handler, fsHandle []byte, verifier uint64) ([]fs.FileInfo, uint64, error) {
+// ReadDirIterator is implemented by billy file systems that can list a
+// directory an entry at a time, such as object stores holding directories
+// too large to read whole. READDIR and READDIRPLUS then stream the listing,
+// holding no more of it than fits in their reply. The first page of a
+// listing reads it through to its end to compute the cookie verifier, and
+// later pages under that verifier read no further than they reach, so a
+// change to the directory between them goes unnoticed. Listings of no more
+// than maxStreamedSnapshot entries are instead kept by a CachingHandler,
+// as those read whole are. Entries must come in name order. ReadDirIter may return billy.ErrNotSupported for a directory
+// it can't stream, which is then read with ReadDir.
+type ReadDirIterator interface {
+	ReadDirIter(path string) (DirEntries, error)
+}
+
+// DirEntries is a directory listing streamed by a ReadDirIterator. Next
+// returns io.EOF once there are no more entries.
+type DirEntries interface {
+	Next() (fs.FileInfo, error)
+	Close() error
+}
+
+// LenientVerifierHandler is implemented by handlers that may accept
+// READDIR and READDIRPLUS cookies whose verifier does not match the
+// directory's listing, for clients that send stale or garbage verifiers
+// and would otherwise be told NFS3ERR_BAD_COOKIE. Such a listing carries
+// on from the cookie's position in the directory as it now stands, so
+// entries added or removed since the client's last page may be skipped or
+// listed twice.
+type LenientVerifierHandler interface {
+	LenientVerifier(fs billy.Filesystem) bool
+}
+
+// badCookie reports whether a listing from cookie, sent with the verifier
+// sent, is to be refused as the directory's listing has verifier.
+func badCookie(userHandle Handler, fs billy.Filesystem, cookie, sent, verifier uint64) bool {
+	if cookie == 0 || sent == 0 || sent == verifier {
+		return false
+	}
+	lh, ok := userHandle.(LenientVerifierHandler)
+	return !ok || !lh.LenientVerifier(fs)
+}
+
+// maxStreamedSnapshot bounds the streamed listings a CachingHandler is
+// given to keep, in entries.
+const maxStreamedSnapshot = 1024
+
+// maxStreamedListings bounds the directories whose streamed listings a
+// server remembers the verifiers of.
+const maxStreamedListings = 4096
+
+// streamedListings remembers the verifier each directory's streamed
+// listing was last given, by path, so that later pages of the listing need
+// not read it through again.
+type streamedListings struct {
+	mu        sync.Mutex
+	verifiers map[string]uint64
+}
+
+func (s *streamedListings) add(path string, verifier uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.verifiers == nil || len(s.verifiers) >= maxStreamedListings {
+		// forgetting every listing only costs a read through each.
+		s.verifiers = make(map[string]uint64)
+	}
+	s.verifiers[path] = verifier
+}
+
+func (s *streamedListings) matches(path string, verifier uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.verifiers[path]
+	return ok && v == verifier
+}
+
+// dirListing is the listing of a directory READDIR and READDIRPLUS page
+// through, read whole or streamed from a ReadDirIterator.
+type dirListing struct {
+	contents []fs.FileInfo
+	// entries streams the listing of the directory at path, if it is not
+	// read whole.
+	entries DirEntries
+	path    string
+	hide    func(fs.FileInfo) bool
+	// sent is the verifier the client sent, and streamed the verifiers
+	// streamed listings were given.
+	sent     uint64
+	streamed *streamedListings
+	// keeper keeps short streamed listings, if the handler can.
+	keeper CachingHandler
+	// verifier is the cookie verifier of the listing. A streamed listing
+	// has it once each returns.
+	verifier uint64
+}
+
+// listDir lists the directory fsHandle refers to, leaving out the entries
+// the server's policy hides, streaming it if the file system can.
+func (s *Server) listDir(ctx context.Context, userHandle Handler, fsHandle []byte, verifier uint64) (*dirListing, error) {
+	fsys, p, err := fromHandle(ctx, userHandle, fsHandle)
+	if err != nil {
+		return nil, &NFSStatusError{NFSStatusStale, err}
+	}
+	if iter, ok := fsys.(ReadDirIterator); ok {
+		path := fsys.Join(p...)
+		keeper, _ := userHandle.(CachingHandler)
+		if keeper != nil && verifier != 0 {
+			if contents := keeper.DataForVerifier(path, verifier); contents != nil {
+				return &dirListing{contents: contents, verifier: verifier}, nil
+			}
+		}
+		entries, err := iter.ReadDirIter(path)
+		if err == nil {
+			return &dirListing{entries: entries, path: path, hide: s.hides, sent: verifier, streamed: &s.streamedListings, keeper: keeper}, nil
+		}
+		if !errors.Is(err, billy.ErrNotSupported) {
+			return nil, readDirError(err)
+		}
+	}
+	contents, verifier, err := getDirListingWithVerifier(ctx, userHandle, fsHandle, verifier, s.hides)
+	if err != nil {
+		return nil, err
+	}
+	return &dirListing{contents: contents, verifier: verifier}, nil
+}
+
+// each calls fn with the index and info of each entry in turn, until it
+// returns false.
+func (l *dirListing) each(fn func(i int, c fs.FileInfo) bool) error {
+	if l.entries == nil {
+		for i, c := range l.contents {
+			if !fn(i, c) {
+				break
+			}
+		}
+		return nil
+	}
+	defer l.entries.Close()
+	// a page of a listing whose verifier is known stops where it ends;
+	// otherwise the listing is read through for its verifier.
+	known := l.sent != 0 && l.streamed.matches(l.path, l.sent)
+	keep := !known && l.keeper != nil
+	var kept []fs.FileInfo
+	// as with a listing read whole, all but the first of a name listed
+	// twice are left out, and then hidden entries.
+	h := newVerifierHash(l.path)
+	var prev string
+	listed := false
+	i := 0
+	for {
+		c, err := l.entries.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return &NFSStatusError{NFSStatusIO, err}
+		}
+		if listed && c.Name() == prev {
+			continue
+		}
+		listed, prev = true, c.Name()
+		if l.hide(c) {
+			continue
+		}
+		h.add(prev)
+		if keep {
+			if len(kept) < maxStreamedSnapshot {
+				kept = append(kept, c)
+			} else {
+				keep, kept = false, nil
+			}
+		}
+		if fn != nil && !fn(i, c) {
+			if known {
+				l.verifier = l.sent
+				return nil
+			}
+			// the rest is read only for the verifier.
+			fn = nil
+		}
+		i++
+	}
+	if keep {
+		l.verifier = l.keeper.VerifierFor(l.path, kept)
+		return nil
+	}
+	l.verifier = h.sum()
+	l.streamed.add(l.path, l.verifier)
+	return nil
+}
+
+// readDirError maps the failure to list a directory to a status.
+func readDirError(err error) error {
+	if os.IsPermission(err) {
+		return &NFSStatusError{NFSStatusAccess, err}
+	}
+	return &NFSStatusError{NFSStatusNotDir, err}
+}
+
+// getDirListingWithVerifier lists the directory fsHandle refers to, leaving
+// out entries for which hide returns true.
+func getDirListingWithVerifier(ctx context.Context, userHandle Handler, fsHandle []byte, verifier uint64, hide func(fs.FileInfo) bool) ([]fs.FileInfo, uint64, error) {
 	// figure out what directory it is.
-	fs, p, err := userHandle.FromHandle(fsHandle)
+	fsys, p, err := fromHandle(ctx, userHandle, fsHandle)
 	if err != nil {
 		return nil, 0, &NFSStatusError{NFSStatusStale, err}
 	}
 
-	path := fs.Join(p...)
+	path := fsys.Join(p...)
 	// see if the verifier has this dir cached:
 	if vh, ok := userHandle.(CachingHandler); verifier != 0 && ok {
 		entries := vh.DataForVerifier(path, verifier)
@@ -152,19 +360,33 @@ func getDirListingWithVerifier(userHandle Handler, fsHandle []byte, verifier uin
 			return entries, verifier, nil
 		}
 	}
-	// load the entries.
-	contents, err := fs.ReadDir(path)
+	// load the entries, sorting a copy, since the backend may hand out a
+	// slice it holds on to.
+	listed, err := fsys.ReadDir(path)
 	if err != nil {
-		if os.IsPermission(err) {
-			return nil, 0, &NFSStatusError{NFSStatusAccess, err}
-		}
-		return nil, 0, &NFSStatusError{NFSStatusNotDir, err}
+		return nil, 0, readDirError(err)
 	}
-
-	sort.Slice(contents, func(i, j int) bool {
+	contents := append([]fs.FileInfo(nil), listed...)
+	sort.SliceStable(contents, func(i, j int) bool {
 		return contents[i].Name() < contents[j].Name()
 	})
 
+	// Keep only the first entry for each name, in case the backend lists a
+	// name more than once, which would otherwise give it several cookies.
+	// Entries are filtered in place in the copy.
+	var prev string
+	visible := contents[:0]
+	for i, c := range contents {
+		if i > 0 && c.Name() == prev {
+			continue
+		}
+		prev = c.Name()
+		if !hide(c) {
+			visible = append(visible, c)
+		}
+	}
+	contents = visible
+
 	if vh, ok := userHandle.(CachingHandler); ok {
 		// let the user handler make a verifier if it can.
 		v := vh.VerifierFor(path, contents)
@@ -176,16 +398,40 @@ func getDirListingWithVerifier(userHandle Handler, fsHandle []byte, verifier uin
 }
 
 func hashPathAndContents(path string, contents []fs.FileInfo) uint64 {
-	//calculate a cookie-verifier.
-	vHash := sha256.New()
+	// Hash the names in order, so that the verifier does not depend on the
+	// order of contents.
+	names := make([]string, 0, len(contents))
+	for _, c := range contents {
+		names = append(names, c.Name())
+	}
+	sort.Strings(names)
+	h := newVerifierHash(path)
+	for _, name := range names {
+		h.add(name)
+	}
+	return h.sum()
+}
+
+// verifierHash computes a cookie verifier from a directory's path and the
+// names it lists, added in order.
+type verifierHash struct {
+	hash.Hash
+}
 
+func newVerifierHash(path string) verifierHash {
+	h := verifierHash{sha256.New()}
 	// Add the path to avoid collisions of directories with the same content
-	vHash.Write([]byte(path))
+	h.add(path)
+	return h
+}
 
-	for _, c := range contents {
-		vHash.Write([]byte(c.Name())) // Never fails according to the docs
-	}
+// add hashes name prefixed with its length, so that no two listings run
+// together into the same bytes.
+func (h verifierHash) add(name string) {
+	h.Write(binary.BigEndian.AppendUint64([]byte{}, uint64(len(name)))) // Never fails according to the docs
+	h.Write([]byte(name))
+}
 
-	verify := vHash.Sum(nil)[0:8]
-	return binary.BigEndian.Uint64(verify)
+func (h verifierHash) sum() uint64 {
+	return binary.BigEndian.Uint64(h.Sum(nil)[0:8])
 }
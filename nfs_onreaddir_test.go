@@ -0,0 +1,280 @@
+package nfs_test
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+)
+
+// streamingFS streams a listing of n generated files for the directory big,
+// which it refuses to read whole, or of listed if it is set.
+type streamingFS struct {
+	billy.Filesystem
+	t      *testing.T
+	n      int
+	listed []fs.FileInfo
+	// heap, if set, is sent the heap in use at the first and last entries.
+	heap chan uint64
+	// opened and read count the listings streamed and the entries read.
+	opened, read atomic.Int64
+}
+
+func (f *streamingFS) ReadDir(path string) ([]os.FileInfo, error) {
+	if path == "big" {
+		f.t.Errorf("read %s whole rather than streaming it", path)
+	}
+	return f.Filesystem.ReadDir(path)
+}
+
+func (f *streamingFS) ReadDirIter(path string) (nfs.DirEntries, error) {
+	if path != "big" {
+		return nil, billy.ErrNotSupported
+	}
+	f.opened.Add(1)
+	if f.listed != nil {
+		return &listedEntries{fs: f, rest: f.listed}, nil
+	}
+	return &generatedEntries{fs: f}, nil
+}
+
+type generatedEntries struct {
+	fs *streamingFS
+	i  int
+}
+
+func (g *generatedEntries) Next() (fs.FileInfo, error) {
+	if g.i == g.fs.n {
+		return nil, io.EOF
+	}
+	if g.fs.heap != nil && (g.i == 0 || g.i == g.fs.n-1) {
+		runtime.GC()
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		g.fs.heap <- m.HeapAlloc
+	}
+	g.i++
+	g.fs.read.Add(1)
+	return generatedInfo(fmt.Sprintf("%06d", g.i-1)), nil
+}
+
+func (g *generatedEntries) Close() error { return nil }
+
+type listedEntries struct {
+	fs   *streamingFS
+	rest []fs.FileInfo
+}
+
+func (l *listedEntries) Next() (fs.FileInfo, error) {
+	if len(l.rest) == 0 {
+		return nil, io.EOF
+	}
+	c := l.rest[0]
+	l.rest = l.rest[1:]
+	l.fs.read.Add(1)
+	return c, nil
+}
+
+func (l *listedEntries) Close() error { return nil }
+
+type generatedInfo string
+
+func (g generatedInfo) Name() string       { return string(g) }
+func (g generatedInfo) Size() int64        { return 0 }
+func (g generatedInfo) Mode() fs.FileMode  { return 0o644 }
+func (g generatedInfo) ModTime() time.Time { return time.Unix(0, 0) }
+func (g generatedInfo) IsDir() bool        { return false }
+func (g generatedInfo) Sys() interface{}   { return nil }
+
+func TestReadDirStreamed(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/small/a": "a"})
+	if err := mem.MkdirAll("/big", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	const n = 100000
+	fs := &streamingFS{Filesystem: mem, t: t, n: n, heap: make(chan uint64, 2)}
+	target := serveAndMount(t, &nfs.Server{Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024)}, rpc.AuthNull)
+	_, fh, err := target.Lookup("/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, verf, eof, err := readDirPage(target, fh, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eof || len(page) < 3 || page[2].FileName != "000000" {
+		t.Fatalf("expected the first page of the listing, got %d entries, eof %v", len(page), eof)
+	}
+	// the listing is read through, for the verifier, without being held.
+	first, last := <-fs.heap, <-fs.heap
+	if last > first && last-first > 2<<20 {
+		t.Fatalf("heap grew by %d bytes over a streamed listing of %d entries", last-first, n)
+	}
+	fs.heap = nil
+
+	// the next page carries on from the last cookie, under the same
+	// verifier, reading no further than the page.
+	read := fs.read.Load()
+	next, nextVerf, _, err := readDirPage(target, fh, page[len(page)-1].Cookie, verf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextVerf != verf {
+		t.Fatalf("verifier changed from %x to %x between pages", verf, nextVerf)
+	}
+	if want := fmt.Sprintf("%06d", len(page)-2); len(next) == 0 || next[0].FileName != want {
+		t.Fatalf("expected the next page to start at %s, got %v", want, next)
+	}
+	if got := fs.read.Load() - read; got >= n {
+		t.Fatalf("read %d entries of a listing of %d for a later page", got, n)
+	}
+
+	// directories the backend can't stream are read whole.
+	entries, err := readDir(target, "/small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].FileName != "a" {
+		t.Fatalf("unexpected listing of a directory read whole: %v", entries)
+	}
+}
+
+func TestReadDirStreamedShort(t *testing.T) {
+	mem := newTestFS(t, map[string]string{})
+	if err := mem.MkdirAll("/big", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// a name listed twice is left out after its first entry, which is
+	// then hidden, as in a listing read whole.
+	backend := &streamingFS{Filesystem: mem, t: t, listed: []fs.FileInfo{
+		generatedInfo("a"), fifoInfo{"dup"}, generatedInfo("dup"), generatedInfo("z"),
+	}}
+	srv := &nfs.Server{Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(backend), 1024)}
+	srv.HideSpecialFiles = true
+	target := serveAndMount(t, srv, rpc.AuthNull)
+	_, fh, err := target.Lookup("/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, verf, eof, err := readDirPage(target, fh, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range page {
+		names = append(names, e.FileName)
+	}
+	if !eof || fmt.Sprint(names) != "[. .. a z]" {
+		t.Fatalf("expected the listing [. .. a z], got %v, eof %v", names, eof)
+	}
+
+	// a short listing is kept by the handler, so later pages need not
+	// stream it again.
+	if _, nextVerf, _, err := readDirPage(target, fh, page[2].Cookie, verf); err != nil {
+		t.Fatal(err)
+	} else if nextVerf != verf {
+		t.Fatalf("verifier changed from %x to %x between pages", verf, nextVerf)
+	}
+	if opened := backend.opened.Load(); opened != 1 {
+		t.Fatalf("streamed the listing %d times", opened)
+	}
+}
+
+func TestReadDirLenientVerifier(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 50; i++ {
+		files[fmt.Sprintf("/dir/%02d", i)] = "x"
+	}
+	for _, lenient := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lenient=%v", lenient), func(t *testing.T) {
+			mem := newTestFS(t, files)
+			handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024, helpers.WithLenientVerifier(lenient))
+			target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+			_, fh, err := target.Lookup("/dir")
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, verf, eof, err := readDirPage(target, fh, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if eof {
+				t.Fatal("expected the listing to take more than a page")
+			}
+
+			last := page[len(page)-1]
+			next, _, _, err := readDirPage(target, fh, last.Cookie, verf+1)
+			if !lenient {
+				if status := nfsStatus(err); status != nfsc.NFS3ErrBadCookie {
+					t.Fatalf("expected BAD_COOKIE for a mismatched verifier, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected a mismatched verifier to be accepted, got %v", err)
+			}
+			if want := fmt.Sprintf("%02d", len(page)-2); len(next) == 0 || next[0].FileName != want {
+				t.Fatalf("expected the listing to carry on from %s, got %v", want, next)
+			}
+		})
+	}
+}
+
+// heldListingFS lists the entries it holds for the directory dir, handing
+// out its own slice.
+type heldListingFS struct {
+	billy.Filesystem
+	entries []os.FileInfo
+}
+
+func (f *heldListingFS) ReadDir(path string) ([]os.FileInfo, error) {
+	if path == "dir" {
+		return f.entries, nil
+	}
+	return f.Filesystem.ReadDir(path)
+}
+
+func TestReadDirLeavesBackendListing(t *testing.T) {
+	held := []os.FileInfo{generatedInfo("c"), generatedInfo("a"), generatedInfo("b"), generatedInfo("a")}
+	fs := &heldListingFS{
+		Filesystem: newTestFS(t, map[string]string{"/dir/a": "", "/dir/b": "", "/dir/c": ""}),
+		entries:    append([]os.FileInfo(nil), held...),
+	}
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+	_, fh, err := target.Lookup("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _, _, err := readDirPage(target, fh, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		if e.FileName != "." && e.FileName != ".." {
+			names = append(names, e.FileName)
+		}
+	}
+	if fmt.Sprint(names) != "[a b c]" {
+		t.Fatalf("expected each name listed once, in order, got %v", names)
+	}
+	for i, e := range fs.entries {
+		if e.Name() != held[i].Name() {
+			t.Fatalf("expected the backend's listing left as it was, got %v", fs.entries)
+		}
+	}
+}
@@ -3,7 +3,7 @@ package nfs
 import (
 	"bytes"
 	"context"
-	"path"
+	"os"
 
 	"github.com/willscott/go-nfs-client/nfs/xdr"
 )
@@ -46,18 +46,19 @@ func onReadDirPlus(ctx context.Context, w *response, userHandle Handler) error {
 		return &NFSStatusError{NFSStatusTooSmall, nil}
 	}
 
-	fs, p, err := userHandle.FromHandle(obj.Handle)
+	fs, p, err := w.fromHandle(ctx, userHandle, obj.Handle)
 	if err != nil {
 		return &NFSStatusError{NFSStatusStale, err}
 	}
 
-	contents, verifier, err := getDirListingWithVerifier(userHandle, obj.Handle, obj.CookieVerif)
+	listing, err := w.Server.listDir(ctx, userHandle, obj.Handle, obj.CookieVerif)
 	if err != nil {
 		return err
 	}
-	if obj.Cookie > 0 && obj.CookieVerif > 0 && verifier != obj.CookieVerif {
-		return &NFSStatusError{NFSStatusBadCookie, nil}
-	}
+
+	// The directory's own attributes are returned both for '.' and as the
+	// reply's dir_attributes, so stat it once to keep the two consistent.
+	dirAttrs := w.tryStat(userHandle, fs, p)
 
 	entities := make([]readDirPlusEntity, 0)
 	dirBytes := uint32(0)
@@ -68,18 +69,17 @@ func onReadDirPlus(ctx context.Context, w *response, userHandle Handler) error {
 		// add '.' and '..' to entities
 		dotdotFileID := uint64(0)
 		if len(p) > 0 {
-			dda := tryStat(fs, p[0:len(p)-1])
+			dda := w.tryStat(userHandle, fs, p[0:len(p)-1])
 			if dda != nil {
 				dotdotFileID = dda.Fileid
 			}
 		}
 		dotFileID := uint64(0)
-		da := tryStat(fs, p)
-		if da != nil {
-			dotFileID = da.Fileid
+		if dirAttrs != nil {
+			dotFileID = dirAttrs.Fileid
 		}
 		entities = append(entities,
-			readDirPlusEntity{Name: []byte("."), Cookie: 0, Next: true, FileID: dotFileID, Attributes: da},
+			readDirPlusEntity{Name: []byte("."), Cookie: 0, Next: true, FileID: dotFileID, Attributes: dirAttrs},
 			readDirPlusEntity{Name: []byte(".."), Cookie: 1, Next: true, FileID: dotdotFileID},
 		)
 	}
@@ -88,40 +88,53 @@ func onReadDirPlus(ctx context.Context, w *response, userHandle Handler) error {
 	maxEntities := userHandle.HandleLimit() / 2
 	fb := 0
 	fss := 0
-	for i, c := range contents {
+	err = listing.each(func(i int, c os.FileInfo) bool {
 		// cookie equates to index within contents + 2 (for '.' and '..')
 		cookie := uint64(i + 2)
 		fb++
 		if started {
 			fss++
-			dirBytes += uint32(len(c.Name()) + 20)
+			name := w.Server.listedName(c.Name())
+			dirBytes += uint32(len(name) + 20)
 			maxBytes += 512 // TODO: better estimation.
 			if dirBytes > obj.DirCount || maxBytes > obj.MaxCount || len(entities) > maxEntities {
 				eof = false
-				break
+				return false
 			}
 
 			filePath := joinPath(p, c.Name())
-			handle := userHandle.ToHandle(fs, filePath)
-			attrs := ToFileAttribute(c, path.Join(filePath...))
-			entities = append(entities, readDirPlusEntity{
+			attrs := w.fileAttribute(userHandle, fs, c, filePath)
+			entity := readDirPlusEntity{
 				FileID:     attrs.Fileid,
-				Name:       []byte(c.Name()),
+				Name:       []byte(name),
 				Cookie:     cookie,
 				Attributes: attrs,
-				Handle:     &handle,
 				Next:       true,
-			})
+			}
+			// a handle refused here is left out, for the client to look
+			// up once it is allowed more.
+			if handle, err := w.toHandle(userHandle, fs, filePath); err == nil {
+				entity.Handle = &handle
+			}
+			entities = append(entities, entity)
 		} else if cookie == obj.Cookie {
 			started = true
 		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	verifier := listing.verifier
+	if badCookie(userHandle, fs, obj.Cookie, obj.CookieVerif, verifier) {
+		return &NFSStatusError{NFSStatusBadCookie, nil}
 	}
 
 	writer := bytes.NewBuffer([]byte{})
 	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
-	if err := WritePostOpAttrs(writer, tryStat(fs, p)); err != nil {
+	if err := WritePostOpAttrs(writer, dirAttrs); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 	if err := xdr.Write(writer, verifier); err != nil {
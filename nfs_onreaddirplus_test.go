@@ -0,0 +1,101 @@
+package nfs_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+func TestReadDirPlusDirAttributes(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/a": "a", "/dir/b": "bb"})
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+	info, fh, err := target.Lookup("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := info.(*nfsc.Fattr)
+
+	type readDirPlusArgs struct {
+		rpc.Header
+		Handle      []byte
+		Cookie      uint64
+		CookieVerif uint64
+		DirCount    uint32
+		MaxCount    uint32
+	}
+	res, err := target.Call(&readDirPlusArgs{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfsc.Nfs3Prog,
+			Vers:    nfsc.Nfs3Vers,
+			Proc:    uint32(nfs.NFSProcedureReadDirPlus),
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		Handle:   fh,
+		DirCount: 512,
+		MaxCount: 4096,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply struct {
+		Status   uint32
+		DirAttrs nfsc.PostOpAttr
+	}
+	if err := xdr.Read(res, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Status != nfsc.NFS3Ok {
+		t.Fatalf("readdirplus failed with status %d", reply.Status)
+	}
+	if !reply.DirAttrs.IsSet {
+		t.Fatal("expected directory attributes in the reply")
+	}
+	got := reply.DirAttrs.Attr
+	if got.Type != nfsc.NF3Dir {
+		t.Fatalf("expected directory type, got %d", got.Type)
+	}
+	if got.Fileid != expected.Fileid || got.Mtime != expected.Mtime || got.FileMode != expected.FileMode {
+		t.Fatalf("directory attributes %+v do not match lookup %+v", got, *expected)
+	}
+}
+
+// duplicatingFS lists every entry of a directory twice.
+type duplicatingFS struct {
+	billy.Filesystem
+}
+
+func (d *duplicatingFS) ReadDir(path string) ([]os.FileInfo, error) {
+	contents, err := d.Filesystem.ReadDir(path)
+	return append(contents, contents...), err
+}
+
+func TestReadDirPlusDuplicateNames(t *testing.T) {
+	mem := &duplicatingFS{newTestFS(t, map[string]string{"/dir/a": "a", "/dir/b": "bb"})}
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+	entries, err := target.ReadDirPlus("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]int)
+	for _, e := range entries {
+		seen[e.FileName]++
+	}
+	for _, name := range []string{"a", "b"} {
+		if seen[name] != 1 {
+			t.Fatalf("expected %q listed once, got %d times", name, seen[name])
+		}
+	}
+}
@@ -3,7 +3,12 @@ package nfs
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"os"
+	"syscall"
+	"time"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/willscott/go-nfs-client/nfs/xdr"
@@ -11,11 +16,11 @@ import (
 
 func onRemove(ctx context.Context, w *response, userHandle Handler) error {
 	w.errorFmt = wccDataErrorFormatter
-	obj := DirOpArg{}
-	if err := xdr.Read(w.req.Body, &obj); err != nil {
+	obj, err := ReadDirOpArg(w.req.Body)
+	if err != nil {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
-	fs, path, err := userHandle.FromHandle(obj.Handle)
+	fs, path, err := w.fromHandle(ctx, userHandle, obj.Handle)
 	if err != nil {
 		return &NFSStatusError{NFSStatusStale, err}
 	}
@@ -24,50 +29,38 @@ func onRemove(ctx context.Context, w *response, userHandle Handler) error {
 		return &NFSStatusError{NFSStatusROFS, os.ErrPermission}
 	}
 
-	if len(string(obj.Filename)) > PathNameMax {
+	if nameTooLong(userHandle, obj.Filename) {
 		return &NFSStatusError{NFSStatusNameTooLong, nil}
 	}
 
-	fullPath := fs.Join(path...)
-	dirInfo, err := fs.Stat(fullPath)
+	dirInfo, err := statDir(fs, path)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return &NFSStatusError{NFSStatusNoEnt, err}
-		}
-		if os.IsPermission(err) {
-			return &NFSStatusError{NFSStatusAccess, err}
-		}
-		return &NFSStatusError{NFSStatusIO, err}
-	}
-	if !dirInfo.IsDir() {
-		return &NFSStatusError{NFSStatusNotDir, nil}
+		return err
 	}
-	preCacheData := ToFileAttribute(dirInfo, fullPath).AsCache()
+	preCacheData := w.fileAttribute(userHandle, fs, dirInfo, path).AsCache()
 
-	toDelete := fs.Join(append(path, string(obj.Filename))...)
-	toDeleteHandle := userHandle.ToHandle(fs, append(path, string(obj.Filename)))
+	toDeletePath := append(path, w.Server.entryName(userHandle, fs, path, string(obj.Filename)))
+	toDelete := fs.Join(toDeletePath...)
 
-	err = fs.Remove(toDelete)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return &NFSStatusError{NFSStatusNoEnt, err}
-		}
-		if os.IsPermission(err) {
-			return &NFSStatusError{NFSStatusAccess, err}
+	if err := fs.Remove(toDelete); err != nil {
+		// a file in use is renamed aside, to be deleted once no handle
+		// reaches it.
+		if !inUse(err) || w.Server.sillyRename(userHandle, fs, toDeletePath) != nil {
+			return &NFSStatusError{statusFromRemoveError(err), err}
 		}
-		return &NFSStatusError{NFSStatusIO, err}
-	}
-
-	if err := userHandle.InvalidateHandle(fs, toDeleteHandle); err != nil {
+	} else if err := invalidateRemoved(userHandle, fs, toDeletePath); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
+	w.Server.createVerifiers.forget(fs, toDelete)
+
+	w.notifyChange(userHandle, ChangeRemove, fs, toDeletePath)
 
 	writer := bytes.NewBuffer([]byte{})
 	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
-	if err := WriteWcc(writer, preCacheData, tryStat(fs, path)); err != nil {
+	if err := WriteWcc(writer, preCacheData, w.tryStat(userHandle, fs, path)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
@@ -76,3 +69,78 @@ func onRemove(ctx context.Context, w *response, userHandle Handler) error {
 	}
 	return nil
 }
+
+// invalidateRemoved drops handles to a removed object, along with any
+// beneath it if the handler is able to invalidate a whole subtree at once.
+func invalidateRemoved(userHandle Handler, fs billy.Filesystem, path []string) error {
+	if invalidator, ok := userHandle.(interface {
+		InvalidateSubtree(billy.Filesystem, []string) int
+	}); ok {
+		invalidator.InvalidateSubtree(fs, path)
+		return nil
+	}
+	return userHandle.InvalidateHandle(fs, userHandle.ToHandle(fs, path))
+}
+
+// inUse reports whether err is the backend refusing to remove a file
+// because it is in use.
+func inUse(err error) bool {
+	return errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.ETXTBSY)
+}
+
+// sillyRename renames the file at path to a hidden name in its directory,
+// starting with the SillyRenamePrefix, and moves its handles along, asking
+// the handler to delete it once the last of them is gone. It fails if the
+// handler can't tell when that is.
+func (s *Server) sillyRename(userHandle Handler, fs billy.Filesystem, path []string) error {
+	notifier, ok := userHandle.(ReleaseNotifier)
+	if !ok {
+		return errNoReleaseNotifier
+	}
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return err
+	}
+	sillyPath := append(append([]string(nil), path[:len(path)-1]...), fmt.Sprintf("%s%x", s.sillyRenamePrefix(), suffix))
+	sillyLoc := fs.Join(sillyPath...)
+	if err := fs.Rename(fs.Join(path...), sillyLoc); err != nil {
+		return err
+	}
+	updateRenamedHandles(userHandle, fs, path, sillyPath)
+	// the handler may release the file from whichever request drops its
+	// last handle, which should not wait on the deletion.
+	notifier.WhenReleased(fs, sillyPath, func() {
+		go s.removeReleased(fs, sillyLoc)
+	})
+	return nil
+}
+
+const (
+	// releasedRemoveAttempts bounds the tries to delete a silly renamed
+	// file the backend still finds in use.
+	releasedRemoveAttempts = 6
+	// releasedRemoveDelay is the wait before the first retry, doubling
+	// for each after it.
+	releasedRemoveDelay = 100 * time.Millisecond
+)
+
+// removeReleased deletes the silly renamed file at name once no handle to
+// it remains, retrying while the backend still finds it in use, as it may
+// until whatever else has it open closes it.
+func (s *Server) removeReleased(fs billy.Filesystem, name string) {
+	delay := releasedRemoveDelay
+	for attempt := 1; ; attempt++ {
+		err := fs.Remove(name)
+		if err == nil || os.IsNotExist(err) {
+			return
+		}
+		if !inUse(err) || attempt == releasedRemoveAttempts {
+			s.logger().Errorf("failed to remove released file %s: %v", name, err)
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+var errNoReleaseNotifier = errors.New("handler does not tell when handles are released")
@@ -0,0 +1,341 @@
+package nfs_test
+
+import (
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// removeIn issues a REMOVE of name within the directory dir and returns
+// the reply's status.
+func removeIn(t *testing.T, target *nfsc.Target, dir []byte, name string) uint32 {
+	t.Helper()
+	type removeArgs struct {
+		rpc.Header
+		Dir  []byte
+		Name string
+	}
+	res, err := target.Call(&removeArgs{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfsc.Nfs3Prog,
+			Vers:    nfsc.Nfs3Vers,
+			Proc:    nfsc.NFSProc3Remove,
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		Dir:  dir,
+		Name: name,
+	})
+	if err != nil {
+		return nfsStatus(err)
+	}
+	status, err := xdr.ReadUint32(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestRemoveStaleParent(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/file": "hello", "/gone/file": "hello", "/dropped/file": "hello"})
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+	lookup := func(p string) []byte {
+		_, fh, err := target.Lookup(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fh
+	}
+	dir, gone, dropped := lookup("/dir"), lookup("/gone"), lookup("/dropped")
+
+	// The parent exists but the child doesn't.
+	if status := removeIn(t, target, dir, "missing"); status != nfsc.NFS3ErrNoEnt {
+		t.Fatalf("expected NOENT for a missing child, got %d", status)
+	}
+
+	// The parent was deleted behind the server's back.
+	if err := mem.Remove("/gone/file"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Remove("/gone"); err != nil {
+		t.Fatal(err)
+	}
+	if status := removeIn(t, target, gone, "file"); status != nfsc.NFS3ErrStale {
+		t.Fatalf("expected STALE under a deleted parent, got %d", status)
+	}
+
+	// The parent's handle was invalidated.
+	fs, _, err := handler.FromHandle(dropped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.InvalidateHandle(fs, dropped); err != nil {
+		t.Fatal(err)
+	}
+	if status := removeIn(t, target, dropped, "file"); status != nfsc.NFS3ErrStale {
+		t.Fatalf("expected STALE under an invalidated parent, got %d", status)
+	}
+}
+
+func TestRemoveParentWcc(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/file": "hello", "/dir/other": "hi"})
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+	_, dir, err := target.Lookup("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := target.GetAttr(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := target.Call(&struct {
+		rpc.Header
+		Dir  []byte
+		Name string
+	}{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfsc.Nfs3Prog,
+			Vers:    nfsc.Nfs3Vers,
+			Proc:    nfsc.NFSProc3Remove,
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		Dir:  dir,
+		Name: "file",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply struct {
+		Status uint32
+		DirWcc nfsc.WccData
+	}
+	if err := xdr.Read(res, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Status != nfsc.NFS3Ok {
+		t.Fatalf("remove failed with status %d", reply.Status)
+	}
+
+	pre, post := reply.DirWcc.Before, reply.DirWcc.After
+	if !pre.IsSet || !post.IsSet {
+		t.Fatal("expected both pre- and post-op attributes of the directory")
+	}
+	if pre.MTime != before.Mtime {
+		t.Fatalf("pre-op mtime %v is not the directory's mtime before the remove, %v", pre.MTime, before.Mtime)
+	}
+	mtime := func(t nfsc.NFS3Time) time.Time { return time.Unix(int64(t.Seconds), int64(t.Nseconds)) }
+	if !mtime(post.Attr.Mtime).After(mtime(pre.MTime)) {
+		t.Fatalf("post-op mtime %v did not advance past the pre-op %v", post.Attr.Mtime, pre.MTime)
+	}
+}
+
+// busyFS refuses to remove the file at busy, as a backend does a file it
+// has open, and sends the names of the files it removes on removed.
+type busyFS struct {
+	billy.Filesystem
+	busy    string
+	removed chan string
+}
+
+func newBusyFS(fs billy.Filesystem, busy string) *busyFS {
+	return &busyFS{fs, busy, make(chan string, 1)}
+}
+
+func (f *busyFS) Remove(filename string) error {
+	if filename == f.busy {
+		return &os.PathError{Op: "remove", Path: filename, Err: syscall.EBUSY}
+	}
+	if err := f.Filesystem.Remove(filename); err != nil {
+		return err
+	}
+	f.removed <- filename
+	return nil
+}
+
+func TestRemoveInUse(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/file": "hello"})
+	busy := newBusyFS(mem, "dir/file")
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(busy), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+	_, dir, err := target.Lookup("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, fh, err := target.Lookup("/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := removeIn(t, target, dir, "file"); status != nfsc.NFS3Ok {
+		t.Fatalf("remove of a file in use failed with status %d", status)
+	}
+	if _, err := mem.Stat("/dir/file"); !os.IsNotExist(err) {
+		t.Fatalf("expected the file to be gone from its name, got %v", err)
+	}
+	entries, err := mem.ReadDir("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !strings.HasPrefix(entries[0].Name(), ".nfs") {
+		t.Fatalf("expected the file renamed aside to a .nfs name, found %v", entries)
+	}
+	silly := "/dir/" + entries[0].Name()
+
+	// the handle still reaches the file under its new name.
+	if reply := read(t, target, fh, 0, 5); string(reply.Data) != "hello" {
+		t.Fatalf("read %q through the handle of the removed file", reply.Data)
+	}
+
+	// the file is deleted once the last handle to it is gone.
+	fs, _, err := handler.FromHandle(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.InvalidateHandle(fs, fh); err != nil {
+		t.Fatal(err)
+	}
+	waitRemoved(t, busy, silly)
+}
+
+// waitRemoved waits for busy to delete the file at name, once released.
+func waitRemoved(t *testing.T, busy *busyFS, name string) {
+	t.Helper()
+	select {
+	case removed := <-busy.removed:
+		if path.Clean("/"+removed) != name {
+			t.Fatalf("expected %s deleted once released, deleted %s", name, removed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected %s deleted once released", name)
+	}
+	if _, err := busy.Stat(name); !os.IsNotExist(err) {
+		t.Fatalf("expected %s gone, got %v", name, err)
+	}
+}
+
+// stubbornFS refuses the first refusals removals of silly renamed files,
+// as a backend does while something else still has them open.
+type stubbornFS struct {
+	billy.Filesystem
+	refusals atomic.Int32
+}
+
+func (f *stubbornFS) Remove(filename string) error {
+	if strings.HasPrefix(path.Base(filename), nfs.DefaultSillyRenamePrefix) && f.refusals.Add(-1) >= 0 {
+		return &os.PathError{Op: "remove", Path: filename, Err: syscall.EBUSY}
+	}
+	return f.Filesystem.Remove(filename)
+}
+
+func TestRemoveInUseRetried(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/file": "hello"})
+	busy := newBusyFS(mem, "dir/file")
+	fs := &stubbornFS{Filesystem: busy}
+	fs.refusals.Store(2)
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+	_, dir, err := target.Lookup("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, fh, err := target.Lookup("/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := removeIn(t, target, dir, "file"); status != nfsc.NFS3Ok {
+		t.Fatalf("remove of a file in use failed with status %d", status)
+	}
+	entries, err := mem.ReadDir("/dir")
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected the file renamed aside, found %v (%v)", entries, err)
+	}
+
+	// the file is deleted once the backend lets it go.
+	released, _, err := handler.FromHandle(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.InvalidateHandle(released, fh); err != nil {
+		t.Fatal(err)
+	}
+	waitRemoved(t, busy, "/dir/"+entries[0].Name())
+	if left := fs.refusals.Load(); left >= 0 {
+		t.Fatalf("expected the removal retried past its refusals, %d left", left)
+	}
+}
+
+func TestRemoveInUseHandles(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/file": "hello"})
+	// without a reverse cache each lookup mints another handle to the file.
+	busy := newBusyFS(mem, "dir/file")
+	handler := helpers.NewCachingHandlerNoReverse(helpers.NewNullAuthHandler(busy), 1024)
+	target := serveAndMount(t, &nfs.Server{
+		Handler:       handler,
+		ServerOptions: nfs.ServerOptions{SillyRenamePrefix: ".busy"},
+	}, rpc.AuthNull)
+	_, dir, err := target.Lookup("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fhs [][]byte
+	for i := 0; i < 2; i++ {
+		_, fh, err := target.Lookup("/dir/file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fhs = append(fhs, fh)
+	}
+	if string(fhs[0]) == string(fhs[1]) {
+		t.Fatal("expected two handles to the file")
+	}
+
+	if status := removeIn(t, target, dir, "file"); status != nfsc.NFS3Ok {
+		t.Fatalf("remove of a file in use failed with status %d", status)
+	}
+	entries, err := mem.ReadDir("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !strings.HasPrefix(entries[0].Name(), ".busy") {
+		t.Fatalf("expected the file renamed aside with the configured prefix, found %v", entries)
+	}
+	silly := "/dir/" + entries[0].Name()
+
+	invalidate := func(fh []byte) {
+		fs, _, err := handler.FromHandle(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := handler.InvalidateHandle(fs, fh); err != nil {
+			t.Fatal(err)
+		}
+	}
+	invalidate(fhs[0])
+	if _, err := mem.Stat(silly); err != nil {
+		t.Fatalf("expected %s kept while a handle to it remains, got %v", silly, err)
+	}
+	if reply := read(t, target, fhs[1], 0, 5); string(reply.Data) != "hello" {
+		t.Fatalf("read %q through the remaining handle", reply.Data)
+	}
+	invalidate(fhs[1])
+	waitRemoved(t, busy, silly)
+}
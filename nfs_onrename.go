@@ -14,21 +14,20 @@ var doubleWccErrorBody = [16]byte{}
 
 func onRename(ctx context.Context, w *response, userHandle Handler) error {
 	w.errorFmt = errFormatterWithBody(doubleWccErrorBody[:])
-	from := DirOpArg{}
-	err := xdr.Read(w.req.Body, &from)
+	from, err := ReadDirOpArg(w.req.Body)
 	if err != nil {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
-	fs, fromPath, err := userHandle.FromHandle(from.Handle)
+	fs, fromPath, err := w.fromHandle(ctx, userHandle, from.Handle)
 	if err != nil {
 		return &NFSStatusError{NFSStatusStale, err}
 	}
 
-	to := DirOpArg{}
-	if err = xdr.Read(w.req.Body, &to); err != nil {
+	to, err := ReadDirOpArg(w.req.Body)
+	if err != nil {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
-	fs2, toPath, err := userHandle.FromHandle(to.Handle)
+	fs2, toPath, err := w.fromHandle(ctx, userHandle, to.Handle)
 	if err != nil {
 		return &NFSStatusError{NFSStatusStale, err}
 	}
@@ -41,79 +40,73 @@ func onRename(ctx context.Context, w *response, userHandle Handler) error {
 		return &NFSStatusError{NFSStatusROFS, os.ErrPermission}
 	}
 
-	if len(string(from.Filename)) > PathNameMax || len(string(to.Filename)) > PathNameMax {
+	if nameTooLong(userHandle, from.Filename) || nameTooLong(userHandle, to.Filename) {
 		return &NFSStatusError{NFSStatusNameTooLong, os.ErrInvalid}
 	}
 
-	fromDirPath := fs.Join(fromPath...)
-	fromDirInfo, err := fs.Stat(fromDirPath)
+	fromDirInfo, err := statDir(fs, fromPath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return &NFSStatusError{NFSStatusNoEnt, err}
+		return err
+	}
+	preCacheData := w.fileAttribute(userHandle, fs, fromDirInfo, fromPath).AsCache()
+
+	// A rename within one directory reports that directory's change as both
+	// the source and the destination, so stat it just once either side.
+	sameDir := reflect.DeepEqual(fromPath, toPath)
+	preDestData := preCacheData
+	if !sameDir {
+		toDirInfo, err := statDir(fs, toPath)
+		if err != nil {
+			return err
 		}
-		return &NFSStatusError{NFSStatusIO, err}
+		preDestData = w.fileAttribute(userHandle, fs, toDirInfo, toPath).AsCache()
 	}
-	if !fromDirInfo.IsDir() {
-		return &NFSStatusError{NFSStatusNotDir, nil}
+	// renaming a file to another form of its own name is allowed.
+	if existing, ok := w.Server.equivalentName(fs, toPath, string(to.Filename)); ok && (!sameDir || existing != string(from.Filename)) {
+		return &NFSStatusError{NFSStatusExist, os.ErrExist}
 	}
-	preCacheData := ToFileAttribute(fromDirInfo, fromDirPath).AsCache()
 
-	toDirPath := fs.Join(toPath...)
-	toDirInfo, err := fs.Stat(toDirPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return &NFSStatusError{NFSStatusNoEnt, err}
-		}
-		return &NFSStatusError{NFSStatusIO, err}
+	// the source is the entry its name refers to, and on a backend matching
+	// names without regard to case so is the destination, unless the rename
+	// only changes the case of the source's name.
+	fromName := w.Server.entryName(userHandle, fs, fromPath, string(from.Filename))
+	toName := resolveName(userHandle, fs, toPath, string(to.Filename))
+	if sameDir && toName == fromName {
+		toName = string(to.Filename)
 	}
-	if !toDirInfo.IsDir() {
-		return &NFSStatusError{NFSStatusNotDir, nil}
-	}
-	preDestData := ToFileAttribute(toDirInfo, toDirPath).AsCache()
 
-	oldPath := append(fromPath, string(from.Filename))
-	newPath := append(toPath, string(to.Filename))
+	oldPath := append(fromPath, fromName)
+	newPath := append(toPath, toName)
 
 	fromLoc := fs.Join(oldPath...)
 	toLoc := fs.Join(newPath...)
 
 	err = fs.Rename(fromLoc, toLoc)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return &NFSStatusError{NFSStatusNoEnt, err}
-		}
-		if os.IsPermission(err) {
-			return &NFSStatusError{NFSStatusAccess, err}
-		}
-		return &NFSStatusError{NFSStatusIO, err}
+		return &NFSStatusError{mapError(err), err}
 	}
 
-	// Update all handles pointing to the old path to point to the new path.
-	// This is critical for NFS silly rename support (unlink while file is open).
-	// We use type assertion to check if the handler supports UpdateHandlesByPath,
-	// which updates handles by path lookup rather than relying on ToHandle
-	// (which may fail due to filesystem instance comparison issues).
-	if updater, ok := userHandle.(interface {
-		UpdateHandlesByPath(billy.Filesystem, []string, []string) int
-	}); ok {
-		updater.UpdateHandlesByPath(fs, oldPath, newPath)
-	} else {
-		// Fall back to the old approach for handlers that don't support UpdateHandlesByPath
-		oldHandle := userHandle.ToHandle(fs, oldPath)
-		if err := userHandle.UpdateHandle(fs, oldHandle, newPath); err != nil {
-			_ = userHandle.InvalidateHandle(fs, oldHandle)
-		}
-	}
+	updateRenamedHandles(userHandle, fs, oldPath, newPath)
+
+	w.Server.createVerifiers.forget(fs, fromLoc)
+	w.Server.createVerifiers.forget(fs, toLoc)
+	w.Server.negativeLookups.forget(fs, toPath, toName)
+	w.notifyRename(userHandle, fs, oldPath, newPath)
 
 	writer := bytes.NewBuffer([]byte{})
 	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
-	if err := WriteWcc(writer, preCacheData, tryStat(fs, fromPath)); err != nil {
+	postFromData := w.tryStat(userHandle, fs, fromPath)
+	postDestData := postFromData
+	if !sameDir {
+		postDestData = w.tryStat(userHandle, fs, toPath)
+	}
+	if err := WriteWcc(writer, preCacheData, postFromData); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
-	if err := WriteWcc(writer, preDestData, tryStat(fs, toPath)); err != nil {
+	if err := WriteWcc(writer, preDestData, postDestData); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
@@ -122,3 +115,23 @@ func onRename(ctx context.Context, w *response, userHandle Handler) error {
 	}
 	return nil
 }
+
+// updateRenamedHandles points the handles to oldPath, and anything beneath
+// it, at newPath.
+// This is critical for NFS silly rename support (unlink while file is open).
+// We use type assertion to check if the handler supports UpdateHandlesByPath,
+// which updates handles by path lookup rather than relying on ToHandle
+// (which may fail due to filesystem instance comparison issues).
+func updateRenamedHandles(userHandle Handler, fs billy.Filesystem, oldPath, newPath []string) {
+	if updater, ok := userHandle.(interface {
+		UpdateHandlesByPath(billy.Filesystem, []string, []string) int
+	}); ok {
+		updater.UpdateHandlesByPath(fs, oldPath, newPath)
+		return
+	}
+	// Fall back to the old approach for handlers that don't support UpdateHandlesByPath
+	oldHandle := userHandle.ToHandle(fs, oldPath)
+	if err := userHandle.UpdateHandle(fs, oldHandle, newPath); err != nil {
+		_ = userHandle.InvalidateHandle(fs, oldHandle)
+	}
+}
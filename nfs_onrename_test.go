@@ -0,0 +1,288 @@
+package nfs_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// tickingFS reports a later modification time on every stat, so that
+// attributes gathered by separate stats of one object never match.
+type tickingFS struct {
+	billy.Filesystem
+	ticks int64
+}
+
+type tickingInfo struct {
+	os.FileInfo
+	mtime time.Time
+}
+
+func (i tickingInfo) ModTime() time.Time { return i.mtime }
+
+func (t *tickingFS) tick(info os.FileInfo, err error) (os.FileInfo, error) {
+	if err != nil {
+		return info, err
+	}
+	n := atomic.AddInt64(&t.ticks, 1)
+	return tickingInfo{info, info.ModTime().Add(time.Duration(n) * time.Second)}, nil
+}
+
+func (t *tickingFS) Stat(filename string) (os.FileInfo, error) {
+	return t.tick(t.Filesystem.Stat(filename))
+}
+
+func (t *tickingFS) Lstat(filename string) (os.FileInfo, error) {
+	return t.tick(t.Filesystem.Lstat(filename))
+}
+
+type renameReply struct {
+	Status  uint32
+	FromWcc nfsc.WccData
+	ToWcc   nfsc.WccData
+}
+
+// rename issues a RENAME and returns the source and destination wcc data.
+func rename(t *testing.T, target *nfsc.Target, fromDir []byte, from string, toDir []byte, to string) (nfsc.WccData, nfsc.WccData) {
+	t.Helper()
+	reply := tryRename(t, target, fromDir, from, toDir, to)
+	if reply.Status != nfsc.NFS3Ok {
+		t.Fatalf("rename failed with status %d", reply.Status)
+	}
+	return reply.FromWcc, reply.ToWcc
+}
+
+// tryRename issues a RENAME, returning its reply whether or not it succeeds.
+func tryRename(t *testing.T, target *nfsc.Target, fromDir []byte, from string, toDir []byte, to string) renameReply {
+	t.Helper()
+	type renameArgs struct {
+		rpc.Header
+		From    []byte
+		Name    string
+		To      []byte
+		NewName string
+	}
+	res, err := target.Call(&renameArgs{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfsc.Nfs3Prog,
+			Vers:    nfsc.Nfs3Vers,
+			Proc:    nfsc.NFSProc3Rename,
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		From:    fromDir,
+		Name:    from,
+		To:      toDir,
+		NewName: to,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply renameReply
+	if err := xdr.Read(res, &reply); err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+func TestRenameWcc(t *testing.T) {
+	mem := &tickingFS{Filesystem: newTestFS(t, map[string]string{"/a/one": "1", "/a/two": "2", "/b/keep": "b"})}
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+	_, a, err := target.Lookup("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, b, err := target.Lookup("/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fromWcc, toWcc := rename(t, target, a, "one", a, "uno")
+	if !fromWcc.Before.IsSet || !fromWcc.After.IsSet {
+		t.Fatal("expected pre and post attributes for a same-directory rename")
+	}
+	if !reflect.DeepEqual(fromWcc, toWcc) {
+		t.Fatalf("same-directory rename reported differing wcc:\n%+v\n%+v", fromWcc, toWcc)
+	}
+
+	fromWcc, toWcc = rename(t, target, a, "two", b, "two")
+	if !fromWcc.Before.IsSet || !fromWcc.After.IsSet || !toWcc.Before.IsSet || !toWcc.After.IsSet {
+		t.Fatal("expected pre and post attributes for both directories of a cross-directory rename")
+	}
+	if fromWcc.After.Attr.Fileid == toWcc.After.Attr.Fileid {
+		t.Fatal("cross-directory rename reported the same directory for source and destination")
+	}
+}
+
+type notification struct {
+	op   string
+	path []string
+	// from is where a renamed object was.
+	from []string
+}
+
+// notifyingHandler sends each change it is told of to changes.
+type notifyingHandler struct {
+	nfs.Handler
+	changes chan notification
+}
+
+func (n *notifyingHandler) OnChange(op string, fs billy.Filesystem, path []string) {
+	n.changes <- notification{op: op, path: path}
+}
+
+func (n *notifyingHandler) OnRename(fs billy.Filesystem, from, to []string) {
+	n.changes <- notification{op: nfs.ChangeRename, path: to, from: from}
+}
+
+func TestRenameNotifies(t *testing.T) {
+	for _, queue := range []int{0, 8} {
+		mem := newTestFS(t, map[string]string{"/a/one": "1", "/b/keep": "b"})
+		notifier := &notifyingHandler{helpers.NewNullAuthHandler(mem), make(chan notification, 8)}
+		srv := &nfs.Server{
+			Handler:       helpers.NewCachingHandler(notifier, 1024),
+			ServerOptions: nfs.ServerOptions{ChangeQueueSize: queue},
+		}
+		target := serveAndMount(t, srv, rpc.AuthNull)
+
+		_, a, err := target.Lookup("/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, b, err := target.Lookup("/b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rename(t, target, a, "one", b, "uno")
+
+		select {
+		case n := <-notifier.changes:
+			want := notification{nfs.ChangeRename, []string{"b", "uno"}, []string{"a", "one"}}
+			if !reflect.DeepEqual(n, want) {
+				t.Fatalf("queue %d: expected %+v, got %+v", queue, want, n)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("queue %d: no notification of the rename", queue)
+		}
+		select {
+		case n := <-notifier.changes:
+			t.Fatalf("queue %d: unexpected second notification %+v", queue, n)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// failingRenameFS fails every rename with err.
+type failingRenameFS struct {
+	billy.Filesystem
+	err error
+}
+
+func (f *failingRenameFS) Rename(from, to string) error {
+	return f.err
+}
+
+func TestRenameErrors(t *testing.T) {
+	mem := &failingRenameFS{Filesystem: newTestFS(t, map[string]string{"/a/one": "1"})}
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+	_, a, err := target.Lookup("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		err  error
+		want nfs.NFSStatus
+	}{
+		{os.ErrNotExist, nfs.NFSStatusNoEnt},
+		{&os.LinkError{Op: "rename", Old: "a", New: "b", Err: syscall.ENOENT}, nfs.NFSStatusNoEnt},
+		{os.ErrPermission, nfs.NFSStatusAccess},
+		{billy.ErrNotSupported, nfs.NFSStatusNotSupp},
+		{syscall.ENOSPC, nfs.NFSStatusNoSPC},
+		{syscall.ENOTEMPTY, nfs.NFSStatusNotEmpty},
+		{syscall.EXDEV, nfs.NFSStatusXDev},
+		{&nfs.NFSStatusError{NFSStatus: nfs.NFSStatusJukebox}, nfs.NFSStatusJukebox},
+		{errors.New("unexpected"), nfs.NFSStatusIO},
+	} {
+		mem.err = tc.err
+		if reply := tryRename(t, target, a, "one", a, "two"); reply.Status != uint32(tc.want) {
+			t.Errorf("rename failing with %v: expected status %d, got %d", tc.err, tc.want, reply.Status)
+		}
+	}
+}
+
+func TestRenameDryRun(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/a/one": "1", "/b/two": "2"})
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024, helpers.WithDryRun(true))
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+	_, a, err := target.Lookup("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, b, err := target.Lookup("/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, one, err := target.Lookup("/a/one")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a rename that would succeed says so, but leaves the backend alone.
+	rename(t, target, a, "one", b, "moved")
+	if _, err := mem.Stat("/a/one"); err != nil {
+		t.Fatalf("expected /a/one to be left in place: %v", err)
+	}
+	if _, err := mem.Stat("/b/moved"); !os.IsNotExist(err) {
+		t.Fatalf("expected /b/moved not to be made, got %v", err)
+	}
+	if _, err := target.GetAttr(one); err != nil {
+		t.Fatalf("expected the handle of /a/one to still name it: %v", err)
+	}
+
+	// one that would fail gets the status it would have.
+	if reply := tryRename(t, target, a, "missing", b, "moved"); reply.Status != nfsc.NFS3ErrNoEnt {
+		t.Fatalf("expected NOENT renaming a missing file, got %d", reply.Status)
+	}
+
+	// as do other changes, while reads behave normally.
+	if err := target.Remove("/b/two"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.Stat("/b/two"); err != nil {
+		t.Fatalf("expected /b/two to be left in place: %v", err)
+	}
+	if reply := write(t, target, one, []byte("changed"), 2); reply.Count != 7 {
+		t.Fatalf("expected 7 bytes reported written, got %d", reply.Count)
+	}
+	f, err := target.Open("/a/one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "1" {
+		t.Fatalf("expected the file to be unchanged, read %q", data)
+	}
+}
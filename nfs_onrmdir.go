@@ -1,9 +1,85 @@
 package nfs
 
 import (
+	"bytes"
 	"context"
+	"os"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
 )
 
 func onRmDir(ctx context.Context, w *response, userHandle Handler) error {
-	return onRemove(ctx, w, userHandle)
+	w.errorFmt = wccDataErrorFormatter
+	obj, err := ReadDirOpArg(w.req.Body)
+	if err != nil {
+		return &NFSStatusError{NFSStatusInval, err}
+	}
+	fs, path, err := w.fromHandle(ctx, userHandle, obj.Handle)
+	if err != nil {
+		return &NFSStatusError{NFSStatusStale, err}
+	}
+
+	if !billy.CapabilityCheck(fs, billy.WriteCapability) {
+		return &NFSStatusError{NFSStatusROFS, os.ErrPermission}
+	}
+
+	if nameTooLong(userHandle, obj.Filename) {
+		return &NFSStatusError{NFSStatusNameTooLong, nil}
+	}
+	if string(obj.Filename) == "." {
+		return &NFSStatusError{NFSStatusInval, os.ErrInvalid}
+	}
+	if string(obj.Filename) == ".." {
+		return &NFSStatusError{NFSStatusExist, os.ErrExist}
+	}
+
+	dirInfo, err := statDir(fs, path)
+	if err != nil {
+		return err
+	}
+	preCacheData := w.fileAttribute(userHandle, fs, dirInfo, path).AsCache()
+
+	toDeletePath := append(path, w.Server.entryName(userHandle, fs, path, string(obj.Filename)))
+	toDelete := fs.Join(toDeletePath...)
+
+	// Check the target is an empty directory first, as backends differ in
+	// whether and how they refuse to remove anything else.
+	info, err := fs.Lstat(toDelete)
+	if err != nil {
+		return &NFSStatusError{statusFromRemoveError(err), err}
+	}
+	if !info.IsDir() {
+		return &NFSStatusError{NFSStatusNotDir, nil}
+	}
+	contents, err := fs.ReadDir(toDelete)
+	if err != nil {
+		return &NFSStatusError{statusFromRemoveError(err), err}
+	}
+	if len(contents) > 0 {
+		return &NFSStatusError{NFSStatusNotEmpty, nil}
+	}
+
+	if err := fs.Remove(toDelete); err != nil {
+		return &NFSStatusError{statusFromRemoveError(err), err}
+	}
+	if err := invalidateRemoved(userHandle, fs, toDeletePath); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+
+	w.notifyChange(userHandle, ChangeRemove, fs, toDeletePath)
+
+	writer := bytes.NewBuffer([]byte{})
+	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+
+	if err := WriteWcc(writer, preCacheData, w.tryStat(userHandle, fs, path)); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+
+	if err := w.Write(writer.Bytes()); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	return nil
 }
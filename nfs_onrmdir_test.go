@@ -0,0 +1,41 @@
+package nfs_test
+
+import (
+	"testing"
+
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+)
+
+func TestRmDir(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/full/file": "hello", "/file": "hello"})
+	if err := mem.MkdirAll("/empty", 0755); err != nil {
+		t.Fatal(err)
+	}
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+	if err := target.RmDir("/empty"); err != nil {
+		t.Fatalf("removing an empty directory failed: %v", err)
+	}
+	if _, err := mem.Stat("/empty"); err == nil {
+		t.Fatal("empty directory still exists after rmdir")
+	}
+
+	if err := target.RmDir("/full"); nfsStatus(err) != nfsc.NFS3ErrNotEmpty {
+		t.Fatalf("expected NOTEMPTY removing a non-empty directory, got %v", err)
+	}
+	if _, err := mem.Stat("/full/file"); err != nil {
+		t.Fatalf("contents of non-empty directory lost: %v", err)
+	}
+
+	if err := target.RmDir("/file"); nfsStatus(err) != nfsc.NFS3ErrNotDir {
+		t.Fatalf("expected NOTDIR removing a file with rmdir, got %v", err)
+	}
+	if _, err := mem.Stat("/file"); err != nil {
+		t.Fatalf("file removed by rmdir: %v", err)
+	}
+}
@@ -11,12 +11,14 @@ import (
 
 func onSetAttr(ctx context.Context, w *response, userHandle Handler) error {
 	w.errorFmt = wccDataErrorFormatter
-	handle, err := xdr.ReadOpaque(w.req.Body)
-	if err != nil {
+	// the sattr3 that follows starts after the handle's padding, which
+	// xdr.ReadOpaque would leave unread.
+	var handle []byte
+	if err := xdr.Read(w.req.Body, &handle); err != nil {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
 
-	fs, path, err := userHandle.FromHandle(handle)
+	fs, path, err := w.fromHandle(ctx, userHandle, handle)
 	if err != nil {
 		return &NFSStatusError{NFSStatusStale, err}
 	}
@@ -28,11 +30,11 @@ func onSetAttr(ctx context.Context, w *response, userHandle Handler) error {
 	fullPath := fs.Join(path...)
 	info, err := fs.Lstat(fullPath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return &NFSStatusError{NFSStatusNoEnt, err}
-		}
-		return &NFSStatusError{NFSStatusAccess, err}
+		return &NFSStatusError{mapError(err), err}
 	}
+	// capture the attributes before any change: a FileInfo need not be a
+	// snapshot, and the wcc data must show what the client last saw.
+	preAttr := w.fileAttribute(userHandle, fs, info, path)
 
 	// see if there's a "guard"
 	if guard, err := xdr.ReadUint32(w.req.Body); err != nil {
@@ -43,8 +45,7 @@ func onSetAttr(ctx context.Context, w *response, userHandle Handler) error {
 		if err := xdr.Read(w.req.Body, &t); err != nil {
 			return &NFSStatusError{NFSStatusInval, err}
 		}
-		attr := ToFileAttribute(info, fullPath)
-		if t != attr.Ctime {
+		if t != preAttr.Ctime {
 			return &NFSStatusError{NFSStatusNotSync, nil}
 		}
 	}
@@ -58,14 +59,17 @@ func onSetAttr(ctx context.Context, w *response, userHandle Handler) error {
 		// Already an nfsstatuserror
 		return err
 	}
+	// Having set the attributes of a file it created exclusively, the
+	// client has committed to it: later creates of it are not retries.
+	w.Server.createVerifiers.forget(fs, fullPath)
 
-	preAttr := ToFileAttribute(info, fullPath).AsCache()
+	w.notifyChange(userHandle, ChangeSetAttr, fs, path)
 
 	writer := bytes.NewBuffer([]byte{})
 	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
-	if err := WriteWcc(writer, preAttr, tryStat(fs, path)); err != nil {
+	if err := WriteWcc(writer, preAttr.AsCache(), w.tryStat(userHandle, fs, path)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
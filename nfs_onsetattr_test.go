@@ -0,0 +1,185 @@
+package nfs_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+type setAttrReply struct {
+	Status uint32
+	Wcc    nfsc.WccData
+}
+
+// setAttr issues a SETATTR of sattr on fh, guarded by ctime if it is set.
+func setAttr(t *testing.T, target *nfsc.Target, fh []byte, sattr nfsc.Sattr3, ctime *nfsc.NFS3Time) setAttrReply {
+	t.Helper()
+	type guard struct {
+		Check bool          `xdr:"union"`
+		Ctime nfsc.NFS3Time `xdr:"unioncase=1"`
+	}
+	args := struct {
+		rpc.Header
+		Handle []byte
+		Attrs  nfsc.Sattr3
+		Guard  guard
+	}{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfsc.Nfs3Prog,
+			Vers:    nfsc.Nfs3Vers,
+			Proc:    nfsc.NFSProc3SetAttr,
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		Handle: fh,
+		Attrs:  sattr,
+	}
+	if ctime != nil {
+		args.Guard = guard{Check: true, Ctime: *ctime}
+	}
+	res, err := target.Call(&args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply setAttrReply
+	if err := xdr.Read(res, &reply); err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+func TestSetAttrSize(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/file": "hello"})
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+	_, fh, err := target.Lookup("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		size uint64
+		want []byte
+	}{
+		{10, []byte("hello\x00\x00\x00\x00\x00")},
+		{2, []byte("he")},
+	} {
+		sattr := nfsc.Sattr3{Size: nfsc.SetSize{SetIt: true, Size: tc.size}}
+		reply := setAttr(t, target, fh, sattr, nil)
+		if reply.Status != nfsc.NFS3Ok {
+			t.Fatalf("truncating to %d: status %d", tc.size, reply.Status)
+		}
+		if got := reply.Wcc.After.Attr.Filesize; got != tc.size {
+			t.Fatalf("truncating to %d: post-op size %d", tc.size, got)
+		}
+
+		f, err := mem.Open("/file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents := make([]byte, 32)
+		n, _ := f.Read(contents)
+		_ = f.Close()
+		if !bytes.Equal(contents[:n], tc.want) {
+			t.Fatalf("truncating to %d: contents %q, expected %q", tc.size, contents[:n], tc.want)
+		}
+	}
+
+	_, dir, err := target.Lookup("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := setAttr(t, target, dir, nfsc.Sattr3{Size: nfsc.SetSize{SetIt: true}}, nil)
+	if reply.Status != uint32(nfs.NFSStatusInval) {
+		t.Fatalf("expected INVAL truncating a directory, got %d", reply.Status)
+	}
+}
+
+func TestSetAttrGuard(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/file": "hello"})
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+	_, fh, err := target.Lookup("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	attr, err := target.GetAttr(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sattr := nfsc.Sattr3{Size: nfsc.SetSize{SetIt: true, Size: 1}}
+
+	stale := attr.Ctime
+	stale.Seconds--
+	if reply := setAttr(t, target, fh, sattr, &stale); reply.Status != uint32(nfs.NFSStatusNotSync) {
+		t.Fatalf("expected NOT_SYNC with a stale guard, got %d", reply.Status)
+	}
+	if info, err := mem.Stat("/file"); err != nil || info.Size() != 5 {
+		t.Fatalf("file changed despite the failed guard: %v, %v", info, err)
+	}
+
+	if reply := setAttr(t, target, fh, sattr, &attr.Ctime); reply.Status != nfsc.NFS3Ok {
+		t.Fatalf("expected a matching guard to succeed, got %d", reply.Status)
+	}
+	if info, err := mem.Stat("/file"); err != nil || info.Size() != 1 {
+		t.Fatalf("file not truncated after a matching guard: %v, %v", info, err)
+	}
+}
+
+// liveInfoFS returns FileInfos that look the file up again on every call,
+// as backends with lazily fetched metadata do.
+type liveInfoFS struct {
+	billy.Filesystem
+}
+
+type liveInfo struct {
+	os.FileInfo
+	fs   billy.Filesystem
+	name string
+}
+
+func (i liveInfo) Size() int64 {
+	if info, err := i.fs.Stat(i.name); err == nil {
+		return info.Size()
+	}
+	return i.FileInfo.Size()
+}
+
+func (l liveInfoFS) Lstat(filename string) (os.FileInfo, error) {
+	info, err := l.Filesystem.Lstat(filename)
+	if err != nil {
+		return nil, err
+	}
+	return liveInfo{info, l.Filesystem, filename}, nil
+}
+
+func TestSetAttrWccBefore(t *testing.T) {
+	mem := liveInfoFS{newTestFS(t, map[string]string{"/file": "hello"})}
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+	_, fh, err := target.Lookup("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := setAttr(t, target, fh, nfsc.Sattr3{Size: nfsc.SetSize{SetIt: true, Size: 2}}, nil)
+	if reply.Status != nfsc.NFS3Ok {
+		t.Fatalf("truncating: status %d", reply.Status)
+	}
+	if !reply.Wcc.Before.IsSet || reply.Wcc.Before.Size != 5 {
+		t.Fatalf("expected the pre-op size 5, got %+v", reply.Wcc.Before)
+	}
+	if size := reply.Wcc.After.Attr.Filesize; size != 2 {
+		t.Fatalf("expected the post-op size 2, got %d", size)
+	}
+}
@@ -25,7 +25,7 @@ type writeArgs struct {
 	Offset uint64
 	Count  uint32
 	How    uint32
-	Data   []byte
+	// the data follows, streamed to the file by writeData.
 }
 
 func onWrite(ctx context.Context, w *response, userHandle Handler) error {
@@ -34,74 +34,131 @@ func onWrite(ctx context.Context, w *response, userHandle Handler) error {
 	if err := xdr.Read(w.req.Body, &req); err != nil {
 		return &NFSStatusError{NFSStatusInval, err}
 	}
+	// The data follows, copied to the file a chunk at a time once the call
+	// is known to be allowed rather than held in memory whole.
+	dataLen, err := xdr.ReadUint32(w.req.Body)
+	if err != nil {
+		return &NFSStatusError{NFSStatusInval, err}
+	}
+	if left, ok := remaining(w.req.Body); ok && int64(dataLen) > left {
+		return &NFSStatusError{NFSStatusInval, io.ErrUnexpectedEOF}
+	}
 
-	fs, path, err := userHandle.FromHandle(req.Handle)
+	fs, path, err := w.fromHandle(ctx, userHandle, req.Handle)
 	if err != nil {
 		return &NFSStatusError{NFSStatusStale, err}
 	}
 	if !billy.CapabilityCheck(fs, billy.WriteCapability) {
 		return &NFSStatusError{NFSStatusROFS, os.ErrPermission}
 	}
-	if len(req.Data) > math.MaxInt32 || req.Count > math.MaxInt32 {
+	if dataLen > math.MaxInt32 || req.Count > math.MaxInt32 {
 		return &NFSStatusError{NFSStatusFBig, os.ErrInvalid}
 	}
 	if req.How != uint32(unstable) && req.How != uint32(dataSync) && req.How != uint32(fileSync) {
 		return &NFSStatusError{NFSStatusInval, os.ErrInvalid}
 	}
+	if err := checkRangeLock(ctx, userHandle, fs, path, req.Offset, uint64(req.Count), true); err != nil {
+		return err
+	}
+	w.pace(ctx, int(dataLen))
+
+	release, err := w.admit(ctx, fs, path)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	locked := false
+	if locker, ok := userHandle.(WriteLockingHandler); ok {
+		if unlock := locker.LockWrites(fs, path); unlock != nil {
+			defer unlock()
+			locked = true
+		}
+	}
 
 	// stat first for pre-op wcc.
 	fullPath := fs.Join(path...)
 	info, err := fs.Stat(fullPath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return &NFSStatusError{NFSStatusNoEnt, err}
-		}
-		return &NFSStatusError{NFSStatusAccess, err}
+		return &NFSStatusError{mapError(err), err}
 	}
 	if !info.Mode().IsRegular() {
 		return &NFSStatusError{NFSStatusInval, os.ErrInvalid}
 	}
-	preOpCache := ToFileAttribute(info, fullPath).AsCache()
+	preOpCache := w.fileAttribute(userHandle, fs, info, path).AsCache()
 
-	// now the actual op.
-	file, err := fs.OpenFile(fs.Join(path...), os.O_RDWR, info.Mode().Perm())
+	// now the actual op. While no other write can move the end of the
+	// file, a write starting there is an append, which backends supporting
+	// O_APPEND extend the file with atomically.
+	flag := os.O_RDWR
+	if locked && req.Offset == uint64(info.Size()) {
+		flag |= os.O_APPEND
+	}
+	file, err := fs.OpenFile(fs.Join(path...), flag, info.Mode().Perm())
 	if err != nil {
-		return &NFSStatusError{NFSStatusAccess, err}
+		return &NFSStatusError{mapError(err), err}
 	}
 	if req.Offset > 0 {
 		if _, err := file.Seek(int64(req.Offset), io.SeekStart); err != nil {
-			return &NFSStatusError{NFSStatusIO, err}
+			_ = file.Close()
+			return &NFSStatusError{mapError(err), err}
 		}
 	}
 	end := req.Count
-	if len(req.Data) < int(end) {
-		end = uint32(len(req.Data))
+	if dataLen < end {
+		end = dataLen
 	}
-	writtenCount, err := file.Write(req.Data[:end])
+	// clients resend whatever is not reported written.
+	if max := w.maxTransferSize(); end > max {
+		end = max
+	}
+	writtenCount, err := writeData(file, w.req.Body, end)
 	if err != nil {
-		Log.Errorf("Error writing: %v", err)
-		return &NFSStatusError{statusFromWriteError(err), err}
+		_ = file.Close()
+		w.logger().Errorf("Error writing: %v", err)
+		return err
+	}
+	// Data written to the backend is as durable as it gets unless its files
+	// can be synced, so only stable writes to such files need to wait.
+	committed := fileSync
+	if req.How == uint32(unstable) {
+		committed = unstable
+	} else if s, ok := file.(syncer); ok {
+		if err := s.Sync(); err != nil {
+			_ = file.Close()
+			w.logger().Errorf("error syncing: %v", err)
+			return &NFSStatusError{mapError(err), err}
+		}
 	}
 	if err := file.Close(); err != nil {
-		Log.Errorf("error closing: %v", err)
-		return &NFSStatusError{statusFromWriteError(err), err}
+		w.logger().Errorf("error closing: %v", err)
+		return &NFSStatusError{mapError(err), err}
 	}
 
+	w.notifyChange(userHandle, ChangeWrite, fs, path)
+
 	writer := bytes.NewBuffer([]byte{})
 	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
-	if err := WriteWcc(writer, preOpCache, tryStat(fs, path)); err != nil {
+	// Backends may not reflect a write in their metadata straight away, but
+	// a client told the file didn't grow would think the write was lost.
+	postOp := w.tryStat(userHandle, fs, path)
+	if end := req.Offset + uint64(writtenCount); postOp != nil && postOp.Filesize < end {
+		postOp.Filesize = end
+	}
+	if err := WriteWcc(writer, preOpCache, postOp); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 	if err := xdr.Write(writer, uint32(writtenCount)); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
-	if err := xdr.Write(writer, fileSync); err != nil {
+	if err := xdr.Write(writer, committed); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
-	if err := xdr.Write(writer, w.Server.ID); err != nil {
+	// The same verifier is returned by COMMIT.
+	if err := xdr.Write(writer, w.Server.WriteVerifier()); err != nil {
 		return &NFSStatusError{NFSStatusServerFault, err}
 	}
 
@@ -110,3 +167,30 @@ func onWrite(ctx context.Context, w *response, userHandle Handler) error {
 	}
 	return nil
 }
+
+// writeData copies n bytes of the data of a WRITE from r to file, through a
+// buffer of writeChunkSize bytes, returning how many were written.
+func writeData(file io.Writer, r io.Reader, n uint32) (int, error) {
+	buf := writeChunks.Get().(*[]byte)
+	defer writeChunks.Put(buf)
+	written := 0
+	for left := int(n); left > 0; {
+		chunk := *buf
+		if left < len(chunk) {
+			chunk = chunk[:left]
+		}
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return written, &NFSStatusError{NFSStatusInval, err}
+		}
+		m, err := file.Write(chunk)
+		written += m
+		if err == nil && m < len(chunk) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			return written, &NFSStatusError{mapError(err), err}
+		}
+		left -= m
+	}
+	return written, nil
+}
@@ -0,0 +1,694 @@
+package nfs_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+type writeReply struct {
+	Status    uint32
+	Wcc       nfsc.WccData
+	Count     uint32
+	Committed uint32
+	Verf      [8]byte
+}
+
+// write issues a WRITE at the given stability level.
+func write(t *testing.T, target *nfsc.Target, fh []byte, data []byte, how uint32) writeReply {
+	t.Helper()
+	return writeAt(t, target, fh, 0, data, how)
+}
+
+// writeAt issues a WRITE of data at offset.
+func writeAt(t *testing.T, target *nfsc.Target, fh []byte, offset uint64, data []byte, how uint32) writeReply {
+	t.Helper()
+	reply := tryWriteAt(t, target, fh, offset, data, how)
+	if reply.Status != nfsc.NFS3Ok {
+		t.Fatalf("write failed with status %d", reply.Status)
+	}
+	return reply
+}
+
+// tryWriteAt issues a WRITE of data at offset, which may fail.
+func tryWriteAt(t *testing.T, target *nfsc.Target, fh []byte, offset uint64, data []byte, how uint32) writeReply {
+	t.Helper()
+	return tryWriteAs(t, target, rpc.AuthNull, fh, offset, data, how)
+}
+
+// tryWriteAs issues a WRITE of data at offset with the credential cred,
+// which may fail.
+func tryWriteAs(t *testing.T, target *nfsc.Target, cred rpc.Auth, fh []byte, offset uint64, data []byte, how uint32) writeReply {
+	t.Helper()
+	type writeArgs struct {
+		rpc.Header
+		FH       []byte
+		Offset   uint64
+		Count    uint32
+		How      uint32
+		Contents []byte
+	}
+	res, err := target.Call(&writeArgs{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfsc.Nfs3Prog,
+			Vers:    nfsc.Nfs3Vers,
+			Proc:    nfsc.NFSProc3Write,
+			Cred:    cred,
+			Verf:    rpc.AuthNull,
+		},
+		FH:       fh,
+		Offset:   offset,
+		Count:    uint32(len(data)),
+		How:      how,
+		Contents: data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply writeReply
+	if reply.Status, err = xdr.ReadUint32(res); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Status != nfsc.NFS3Ok {
+		if err := xdr.Read(res, &reply.Wcc); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+	var ok struct {
+		Wcc       nfsc.WccData
+		Count     uint32
+		Committed uint32
+		Verf      [8]byte
+	}
+	if err := xdr.Read(res, &ok); err != nil {
+		t.Fatal(err)
+	}
+	reply.Wcc, reply.Count, reply.Committed, reply.Verf = ok.Wcc, ok.Count, ok.Committed, ok.Verf
+	return reply
+}
+
+func TestWriteStability(t *testing.T) {
+	const (
+		unstable = 0
+		dataSync = 1
+		fileSync = 2
+	)
+	mem := &syncCountingFS{Filesystem: newTestFS(t, map[string]string{"/test": "hello"})}
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+	_, fh, err := target.Lookup("/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verf := commit(t, target, fh)
+	atomic.StoreInt32(&mem.syncs, 0)
+
+	for _, tc := range []struct {
+		name      string
+		how       uint32
+		committed uint32
+		syncs     int32
+	}{
+		{"unstable", unstable, unstable, 0},
+		{"data sync", dataSync, fileSync, 1},
+		{"file sync", fileSync, fileSync, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := atomic.LoadInt32(&mem.syncs)
+			reply := write(t, target, fh, []byte("HELLO"), tc.how)
+			if reply.Count != 5 {
+				t.Fatalf("expected 5 bytes written, got %d", reply.Count)
+			}
+			if reply.Committed != tc.committed {
+				t.Fatalf("expected committed level %d, got %d", tc.committed, reply.Committed)
+			}
+			if reply.Verf != verf {
+				t.Fatalf("write verifier %x does not match commit verifier %x", reply.Verf, verf)
+			}
+			if n := atomic.LoadInt32(&mem.syncs) - before; n != tc.syncs {
+				t.Fatalf("expected %d syncs, got %d", tc.syncs, n)
+			}
+		})
+	}
+}
+
+// lazySizeFS reports file sizes as they were when the file was last opened,
+// like backends that only refresh metadata once a file is flushed.
+type lazySizeFS struct {
+	billy.Filesystem
+	mu    sync.Mutex
+	sizes map[string]int64
+}
+
+type sizedInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (i sizedInfo) Size() int64 { return i.size }
+
+func (l *lazySizeFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if info, err := l.Filesystem.Stat(filename); err == nil {
+		l.mu.Lock()
+		l.sizes[l.Join("/", filename)] = info.Size()
+		l.mu.Unlock()
+	}
+	return l.Filesystem.OpenFile(filename, flag, perm)
+}
+
+func (l *lazySizeFS) Lstat(filename string) (os.FileInfo, error) {
+	info, err := l.Filesystem.Lstat(filename)
+	if err != nil {
+		return info, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if size, ok := l.sizes[l.Join("/", filename)]; ok {
+		return sizedInfo{info, size}, nil
+	}
+	return info, nil
+}
+
+func TestWriteGrowsFile(t *testing.T) {
+	const fileSync = 2
+	for _, tc := range []struct {
+		name string
+		fs   func(billy.Filesystem) billy.Filesystem
+	}{
+		{"eager metadata", func(fs billy.Filesystem) billy.Filesystem { return fs }},
+		{"lazy metadata", func(fs billy.Filesystem) billy.Filesystem {
+			return &lazySizeFS{Filesystem: fs, sizes: make(map[string]int64)}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mem := tc.fs(newTestFS(t, map[string]string{"/test": "hello"}))
+			handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+			target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+			_, fh, err := target.Lookup("/test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply := writeAt(t, target, fh, 10, []byte("world"), fileSync)
+			if !reply.Wcc.After.IsSet {
+				t.Fatal("expected post-op attributes from write")
+			}
+			if size := reply.Wcc.After.Attr.Filesize; size != 15 {
+				t.Fatalf("expected post-op size 15 after writing past EOF, got %d", size)
+			}
+		})
+	}
+}
+
+// blobFS stores each file as a whole, like an object store: a file opened
+// for writing works on a copy of its contents, which replaces the stored
+// file when closed. Concurrent writers can therefore lose each other's data.
+// Replacing a file is atomic to those inspecting it, as in an object store.
+type blobFS struct {
+	billy.Filesystem
+	mu sync.RWMutex
+}
+
+func (b *blobFS) Stat(filename string) (os.FileInfo, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.Filesystem.Stat(filename)
+}
+
+func (b *blobFS) Lstat(filename string) (os.FileInfo, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.Filesystem.Lstat(filename)
+}
+
+func (b *blobFS) Open(filename string) (billy.File, error) {
+	return b.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (b *blobFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	f, err := b.Filesystem.OpenFile(filename, os.O_RDONLY, perm)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := io.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	return &blobFile{File: f, fs: b, name: filename, contents: contents, writable: writable}, nil
+}
+
+type blobFile struct {
+	billy.File
+	fs       *blobFS
+	name     string
+	writable bool
+	contents []byte
+	pos      int64
+}
+
+func (f *blobFile) Seek(offset int64, whence int) (int64, error) {
+	if whence != io.SeekStart {
+		return 0, os.ErrInvalid
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *blobFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *blobFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(f.contents)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.contents[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *blobFile) Write(p []byte) (int, error) {
+	if end := f.pos + int64(len(p)); end > int64(len(f.contents)) {
+		f.contents = append(f.contents, make([]byte, end-int64(len(f.contents)))...)
+	}
+	n := copy(f.contents[f.pos:], p)
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *blobFile) Close() error {
+	if !f.writable {
+		return nil
+	}
+	// widen the window in which another writer can be overwritten.
+	time.Sleep(time.Millisecond)
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	out, err := f.fs.Filesystem.Create(f.name)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(f.contents); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func TestConcurrentAppends(t *testing.T) {
+	const (
+		writers = 8
+		records = 8
+		recLen  = 16
+	)
+	mem := &blobFS{Filesystem: newTestFS(t, map[string]string{"/log": ""})}
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024, helpers.WithWriteLocking())
+
+	// Each writer appends its records at the next free offset, as writers
+	// sharing a log would, over its own connection.
+	var next int64
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		client := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			f, err := client.OpenFile("/log", 0644)
+			if err != nil {
+				errs <- err
+				return
+			}
+			for j := 0; j < records; j++ {
+				rec := []byte(fmt.Sprintf("w%02d r%02d........", i, j))[:recLen]
+				off := atomic.AddInt64(&next, recLen) - recLen
+				if _, err := f.Seek(off, io.SeekStart); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := f.Write(rec); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	f, err := mem.Open("/log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents) != writers*records*recLen {
+		t.Fatalf("expected %d bytes, got %d", writers*records*recLen, len(contents))
+	}
+	for i := 0; i < writers; i++ {
+		for j := 0; j < records; j++ {
+			if !bytes.Contains(contents, []byte(fmt.Sprintf("w%02d r%02d", i, j))) {
+				t.Fatalf("record %d of writer %d was lost", j, i)
+			}
+		}
+	}
+}
+
+// appendCountingFS counts the opens of files with O_APPEND.
+type appendCountingFS struct {
+	billy.Filesystem
+	appends atomic.Int32
+}
+
+func (f *appendCountingFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag&os.O_APPEND != 0 {
+		f.appends.Add(1)
+	}
+	return f.Filesystem.OpenFile(filename, flag, perm)
+}
+
+func TestWriteAppendsAtEOF(t *testing.T) {
+	const fileSync = 2
+	for _, locking := range []bool{false, true} {
+		t.Run(fmt.Sprintf("locking=%v", locking), func(t *testing.T) {
+			fs := &appendCountingFS{Filesystem: newTestFS(t, map[string]string{"/log": "hello"})}
+			var opts []helpers.CachingOption
+			if locking {
+				opts = append(opts, helpers.WithWriteLocking())
+			}
+			target := serveAndMount(t, &nfs.Server{Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024, opts...)}, rpc.AuthNull)
+			_, fh, err := target.Lookup("/log")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// only a write at the end of the file, with writes locked,
+			// is an append.
+			writeAt(t, target, fh, 0, []byte("H"), fileSync)
+			writeAt(t, target, fh, 5, []byte(" world"), fileSync)
+			want := int32(0)
+			if locking {
+				want = 1
+			}
+			if got := fs.appends.Load(); got != want {
+				t.Fatalf("expected %d appends, got %d", want, got)
+			}
+			if contents, err := util.ReadFile(fs, "/log"); err != nil || string(contents) != "Hello world" {
+				t.Fatalf("expected the writes in place, got %q (%v)", contents, err)
+			}
+		})
+	}
+}
+
+// quotaFS fails to allocate space with err, wrapped as backends do: new
+// files cannot be created, and writes to existing files fail.
+type quotaFS struct {
+	billy.Filesystem
+	err error
+}
+
+func (f *quotaFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if _, err := f.Filesystem.Stat(filename); os.IsNotExist(err) && flag&os.O_CREATE != 0 {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: f.err}
+	}
+	file, err := f.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &quotaFile{File: file, err: f.err}, nil
+}
+
+type quotaFile struct {
+	billy.File
+	err error
+}
+
+func (f *quotaFile) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("flushing block: %w", &os.PathError{Op: "write", Path: f.Name(), Err: f.err})
+}
+
+func TestWriteDiskFull(t *testing.T) {
+	const fileSync = 2
+	for _, tc := range []struct {
+		name   string
+		err    error
+		status uint32
+	}{
+		{"no space", syscall.ENOSPC, nfsc.NFS3ErrNoSpc},
+		{"over quota", syscall.EDQUOT, nfsc.NFS3ErrDQuot},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mem := &quotaFS{Filesystem: newTestFS(t, map[string]string{"/test": "hello"}), err: tc.err}
+			handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+			target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+
+			_, fh, err := target.Lookup("/test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply := tryWriteAt(t, target, fh, 0, []byte("world"), fileSync); reply.Status != tc.status {
+				t.Fatalf("write: expected status %d, got %d", tc.status, reply.Status)
+			}
+
+			_, root, err := target.Lookup("/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply := create(t, target, root, "new", createUnchecked, [8]byte{}); reply.Status != tc.status {
+				t.Fatalf("create: expected status %d, got %d", tc.status, reply.Status)
+			}
+		})
+	}
+}
+
+func TestWriteRangeLocked(t *testing.T) {
+	const fileSync = 2
+	mem := newTestFS(t, map[string]string{"/file": strings.Repeat("x", 64)})
+	locks := helpers.NewLockTable()
+	srv := &nfs.Server{Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024, helpers.WithRangeLocks(locks))}
+	owner := rpc.NewAuthUnix("owner", 1000, 1000).Auth()
+	other := rpc.NewAuthUnix("other", 2000, 2000).Auth()
+	target := serveAndMount(t, srv, owner)
+	_, fh, err := target.Lookup("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := locks.Lock(mem, []string{"file"}, nfs.UnixPrincipal(1000), 8, 8, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		cred   rpc.Auth
+		offset uint64
+		want   uint32
+	}{
+		{"owner within the lock", owner, 8, nfsc.NFS3Ok},
+		{"other overlapping the lock", other, 6, nfsc.NFS3ErrAcces},
+		{"other past the lock", other, 16, nfsc.NFS3Ok},
+		{"unauthenticated within the lock", rpc.AuthNull, 12, nfsc.NFS3ErrAcces},
+	} {
+		if reply := tryWriteAs(t, target, tc.cred, fh, tc.offset, []byte("data"), fileSync); reply.Status != tc.want {
+			t.Fatalf("%s: write got status %d, want %d", tc.name, reply.Status, tc.want)
+		}
+	}
+
+	locks.Unlock(mem, []string{"file"}, nfs.UnixPrincipal(1000), 0, 0)
+	if reply := tryWriteAs(t, target, other, fh, 8, []byte("data"), fileSync); reply.Status != nfsc.NFS3Ok {
+		t.Fatalf("write after unlock got status %d", reply.Status)
+	}
+}
+
+// discardFS counts the bytes written to its files, keeping none of them.
+type discardFS struct {
+	billy.Filesystem
+	written int64
+}
+
+func (f *discardFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	file, err := f.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &discardFile{File: file, fs: f}, nil
+}
+
+type discardFile struct {
+	billy.File
+	fs *discardFS
+}
+
+func (f *discardFile) Write(p []byte) (int, error) {
+	atomic.AddInt64(&f.fs.written, int64(len(p)))
+	return len(p), nil
+}
+
+// streamWrite sends a WRITE of size bytes to the server listening at addr
+// over a connection of its own, generating the data as it goes rather
+// than holding it, and returns the reply.
+func streamWrite(t *testing.T, addr net.Addr, fh []byte, size uint32) writeReply {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	call := new(bytes.Buffer)
+	if err := xdr.Write(call, &struct {
+		Xid  uint32
+		Type uint32
+		rpc.Header
+		FH      []byte
+		Offset  uint64
+		Count   uint32
+		How     uint32
+		DataLen uint32
+	}{
+		Xid: 1,
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfsc.Nfs3Prog,
+			Vers:    nfsc.Nfs3Vers,
+			Proc:    nfsc.NFSProc3Write,
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		FH:      fh,
+		Count:   size,
+		How:     2,
+		DataLen: size,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var fragment [4]byte
+	binary.BigEndian.PutUint32(fragment[:], uint32(call.Len())+size|1<<31)
+	if _, err := conn.Write(append(fragment[:], call.Bytes()...)); err != nil {
+		t.Fatal(err)
+	}
+	chunk := bytes.Repeat([]byte("x"), 32<<10)
+	for left := int(size); left > 0; left -= len(chunk) {
+		if left < len(chunk) {
+			chunk = chunk[:left]
+		}
+		if _, err := conn.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(conn, fragment[:]); err != nil {
+		t.Fatal(err)
+	}
+	res := io.LimitReader(conn, int64(binary.BigEndian.Uint32(fragment[:])&^(1<<31)))
+	var head struct {
+		Xid        uint32
+		Type       uint32
+		ReplyStat  uint32
+		Verf       rpc.Auth
+		AcceptStat uint32
+	}
+	if err := xdr.Read(res, &head); err != nil {
+		t.Fatal(err)
+	}
+	if head.ReplyStat != rpc.MsgAccepted || head.AcceptStat != 0 {
+		t.Fatalf("expected the write accepted, got stat %d accept_stat %d", head.ReplyStat, head.AcceptStat)
+	}
+	var reply writeReply
+	if err := xdr.Read(res, &reply); err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+func TestWriteStreaming(t *testing.T) {
+	const size = nfs.MaxRead
+	fs := &discardFS{Filesystem: newTestFS(t, map[string]string{"/file": ""})}
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024)
+	srv := &nfs.Server{Handler: handler, ServerOptions: nfs.ServerOptions{MaxTransferSize: size}}
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		_ = srv.Serve(listener)
+	}()
+	fh := handler.ToHandle(fs, []string{"file"})
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	reply := streamWrite(t, listener.Addr(), fh, size)
+	runtime.ReadMemStats(&after)
+
+	if reply.Status != nfsc.NFS3Ok || reply.Count != size {
+		t.Fatalf("expected all %d bytes written, got status %d and %d bytes", size, reply.Status, reply.Count)
+	}
+	if written := atomic.LoadInt64(&fs.written); written != size {
+		t.Fatalf("expected %d bytes to reach the file, got %d", size, written)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size/8 {
+		t.Fatalf("writing %d bytes allocated %d bytes, expected the data streamed", size, allocated)
+	}
+}
+
+func TestWriteShortData(t *testing.T) {
+	fs := &discardFS{Filesystem: newTestFS(t, map[string]string{"/file": ""})}
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024)
+	srv := &nfs.Server{Handler: handler}
+	fh := handler.ToHandle(fs, []string{"file"})
+
+	// the data claims a megabyte, but the call holds four bytes of it.
+	res := callProcWithCredential(t, srv, nfsc.Nfs3Prog, nfsc.Nfs3Vers, nfsc.NFSProc3Write, rpc.AuthNull, rpc.AuthNull,
+		fh, uint64(0), uint32(1<<20), uint32(2), uint32(1<<20), uint32(0))
+	var reply struct {
+		Type       uint32
+		ReplyStat  uint32
+		Verf       rpc.Auth
+		AcceptStat uint32
+		Status     uint32
+	}
+	if err := xdr.Read(res, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Status != nfsc.NFS3ErrInval {
+		t.Fatalf("expected INVAL for data beyond the call, got status %d", reply.Status)
+	}
+	if written := atomic.LoadInt64(&fs.written); written != 0 {
+		t.Fatalf("expected nothing written, got %d bytes", written)
+	}
+}
@@ -2,13 +2,16 @@ package nfs_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
 	"os"
 	"reflect"
 	"sort"
 	"sync"
+	"syscall"
 	"testing"
 
 	"github.com/go-git/go-billy/v5"
@@ -91,6 +94,86 @@ func (f *trackingFile) Close() error {
 	return f.File.Close()
 }
 
+// dial connects an RPC client to the server listening at addr.
+func dial(t testing.TB, addr net.Addr) *rpc.Client {
+	t.Helper()
+	// The client binds a random local port, which can collide with one in
+	// use; try a few before giving up.
+	for attempt := 0; ; attempt++ {
+		c, err := rpc.DialTCP(addr.Network(), addr.String(), false)
+		if err == nil {
+			return c
+		}
+		if !errors.Is(err, syscall.EADDRINUSE) || attempt == 4 {
+			t.Fatal(err)
+		}
+	}
+}
+
+// serveAndMount runs srv on a loopback listener for the duration of the test
+// and returns a client mounted at the root of the export.
+func serveAndMount(t testing.TB, srv *nfs.Server, auth rpc.Auth) *nfsc.Target {
+	t.Helper()
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		_ = srv.Serve(listener)
+	}()
+
+	c := dial(t, listener.Addr())
+	t.Cleanup(c.Close)
+
+	var mounter nfsc.Mount
+	mounter.Client = c
+	target, err := mounter.Mount("/", auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = mounter.Unmount() })
+	return target
+}
+
+// newTestFS returns an in-memory filesystem holding the given files.
+func newTestFS(t testing.TB, files map[string]string) billy.Filesystem {
+	t.Helper()
+	mem := memfs.New()
+	for name, contents := range files {
+		f, err := mem.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return mem
+}
+
+// nfsStatus extracts the NFS status code from a client error.
+func nfsStatus(err error) uint32 {
+	switch {
+	case err == nil:
+		return nfsc.NFS3Ok
+	case errors.Is(err, os.ErrPermission):
+		return nfsc.NFS3ErrPerm
+	case errors.Is(err, os.ErrExist):
+		return nfsc.NFS3ErrExist
+	case errors.Is(err, os.ErrNotExist):
+		return nfsc.NFS3ErrNoEnt
+	}
+	var nfsErr *nfsc.Error
+	if errors.As(err, &nfsErr) {
+		return nfsErr.ErrorNum
+	}
+	return ^uint32(0)
+}
+
 func TestNFS(t *testing.T) {
 	if testing.Verbose() {
 		util.DefaultLogger.SetDebug(true)
@@ -120,10 +203,7 @@ func TestNFS(t *testing.T) {
 		_ = nfs.Serve(listener, cacheHelper)
 	}()
 
-	c, err := rpc.DialTCP(listener.Addr().Network(), listener.Addr().(*net.TCPAddr).String(), false)
-	if err != nil {
-		t.Fatal(err)
-	}
+	c := dial(t, listener.Addr())
 	defer c.Close()
 
 	var mounter nfsc.Mount
@@ -172,7 +252,8 @@ func TestNFS(t *testing.T) {
 	}
 	defer mf.Close()
 	buf := make([]byte, len(b))
-	if _, err = mf.Read(buf[:]); err != nil {
+	// reading up to the end of the file may report io.EOF along with it.
+	if _, err = mf.Read(buf[:]); err != nil && err != io.EOF {
 		t.Fatal(err)
 	}
 	if !bytes.Equal(buf, b) {
@@ -293,6 +374,33 @@ func readDir(target *nfsc.Target, dir string) ([]*readDirEntry, error) {
 		return nil, err
 	}
 
+	cookie := uint64(0)
+	cookieVerf := uint64(0)
+	eof := false
+
+	var entries []*readDirEntry
+	for !eof {
+		var page []*readDirEntry
+		page, cookieVerf, eof, err = readDirPage(target, fh, cookie, cookieVerf)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range page {
+			cookie = e.Cookie
+			if e.FileName == "." || e.FileName == ".." {
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+// readDirPage issues a single READDIR of the directory fh from cookie,
+// returning the entries of the reply, its cookie verifier and whether it
+// reached the end of the directory.
+func readDirPage(target *nfsc.Target, fh []byte, cookie, cookieVerf uint64) ([]*readDirEntry, uint64, bool, error) {
 	type readDirArgs struct {
 		rpc.Header
 		Handle      []byte
@@ -311,67 +419,55 @@ func readDir(target *nfsc.Target, dir string) ([]*readDirEntry, error) {
 		CookieVerf uint64
 	}
 
-	cookie := uint64(0)
-	cookieVerf := uint64(0)
-	eof := false
-
-	var entries []*readDirEntry
-	for !eof {
-		res, err := target.Call(&readDirArgs{
-			Header: rpc.Header{
-				Rpcvers: 2,
-				Vers:    nfsc.Nfs3Vers,
-				Prog:    nfsc.Nfs3Prog,
-				Proc:    uint32(nfs.NFSProcedureReadDir),
-				Cred:    rpc.AuthNull,
-				Verf:    rpc.AuthNull,
-			},
-			Handle:      fh,
-			Cookie:      cookie,
-			CookieVerif: cookieVerf,
-			Count:       4096,
-		})
-		if err != nil {
-			return nil, err
-		}
-
-		status, err := xdr.ReadUint32(res)
-		if err != nil {
-			return nil, err
-		}
-
-		if err = nfsc.NFS3Error(status); err != nil {
-			return nil, err
-		}
+	res, err := target.Call(&readDirArgs{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Vers:    nfsc.Nfs3Vers,
+			Prog:    nfsc.Nfs3Prog,
+			Proc:    uint32(nfs.NFSProcedureReadDir),
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+		Handle:      fh,
+		Cookie:      cookie,
+		CookieVerif: cookieVerf,
+		Count:       4096,
+	})
+	if err != nil {
+		return nil, 0, false, err
+	}
 
-		dirListOK := new(readDirListOK)
-		if err = xdr.Read(res, dirListOK); err != nil {
-			return nil, err
-		}
+	status, err := xdr.ReadUint32(res)
+	if err != nil {
+		return nil, 0, false, err
+	}
 
-		for {
-			var item readDirList
-			if err = xdr.Read(res, &item); err != nil {
-				return nil, err
-			}
+	if err = nfsc.NFS3Error(status); err != nil {
+		return nil, 0, false, err
+	}
 
-			if !item.IsSet {
-				break
-			}
+	dirListOK := new(readDirListOK)
+	if err = xdr.Read(res, dirListOK); err != nil {
+		return nil, 0, false, err
+	}
 
-			cookie = item.Entry.Cookie
-			if item.Entry.FileName == "." || item.Entry.FileName == ".." {
-				continue
-			}
-			entries = append(entries, &item.Entry)
+	var entries []*readDirEntry
+	for {
+		var item readDirList
+		if err = xdr.Read(res, &item); err != nil {
+			return nil, 0, false, err
 		}
 
-		if err = xdr.Read(res, &eof); err != nil {
-			return nil, err
+		if !item.IsSet {
+			break
 		}
+		entries = append(entries, &item.Entry)
+	}
 
-		cookieVerf = dirListOK.CookieVerf
+	var eof bool
+	if err = xdr.Read(res, &eof); err != nil {
+		return nil, 0, false, err
 	}
 
-	return entries, nil
+	return entries, dirListOK.CookieVerf, eof, nil
 }
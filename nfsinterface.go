@@ -101,6 +101,7 @@ const (
 	NFSStatusNoSPC       NFSStatus = 28
 	NFSStatusROFS        NFSStatus = 30
 	NFSStatusMlink       NFSStatus = 31
+	NFSStatusLoop        NFSStatus = 40 // ELOOP; not part of RFC 1813
 	NFSStatusNameTooLong NFSStatus = 63
 	NFSStatusNotEmpty    NFSStatus = 66
 	NFSStatusDQuot       NFSStatus = 69
@@ -150,6 +151,8 @@ func (s NFSStatus) String() string {
 		return "Read only file system"
 	case NFSStatusMlink:
 		return "Too many hard links"
+	case NFSStatusLoop:
+		return "Too many levels of symbolic links"
 	case NFSStatusNameTooLong:
 		return "Name too long"
 	case NFSStatusNotEmpty:
@@ -0,0 +1,185 @@
+package nfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// NLMProgram is the RPC program of the Network Lock Manager, through which
+// clients take byte-range locks on the files of an export.
+const NLMProgram = 100021
+
+// NLMVersion is the version of NLMProgram served, that of RFC 1813's NLM4.
+const NLMVersion = 4
+
+// NLMProcedure is a procedure of NLMProgram.
+type NLMProcedure uint32
+
+// NLMProcedure Codes
+const (
+	NLMProcNull   NLMProcedure = 0
+	NLMProcLock   NLMProcedure = 2
+	NLMProcUnlock NLMProcedure = 4
+)
+
+func (p NLMProcedure) String() string {
+	switch p {
+	case NLMProcNull:
+		return "Null"
+	case NLMProcLock:
+		return "Lock"
+	case NLMProcUnlock:
+		return "Unlock"
+	default:
+		return "Unknown"
+	}
+}
+
+// NLMStatus is the nlm4_stats an NLM procedure replies with.
+type NLMStatus uint32
+
+// NLMStatus Codes
+const (
+	NLMStatusGranted NLMStatus = iota
+	NLMStatusDenied
+	NLMStatusDeniedNoLocks
+	NLMStatusBlocked
+	NLMStatusDeniedGracePeriod
+	NLMStatusDeadlock
+	NLMStatusROFS
+	NLMStatusStaleFH
+	NLMStatusFBig
+	NLMStatusFailed
+)
+
+// nlmMaxNetobj bounds the cookies, names and handles of NLM calls, as
+// LM_MAXSTRLEN and MAXNETOBJ_SZ do.
+const nlmMaxNetobj = 1024
+
+func init() {
+	_ = RegisterMessageHandler(NLMProgram, uint32(NLMProcNull), onNull)
+	_ = RegisterMessageHandler(NLMProgram, uint32(NLMProcLock), onNLMLock)
+	_ = RegisterMessageHandler(NLMProgram, uint32(NLMProcUnlock), onNLMUnlock)
+}
+
+// nlmLock is an nlm4_lock, the range of a file a lock covers.
+type nlmLock struct {
+	CallerName []byte
+	Handle     []byte
+	Owner      []byte
+	Svid       uint32
+	Offset     uint64
+	Length     uint64
+}
+
+// readNLMLock reads an nlm4_lock from r, bounding its variable-length
+// fields before allocating them.
+func readNLMLock(r io.Reader) (nlmLock, error) {
+	var l nlmLock
+	var err error
+	if l.CallerName, err = readOpaque(r, nlmMaxNetobj); err != nil {
+		return nlmLock{}, err
+	}
+	if l.Handle, err = readOpaque(r, FHSize); err != nil {
+		return nlmLock{}, err
+	}
+	if l.Owner, err = readOpaque(r, nlmMaxNetobj); err != nil {
+		return nlmLock{}, err
+	}
+	var tail struct {
+		Svid   uint32
+		Offset uint64
+		Length uint64
+	}
+	if err := xdr.Read(r, &tail); err != nil {
+		return nlmLock{}, err
+	}
+	l.Svid, l.Offset, l.Length = tail.Svid, tail.Offset, tail.Length
+	return l, nil
+}
+
+// writeNLMRes replies to an NLM call with an nlm4_res, echoing its cookie.
+func (w *response) writeNLMRes(cookie []byte, status NLMStatus) error {
+	writer := bytes.NewBuffer([]byte{})
+	if err := xdr.Write(writer, cookie); err != nil {
+		return err
+	}
+	if err := xdr.Write(writer, uint32(status)); err != nil {
+		return err
+	}
+	return w.Write(writer.Bytes())
+}
+
+// onNLMLock takes the lock an nlm4_lockargs asks for through the handler's
+// LockHandler. Blocking requests are not queued: a conflicting lock is
+// denied, and the client retries it.
+func onNLMLock(ctx context.Context, w *response, userHandle Handler) error {
+	cookie, err := readOpaque(w.req.Body, nlmMaxNetobj)
+	if err != nil {
+		return &ResponseCodeGarbageArgsError{}
+	}
+	var flags struct {
+		Block     bool
+		Exclusive bool
+	}
+	if err := xdr.Read(w.req.Body, &flags); err != nil {
+		return &ResponseCodeGarbageArgsError{}
+	}
+	lock, err := readNLMLock(w.req.Body)
+	if err != nil {
+		return &ResponseCodeGarbageArgsError{}
+	}
+	var reclaim struct {
+		Reclaim bool
+		State   uint32
+	}
+	if err := xdr.Read(w.req.Body, &reclaim); err != nil {
+		return &ResponseCodeGarbageArgsError{}
+	}
+
+	lh, ok := userHandle.(LockHandler)
+	if !ok {
+		return w.writeNLMRes(cookie, NLMStatusDeniedNoLocks)
+	}
+	// only locks held before a restart may be taken back while clients
+	// reclaim them.
+	if w.Server.inGracePeriod() && !reclaim.Reclaim {
+		return w.writeNLMRes(cookie, NLMStatusDeniedGracePeriod)
+	}
+	fs, path, err := w.fromHandle(ctx, userHandle, lock.Handle)
+	if err != nil {
+		return w.writeNLMRes(cookie, NLMStatusStaleFH)
+	}
+	if err := lh.LockRange(ctx, fs, path, lock.Offset, lock.Length, flags.Exclusive); err != nil {
+		w.logger().Debugf("denying lock of %s: %v", fs.Join(path...), err)
+		return w.writeNLMRes(cookie, NLMStatusDenied)
+	}
+	return w.writeNLMRes(cookie, NLMStatusGranted)
+}
+
+// onNLMUnlock releases the range an nlm4_unlockargs names through the
+// handler's LockHandler.
+func onNLMUnlock(ctx context.Context, w *response, userHandle Handler) error {
+	cookie, err := readOpaque(w.req.Body, nlmMaxNetobj)
+	if err != nil {
+		return &ResponseCodeGarbageArgsError{}
+	}
+	lock, err := readNLMLock(w.req.Body)
+	if err != nil {
+		return &ResponseCodeGarbageArgsError{}
+	}
+
+	lh, ok := userHandle.(LockHandler)
+	if !ok {
+		return w.writeNLMRes(cookie, NLMStatusDeniedNoLocks)
+	}
+	fs, path, err := w.fromHandle(ctx, userHandle, lock.Handle)
+	if err != nil {
+		return w.writeNLMRes(cookie, NLMStatusStaleFH)
+	}
+	lh.UnlockRange(ctx, fs, path, lock.Offset, lock.Length)
+	return w.writeNLMRes(cookie, NLMStatusGranted)
+}
@@ -0,0 +1,119 @@
+package nfs_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// nlmLock is an nlm4_lock, as a client sends it.
+type nlmLock struct {
+	CallerName string
+	FH         []byte
+	Owner      []byte
+	Svid       uint32
+	Offset     uint64
+	Length     uint64
+}
+
+// nlmCall issues the NLM procedure proc with the credential cred, and
+// returns the status of its nlm4_res, checking the cookie is echoed.
+func nlmCall(t *testing.T, target *nfsc.Target, cred rpc.Auth, proc nfs.NLMProcedure, args interface{}) nfs.NLMStatus {
+	t.Helper()
+	cookie := []byte("cookie")
+	res, err := target.Call(&struct {
+		rpc.Header
+		Cookie []byte
+		Args   interface{}
+	}{
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    nfs.NLMProgram,
+			Vers:    nfs.NLMVersion,
+			Proc:    uint32(proc),
+			Cred:    cred,
+			Verf:    rpc.AuthNull,
+		},
+		Cookie: cookie,
+		Args:   args,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply struct {
+		Cookie []byte
+		Status uint32
+	}
+	if err := xdr.Read(res, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply.Cookie, cookie) {
+		t.Fatalf("expected the cookie %q echoed, got %q", cookie, reply.Cookie)
+	}
+	return nfs.NLMStatus(reply.Status)
+}
+
+// nlmLockCall issues an NLM LOCK of length bytes at offset of fh.
+func nlmLockCall(t *testing.T, target *nfsc.Target, cred rpc.Auth, fh []byte, offset, length uint64, exclusive bool) nfs.NLMStatus {
+	t.Helper()
+	return nlmCall(t, target, cred, nfs.NLMProcLock, &struct {
+		Block     bool
+		Exclusive bool
+		Lock      nlmLock
+		Reclaim   bool
+		State     uint32
+	}{
+		Exclusive: exclusive,
+		Lock:      nlmLock{CallerName: "client", FH: fh, Owner: []byte("owner"), Offset: offset, Length: length},
+	})
+}
+
+// nlmUnlockCall issues an NLM UNLOCK of length bytes at offset of fh.
+func nlmUnlockCall(t *testing.T, target *nfsc.Target, cred rpc.Auth, fh []byte, offset, length uint64) nfs.NLMStatus {
+	t.Helper()
+	return nlmCall(t, target, cred, nfs.NLMProcUnlock, &nlmLock{CallerName: "client", FH: fh, Owner: []byte("owner"), Offset: offset, Length: length})
+}
+
+func TestNLMLock(t *testing.T) {
+	const fileSync = 2
+	mem := newTestFS(t, map[string]string{"/file": strings.Repeat("x", 64)})
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024, helpers.WithRangeLocks(helpers.NewLockTable()))
+	owner := rpc.NewAuthUnix("owner", 1000, 1000).Auth()
+	other := rpc.NewAuthUnix("other", 2000, 2000).Auth()
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, owner)
+	_, fh, err := target.Lookup("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := nlmLockCall(t, target, owner, fh, 8, 8, true); status != nfs.NLMStatusGranted {
+		t.Fatalf("lock got status %d", status)
+	}
+	if status := nlmLockCall(t, target, other, fh, 12, 8, false); status != nfs.NLMStatusDenied {
+		t.Fatalf("expected a conflicting lock denied, got status %d", status)
+	}
+	if reply := tryWriteAs(t, target, other, fh, 6, []byte("data"), fileSync); reply.Status != nfsc.NFS3ErrAcces {
+		t.Fatalf("expected a write by another owner refused, got status %d", reply.Status)
+	}
+	if reply := tryWriteAs(t, target, owner, fh, 8, []byte("data"), fileSync); reply.Status != nfsc.NFS3Ok {
+		t.Fatalf("expected the owner's write allowed, got status %d", reply.Status)
+	}
+
+	if status := nlmUnlockCall(t, target, owner, fh, 0, 0); status != nfs.NLMStatusGranted {
+		t.Fatalf("unlock got status %d", status)
+	}
+	if reply := tryWriteAs(t, target, other, fh, 6, []byte("data"), fileSync); reply.Status != nfsc.NFS3Ok {
+		t.Fatalf("expected a write allowed after unlock, got status %d", reply.Status)
+	}
+
+	if status := nlmLockCall(t, target, owner, []byte("stale"), 0, 0, true); status != nfs.NLMStatusStaleFH {
+		t.Fatalf("expected a lock of an unknown handle to be stale, got status %d", status)
+	}
+}
@@ -0,0 +1,126 @@
+package nfs
+
+import (
+	"github.com/go-git/go-billy/v5"
+)
+
+// Operations reported to a ChangeNotifier.
+const (
+	ChangeCreate  = "create"
+	ChangeRemove  = "remove"
+	ChangeRename  = "rename"
+	ChangeWrite   = "write"
+	ChangeSetAttr = "setattr"
+)
+
+// ChangeNotifier is implemented by handlers that want to hear of the
+// changes clients make to their file systems, for instance to invalidate
+// caches layered above them. OnChange is called after each successful
+// change with the path of the object changed; for a rename, its new path,
+// unless the handler is also a RenameNotifier. See
+// ServerOptions.ChangeQueueSize for when it is called.
+type ChangeNotifier interface {
+	OnChange(op string, fs billy.Filesystem, path []string)
+}
+
+// RenameNotifier is implemented by ChangeNotifiers that want to hear the
+// path a renamed object moved from as well as the one it moved to. OnRename
+// is called for each successful rename in place of OnChange.
+type RenameNotifier interface {
+	OnRename(fs billy.Filesystem, from, to []string)
+}
+
+// Watcher is implemented by handlers whose backend reports the changes made
+// to it other than through the server, such as a local file system watched
+// with fsnotify. While serving, the server watches the handler, and stops
+// remembering that any file changed is missing (see NegativeLookupTTL);
+// handlers layering caches over a Watcher should drop what they cache of
+// the path before passing the change on. Watch calls changed with the path
+// of each file or directory changed until stop is called.
+type Watcher interface {
+	Watch(changed func(fs billy.Filesystem, path []string)) (stop func())
+}
+
+// onExternalChange drops what the server remembers of a file changed other
+// than through it: that it was missing from its directory.
+func (s *Server) onExternalChange(fs billy.Filesystem, path []string) {
+	if len(path) > 0 {
+		s.negativeLookups.forget(fs, path[:len(path)-1], path[len(path)-1])
+	}
+}
+
+type change struct {
+	notifier ChangeNotifier
+	op       string
+	fs       billy.Filesystem
+	path     []string
+	// from is where a renamed object was.
+	from []string
+}
+
+// deliver tells the notifier of the change.
+func (c change) deliver() {
+	if rn, ok := c.notifier.(RenameNotifier); ok && c.op == ChangeRename {
+		rn.OnRename(c.fs, c.from, c.path)
+		return
+	}
+	c.notifier.OnChange(c.op, c.fs, c.path)
+}
+
+// notifyChange reports a change made by the request to userHandle, if it
+// is a ChangeNotifier.
+func (w *response) notifyChange(userHandle Handler, op string, fs billy.Filesystem, path []string) {
+	w.notify(userHandle, change{op: op, fs: fs, path: path})
+}
+
+// notifyRename reports the rename of from to to made by the request.
+func (w *response) notifyRename(userHandle Handler, fs billy.Filesystem, from, to []string) {
+	w.notify(userHandle, change{op: ChangeRename, fs: fs, path: to, from: append([]string{}, from...)})
+}
+
+func (w *response) notify(userHandle Handler, c change) {
+	n, ok := userHandle.(ChangeNotifier)
+	if !ok {
+		return
+	}
+	c.notifier = n
+	c.path = append([]string{}, c.path...)
+	s := w.Server
+	if s.ChangeQueueSize <= 0 {
+		c.deliver()
+		return
+	}
+	s.changesMu.Lock()
+	defer s.changesMu.Unlock()
+	if s.changesClosed {
+		w.logger().Warnf("dropping %s notification for %v: server shut down", c.op, c.path)
+		return
+	}
+	if s.changes == nil {
+		s.changes = make(chan change, s.ChangeQueueSize)
+		go func(changes chan change) {
+			for c := range changes {
+				c.deliver()
+			}
+		}(s.changes)
+	}
+	select {
+	case s.changes <- c:
+	default:
+		w.logger().Warnf("dropping %s notification for %v: queue full", c.op, c.path)
+	}
+}
+
+// closeChanges stops the goroutine notifying changes from the queue once
+// it has delivered those queued.
+func (s *Server) closeChanges() {
+	s.changesMu.Lock()
+	defer s.changesMu.Unlock()
+	if s.changesClosed {
+		return
+	}
+	s.changesClosed = true
+	if s.changes != nil {
+		close(s.changes)
+	}
+}
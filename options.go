@@ -0,0 +1,337 @@
+package nfs
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/willscott/go-nfs-client/nfs/rpc"
+)
+
+// ServerOptions holds optional policy for a Server.
+// The zero value leaves the server unrestricted.
+type ServerOptions struct {
+	// AllowedOperations limits which NFS procedures a principal may call.
+	AllowedOperations OperationAllowList
+	// LogPanicArguments retains the raw arguments of each call, so that
+	// they can be logged along with the stack if its handler panics.
+	LogPanicArguments bool
+	// DisablePanicRecovery lets a panic in a handler, which is otherwise
+	// logged and answered with NFS3ERR_SERVERFAULT, crash the server, as
+	// when debugging a backend.
+	DisablePanicRecovery bool
+	// Logger receives the server's messages about request handling.
+	Logger LeveledLogger
+	// PerClientBandwidth caps the READ and WRITE data, in bytes per second,
+	// exchanged with each client address. Zero means unlimited.
+	PerClientBandwidth int64
+	// MaxOpsPerHandle caps the READ and WRITE operations in progress at
+	// once on any one file, through whichever of its handles, so a single
+	// hot file cannot occupy every worker. Zero means unlimited.
+	MaxOpsPerHandle int
+	// HandleQueueTimeout is how long an operation beyond MaxOpsPerHandle
+	// waits for its turn before the server replies NFS3ERR_JUKEBOX.
+	HandleQueueTimeout time.Duration
+	// GracePeriod is how long after the server starts that it refuses
+	// operations modifying the file system with NFS3ERR_JUKEBOX, giving
+	// clients of a previous instance time to reclaim their state first.
+	GracePeriod time.Duration
+	// HideSpecialFiles exports only regular files and directories: symlinks,
+	// devices, sockets and FIFOs are left out of directory listings and
+	// cannot be looked up.
+	HideSpecialFiles bool
+	// ExportSubdirectories makes MNT return a handle for the directory its
+	// path names within the file system the Handler mounts, rather than for
+	// that file system's root. Symlinks along the path are followed. This
+	// does not confine clients to the directory.
+	ExportSubdirectories bool
+	// MaxSymlinkHops bounds the symlinks followed resolving a path, past
+	// which resolution fails with NFSStatusLoop. Zero means 40.
+	MaxSymlinkHops int
+	// ChangeQueueSize, when positive, has a ChangeNotifier Handler notified
+	// from a queue of this many changes on its own goroutine, rather than
+	// before each reply. Changes are dropped while the queue is full.
+	ChangeQueueSize int
+	// MaxMountsPerExport caps the mounts of each export path that MNT will
+	// grant before UMNT releases one; MNT beyond it is refused with
+	// MNT3ERR_ACCES. Zero means unlimited.
+	MaxMountsPerExport int
+	// MaxTransferSize caps the bytes of file data a single READ or WRITE
+	// moves, and is advertised to clients by FSINFO. Larger READs are
+	// shortened and larger WRITEs are partially applied, so a client cannot
+	// have the server allocate a buffer of its choosing. Zero means
+	// DefaultMaxTransferSize.
+	MaxTransferSize int
+	// NormalizeName, when set, has names that it maps to the same form
+	// treated as one: READDIR and READDIRPLUS list entries in that form,
+	// LOOKUP, REMOVE, RMDIR and the source of RENAME find the equivalent
+	// entry of a name that does not exist, and creating a name equivalent to
+	// an existing entry fails with NFS3ERR_EXIST. With norm.NFC.String from
+	// golang.org/x/text/unicode/norm, names differing only in Unicode
+	// normalization are one name, so that the NFD names macOS clients send
+	// find files created in NFC elsewhere. Each such check reads the whole
+	// directory.
+	NormalizeName func(string) string
+	// NegativeLookupTTL, when positive, is how long a name LOOKUP found
+	// missing is answered NFS3ERR_NOENT without asking the backend again.
+	// Names created through the server are found at once; files added by
+	// other means are found once the TTL passes, or Server.ForgetMissing is
+	// called.
+	NegativeLookupTTL time.Duration
+	// AllowedClients, when set, limits the server to clients whose address
+	// is within one of these prefixes. Calls from other clients, whether to
+	// mount or otherwise, are denied with AUTH_TOOWEAK.
+	AllowedClients []netip.Prefix
+	// KeepAlive is the period of the TCP keep-alive probes sent on accepted
+	// connections, so that those of clients that vanished are noticed.
+	// Zero leaves the system's default; negative disables keep-alives.
+	KeepAlive time.Duration
+	// IdleTimeout, when positive, closes connections on which no call has
+	// arrived for this long, such as those of clients that vanished
+	// without unmounting. Handles looked up over them stay valid, since
+	// clients reconnect and go on using the handles they hold.
+	IdleTimeout time.Duration
+	// ZeroCopyRead has READ send file data over TCP connections with
+	// sendfile(2), skipping the copy through a buffer, where the backend's
+	// files implement OSFile. It is only available on Linux; elsewhere, and
+	// for other files, READ is answered as usual.
+	ZeroCopyRead bool
+	// DuplicateRequestTTL, when positive, is how long the reply to a call
+	// modifying the file system is kept, so that a retransmit of the call,
+	// with the same xid from the same client address, is answered with it
+	// rather than run again. A retransmit arriving while the original is
+	// still running waits for its reply.
+	DuplicateRequestTTL time.Duration
+	// Backlog, when positive, is how many connections the listeners made by
+	// Server.Listen queue before they are accepted, in place of the
+	// system's default, so bursts of connections are not refused.
+	Backlog int
+	// ReuseAddr sets SO_REUSEADDR on the listeners made by Server.Listen, so
+	// a restarted server can bind its port while connections of the last
+	// one linger in TIME_WAIT. It changes nothing on Unix systems, where Go
+	// sets SO_REUSEADDR on every listener already, and is ignored on
+	// others, Windows among them, where the option lets another socket
+	// take over the port rather than rebind it.
+	ReuseAddr bool
+	// DefaultFileMode, when set, is the permissions reported for files whose
+	// backend gives them none, as object stores and other backends without
+	// modes do, so that clients do not take them for inaccessible.
+	// Directories are also reported executable wherever it grants read:
+	// 0644 reports files as 0644 and directories as 0755.
+	DefaultFileMode os.FileMode
+	// ReadAhead, when positive, is how many bytes past a sequential READ
+	// are prefetched from backends that are Prefetchers, so that the
+	// latency of reaching them is hidden from clients reading a file
+	// through. Zero turns read-ahead off.
+	ReadAhead int
+	// Metrics, when set, is told of each call the server answers.
+	Metrics MetricsSink
+	// Authenticate, when set, decides on calls whose credential is of a
+	// flavor the server does not understand, such as AUTH_DH or one of a
+	// deployment's own, which are otherwise rejected with AUTH_BADCRED. It
+	// is given the raw credential and verifier, and returns nil to accept
+	// the call or an *AuthError to reject it with. Handlers find them with
+	// CredentialFromContext and VerifierFromContext. Replies still carry an
+	// AUTH_NULL verifier.
+	Authenticate func(cred, verf rpc.Auth) error
+	// GSS, when set, authenticates calls made with the RPCSEC_GSS flavor
+	// of rfc2203 in the security contexts clients establish with it, which
+	// for Kerberos V5 provides the krb5, krb5i and krb5p flavors a handler
+	// may list in MOUNT replies. PrincipalFromContext names the client of
+	// such calls, and GSSServiceFromContext the protection they have.
+	GSS GSSProvider
+	// RequireGSSService, when set, rejects calls with AUTH_TOOWEAK unless
+	// they are made in an RPCSEC_GSS context with at least this service,
+	// so that GSSServiceIntegrity has every call's arguments and results
+	// checksummed (krb5i) without requiring their encryption. Calls to
+	// the NULL procedure, which clients probe servers with, are exempt.
+	RequireGSSService GSSService
+	// SillyRenamePrefix is the start of the hidden names files REMOVE
+	// finds in use are renamed aside to (see ReleaseNotifier), in place of
+	// DefaultSillyRenamePrefix. Clients hide names of their own silly
+	// renames by this prefix, so changing it is for backends keeping such
+	// names for themselves.
+	SillyRenamePrefix string
+	// MaxConcurrentRequests bounds the calls the server handles at once,
+	// over all its TCP connections and UDP sockets, in place of
+	// DefaultMaxConcurrentRequests. Beyond it, a connection reads its next
+	// call, and a UDP socket its next datagram, only once a call has been
+	// answered.
+	MaxConcurrentRequests int
+}
+
+// DefaultMaxConcurrentRequests is the MaxConcurrentRequests of servers not
+// setting one.
+const DefaultMaxConcurrentRequests = 1024
+
+// maxConcurrentRequests returns MaxConcurrentRequests, or its default if
+// unset.
+func (o *ServerOptions) maxConcurrentRequests() int {
+	if o.MaxConcurrentRequests <= 0 {
+		return DefaultMaxConcurrentRequests
+	}
+	return o.MaxConcurrentRequests
+}
+
+// DefaultMaxTransferSize is the MaxTransferSize of servers not setting one.
+const DefaultMaxTransferSize = 1 << 20
+
+// maxTransferSize returns MaxTransferSize, or its default if unset. It
+// never exceeds MaxRead.
+func (o *ServerOptions) maxTransferSize() uint32 {
+	if o.MaxTransferSize <= 0 {
+		return DefaultMaxTransferSize
+	}
+	if o.MaxTransferSize > MaxRead {
+		return MaxRead
+	}
+	return uint32(o.MaxTransferSize)
+}
+
+// DefaultSillyRenamePrefix is the SillyRenamePrefix of servers not setting
+// one, the prefix NFS clients use for their own silly renames.
+const DefaultSillyRenamePrefix = ".nfs"
+
+// sillyRenamePrefix returns SillyRenamePrefix, or its default if unset.
+func (o *ServerOptions) sillyRenamePrefix() string {
+	if o.SillyRenamePrefix == "" {
+		return DefaultSillyRenamePrefix
+	}
+	return o.SillyRenamePrefix
+}
+
+// maxSymlinkHops returns MaxSymlinkHops, or its default if unset.
+func (o *ServerOptions) maxSymlinkHops() int {
+	if o.MaxSymlinkHops > 0 {
+		return o.MaxSymlinkHops
+	}
+	return 40
+}
+
+// hides reports whether the server's policy keeps info from clients.
+func (o *ServerOptions) hides(info os.FileInfo) bool {
+	return o.HideSpecialFiles && !info.Mode().IsRegular() && !info.IsDir()
+}
+
+// allowsClient reports whether the server's policy admits the client at
+// addr. Clients whose address is not an IP address are admitted only if
+// there is no AllowedClients list.
+func (o *ServerOptions) allowsClient(addr net.Addr) bool {
+	if o.AllowedClients == nil {
+		return true
+	}
+	if addr == nil {
+		return false
+	}
+	ip, err := netip.ParseAddr(peerOf(addr))
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, p := range o.AllowedClients {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// OperationAllowList maps a principal (see PrincipalFromContext) to the
+// NFS procedures it may call. Principals without an entry, and requests
+// that carry no principal, are not restricted. NULL is always permitted.
+// The procedures of ExtensionProgram are allowed as the NFS procedures
+// they stand in for are.
+type OperationAllowList map[string][]NFSProcedure
+
+// Allows reports whether principal may call proc.
+func (l OperationAllowList) Allows(principal string, proc NFSProcedure) bool {
+	allowed, ok := l[principal]
+	if !ok || proc == NFSProcedureNull {
+		return true
+	}
+	for _, p := range allowed {
+		if p == proc {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOperation applies server policy to a request before it is dispatched.
+// Calls to ExtensionProgram are held to the policy of the NFS procedures
+// they stand in for.
+func (s *Server) checkOperation(ctx context.Context, w *response) error {
+	var proc NFSProcedure
+	var errorFmt func(error) RPCError
+	switch w.req.Header.Prog {
+	case nfsServiceID:
+		proc = NFSProcedure(w.req.Header.Proc)
+		errorFmt = errorFormatterFor(proc)
+	case ExtensionProgram:
+		var ok bool
+		if proc, ok = ExtensionProcedure(w.req.Header.Proc).standsFor(); !ok {
+			return nil
+		}
+		errorFmt = opAttrErrorFormatter
+	default:
+		return nil
+	}
+
+	if s.AllowedOperations != nil {
+		if principal, ok := PrincipalFromContext(ctx); ok && !s.AllowedOperations.Allows(principal, proc) {
+			w.logger().Debugf("rejecting call from %s: not in allow-list", principal)
+			w.errorFmt = errorFmt
+			return &NFSStatusError{NFSStatusAccess, os.ErrPermission}
+		}
+	}
+
+	if s.readOnly.Load() && modifiesFS(proc) {
+		w.logger().Debugf("refusing call: server is read-only")
+		w.errorFmt = errorFmt
+		return &NFSStatusError{NFSStatusROFS, errReadOnly}
+	}
+
+	if s.inGracePeriod() && modifiesFS(proc) {
+		w.logger().Debugf("deferring call: server is in its grace period")
+		w.errorFmt = errorFmt
+		return &NFSStatusError{NFSStatusJukebox, errGracePeriod}
+	}
+	return nil
+}
+
+var (
+	errGracePeriod = errors.New("server in grace period")
+	errReadOnly    = errors.New("server is read-only")
+)
+
+// SetReadOnly switches the running server into or out of read-only mode.
+// While read-only, calls that would modify the file system are refused
+// with NFS3ERR_ROFS; calls already in progress are unaffected.
+func (s *Server) SetReadOnly(readOnly bool) {
+	s.readOnly.Store(readOnly)
+}
+
+// ReadOnly reports whether the server is in read-only mode.
+func (s *Server) ReadOnly() bool {
+	return s.readOnly.Load()
+}
+
+// inGracePeriod reports whether the server started less than GracePeriod ago.
+func (s *Server) inGracePeriod() bool {
+	started := s.started.Load()
+	return s.GracePeriod > 0 && started != 0 && s.clock().Sub(time.Unix(0, started)) < s.GracePeriod
+}
+
+// modifiesFS reports whether proc changes the exported file system.
+func modifiesFS(proc NFSProcedure) bool {
+	switch proc {
+	case NFSProcedureSetAttr, NFSProcedureWrite, NFSProcedureCreate, NFSProcedureMkDir,
+		NFSProcedureSymlink, NFSProcedureMkNod, NFSProcedureRemove, NFSProcedureRmDir,
+		NFSProcedureRename, NFSProcedureLink:
+		return true
+	}
+	return false
+}
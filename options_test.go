@@ -0,0 +1,342 @@
+package nfs_test
+
+import (
+	"io"
+	"net/netip"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// testClock is a clock a test moves by hand, for servers to tell the time
+// by through nfs.SetClock.
+type testClock struct {
+	now atomic.Int64
+}
+
+func newTestClock(srv *nfs.Server) *testClock {
+	c := &testClock{}
+	c.now.Store(time.Now().UnixNano())
+	nfs.SetClock(srv, c.Now)
+	return c
+}
+
+func (c *testClock) Now() time.Time {
+	return time.Unix(0, c.now.Load())
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now.Add(int64(d))
+}
+
+func TestGracePeriod(t *testing.T) {
+	const nfs3ErrJukebox = 10008
+	const grace = time.Minute
+
+	mem := newTestFS(t, map[string]string{"/dir/test": "hello"})
+	srv := &nfs.Server{
+		Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024),
+		ServerOptions: nfs.ServerOptions{GracePeriod: grace},
+	}
+	clock := newTestClock(srv)
+	target := serveAndMount(t, srv, rpc.AuthNull)
+
+	_, dir, err := target.Lookup("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reads proceed during the grace period.
+	f, err := target.Open("/dir/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(f); err != nil {
+		t.Fatalf("read during grace period failed: %v", err)
+	}
+
+	clock.Advance(grace - time.Second)
+	if status := removeIn(t, target, dir, "test"); status != nfs3ErrJukebox {
+		t.Fatalf("expected JUKEBOX removing during the grace period, got %d", status)
+	}
+	if _, err := mem.Stat("/dir/test"); err != nil {
+		t.Fatalf("file removed during the grace period: %v", err)
+	}
+
+	clock.Advance(time.Second)
+	if status := removeIn(t, target, dir, "test"); status != nfsc.NFS3Ok {
+		t.Fatalf("expected removal to proceed after the grace period, got %d", status)
+	}
+}
+
+func TestSetReadOnly(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/test": "hello"})
+	srv := &nfs.Server{Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+
+	write := func() error {
+		f, err := target.OpenFile("/test", 0644)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write([]byte("HELLO"))
+		return err
+	}
+
+	srv.SetReadOnly(true)
+	if !srv.ReadOnly() {
+		t.Fatal("expected the server to report read-only mode")
+	}
+	if err := write(); nfsStatus(err) != nfsc.NFS3ErrROFS {
+		t.Fatalf("expected ROFS writing in read-only mode, got %v", err)
+	}
+	// Reads proceed in read-only mode.
+	f, err := target.Open("/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(f); err != nil || string(got) != "hello" {
+		t.Fatalf("read in read-only mode returned %q, %v", got, err)
+	}
+
+	srv.SetReadOnly(false)
+	if err := write(); err != nil {
+		t.Fatalf("write after leaving read-only mode failed: %v", err)
+	}
+	if got, err := util.ReadFile(mem, "/test"); err != nil || string(got) != "HELLO" {
+		t.Fatalf("file holds %q, %v after the write", got, err)
+	}
+}
+
+func TestAllowedClients(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/test": "hello"})
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+
+	allowed := &nfs.Server{
+		Handler:       handler,
+		ServerOptions: nfs.ServerOptions{AllowedClients: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}},
+	}
+	target := serveAndMount(t, allowed, rpc.AuthNull)
+	if _, _, err := target.Lookup("/test"); err != nil {
+		t.Fatalf("lookup from an allowed address failed: %v", err)
+	}
+
+	denied := &nfs.Server{
+		Handler:       handler,
+		ServerOptions: nfs.ServerOptions{AllowedClients: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}},
+	}
+	res := callWithCredential(t, denied, rpc.AuthNull, rpc.AuthNull)
+	var reply struct {
+		Type       uint32
+		ReplyStat  uint32
+		RejectStat uint32
+		AuthStat   uint32
+	}
+	if err := xdr.Read(res, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.ReplyStat != rpc.MsgDenied || nfs.AuthStat(reply.AuthStat) != nfs.AuthStatTooWeak {
+		t.Fatalf("expected a call from a denied address to be rejected with AUTH_TOOWEAK, got %+v", reply)
+	}
+}
+
+// fifoFS adds a FIFO at fifo, which memfs cannot represent itself.
+type fifoFS struct {
+	billy.Filesystem
+	fifo string
+}
+
+type fifoInfo struct {
+	name string
+}
+
+func (f fifoInfo) Name() string       { return f.name }
+func (f fifoInfo) Size() int64        { return 0 }
+func (f fifoInfo) Mode() os.FileMode  { return os.ModeNamedPipe | 0644 }
+func (f fifoInfo) ModTime() time.Time { return time.Time{} }
+func (f fifoInfo) IsDir() bool        { return false }
+func (f fifoInfo) Sys() interface{}   { return nil }
+
+func (f *fifoFS) is(filename string) bool {
+	return path.Clean("/"+filename) == f.fifo
+}
+
+func (f *fifoFS) Lstat(filename string) (os.FileInfo, error) {
+	if f.is(filename) {
+		return fifoInfo{path.Base(f.fifo)}, nil
+	}
+	return f.Filesystem.Lstat(filename)
+}
+
+func (f *fifoFS) Stat(filename string) (os.FileInfo, error) {
+	return f.Lstat(filename)
+}
+
+func (f *fifoFS) ReadDir(dir string) ([]os.FileInfo, error) {
+	contents, err := f.Filesystem.ReadDir(dir)
+	if err == nil && path.Clean("/"+dir) == path.Dir(f.fifo) {
+		contents = append(contents, fifoInfo{path.Base(f.fifo)})
+	}
+	return contents, err
+}
+
+func TestHideSpecialFiles(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/file": "hello", "/dir/sub/nested": "hi"})
+	if err := mem.Symlink("file", "/dir/link"); err != nil {
+		t.Fatal(err)
+	}
+	fs := &fifoFS{Filesystem: mem, fifo: "/dir/fifo"}
+
+	for _, hide := range []bool{false, true} {
+		srv := &nfs.Server{
+			Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024),
+			ServerOptions: nfs.ServerOptions{HideSpecialFiles: hide},
+		}
+		target := serveAndMount(t, srv, rpc.AuthNull)
+
+		expected := []string{"fifo", "file", "link", "sub"}
+		if hide {
+			expected = []string{"file", "sub"}
+		}
+
+		entries, err := readDir(target, "/dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.FileName)
+		}
+		sort.Strings(names)
+		if strings.Join(names, ",") != strings.Join(expected, ",") {
+			t.Fatalf("hide=%v: readdir listed %v, expected %v", hide, names, expected)
+		}
+
+		plus, err := target.ReadDirPlus("/dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = names[:0]
+		for _, e := range plus {
+			if e.FileName != "." && e.FileName != ".." {
+				names = append(names, e.FileName)
+			}
+		}
+		sort.Strings(names)
+		if strings.Join(names, ",") != strings.Join(expected, ",") {
+			t.Fatalf("hide=%v: readdirplus listed %v, expected %v", hide, names, expected)
+		}
+
+		for _, name := range []string{"link", "fifo"} {
+			_, _, err := target.Lookup("/dir/" + name)
+			if hide && nfsStatus(err) != nfsc.NFS3ErrNoEnt {
+				t.Fatalf("expected NOENT looking up hidden %s, got %v", name, err)
+			} else if !hide && err != nil {
+				t.Fatalf("looking up %s failed: %v", name, err)
+			}
+		}
+	}
+}
+
+// listingFS hands out the same slice for every listing of a directory, as a
+// backend caching its listings would.
+type listingFS struct {
+	billy.Filesystem
+	listings map[string][]os.FileInfo
+}
+
+func (f *listingFS) ReadDir(dir string) ([]os.FileInfo, error) {
+	key := path.Clean("/" + dir)
+	if contents, ok := f.listings[key]; ok {
+		return contents, nil
+	}
+	contents, err := f.Filesystem.ReadDir(dir)
+	if err == nil {
+		sort.Slice(contents, func(i, j int) bool { return contents[i].Name() < contents[j].Name() })
+		f.listings[key] = contents
+	}
+	return contents, err
+}
+
+func TestHideSpecialFilesKeepsBackendListing(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/dir/b": "hello", "/dir/c": "hi"})
+	if err := mem.Symlink("b", "/dir/a"); err != nil {
+		t.Fatal(err)
+	}
+	fs := &listingFS{Filesystem: mem, listings: make(map[string][]os.FileInfo)}
+	srv := &nfs.Server{
+		Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024),
+		ServerOptions: nfs.ServerOptions{HideSpecialFiles: true},
+	}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+
+	if _, err := readDir(target, "/dir"); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range fs.listings["/dir"] {
+		names = append(names, c.Name())
+	}
+	if strings.Join(names, ",") != "a,b,c" {
+		t.Fatalf("listing the directory rewrote the backend's listing to %v", names)
+	}
+}
+
+func TestNormalizeNameRoundTrip(t *testing.T) {
+	const nfc, nfd = "caf\u00e9", "cafe\u0301"
+
+	// whichever form the file is stored in, it is listed in the normalized
+	// form and found, and removed, by either.
+	for _, stored := range []string{nfc, nfd} {
+		mem := newTestFS(t, map[string]string{"/dir/" + stored: "hello"})
+		srv := &nfs.Server{
+			Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024),
+			ServerOptions: nfs.ServerOptions{NormalizeName: composeAcute},
+		}
+		target := serveAndMount(t, srv, rpc.AuthNull)
+
+		entries, err := readDir(target, "/dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].FileName != nfc {
+			t.Fatalf("expected READDIR to list %q, got %v", nfc, entries)
+		}
+		plus, err := target.ReadDirPlus("/dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range plus {
+			if e.FileName != "." && e.FileName != ".." && e.FileName != nfc {
+				t.Fatalf("expected READDIRPLUS to list %q, got %q", nfc, e.FileName)
+			}
+		}
+		for _, name := range []string{nfc, nfd} {
+			if _, _, err := target.Lookup("/dir/" + name); err != nil {
+				t.Fatalf("expected %q to find %q: %v", name, stored, err)
+			}
+		}
+		other := nfd
+		if stored == nfd {
+			other = nfc
+		}
+		if err := target.Remove("/dir/" + other); err != nil {
+			t.Fatalf("expected removing %q to remove %q: %v", other, stored, err)
+		}
+		if _, err := mem.Stat("/dir/" + stored); !os.IsNotExist(err) {
+			t.Fatalf("expected %q to be removed, got %v", stored, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package nfs
+
+import (
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+// Prefetcher is implemented by billy file systems that can fetch a range of
+// a file ahead of its being read, such as high-latency backends keeping a
+// cache of their own. With ServerOptions.ReadAhead set, each READ that
+// continues where the last READ through the same handle ended has the
+// server call Prefetch, in the background, for the ReadAhead bytes that
+// follow. READ always serves what it reads itself, so a failed Prefetch
+// costs only the latency it was to hide.
+type Prefetcher interface {
+	Prefetch(filename string, offset int64, length int) error
+}
+
+// maxReadAheads bounds the handles a server tracks the reads of.
+const maxReadAheads = 4096
+
+// readAheads tracks, per handle, where the last READ ended and how far
+// ahead of it has been prefetched.
+type readAheads struct {
+	mu     sync.Mutex
+	states map[string]readAheadState
+}
+
+type readAheadState struct {
+	end   uint64
+	ahead uint64
+}
+
+// next records a READ of [offset, end) through fh, returning the range to
+// prefetch after it with a window of size, if the read was sequential and
+// the range is not already prefetched.
+func (r *readAheads) next(fh []byte, offset, end, size uint64) (from, to uint64, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.states == nil || len(r.states) >= maxReadAheads {
+		// forgetting every handle only costs a read each to find again.
+		r.states = make(map[string]readAheadState)
+	}
+	state, seen := r.states[string(fh)]
+	if offset == 0 {
+		// a read from the start begins a sequence of its own.
+		seen, state.end, state.ahead = true, 0, 0
+	}
+	from, to = end, end+size
+	if state.ahead > from {
+		from = state.ahead
+	}
+	ok = seen && offset == state.end && from < to
+	if ok {
+		state.ahead = to
+	}
+	state.end = end
+	r.states[string(fh)] = state
+	return from, to, ok
+}
+
+// readAhead prefetches past a READ of [offset, end) of the file at path
+// through fh, if ReadAhead is set, the read was sequential and the backend
+// is a Prefetcher.
+func (w *response) readAhead(fs billy.Filesystem, path []string, fh []byte, offset, end uint64) {
+	if w.Server.ReadAhead <= 0 {
+		return
+	}
+	prefetcher, ok := fs.(Prefetcher)
+	if !ok {
+		return
+	}
+	from, to, ok := w.Server.readAheads.next(fh, offset, end, uint64(w.Server.ReadAhead))
+	if !ok {
+		return
+	}
+	name := fs.Join(path...)
+	logger := w.logger()
+	go func() {
+		if err := prefetcher.Prefetch(name, int64(from), int(to-from)); err != nil {
+			logger.Debugf("prefetch of %s at %d failed: %v", name, from, err)
+		}
+	}()
+}
@@ -0,0 +1,167 @@
+package nfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// OSFile is implemented by billy files backed by an operating system file,
+// such as those of osfs.BoundOS, whose descriptor lets READ send data with
+// sendfile(2) under ServerOptions.ZeroCopyRead.
+type OSFile interface {
+	billy.File
+	Fd() uintptr
+}
+
+// fileSection is a range of a file sent as the end of a reply, straight
+// from the file to the connection.
+type fileSection struct {
+	file   OSFile
+	offset int64
+	n      int
+}
+
+// length is the bytes the section takes on the wire, with its XDR padding.
+func (s *fileSection) length() int {
+	if s == nil {
+		return 0
+	}
+	return s.n + (4-s.n%4)%4
+}
+
+// close releases the section's file.
+func (s *fileSection) close() {
+	if s != nil {
+		_ = s.file.Close()
+	}
+}
+
+var errNoSendFile = errors.New("sendfile unavailable")
+
+// reply is a message for serializeWrites to send, followed by the data of
+// a file section if it has one.
+type reply struct {
+	msg  []byte
+	file *fileSection
+}
+
+// readZeroCopy answers a READ by sending the file's data from the file
+// itself, reporting false without replying when the file or connection
+// cannot be sent from that way.
+func (w *response) readZeroCopy(ctx context.Context, userHandle Handler, fs billy.Filesystem, path []string, obj nfsReadArgs) (bool, error) {
+	if !canSendFile || !w.Server.ZeroCopyRead {
+		return false, nil
+	}
+	if w.gss != nil && w.gss.service != GSSServiceNone {
+		// the data is protected along with the rest of the results.
+		return false, nil
+	}
+	if _, ok := w.conn.Conn.(*net.TCPConn); !ok {
+		return false, nil
+	}
+	if err := checkRangeLock(ctx, userHandle, fs, path, obj.Offset, uint64(obj.Count), false); err != nil {
+		return false, err
+	}
+
+	release, err := w.admit(ctx, fs, path)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	fh, err := fs.Open(fs.Join(path...))
+	if err != nil {
+		return false, &NFSStatusError{mapError(err), err}
+	}
+	f, ok := fh.(OSFile)
+	if !ok {
+		_ = fh.Close()
+		return false, nil
+	}
+	// only regular files have data to send; READ of anything else is
+	// refused the way the buffered path refuses it.
+	postOp := w.tryStat(userHandle, fs, path)
+	if postOp == nil || postOp.Type != FileTypeRegular {
+		_ = f.Close()
+		return false, nil
+	}
+	w.updateAtime(userHandle, fs, path, postOp)
+
+	count := obj.Count
+	if max := w.maxTransferSize(); count > max {
+		count = max
+	}
+	if obj.Offset >= postOp.Filesize {
+		count = 0
+	} else if postOp.Filesize-obj.Offset < uint64(count) {
+		count = uint32(postOp.Filesize - obj.Offset)
+	}
+	eof := uint32(0)
+	if obj.Offset+uint64(count) >= postOp.Filesize {
+		eof = 1
+	}
+	if eof == 0 {
+		w.readAhead(fs, path, obj.Handle, obj.Offset, obj.Offset+uint64(count))
+	}
+
+	writer := bytes.NewBuffer([]byte{})
+	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
+		_ = f.Close()
+		return false, &NFSStatusError{NFSStatusServerFault, err}
+	}
+	if err := WritePostOpAttrs(writer, postOp); err != nil {
+		_ = f.Close()
+		return false, &NFSStatusError{NFSStatusServerFault, err}
+	}
+	// count and eof, then the length of the opaque data that follows.
+	if err := xdr.Write(writer, [3]uint32{count, eof, count}); err != nil {
+		_ = f.Close()
+		return false, &NFSStatusError{NFSStatusServerFault, err}
+	}
+	if err := w.Write(writer.Bytes()); err != nil {
+		_ = f.Close()
+		return false, &NFSStatusError{NFSStatusServerFault, err}
+	}
+	if count == 0 {
+		_ = f.Close()
+	} else {
+		w.file = &fileSection{file: f, offset: int64(obj.Offset), n: int(count)}
+	}
+	w.pace(ctx, int(count))
+	return true, nil
+}
+
+// writeSection sends the data of s on the connection, after flushing what
+// bw holds before it.
+func (c *conn) writeSection(bw *bufio.Writer, s *fileSection) error {
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	sent, err := 0, errNoSendFile
+	if tc, ok := c.Conn.(*net.TCPConn); ok {
+		sent, err = sendFile(tc, s.file.Fd(), s.offset, s.n)
+	}
+	if err != nil {
+		// the file may not support sendfile; copy the rest through a buffer.
+		m, err := io.Copy(bw, io.NewSectionReader(s.file, s.offset+int64(sent), int64(s.n-sent)))
+		sent += int(m)
+		if err != nil {
+			return err
+		}
+	}
+	// the file may have been truncated since the reply announced its
+	// length; make up the difference with zeros to keep the framing.
+	for pad := s.length() - sent; pad > 0; pad-- {
+		if err := bw.WriteByte(0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
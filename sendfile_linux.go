@@ -0,0 +1,47 @@
+//go:build linux
+
+package nfs
+
+import (
+	"net"
+	"syscall"
+)
+
+const canSendFile = true
+
+// sendFile copies n bytes of the file fd from offset to conn with
+// sendfile(2), returning how many were sent.
+func sendFile(conn *net.TCPConn, fd uintptr, offset int64, n int) (int, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	var sendErr error
+	err = raw.Write(func(s uintptr) bool {
+		for sent < n {
+			m, err := syscall.Sendfile(int(s), int(fd), &offset, n-sent)
+			if m > 0 {
+				sent += m
+			}
+			switch {
+			case err == syscall.EAGAIN:
+				// wait for the socket to become writable.
+				return false
+			case err == syscall.EINTR:
+				continue
+			case err != nil:
+				sendErr = err
+				return true
+			case m == 0:
+				// the file ended early.
+				return true
+			}
+		}
+		return true
+	})
+	if sendErr != nil {
+		return sent, sendErr
+	}
+	return sent, err
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package nfs
+
+import "net"
+
+const canSendFile = false
+
+func sendFile(conn *net.TCPConn, fd uintptr, offset int64, n int) (int, error) {
+	return 0, errNoSendFile
+}
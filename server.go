@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,8 +17,68 @@ type Server struct {
 	Handler
 	ID [8]byte
 	context.Context
+	ServerOptions
+
+	limitersMu sync.Mutex
+	limiters   map[string]*bandwidthLimiter
+
+	// started is when the server first served, in nanoseconds since the
+	// Unix epoch, or zero before then.
+	started atomic.Int64
+	// now tells the time in place of time.Now, if set.
+	now func() time.Time
+
+	// requestSlots holds a token for each call being handled, bounding them
+	// to MaxConcurrentRequests. It is made once, by slots.
+	requestSlotsOnce sync.Once
+	requestSlots     chan struct{}
+
+	// idOnce guards giving the server an ID, if it has none.
+	idOnce sync.Once
+	idErr  error
+
+	handleQueuesMu sync.Mutex
+	handleQueues   map[string][]*handleQueue
+
+	createVerifiers createVerifierTable
+
+	// changesMu guards the queue of changes to notify, which is closed on
+	// Shutdown.
+	changesMu     sync.Mutex
+	changes       chan change
+	changesClosed bool
+
+	mountsMu sync.Mutex
+	mounts   map[MountEntry]int
+
+	// buffers recycles the buffers of READ and WRITE data.
+	buffers sync.Pool
+
+	readOnly atomic.Bool
+
+	negativeLookups negativeLookups
+
+	readAheads readAheads
+
+	streamedListings streamedListings
+
+	duplicates duplicateRequests
+
+	gssSessions gssSessions
+
+	// connsMu guards the listeners and connections Shutdown closes, and
+	// orders requests starting against Shutdown waiting for them.
+	connsMu      sync.Mutex
+	listeners    map[net.Listener]struct{}
+	conns        map[*conn]struct{}
+	packetConns  map[net.PacketConn]struct{}
+	shuttingDown bool
+	inFlight     sync.WaitGroup
 }
 
+// ErrServerClosed is returned by Serve once Shutdown has been called.
+var ErrServerClosed = errors.New("nfs: server closed")
+
 // RegisterMessageHandler registers a handler for a specific
 // XDR procedure.
 func RegisterMessageHandler(protocol uint32, proc uint32, handler HandleFunc) error {
@@ -46,14 +109,20 @@ var registeredHandlers map[registeredHandlerID]HandleFunc
 // Serve listens on the provided listener port for incoming client requests.
 func (s *Server) Serve(l net.Listener) error {
 	defer l.Close()
+	if !s.trackListener(l, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(l, false)
 	baseCtx := context.Background()
 	if s.Context != nil {
 		baseCtx = s.Context
 	}
-	if bytes.Equal(s.ID[:], []byte{0, 0, 0, 0, 0, 0, 0, 0}) {
-		if _, err := rand.Reader.Read(s.ID[:]); err != nil {
-			return err
-		}
+	if err := s.prepare(); err != nil {
+		return err
+	}
+	if watcher, ok := s.Handler.(Watcher); ok {
+		stop := watcher.Watch(s.onExternalChange)
+		defer stop()
 	}
 
 	var tempDelay time.Duration
@@ -73,18 +142,210 @@ func (s *Server) Serve(l net.Listener) error {
 				time.Sleep(tempDelay)
 				continue
 			}
+			if s.closing() {
+				return ErrServerClosed
+			}
 			return err
 		}
 		tempDelay = 0
 		c := s.newConn(conn)
-		go c.serve(baseCtx)
+		if !s.trackConn(c, true) {
+			c.releaseLimiter()
+			_ = conn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer c.releaseLimiter()
+			defer s.trackConn(c, false)
+			c.serve(baseCtx)
+		}()
+	}
+}
+
+// prepare gives the server an ID, if it has none, and notes when it started.
+func (s *Server) prepare() error {
+	if err := s.ensureID(); err != nil {
+		return err
+	}
+
+	s.started.CompareAndSwap(0, s.clock().UnixNano())
+	return nil
+}
+
+// clock returns the current time, by the server's clock.
+func (s *Server) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+// acquireSlot waits for one of the MaxConcurrentRequests calls the server
+// handles at once to be answered, if that many are being handled, and
+// reports false if ctx ends first. The call returns its slot with
+// releaseSlot.
+func (s *Server) acquireSlot(ctx context.Context) bool {
+	s.requestSlotsOnce.Do(func() {
+		s.requestSlots = make(chan struct{}, s.maxConcurrentRequests())
+	})
+	select {
+	case s.requestSlots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// releaseSlot returns the slot of a call taken with acquireSlot.
+func (s *Server) releaseSlot() {
+	<-s.requestSlots
+}
+
+// WriteVerifier returns the verifier WRITE and COMMIT reply with, which is
+// the server's ID: chosen at random when the server first serves, or is
+// first asked, unless ID is set. A new server has another, telling clients
+// to resend the writes that were not committed before it restarted.
+func (s *Server) WriteVerifier() uint64 {
+	_ = s.ensureID()
+	return binary.BigEndian.Uint64(s.ID[:])
+}
+
+// ensureID gives the server a random ID, if it has none, just once.
+func (s *Server) ensureID() error {
+	s.idOnce.Do(func() {
+		if bytes.Equal(s.ID[:], []byte{0, 0, 0, 0, 0, 0, 0, 0}) {
+			_, s.idErr = rand.Reader.Read(s.ID[:])
+		}
+	})
+	return s.idErr
+}
+
+// Shutdown stops the server gracefully: it closes its listeners, waits for
+// the requests being handled to be answered, then closes its connections.
+// Requests arriving meanwhile are not handled; clients retry them once they
+// reconnect. Changes still queued for a ChangeNotifier are delivered, but
+// no more are queued. If ctx ends first, Shutdown closes the connections without
+// waiting further and returns ctx's error. Serve returns ErrServerClosed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.connsMu.Lock()
+	s.shuttingDown = true
+	for l := range s.listeners {
+		_ = l.Close()
+	}
+	s.connsMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.connsMu.Lock()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+	for pc := range s.packetConns {
+		_ = pc.Close()
+	}
+	s.connsMu.Unlock()
+	s.closeChanges()
+	return err
+}
+
+// closing reports whether Shutdown has been called.
+func (s *Server) closing() bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	return s.shuttingDown
+}
+
+// trackListener adds l to, or removes it from, the listeners Shutdown
+// closes. It refuses to add one once the server is shutting down.
+func (s *Server) trackListener(l net.Listener, add bool) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if !add {
+		delete(s.listeners, l)
+		return true
+	}
+	if s.shuttingDown {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+// trackConn adds c to, or removes it from, the connections Shutdown closes.
+// It refuses to add one once the server is shutting down.
+func (s *Server) trackConn(c *conn, add bool) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if !add {
+		delete(s.conns, c)
+		return true
+	}
+	if s.shuttingDown {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[*conn]struct{})
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
+
+// trackPacketConn adds pc to, or removes it from, the packet conns Shutdown
+// closes once the requests in flight are answered. It refuses to add one
+// once the server is shutting down.
+func (s *Server) trackPacketConn(pc net.PacketConn, add bool) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if !add {
+		delete(s.packetConns, pc)
+		return true
+	}
+	if s.shuttingDown {
+		return false
 	}
+	if s.packetConns == nil {
+		s.packetConns = make(map[net.PacketConn]struct{})
+	}
+	s.packetConns[pc] = struct{}{}
+	return true
+}
+
+// beginRequest counts a request as in flight until its reply has been
+// written or dropped, unless the server is shutting down.
+func (s *Server) beginRequest() bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if s.shuttingDown {
+		return false
+	}
+	s.inFlight.Add(1)
+	return true
 }
 
 func (s *Server) newConn(nc net.Conn) *conn {
+	if tc, ok := nc.(*net.TCPConn); ok && s.KeepAlive != 0 {
+		_ = tc.SetKeepAlive(s.KeepAlive > 0)
+		if s.KeepAlive > 0 {
+			_ = tc.SetKeepAlivePeriod(s.KeepAlive)
+		}
+	}
 	c := &conn{
-		Server: s,
-		Conn:   nc,
+		Server:  s,
+		Conn:    nc,
+		limiter: s.limiterFor(nc.RemoteAddr()),
 	}
 	return c
 }
@@ -100,6 +361,20 @@ func (s *Server) handlerFor(prog uint32, proc uint32) HandleFunc {
 	return nil
 }
 
+// logger picks the logger for this server: its own, then its handler's,
+// then the package-level Log.
+func (s *Server) logger() LeveledLogger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	if lh, ok := s.Handler.(LoggingHandler); ok {
+		if l := lh.Logger(); l != nil {
+			return l
+		}
+	}
+	return Log
+}
+
 // Serve is a singleton listener paralleling http.Serve
 func Serve(l net.Listener, handler Handler) error {
 	srv := &Server{Handler: handler}
@@ -0,0 +1,173 @@
+package nfs_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+)
+
+// slowOpenFS holds up opening files until release is closed, signalling on
+// opened as each open starts.
+type slowOpenFS struct {
+	billy.Filesystem
+	opened  chan struct{}
+	release chan struct{}
+}
+
+func (s *slowOpenFS) Open(filename string) (billy.File, error) {
+	s.opened <- struct{}{}
+	<-s.release
+	return s.Filesystem.Open(filename)
+}
+
+// startSlowRead serves a file that is slow to open, with ctx as the
+// server's Context, and starts a READ of it, returning once the READ is in
+// progress. It also opens an idle connection to the server. The outcomes of
+// the READ and of Serve are sent on the returned channels.
+func startSlowRead(t *testing.T, ctx context.Context) (*nfs.Server, *slowOpenFS, net.Conn, chan error, chan error) {
+	t.Helper()
+	fs := &slowOpenFS{
+		Filesystem: newTestFS(t, map[string]string{"/file": "hello"}),
+		opened:     make(chan struct{}, 1),
+		release:    make(chan struct{}),
+	}
+	srv := &nfs.Server{Handler: helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024), Context: ctx}
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(listener)
+	}()
+
+	// the client reconnects for as long as a call is unanswered, so it is
+	// closed rather than unmounted once the server has shut down.
+	c := dial(t, listener.Addr())
+	t.Cleanup(c.Close)
+	mounter := nfsc.Mount{Client: c}
+	target, err := mounter.Mount("/", rpc.AuthNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, fh, err := target.Lookup("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idle, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = idle.Close() })
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := target.Call(&struct {
+			rpc.Header
+			FH     []byte
+			Offset uint64
+			Count  uint32
+		}{
+			Header: rpc.Header{
+				Rpcvers: 2,
+				Prog:    nfsc.Nfs3Prog,
+				Vers:    nfsc.Nfs3Vers,
+				Proc:    nfsc.NFSProc3Read,
+				Cred:    rpc.AuthNull,
+				Verf:    rpc.AuthNull,
+			},
+			FH:    fh,
+			Count: 5,
+		})
+		done <- err
+	}()
+	<-fs.opened
+	return srv, fs, idle, done, served
+}
+
+// expectClosed fails t unless the server has closed conn.
+func expectClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+}
+
+func TestShutdownWaitsForRequests(t *testing.T) {
+	srv, fs, idle, done, served := startSlowRead(t, context.Background())
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		shutdown <- srv.Shutdown(ctx)
+	}()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown returned %v with a request in flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(fs.release)
+	if err := <-shutdown; err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("request in flight failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request in flight was not answered")
+	}
+	if err := <-served; !errors.Is(err, nfs.ErrServerClosed) {
+		t.Fatalf("expected Serve to return ErrServerClosed, got %v", err)
+	}
+	expectClosed(t, idle)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	srv, fs, idle, _, _ := startSlowRead(t, context.Background())
+	defer close(fs.release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := srv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected shutdown to give up at its deadline, got %v", err)
+	}
+	if waited := time.Since(start); waited < 100*time.Millisecond {
+		t.Fatalf("shutdown gave up after %v, before its deadline", waited)
+	}
+	expectClosed(t, idle)
+}
+
+func TestShutdownAfterContextCanceled(t *testing.T) {
+	// the reply of a call finishing after the server's context is canceled
+	// may or may not be queued before it is dropped; either way it is no
+	// longer in flight. Several calls are made to see both.
+	for i := 0; i < 8; i++ {
+		serverCtx, cancelServer := context.WithCancel(context.Background())
+		srv, fs, _, _, _ := startSlowRead(t, serverCtx)
+		cancelServer()
+		close(fs.release)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := srv.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("shutdown failed: %v", err)
+		}
+	}
+}
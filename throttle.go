@@ -0,0 +1,218 @@
+package nfs
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+// bandwidthLimiter paces data transfers so that, over time, no more than
+// rate bytes per second pass through it.
+type bandwidthLimiter struct {
+	mu   sync.Mutex
+	rate int64
+	// next is when the transfers reserved so far will have been paid for.
+	next time.Time
+	// conns counts the connections sharing the limiter. It is guarded by
+	// the server's limitersMu.
+	conns int
+}
+
+// idle reports whether the transfers through l have all been paid for.
+func (l *bandwidthLimiter) idle(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return !l.next.After(now)
+}
+
+// reserve accounts for a transfer of n bytes, returning how long the
+// caller must wait before it is within the limit.
+func (l *bandwidthLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	return l.next.Sub(now)
+}
+
+// limiterFor returns the limiter shared by all connections from the peer
+// at addr, or nil if the server does not limit bandwidth. The connection
+// returns it with releaseLimiter once closed.
+func (s *Server) limiterFor(addr net.Addr) *bandwidthLimiter {
+	if s.PerClientBandwidth <= 0 || addr == nil {
+		return nil
+	}
+	peer := peerOf(addr)
+	s.limitersMu.Lock()
+	defer s.limitersMu.Unlock()
+	if s.limiters == nil {
+		s.limiters = make(map[string]*bandwidthLimiter)
+	}
+	l, ok := s.limiters[peer]
+	if !ok {
+		// forget peers with no connections and nothing left to pay for.
+		now := time.Now()
+		for k, v := range s.limiters {
+			if v.conns == 0 && v.idle(now) {
+				delete(s.limiters, k)
+			}
+		}
+		l = &bandwidthLimiter{rate: s.PerClientBandwidth}
+		s.limiters[peer] = l
+	}
+	l.conns++
+	return l
+}
+
+// releaseLimiter returns the connection's limiter from limiterFor.
+func (c *conn) releaseLimiter() {
+	if c.limiter == nil {
+		return
+	}
+	c.Server.limitersMu.Lock()
+	defer c.Server.limitersMu.Unlock()
+	c.limiter.conns--
+}
+
+// peerOf identifies a client by the host of its address.
+func peerOf(addr net.Addr) string {
+	peer := addr.String()
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	return peer
+}
+
+// toHandle issues the handle to path for the request's peer, through the
+// handler's ToHandleFor if it accounts handles to clients.
+func (w *response) toHandle(userHandle Handler, fs billy.Filesystem, path []string) ([]byte, error) {
+	ch, ok := userHandle.(ClientHandleHandler)
+	if !ok || w.conn == nil || w.conn.RemoteAddr() == nil {
+		return userHandle.ToHandle(fs, path), nil
+	}
+	return ch.ToHandleFor(peerOf(w.conn.RemoteAddr()), fs, path)
+}
+
+// createdHandle is toHandle for a file the request has created. The file
+// is there whether or not the handler issues a handle for it, so a refused
+// handle is left out of the reply, for the client to look up once it is
+// allowed more, rather than failing the request.
+func (w *response) createdHandle(userHandle Handler, fs billy.Filesystem, path []string) []byte {
+	fh, err := w.toHandle(userHandle, fs, path)
+	if err != nil {
+		w.logger().Debugf("no handle issued for %s: %v", fs.Join(path...), err)
+		return nil
+	}
+	return fh
+}
+
+// pace blocks until transferring n bytes of READ or WRITE data keeps the
+// request's peer within the server's PerClientBandwidth. Only this
+// connection waits; other clients are unaffected.
+func (w *response) pace(ctx context.Context, n int) {
+	if w.limiter == nil || n <= 0 {
+		return
+	}
+	delay := w.limiter.reserve(n)
+	if delay <= 0 {
+		return
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// handleQueue bounds the operations in progress on one file.
+type handleQueue struct {
+	fs    billy.Filesystem
+	slots chan struct{}
+	// users counts the operations holding or waiting for a slot, so the
+	// queue can be dropped once its file is idle.
+	users int
+}
+
+var errHandleBusy = errors.New("too many operations in progress on file")
+
+// admit waits for one of the server's MaxOpsPerHandle slots for the file
+// at path, returning a function that gives it back. Queues are kept by the
+// file a handle resolves to rather than by its bytes, so that handles that
+// differ but reach the same file share one. If no slot frees up within
+// HandleQueueTimeout, it fails with NFS3ERR_JUKEBOX so the client retries
+// later instead of tying up another worker.
+func (w *response) admit(ctx context.Context, fs billy.Filesystem, path []string) (func(), error) {
+	s := w.Server
+	if s.MaxOpsPerHandle <= 0 {
+		return func() {}, nil
+	}
+	key := fs.Join(path...)
+	s.handleQueuesMu.Lock()
+	if s.handleQueues == nil {
+		s.handleQueues = make(map[string][]*handleQueue)
+	}
+	var q *handleQueue
+	for _, candidate := range s.handleQueues[key] {
+		if reflect.DeepEqual(candidate.fs, fs) {
+			q = candidate
+			break
+		}
+	}
+	if q == nil {
+		q = &handleQueue{fs: fs, slots: make(chan struct{}, s.MaxOpsPerHandle)}
+		s.handleQueues[key] = append(s.handleQueues[key], q)
+	}
+	q.users++
+	s.handleQueuesMu.Unlock()
+
+	leave := func() {
+		s.handleQueuesMu.Lock()
+		defer s.handleQueuesMu.Unlock()
+		q.users--
+		if q.users > 0 {
+			return
+		}
+		queues := s.handleQueues[key]
+		for i, candidate := range queues {
+			if candidate == q {
+				queues = append(queues[:i:i], queues[i+1:]...)
+				break
+			}
+		}
+		if len(queues) == 0 {
+			delete(s.handleQueues, key)
+		} else {
+			s.handleQueues[key] = queues
+		}
+	}
+	release := func() {
+		<-q.slots
+		leave()
+	}
+
+	select {
+	case q.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	t := time.NewTimer(s.HandleQueueTimeout)
+	defer t.Stop()
+	select {
+	case q.slots <- struct{}{}:
+		return release, nil
+	case <-t.C:
+	case <-ctx.Done():
+	}
+	leave()
+	w.logger().Debugf("deferring call: handle is busy")
+	return nil, &NFSStatusError{NFSStatusJukebox, errHandleBusy}
+}
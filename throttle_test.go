@@ -0,0 +1,221 @@
+package nfs_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+)
+
+func TestPerClientBandwidth(t *testing.T) {
+	const (
+		limit = 256 * 1024
+		total = 256 * 1024
+		chunk = 32 * 1024
+	)
+	mem := newTestFS(t, map[string]string{"/file": strings.Repeat("x", total)})
+	srv := &nfs.Server{
+		Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024),
+		ServerOptions: nfs.ServerOptions{PerClientBandwidth: limit},
+	}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+
+	f, err := target.Open("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, chunk)
+	read := 0
+	start := time.Now()
+	for read < total {
+		n, err := f.ReadAt(buf, int64(read))
+		if n == 0 && err != nil {
+			t.Fatal(err)
+		}
+		read += n
+	}
+	elapsed := time.Since(start)
+
+	if rate := float64(read) / elapsed.Seconds(); rate > limit*1.1 {
+		t.Fatalf("read %d bytes in %v (%.0f B/s), above the limit of %d B/s", read, elapsed, rate, limit)
+	}
+	if elapsed > 5*time.Second {
+		t.Fatalf("read %d bytes in %v, far slower than the limit of %d B/s", read, elapsed, limit)
+	}
+}
+
+// gatedFS holds every read of a file named "hot" until gate is closed,
+// tracking how many are in progress at once.
+type gatedFS struct {
+	billy.Filesystem
+	gate chan struct{}
+
+	mu               sync.Mutex
+	inFlight, maxHot int
+}
+
+func (g *gatedFS) Open(filename string) (billy.File, error) {
+	return g.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (g *gatedFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	f, err := g.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil || path.Base(filename) != "hot" {
+		return f, err
+	}
+	return &gatedFile{File: f, fs: g}, nil
+}
+
+func (g *gatedFS) hotReads() (inFlight, max int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.inFlight, g.maxHot
+}
+
+type gatedFile struct {
+	billy.File
+	fs *gatedFS
+}
+
+func (f *gatedFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	f.fs.inFlight++
+	if f.fs.inFlight > f.fs.maxHot {
+		f.fs.maxHot = f.fs.inFlight
+	}
+	f.fs.mu.Unlock()
+	defer func() {
+		f.fs.mu.Lock()
+		f.fs.inFlight--
+		f.fs.mu.Unlock()
+	}()
+	<-f.fs.gate
+	return f.File.ReadAt(p, off)
+}
+
+func TestMaxOpsPerHandle(t *testing.T) {
+	const (
+		limit   = 2
+		readers = 6
+	)
+	fs := &gatedFS{
+		Filesystem: newTestFS(t, map[string]string{"/hot": "hot", "/cold": "cold"}),
+		gate:       make(chan struct{}),
+	}
+	srv := &nfs.Server{
+		Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024),
+		ServerOptions: nfs.ServerOptions{MaxOpsPerHandle: limit, HandleQueueTimeout: 10 * time.Second},
+	}
+
+	// Requests on one connection are handled in turn, so each reader needs
+	// its own.
+	files := make([]*nfsc.File, readers)
+	for i := range files {
+		f, err := serveAndMount(t, srv, rpc.AuthNull).Open("/hot")
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[i] = f
+	}
+	cold, err := serveAndMount(t, srv, rpc.AuthNull).Open("/cold")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, readers)
+	for _, f := range files {
+		go func(f *nfsc.File) {
+			_, err := f.ReadAt(make([]byte, 16), 0)
+			if err == io.EOF {
+				err = nil
+			}
+			errs <- err
+		}(f)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for n, _ := fs.hotReads(); n < limit; n, _ = fs.hotReads() {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d reads of the hot file started", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Give any reads beyond the limit a chance to (wrongly) start.
+	time.Sleep(100 * time.Millisecond)
+
+	buf := make([]byte, 16)
+	if n, err := cold.ReadAt(buf, 0); (err != nil && err != io.EOF) || string(buf[:n]) != "cold" {
+		t.Fatalf("read of another handle while the hot one was busy: %q, %v", buf[:n], err)
+	}
+
+	close(fs.gate)
+	for range files {
+		if err := <-errs; err != nil {
+			t.Fatalf("queued read failed: %v", err)
+		}
+	}
+	if _, max := fs.hotReads(); max != limit {
+		t.Fatalf("expected at most %d concurrent reads of the hot file, saw %d", limit, max)
+	}
+}
+
+// aliasHandler resolves a handle with "alias" appended as the handle
+// itself, so that a file is reachable through handles that differ.
+type aliasHandler struct {
+	nfs.Handler
+}
+
+func (a aliasHandler) FromHandle(fh []byte) (billy.Filesystem, []string, error) {
+	return a.Handler.FromHandle(bytes.TrimSuffix(fh, []byte("alias")))
+}
+
+func TestMaxOpsPerHandleAliases(t *testing.T) {
+	fs := &gatedFS{
+		Filesystem: newTestFS(t, map[string]string{"/hot": "hot"}),
+		gate:       make(chan struct{}),
+	}
+	srv := &nfs.Server{
+		Handler:       aliasHandler{helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024)},
+		ServerOptions: nfs.ServerOptions{MaxOpsPerHandle: 1, HandleQueueTimeout: 10 * time.Second},
+	}
+	first := serveAndMount(t, srv, rpc.AuthNull)
+	second := serveAndMount(t, srv, rpc.AuthNull)
+	_, fh, err := first.Lookup("/hot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 2)
+	for _, call := range []struct {
+		target *nfsc.Target
+		fh     []byte
+	}{{first, fh}, {second, append(append([]byte(nil), fh...), "alias"...)}} {
+		go func(target *nfsc.Target, fh []byte) {
+			_, err := tryRead(target, fh, 0, 16)
+			errs <- err
+		}(call.target, call.fh)
+	}
+
+	// give the read through the other handle a chance to (wrongly) start.
+	time.Sleep(100 * time.Millisecond)
+	close(fs.gate)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("queued read failed: %v", err)
+		}
+	}
+	if _, max := fs.hotReads(); max != 1 {
+		t.Fatalf("expected reads through either handle to share a queue, saw %d at once", max)
+	}
+}
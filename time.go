@@ -19,6 +19,19 @@ func ToNFSTime(t time.Time) FileTime {
 	}
 }
 
+// Truncate rounds t down to a multiple of d, if d is positive.
+func (t FileTime) Truncate(d time.Duration) FileTime {
+	if d <= 0 {
+		return t
+	}
+	ns := int64(t.Seconds)*int64(time.Second) + int64(t.Nseconds)
+	ns -= ns % int64(d)
+	return FileTime{
+		Seconds:  uint32(ns / int64(time.Second)),
+		Nseconds: uint32(ns % int64(time.Second)),
+	}
+}
+
 // Native generates a golang time from an nfs time spec
 func (t FileTime) Native() *time.Time {
 	ts := time.Unix(int64(t.Seconds), int64(t.Nseconds))
@@ -0,0 +1,134 @@
+package nfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+// maxDatagram is the largest call or reply carried in a UDP datagram.
+const maxDatagram = 65507
+
+// maxDatagramTransfer caps the data a READ or WRITE moves over UDP, so
+// that its call or reply, headers and all, fits in one datagram.
+const maxDatagramTransfer = 32 * 1024
+
+// ServeUDP answers the calls that arrive as datagrams on pc, each reply sent
+// in a datagram to the address the call came from. Without TCP's record
+// marking each datagram holds a whole call, so READ and WRITE move at most
+// 32KiB over UDP, as FSINFO tells clients of it. It handles at most
+// MaxConcurrentRequests calls at once, along with those of Serve. Shutdown
+// stops it, after which it returns ErrServerClosed.
+func (s *Server) ServeUDP(pc net.PacketConn) error {
+	defer pc.Close()
+	if !s.trackPacketConn(pc, true) {
+		return ErrServerClosed
+	}
+	defer s.trackPacketConn(pc, false)
+	baseCtx := context.Background()
+	if s.Context != nil {
+		baseCtx = s.Context
+	}
+	if err := s.prepare(); err != nil {
+		return err
+	}
+	if watcher, ok := s.Handler.(Watcher); ok {
+		stop := watcher.Watch(s.onExternalChange)
+		defer stop()
+	}
+
+	buf := make([]byte, maxDatagram)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			if s.closing() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		// datagrams beyond those the server may handle at once wait in the
+		// socket's buffer, or are dropped and retransmitted.
+		if !s.acquireSlot(baseCtx) {
+			return baseCtx.Err()
+		}
+		if !s.beginRequest() {
+			s.releaseSlot()
+			// the client retransmits the call to the restarted server.
+			continue
+		}
+		msg := append([]byte(nil), buf[:n]...)
+		c := &conn{
+			Server:  s,
+			Conn:    &datagramConn{pc, addr},
+			limiter: s.limiterFor(addr),
+		}
+		go func() {
+			defer s.releaseSlot()
+			defer c.releaseLimiter()
+			c.serveDatagram(baseCtx, msg)
+		}()
+	}
+}
+
+// serveDatagram answers the call msg holds.
+func (c *conn) serveDatagram(ctx context.Context, msg []byte) {
+	defer c.Server.inFlight.Done()
+	w, err := c.readRequest(&io.LimitedReader{R: bytes.NewReader(msg), N: int64(len(msg))})
+	if err != nil {
+		c.logger().Debugf("dropping datagram from %v: %v", c.RemoteAddr(), err)
+		return
+	}
+	w.logger().Tracef("request: %v", w.req)
+	if err := c.handleOnce(ctx, w); err != nil {
+		c.logger().Errorf("error handling req: %v", err)
+		return
+	}
+	if w.dropped {
+		return
+	}
+	if w.writer.Len() > maxDatagram {
+		w.logger().Errorf("dropping reply of %d bytes, too long for a datagram", w.writer.Len())
+		return
+	}
+	if _, err := c.Write(w.writer.Bytes()); err != nil {
+		c.logger().Errorf("error sending response: %v", err)
+	}
+}
+
+// maxTransferSize returns the most data a READ or WRITE on c may move.
+func (c *conn) maxTransferSize() uint32 {
+	max := c.Server.maxTransferSize()
+	if _, ok := c.Conn.(*datagramConn); ok && max > maxDatagramTransfer {
+		return maxDatagramTransfer
+	}
+	return max
+}
+
+// datagramConn is the net.Conn of the calls a UDP client makes, on which
+// each Write sends a datagram to the client.
+type datagramConn struct {
+	pc   net.PacketConn
+	addr net.Addr
+}
+
+func (d *datagramConn) Read(b []byte) (int, error) { return 0, io.EOF }
+
+func (d *datagramConn) Write(b []byte) (int, error) { return d.pc.WriteTo(b, d.addr) }
+
+// Close leaves the packet conn open for the calls of other clients.
+func (d *datagramConn) Close() error { return nil }
+
+func (d *datagramConn) LocalAddr() net.Addr { return d.pc.LocalAddr() }
+
+func (d *datagramConn) RemoteAddr() net.Addr { return d.addr }
+
+func (d *datagramConn) SetDeadline(t time.Time) error { return nil }
+
+func (d *datagramConn) SetReadDeadline(t time.Time) error { return nil }
+
+func (d *datagramConn) SetWriteDeadline(t time.Time) error { return nil }
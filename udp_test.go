@@ -0,0 +1,221 @@
+package nfs_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// sendUDP sends the call args, whose header is completed with prog, vers
+// and proc, in a datagram on conn.
+func sendUDP(t *testing.T, conn net.Conn, xid, prog, vers, proc uint32, args ...interface{}) {
+	t.Helper()
+	call := new(bytes.Buffer)
+	if err := xdr.Write(call, &struct {
+		Xid  uint32
+		Type uint32
+		rpc.Header
+	}{
+		Xid: xid,
+		Header: rpc.Header{
+			Rpcvers: 2,
+			Prog:    prog,
+			Vers:    vers,
+			Proc:    proc,
+			Cred:    rpc.AuthNull,
+			Verf:    rpc.AuthNull,
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range args {
+		if err := xdr.Write(call, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := conn.Write(call.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receiveUDP reads a reply from conn, and returns its xid and its result.
+func receiveUDP(t *testing.T, conn net.Conn) (uint32, io.Reader) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	datagram := make([]byte, 65536)
+	n, err := conn.Read(datagram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := bytes.NewReader(datagram[:n])
+	var head struct {
+		Xid       uint32
+		Type      uint32
+		ReplyStat uint32
+		Verf      rpc.Auth
+		Accept    uint32
+	}
+	if err := xdr.Read(res, &head); err != nil {
+		t.Fatal(err)
+	}
+	if head.Type != 1 || head.ReplyStat != rpc.MsgAccepted || head.Accept != 0 {
+		t.Fatalf("unexpected reply header %+v", head)
+	}
+	return head.Xid, res
+}
+
+// callUDP sends a call as sendUDP does, and returns the result of its reply.
+func callUDP(t *testing.T, conn net.Conn, xid, prog, vers, proc uint32, args ...interface{}) io.Reader {
+	t.Helper()
+	sendUDP(t, conn, xid, prog, vers, proc, args...)
+	replied, res := receiveUDP(t, conn)
+	if replied != xid {
+		t.Fatalf("expected the reply to call %d, got one to %d", xid, replied)
+	}
+	return res
+}
+
+func TestUDP(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/file": "hello"})
+	srv := &nfs.Server{
+		Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024),
+		ServerOptions: nfs.ServerOptions{MaxTransferSize: 1 << 20},
+	}
+	pc, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+	go func() {
+		_ = srv.ServeUDP(pc)
+	}()
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	res := callUDP(t, conn, 1, nfsc.MountProg, nfsc.MountVers, nfsc.MountProc3MNT, "/")
+	var mnt struct {
+		Status uint32
+		FH     []byte
+	}
+	if err := xdr.Read(res, &mnt); err != nil {
+		t.Fatal(err)
+	}
+	if mnt.Status != nfsc.MNT3Ok {
+		t.Fatalf("mount failed with status %d", mnt.Status)
+	}
+
+	res = callUDP(t, conn, 2, nfsc.Nfs3Prog, nfsc.Nfs3Vers, nfsc.NFSProc3GetAttr, mnt.FH)
+	var getattr struct {
+		Status uint32
+		Attr   nfsc.Fattr
+	}
+	if err := xdr.Read(res, &getattr); err != nil {
+		t.Fatal(err)
+	}
+	if getattr.Status != nfsc.NFS3Ok {
+		t.Fatalf("getattr failed with status %d", getattr.Status)
+	}
+	if !getattr.Attr.IsDir() {
+		t.Fatalf("expected the root to be a directory, got %v", getattr.Attr.Mode())
+	}
+
+	// transfers are limited to what fits in a datagram.
+	res = callUDP(t, conn, 3, nfsc.Nfs3Prog, nfsc.Nfs3Vers, nfsc.NFSProc3FSInfo, mnt.FH)
+	var fsinfo struct {
+		Status uint32
+		nfsc.FSInfo
+	}
+	if err := xdr.Read(res, &fsinfo); err != nil {
+		t.Fatal(err)
+	}
+	if fsinfo.Status != nfsc.NFS3Ok {
+		t.Fatalf("fsinfo failed with status %d", fsinfo.Status)
+	}
+	if fsinfo.RTMax > 32*1024 || fsinfo.WTMax > 32*1024 {
+		t.Fatalf("advertised transfers of %d and %d bytes over UDP", fsinfo.RTMax, fsinfo.WTMax)
+	}
+}
+
+func TestUDPConcurrencyLimit(t *testing.T) {
+	fs := &slowOpenFS{
+		Filesystem: newTestFS(t, map[string]string{"/file": "hello"}),
+		opened:     make(chan struct{}, 2),
+		release:    make(chan struct{}),
+	}
+	srv := &nfs.Server{
+		Handler:       helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024),
+		ServerOptions: nfs.ServerOptions{MaxConcurrentRequests: 1},
+	}
+	pc, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+	go func() {
+		_ = srv.ServeUDP(pc)
+	}()
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	res := callUDP(t, conn, 1, nfsc.MountProg, nfsc.MountVers, nfsc.MountProc3MNT, "/")
+	var mnt struct {
+		Status uint32
+		FH     []byte
+	}
+	if err := xdr.Read(res, &mnt); err != nil {
+		t.Fatal(err)
+	}
+	res = callUDP(t, conn, 2, nfsc.Nfs3Prog, nfsc.Nfs3Vers, nfsc.NFSProc3Lookup, mnt.FH, "file")
+	var lookup struct {
+		Status uint32
+		FH     []byte
+	}
+	if err := xdr.Read(res, &lookup); err != nil {
+		t.Fatal(err)
+	}
+	if lookup.Status != nfsc.NFS3Ok {
+		t.Fatalf("lookup failed with status %d", lookup.Status)
+	}
+
+	// with one request allowed at a time, the second READ is not started
+	// until the first, held up opening the file, has finished.
+	sendUDP(t, conn, 3, nfsc.Nfs3Prog, nfsc.Nfs3Vers, nfsc.NFSProc3Read, lookup.FH, uint64(0), uint32(5))
+	sendUDP(t, conn, 4, nfsc.Nfs3Prog, nfsc.Nfs3Vers, nfsc.NFSProc3Read, lookup.FH, uint64(0), uint32(5))
+	select {
+	case <-fs.opened:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first READ did not start")
+	}
+	select {
+	case <-fs.opened:
+		t.Fatal("started a second READ while the first was in progress")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(fs.release)
+
+	for i := 0; i < 2; i++ {
+		_, res := receiveUDP(t, conn)
+		var status uint32
+		if err := xdr.Read(res, &status); err != nil {
+			t.Fatal(err)
+		}
+		if status != nfsc.NFS3Ok {
+			t.Fatalf("read failed with status %d", status)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package nfs
+
+import (
+	"bytes"
+	"context"
+	"os"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// XattrFilesystem is implemented by billy filesystems that keep extended
+// attributes of their files, which the Getxattr, Setxattr and Listxattr
+// procedures of ExtensionProgram expose. NFSv3 itself has no way to reach
+// them. Getxattr returns an error satisfying os.IsNotExist for an
+// attribute the file lacks. A backend keeps a file's attributes when it is
+// renamed, since RENAME moves it with fs.Rename.
+type XattrFilesystem interface {
+	Getxattr(path, name string) ([]byte, error)
+	Setxattr(path, name string, value []byte) error
+	Listxattr(path string) ([]string, error)
+}
+
+// xattrNameMax is the longest name of an extended attribute, as on Linux.
+const xattrNameMax = 255
+
+// xattrFilesystem returns fs as an XattrFilesystem, or refuses with
+// NFS3ERR_NOTSUPP.
+func xattrFilesystem(fs billy.Filesystem) (XattrFilesystem, error) {
+	xfs, ok := fs.(XattrFilesystem)
+	if !ok {
+		return nil, &NFSStatusError{NFSStatusNotSupp, billy.ErrNotSupported}
+	}
+	return xfs, nil
+}
+
+type getxattrArgs struct {
+	Handle []byte
+	Name   string
+}
+
+func onGetxattr(ctx context.Context, w *response, userHandle Handler) error {
+	w.errorFmt = opAttrErrorFormatter
+	var obj getxattrArgs
+	if err := xdr.Read(w.req.Body, &obj); err != nil {
+		return &NFSStatusError{NFSStatusInval, err}
+	}
+	fs, path, err := w.fromHandle(ctx, userHandle, obj.Handle)
+	if err != nil {
+		return &NFSStatusError{NFSStatusStale, err}
+	}
+	xfs, err := xattrFilesystem(fs)
+	if err != nil {
+		return err
+	}
+	if len(obj.Name) > xattrNameMax {
+		return &NFSStatusError{NFSStatusNameTooLong, os.ErrInvalid}
+	}
+	value, err := xfs.Getxattr(fs.Join(path...), obj.Name)
+	if err != nil {
+		return &NFSStatusError{mapError(err), err}
+	}
+
+	writer := bytes.NewBuffer([]byte{})
+	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	if err := WritePostOpAttrs(writer, w.tryStat(userHandle, fs, path)); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	if err := xdr.Write(writer, value); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	if err := w.Write(writer.Bytes()); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	return nil
+}
+
+type setxattrArgs struct {
+	Handle []byte
+	Name   string
+	Value  []byte
+}
+
+func onSetxattr(ctx context.Context, w *response, userHandle Handler) error {
+	w.errorFmt = opAttrErrorFormatter
+	var obj setxattrArgs
+	if err := xdr.Read(w.req.Body, &obj); err != nil {
+		return &NFSStatusError{NFSStatusInval, err}
+	}
+	fs, path, err := w.fromHandle(ctx, userHandle, obj.Handle)
+	if err != nil {
+		return &NFSStatusError{NFSStatusStale, err}
+	}
+	xfs, err := xattrFilesystem(fs)
+	if err != nil {
+		return err
+	}
+	if !billy.CapabilityCheck(fs, billy.WriteCapability) {
+		return &NFSStatusError{NFSStatusROFS, os.ErrPermission}
+	}
+	if len(obj.Name) > xattrNameMax {
+		return &NFSStatusError{NFSStatusNameTooLong, os.ErrInvalid}
+	}
+	if err := xfs.Setxattr(fs.Join(path...), obj.Name, obj.Value); err != nil {
+		return &NFSStatusError{mapError(err), err}
+	}
+
+	writer := bytes.NewBuffer([]byte{})
+	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	if err := WritePostOpAttrs(writer, w.tryStat(userHandle, fs, path)); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	if err := w.Write(writer.Bytes()); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	return nil
+}
+
+func onListxattr(ctx context.Context, w *response, userHandle Handler) error {
+	w.errorFmt = opAttrErrorFormatter
+	var handle []byte
+	if err := xdr.Read(w.req.Body, &handle); err != nil {
+		return &NFSStatusError{NFSStatusInval, err}
+	}
+	fs, path, err := w.fromHandle(ctx, userHandle, handle)
+	if err != nil {
+		return &NFSStatusError{NFSStatusStale, err}
+	}
+	xfs, err := xattrFilesystem(fs)
+	if err != nil {
+		return err
+	}
+	names, err := xfs.Listxattr(fs.Join(path...))
+	if err != nil {
+		return &NFSStatusError{mapError(err), err}
+	}
+
+	writer := bytes.NewBuffer([]byte{})
+	if err := xdr.Write(writer, uint32(NFSStatusOk)); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	if err := WritePostOpAttrs(writer, w.tryStat(userHandle, fs, path)); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	if err := xdr.Write(writer, names); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	if err := w.Write(writer.Bytes()); err != nil {
+		return &NFSStatusError{NFSStatusServerFault, err}
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package nfs_test
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// xattrFS keeps extended attributes of the files of its filesystem by path,
+// moving them when a file is renamed.
+type xattrFS struct {
+	billy.Filesystem
+	mu     sync.Mutex
+	xattrs map[string]map[string][]byte
+}
+
+func (f *xattrFS) Getxattr(path, name string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, ok := f.xattrs[path][name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return value, nil
+}
+
+func (f *xattrFS) Setxattr(path, name string, value []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.xattrs[path] == nil {
+		f.xattrs[path] = make(map[string][]byte)
+	}
+	f.xattrs[path][name] = value
+	return nil
+}
+
+func (f *xattrFS) Listxattr(path string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := []string{}
+	for name := range f.xattrs[path] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (f *xattrFS) Rename(from, to string) error {
+	if err := f.Filesystem.Rename(from, to); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if attrs, ok := f.xattrs[from]; ok {
+		f.xattrs[to] = attrs
+		delete(f.xattrs, from)
+	}
+	return nil
+}
+
+// xattrHeader is the header of a call of the extension procedure proc.
+func xattrHeader(proc nfs.ExtensionProcedure) rpc.Header {
+	return rpc.Header{
+		Rpcvers: 2,
+		Prog:    nfs.ExtensionProgram,
+		Vers:    nfs.ExtensionVersion,
+		Proc:    uint32(proc),
+		Cred:    rpc.AuthNull,
+		Verf:    rpc.AuthNull,
+	}
+}
+
+// callXattr makes call, one of the xattr procedures with its arguments,
+// returning its status and, past the file's post_op_attr, the rest of its
+// reply.
+func callXattr(t *testing.T, target *nfsc.Target, call interface{}) (uint32, io.Reader) {
+	t.Helper()
+	res, err := target.Call(call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := xdr.ReadUint32(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var attrs nfsc.PostOpAttr
+	if err := xdr.Read(res, &attrs); err != nil {
+		t.Fatal(err)
+	}
+	return status, res
+}
+
+type getxattrCall struct {
+	rpc.Header
+	FH   []byte
+	Name string
+}
+
+type setxattrCall struct {
+	rpc.Header
+	FH    []byte
+	Name  string
+	Value []byte
+}
+
+type listxattrCall struct {
+	rpc.Header
+	FH []byte
+}
+
+func TestXattr(t *testing.T) {
+	fs := &xattrFS{Filesystem: newTestFS(t, map[string]string{"/file": "hello"}), xattrs: make(map[string]map[string][]byte)}
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+	_, fh, err := target.Lookup("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, _ := callXattr(t, target, &getxattrCall{xattrHeader(nfs.ExtensionProcGetxattr), fh, "user.tag"}); status != nfsc.NFS3ErrNoEnt {
+		t.Fatalf("expected NOENT getting a missing attribute, got %d", status)
+	}
+	for _, name := range []string{"user.tag", "user.color"} {
+		if status, _ := callXattr(t, target, &setxattrCall{xattrHeader(nfs.ExtensionProcSetxattr), fh, name, []byte(name + " value")}); status != nfsc.NFS3Ok {
+			t.Fatalf("setxattr of %s failed with %d", name, status)
+		}
+	}
+	status, res := callXattr(t, target, &listxattrCall{xattrHeader(nfs.ExtensionProcListxattr), fh})
+	if status != nfsc.NFS3Ok {
+		t.Fatalf("listxattr failed with %d", status)
+	}
+	var names []string
+	if err := xdr.Read(res, &names); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "user.color,user.tag" {
+		t.Fatalf("listed attributes %v", names)
+	}
+
+	// the attributes follow the file through a rename.
+	if err := target.Rename("/file", "/renamed"); err != nil {
+		t.Fatal(err)
+	}
+	_, fh, err = target.Lookup("/renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, res = callXattr(t, target, &getxattrCall{xattrHeader(nfs.ExtensionProcGetxattr), fh, "user.tag"})
+	if status != nfsc.NFS3Ok {
+		t.Fatalf("getxattr after rename failed with %d", status)
+	}
+	value, err := xdr.ReadOpaque(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "user.tag value" {
+		t.Fatalf("got value %q", value)
+	}
+}
+
+func TestXattrNotSupported(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/file": "hello"})
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	target := serveAndMount(t, &nfs.Server{Handler: handler}, rpc.AuthNull)
+	_, fh, err := target.Lookup("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := callXattr(t, target, &listxattrCall{xattrHeader(nfs.ExtensionProcListxattr), fh}); status != nfsc.NFS3ErrNotSupp {
+		t.Fatalf("expected NOTSUPP listing attributes, got %d", status)
+	}
+	if status, _ := callXattr(t, target, &setxattrCall{xattrHeader(nfs.ExtensionProcSetxattr), fh, "user.tag", []byte("v")}); status != nfsc.NFS3ErrNotSupp {
+		t.Fatalf("expected NOTSUPP setting an attribute, got %d", status)
+	}
+}
+
+func TestXattrReadOnly(t *testing.T) {
+	const nfs3ErrJukebox = 10008
+	fs := &xattrFS{Filesystem: newTestFS(t, map[string]string{"/file": "hello"}), xattrs: make(map[string]map[string][]byte)}
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(fs), 1024)
+	srv := &nfs.Server{Handler: handler}
+	target := serveAndMount(t, srv, rpc.AuthNull)
+	_, fh, err := target.Lookup("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.SetReadOnly(true)
+	if status, _ := callXattr(t, target, &setxattrCall{xattrHeader(nfs.ExtensionProcSetxattr), fh, "user.tag", []byte("v")}); status != nfsc.NFS3ErrROFS {
+		t.Fatalf("expected ROFS setting an attribute on a read-only server, got %d", status)
+	}
+	if status, _ := callXattr(t, target, &listxattrCall{xattrHeader(nfs.ExtensionProcListxattr), fh}); status != nfsc.NFS3Ok {
+		t.Fatalf("expected attributes still listed on a read-only server, got %d", status)
+	}
+
+	// a server in its grace period defers setting attributes, as it does
+	// SETATTR.
+	graceTarget := serveAndMount(t, &nfs.Server{Handler: handler, ServerOptions: nfs.ServerOptions{GracePeriod: time.Hour}}, rpc.AuthNull)
+	if status, _ := callXattr(t, graceTarget, &setxattrCall{xattrHeader(nfs.ExtensionProcSetxattr), fh, "user.tag", []byte("v")}); status != nfs3ErrJukebox {
+		t.Fatalf("expected JUKEBOX setting an attribute in the grace period, got %d", status)
+	}
+	if status, _ := callXattr(t, graceTarget, &listxattrCall{xattrHeader(nfs.ExtensionProcListxattr), fh}); status != nfsc.NFS3Ok {
+		t.Fatalf("expected attributes listed in the grace period, got %d", status)
+	}
+	if _, err := fs.Getxattr("file", "user.tag"); err == nil {
+		t.Fatal("expected the attribute left unset")
+	}
+}
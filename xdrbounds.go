@@ -0,0 +1,69 @@
+package nfs
+
+import (
+	"errors"
+	"io"
+
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// MaxFilenameSize bounds the names ReadDirOpArg reads, in bytes. It is well
+// beyond any name a handler accepts, so that names too long for a handler
+// are still refused with NFS3ERR_NAMETOOLONG, but keeps a forged length
+// from having a call allocate more than a name could ever need.
+const MaxFilenameSize = 1 << 16
+
+// errOpaqueTooLong is returned for variable-length opaque data longer than
+// the bound it is read with.
+var errOpaqueTooLong = errors.New("xdr: opaque data exceeds its bound")
+
+// ReadDirOpArg reads a DirOpArg from r, refusing handles longer than FHSize
+// and names longer than MaxFilenameSize before allocating them. Unlike
+// xdr.Read, it never allocates more than r can still hold, when r is the
+// body of a call.
+func ReadDirOpArg(r io.Reader) (DirOpArg, error) {
+	var obj DirOpArg
+	var err error
+	if obj.Handle, err = readOpaque(r, FHSize); err != nil {
+		return DirOpArg{}, err
+	}
+	if obj.Filename, err = readOpaque(r, MaxFilenameSize); err != nil {
+		return DirOpArg{}, err
+	}
+	return obj, nil
+}
+
+// readOpaque reads variable-length opaque data of at most max bytes from r,
+// along with the padding following it.
+func readOpaque(r io.Reader, max int) ([]byte, error) {
+	n, err := xdr.ReadUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	if int64(n) > int64(max) {
+		return nil, errOpaqueTooLong
+	}
+	size := int64(n+3) &^ 3
+	if left, ok := remaining(r); ok && size > left {
+		return nil, io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// remaining returns how many bytes are left to read from r, if r knows.
+func remaining(r io.Reader) (int64, bool) {
+	switch r := r.(type) {
+	case *io.LimitedReader:
+		return r.N, true
+	case interface{ Len() int }:
+		return int64(r.Len()), true
+	}
+	return 0, false
+}
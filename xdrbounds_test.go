@@ -0,0 +1,111 @@
+package nfs_test
+
+import (
+	"bytes"
+	"testing"
+
+	nfs "github.com/willscott/go-nfs"
+	"github.com/willscott/go-nfs/helpers"
+
+	nfsc "github.com/willscott/go-nfs-client/nfs"
+	rpc "github.com/willscott/go-nfs-client/nfs/rpc"
+	"github.com/willscott/go-nfs-client/nfs/xdr"
+)
+
+// encodeDirOpArg encodes a DirOpArg as a client sends it.
+func encodeDirOpArg(t testing.TB, handle []byte, name string) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	if err := xdr.Write(buf, nfs.DirOpArg{Handle: handle, Filename: []byte(name)}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func FuzzDirOpArg(f *testing.F) {
+	// Seeds are the arguments of calls a client makes: a LOOKUP of a
+	// name in the root, and the same with a handle of the largest size.
+	mem := newTestFS(f, map[string]string{"/file": "hello"})
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	root := handler.ToHandle(mem, nil)
+	for _, name := range []string{"file", "", ".", "..", "é日本", string(bytes.Repeat([]byte("a"), nfs.PathNameMax))} {
+		f.Add(encodeDirOpArg(f, root, name))
+	}
+	f.Add(encodeDirOpArg(f, bytes.Repeat([]byte{0xff}, nfs.FHSize), "file"))
+	f.Add([]byte{})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+	// a lookup in the root whose name claims the most bytes it could.
+	empty := encodeDirOpArg(f, root, "")
+	f.Add(append(empty[:len(empty)-4:len(empty)-4], 0x7f, 0xff, 0xff, 0xff))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := bytes.NewReader(data)
+		obj, err := nfs.ReadDirOpArg(r)
+		if err != nil {
+			return
+		}
+		if len(obj.Handle) > nfs.FHSize || len(obj.Filename) > nfs.MaxFilenameSize {
+			t.Fatalf("read a handle of %d bytes and a name of %d bytes, beyond their bounds", len(obj.Handle), len(obj.Filename))
+		}
+		encoded := encodeDirOpArg(t, obj.Handle, string(obj.Filename))
+		if consumed := len(data) - r.Len(); consumed != len(encoded) {
+			t.Fatalf("consumed %d bytes for an argument of %d", consumed, len(encoded))
+		}
+		again, err := nfs.ReadDirOpArg(bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatalf("failed to read back %+v: %v", obj, err)
+		}
+		if !bytes.Equal(again.Handle, obj.Handle) || !bytes.Equal(again.Filename, obj.Filename) {
+			t.Fatalf("read back %+v as %+v", obj, again)
+		}
+	})
+}
+
+// lookupStatus sends srv a LOOKUP with the arguments args encode, and
+// returns the status it replies with.
+func lookupStatus(t *testing.T, srv *nfs.Server, args ...interface{}) uint32 {
+	t.Helper()
+	res := callProcWithCredential(t, srv, nfsc.Nfs3Prog, nfsc.Nfs3Vers, nfsc.NFSProc3Lookup, rpc.AuthNull, rpc.AuthNull, args...)
+	var reply struct {
+		Type       uint32
+		ReplyStat  uint32
+		Verf       rpc.Auth
+		AcceptStat uint32
+		Status     uint32
+	}
+	if err := xdr.Read(res, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.ReplyStat != rpc.MsgAccepted || reply.AcceptStat != 0 {
+		t.Fatalf("expected the call accepted, got stat %d accept_stat %d", reply.ReplyStat, reply.AcceptStat)
+	}
+	return reply.Status
+}
+
+func TestDirOpArgBounds(t *testing.T) {
+	mem := newTestFS(t, map[string]string{"/file": "hello"})
+	handler := helpers.NewCachingHandler(helpers.NewNullAuthHandler(mem), 1024)
+	srv := &nfs.Server{Handler: handler}
+	root := handler.ToHandle(mem, nil)
+
+	for _, tc := range []struct {
+		name string
+		args []interface{}
+	}{
+		{"handle longer than FHSize", []interface{}{make([]byte, nfs.FHSize+4), "file"}},
+		{"handle length beyond the call", []interface{}{uint32(1<<31 - 1)}},
+		{"name longer than its bound", []interface{}{root, uint32(nfs.MaxFilenameSize + 1)}},
+		{"name length beyond the call", []interface{}{root, uint32(1<<31 - 1)}},
+		{"name cut short", []interface{}{root, uint32(8), uint32(0)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if status := lookupStatus(t, srv, tc.args...); status != nfsc.NFS3ErrInval {
+				t.Fatalf("expected INVAL, got status %d", status)
+			}
+		})
+	}
+
+	if status := lookupStatus(t, srv, root, "file"); status != nfsc.NFS3Ok {
+		t.Fatalf("expected a well-formed lookup to succeed, got status %d", status)
+	}
+}